@@ -0,0 +1,31 @@
+//go:build !windows
+
+// Command tpm-pills gathers the examples of every pill in a single CLI.
+package main
+
+import (
+	pill04 "github.com/loicsikidi/tpm-pills/examples/04-pill"
+	pill05 "github.com/loicsikidi/tpm-pills/examples/05-pill"
+	pill06 "github.com/loicsikidi/tpm-pills/examples/06-pill"
+	pill07 "github.com/loicsikidi/tpm-pills/examples/07-pill"
+	pill08 "github.com/loicsikidi/tpm-pills/examples/08-pill"
+	pill09 "github.com/loicsikidi/tpm-pills/examples/09-pill"
+	pill10 "github.com/loicsikidi/tpm-pills/examples/10-pill"
+	pill11 "github.com/loicsikidi/tpm-pills/examples/11-pill"
+	pill12 "github.com/loicsikidi/tpm-pills/examples/12-pill"
+	"github.com/loicsikidi/tpm-pills/internal/cli"
+)
+
+func main() {
+	app := cli.New("tpm-pills")
+	app.Register(pill04.Commands()...)
+	app.Register(pill05.Commands()...)
+	app.Register(pill06.Commands()...)
+	app.Register(pill07.Commands()...)
+	app.Register(pill08.Commands()...)
+	app.Register(pill09.Commands()...)
+	app.Register(pill10.Commands()...)
+	app.Register(pill11.Commands()...)
+	app.Register(pill12.Commands()...)
+	app.Main()
+}
@@ -1,90 +1,72 @@
 //go:build !windows
 
-package main
+// Package pill04 holds the commands of pill #4: create an ordinary key and load it into the TPM.
+package pill04
 
 import (
 	"flag"
 	"fmt"
-	"os"
 
 	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/tpm-pills/internal/cli"
 	"github.com/loicsikidi/tpm-pills/internal/options"
 	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
 )
 
-var useTPM bool
-
-func main() {
-	if err := run(); err != nil {
-		fmt.Fprintf(os.Stderr, "%v\n", err)
-		os.Exit(1)
-	}
-}
-
-func run() error {
+// Commands returns the commands introduced by pill #4.
+func Commands() []*cli.Command {
 	createOpts := &options.CreateKeyOpts{
 		KeyType: options.Signer.String(),
 	}
 	loadOpts := &LoadKeyOpts{}
 
-	createCmd := flag.NewFlagSet("create", flag.ExitOnError)
-	loadCmd := flag.NewFlagSet("load", flag.ExitOnError)
-
-	// Define flags for the create subcommand
-	createCmd.StringVar(&createOpts.OutputDir, "out", "", "Output directory for the created key")
-	createCmd.BoolVar(&useTPM, "use-real-tpm", false, "Use real TPM instead of swtpm")
-
-	// Define flags for the load subcommand
-	loadCmd.StringVar(&loadOpts.KeyBlobPath, "key", "", "Path to TPM key blob file")
-	loadCmd.BoolVar(&useTPM, "use-real-tpm", false, "Use real TPM instead of swtpm")
-
-	if len(os.Args) < 2 {
-		flag.Usage()
-		return fmt.Errorf("missing subcommand")
-	}
-
-	switch subcmd := os.Args[1]; subcmd {
-	case "create", "load":
-		switch subcmd {
-		case "create":
-			createCmd.Parse(os.Args[2:])
-		case "load":
-			loadCmd.Parse(os.Args[2:])
-		}
-
-		var device tpmutil.Device
-		if useTPM {
-			device = tpmutil.LINUX
-		} else {
-			device = tpmutil.SWTPM
-		}
-
-		tpm, err := tpmutil.OpenTPM(device)
-		if err != nil {
-			return fmt.Errorf("can't open tpm: %w", err)
-		}
-		defer tpm.Close()
-
-		if subcmd == "create" {
-			if err := createCommand(tpm, createOpts); err != nil {
-				return fmt.Errorf("error creating key: %w", err)
-			}
-			fmt.Println("Ordinary key created successfully 🚀")
-		} else {
-			if err := loadCommand(tpm, loadOpts); err != nil {
-				return fmt.Errorf("error loading key: %w", err)
-			}
-			fmt.Println("Ordinary key loaded successfully 🚀")
-		}
-	case "cleanup":
-		if err := os.RemoveAll(tpmutil.SWTPM_ROOT_STATE); err != nil {
-			return fmt.Errorf("error cleaning state: %w", err)
-		}
-		fmt.Println("State cleaned successfully 🚀")
-	default:
-		return fmt.Errorf("unknown subcommand %q. Expected 'create', 'load' or 'cleanup'", subcmd)
+	return []*cli.Command{
+		{
+			Name:  "key",
+			Usage: "Create and load ordinary keys",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "create",
+					Usage: "Create an ordinary signing key",
+					Flags: func(fs *flag.FlagSet) {
+						fs.StringVar(&createOpts.OutputDir, "out", "", "Output directory for the created key")
+						fs.StringVar(&createOpts.TemplatePath, "template", "", "JSON template spec of the key (see 'template export')")
+						fs.StringVar(&createOpts.Attributes, "attributes", "", "Object attributes overriding the template's (e.g. 'fixedtpm|fixedparent|sensitivedataorigin|userwithauth|sign')")
+						cli.AuthFlags(fs, &createOpts.AuthOpts)
+					},
+					Run: func(env *cli.Env) error {
+						tpm, err := env.TPM()
+						if err != nil {
+							return err
+						}
+						if err := createCommand(tpm, createOpts); err != nil {
+							return fmt.Errorf("error creating key: %w", err)
+						}
+						fmt.Fprintln(env.Stdout, "Ordinary key created successfully 🚀")
+						return nil
+					},
+				},
+				{
+					Name:  "load",
+					Usage: "Load an ordinary key into the TPM",
+					Flags: func(fs *flag.FlagSet) {
+						fs.StringVar(&loadOpts.KeyBlobPath, "key", "", "Path to TPM key blob file")
+					},
+					Run: func(env *cli.Env) error {
+						tpm, err := env.TPM()
+						if err != nil {
+							return err
+						}
+						if err := loadCommand(tpm, loadOpts); err != nil {
+							return fmt.Errorf("error loading key: %w", err)
+						}
+						fmt.Fprintln(env.Stdout, "Ordinary key loaded successfully 🚀")
+						return nil
+					},
+				},
+			},
+		},
 	}
-	return nil
 }
 
 type LoadKeyOpts struct {
@@ -102,12 +84,23 @@ func createCommand(tpm transport.TPM, opts *options.CreateKeyOpts) error {
 	if err := opts.CheckAndSetDefaults(); err != nil {
 		return err
 	}
+	policy, err := tpmutil.AuthPolicy(&opts.AuthOpts)
+	if err != nil {
+		return err
+	}
+
+	template, err := tpmutil.KeyTemplate(opts, tpmutil.ECCSignerTemplate)
+	if err != nil {
+		return err
+	}
 
 	return tpmutil.CreateKey(tpm, tpmutil.CreateKeyConfig{
 		OutDir:           opts.OutputDir,
 		ParentTemplate:   tpmutil.ECCSRKTemplate,
-		OrdinaryTemplate: tpmutil.ECCSignerTemplate,
+		OrdinaryTemplate: template,
 		CreatePublicKey:  false,
+		UserAuth:         opts.GetAuth(),
+		Policy:           policy,
 	})
 }
 
@@ -1,11 +1,11 @@
-package main
+package pill04
 
 import (
 	"path/filepath"
 	"testing"
 
-	"github.com/loicsikidi/go-tpm-kit/tpmtest"
 	"github.com/loicsikidi/tpm-pills/internal/options"
+	"github.com/loicsikidi/tpm-pills/internal/tpmreplay"
 	"github.com/stretchr/testify/require"
 )
 
@@ -13,7 +13,7 @@ import (
 // 1. Create a signing key
 // 2. Load the key back into the TPM
 func TestCreateLoadWorkflow(t *testing.T) {
-	tpm := tpmtest.OpenSimulator(t)
+	tpm := tpmreplay.Open(t, "testdata/TestCreateLoadWorkflow.json")
 
 	tempDir := t.TempDir()
 	keyPath := filepath.Join(tempDir, "key.tpm")
@@ -1,4 +1,4 @@
-package main
+package pill04
 
 import (
 	"crypto"
@@ -1,33 +1,23 @@
 //go:build !windows
 
-package main
+// Package pill05 holds the commands of pill #5: encrypt, decrypt, sign and verify with asymmetric keys.
+package pill05
 
 import (
-	"crypto/ecdsa"
 	"crypto/rsa"
-	"crypto/sha256"
-	"encoding/asn1"
 	"flag"
 	"fmt"
 	"os"
 
-	"github.com/google/go-tpm/tpm2"
 	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/tpm-pills/internal/cli"
 	"github.com/loicsikidi/tpm-pills/internal/options"
 	"github.com/loicsikidi/tpm-pills/internal/pemutil"
 	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
 )
 
-var useTPM bool
-
-func main() {
-	if err := run(); err != nil {
-		fmt.Fprintf(os.Stderr, "%v\n", err)
-		os.Exit(1)
-	}
-}
-
-func run() error {
+// Commands returns the commands introduced by pill #5.
+func Commands() []*cli.Command {
 	createOpts := &options.CreateKeyOpts{
 		KeyType: options.Decrypt.String(),
 	}
@@ -36,134 +26,156 @@ func run() error {
 	signOpts := &options.SignOpts{}
 	verifyOpts := &options.VerifyOpts{}
 
-	createCmd := flag.NewFlagSet("create", flag.ExitOnError)
-	encryptCmd := flag.NewFlagSet("encrypt", flag.ExitOnError)
-	decryptCmd := flag.NewFlagSet("decrypt", flag.ExitOnError)
-	signCmd := flag.NewFlagSet("sign", flag.ExitOnError)
-	verifyCmd := flag.NewFlagSet("verify", flag.ExitOnError)
-
-	// Define flags for the create subcommand
-	createCmd.StringVar(&createOpts.OutputDir, "out", "", "Output directory for the created key")
-	createCmd.StringVar(&createOpts.KeyType, "type", "decrypt", "Key type to create (decrypt, signer or restrictedSigner)")
-	createCmd.BoolVar(&useTPM, "use-real-tpm", false, "Use real TPM instead of swtpm")
-
-	// Define flags for the encrypt subcommand
-	encryptCmd.StringVar(&encryptOpts.PublicKeyPath, "pubkey", "", "Path to the public key file")
-	encryptCmd.StringVar(&encryptOpts.Message, "message", "", "Message to encrypt")
-	encryptCmd.StringVar(&encryptOpts.OutputFilePath, "output", "", "Output file for the encrypted message")
-	encryptCmd.BoolVar(&useTPM, "use-real-tpm", false, "Use real TPM instead of swtpm")
-
-	// Define flags for the decrypt subcommand
-	decryptCmd.StringVar(&decryptOpts.KeyBlobPath, "key", "", "Path to TPM key blob file")
-	decryptCmd.StringVar(&decryptOpts.InputFilePath, "in", "", "Input file to decrypt")
-	decryptCmd.BoolVar(&useTPM, "use-real-tpm", false, "Use real TPM instead of swtpm")
-
-	// Define flags for the sign subcommand
-	signCmd.StringVar(&signOpts.KeyBlobPath, "key", "", "Path to TPM key blob file")
-	signCmd.StringVar(&signOpts.Message, "message", "", "Message to sign")
-	signCmd.StringVar(&signOpts.OutputFilePath, "output", "", "Output file for the signed message")
-	signCmd.BoolVar(&useTPM, "use-real-tpm", false, "Use real TPM instead of swtpm")
-
-	// Define flags for the verify subcommand
-	verifyCmd.StringVar(&verifyOpts.PublicKeyPath, "pubkey", "", "Path to the public key file")
-	verifyCmd.StringVar(&verifyOpts.Message, "message", "", "Message to verify")
-	verifyCmd.StringVar(&verifyOpts.SignaturePath, "signature", "", "Path to the signature file")
-	verifyCmd.BoolVar(&useTPM, "use-real-tpm", false, "Use real TPM instead of swtpm")
-
-	if len(os.Args) < 2 {
-		flag.Usage()
-		return fmt.Errorf("missing subcommand")
-	}
-
-	switch subcmd := os.Args[1]; subcmd {
-	// commands involving a TPM
-	case "create", "decrypt", "sign":
-		switch subcmd {
-		case "create":
-			createCmd.Parse(os.Args[2:])
-		case "decrypt":
-			decryptCmd.Parse(os.Args[2:])
-		case "sign":
-			signCmd.Parse(os.Args[2:])
-		}
-
-		var device tpmutil.Device
-		if useTPM {
-			device = tpmutil.LINUX
-		} else {
-			device = tpmutil.SWTPM
-		}
-
-		tpm, err := tpmutil.OpenTPM(device)
-		if err != nil {
-			return fmt.Errorf("can't open tpm: %w", err)
-		}
-		defer tpm.Close()
-
-		if subcmd == "create" {
-			if err := createCommand(tpm, createOpts); err != nil {
-				return fmt.Errorf("error creating key: %w", err)
-			}
-			fmt.Println("Ordinary key created successfully 🚀")
-		}
-		if subcmd == "decrypt" {
-			secret, err := decryptCommand(tpm, decryptOpts)
-			if err != nil {
-				return fmt.Errorf("error decrypting blob: %w", err)
-			}
-			fmt.Printf("Decrypted %q successfully 🚀\n", secret)
-		}
-		if subcmd == "sign" {
-			if err := signCommand(tpm, signOpts); err != nil {
-				return fmt.Errorf("error signing message: %w", err)
-			}
-			fmt.Printf("Signature saved to %s 🚀\n", signOpts.OutputFilePath)
-		}
-	case "encrypt":
-		encryptCmd.Parse(os.Args[2:])
-		if err := encryptCommand(encryptOpts); err != nil {
-			return fmt.Errorf("error encrypting message: %w", err)
-		}
-		fmt.Printf("Encrypted message saved to %s 🚀\n", encryptOpts.OutputFilePath)
-	case "verify":
-		verifyCmd.Parse(os.Args[2:])
-		if err := verifyCommand(verifyOpts); err != nil {
-			return fmt.Errorf("error verifying signature: %w", err)
-		}
-		fmt.Println("Signature verified successfully 🚀")
-	case "cleanup":
-		if err := os.RemoveAll(tpmutil.SWTPM_ROOT_STATE); err != nil {
-			return fmt.Errorf("error cleaning state: %w", err)
-		}
-		fmt.Println("State cleaned successfully 🚀")
-	default:
-		return fmt.Errorf("unknown subcommand %q. Expected 'create', 'encrypt', 'decrypt', 'sign', 'verify' or 'cleanup'", subcmd)
+	return []*cli.Command{
+		{
+			Name:  "asym",
+			Usage: "Perform crypto operations with asymmetric keys",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "create",
+					Usage: "Create an asymmetric key",
+					Flags: func(fs *flag.FlagSet) {
+						fs.StringVar(&createOpts.OutputDir, "out", "", "Output directory for the created key")
+						fs.StringVar(&createOpts.KeyType, "type", "decrypt", "Key type to create (decrypt, signer or restricted-signer)")
+						fs.StringVar(&createOpts.Alg, "alg", "", "Key algorithm: rsa2048, rsa3072, rsa4096, ecc256, ecc384 or ecc521, optionally followed by the name algorithm (e.g. ecc256:sha384)")
+						fs.StringVar(&createOpts.TemplatePath, "template", "", "JSON template spec of the key, overrides --type (see 'template export')")
+						fs.StringVar(&createOpts.Attributes, "attributes", "", "Object attributes overriding the template's (e.g. 'fixedtpm|fixedparent|sensitivedataorigin|userwithauth|sign')")
+						cli.AuthFlags(fs, &createOpts.AuthOpts)
+					},
+					Run: func(env *cli.Env) error {
+						tpm, err := env.TPM()
+						if err != nil {
+							return err
+						}
+						if err := createCommand(tpm, createOpts); err != nil {
+							return fmt.Errorf("error creating key: %w", err)
+						}
+						fmt.Fprintln(env.Stdout, "Ordinary key created successfully 🚀")
+						return nil
+					},
+				},
+				{
+					Name:  "encrypt",
+					Usage: "Encrypt a message with a RSA public key",
+					Flags: func(fs *flag.FlagSet) {
+						fs.StringVar(&encryptOpts.PublicKeyPath, "pubkey", "", "Path to the public key file")
+						fs.StringVar(&encryptOpts.Message, "message", "", "Message to encrypt")
+						fs.StringVar(&encryptOpts.OutputFilePath, "output", "", "Output file for the encrypted message")
+					},
+					Run: func(env *cli.Env) error {
+						if err := encryptCommand(encryptOpts); err != nil {
+							return fmt.Errorf("error encrypting message: %w", err)
+						}
+						fmt.Fprintf(env.Stdout, "Encrypted message saved to %s 🚀\n", encryptOpts.OutputFilePath)
+						return nil
+					},
+				},
+				{
+					Name:  "decrypt",
+					Usage: "Decrypt a blob with a TPM key",
+					Flags: func(fs *flag.FlagSet) {
+						fs.StringVar(&decryptOpts.KeyBlobPath, "key", "", "Path to TPM key blob file")
+						fs.StringVar(&decryptOpts.InputFilePath, "in", "", "Input file to decrypt")
+						cli.AuthFlags(fs, &decryptOpts.AuthOpts)
+					},
+					Run: func(env *cli.Env) error {
+						tpm, err := env.TPM()
+						if err != nil {
+							return err
+						}
+						secret, err := decryptCommand(tpm, decryptOpts)
+						if err != nil {
+							return fmt.Errorf("error decrypting blob: %w", err)
+						}
+						fmt.Fprintf(env.Stdout, "Decrypted %q successfully 🚀\n", secret)
+						return nil
+					},
+				},
+				{
+					Name:  "sign",
+					Usage: "Sign a message with a TPM key",
+					Flags: func(fs *flag.FlagSet) {
+						fs.StringVar(&signOpts.KeyBlobPath, "key", "", "Path to TPM key blob file")
+						fs.StringVar(&signOpts.Message, "message", "", "Message to sign")
+						fs.StringVar(&signOpts.OutputFilePath, "output", "", "Output file for the signed message")
+						fs.StringVar(&signOpts.Scheme, "scheme", "", "Signing scheme: rsassa, rsapss or ecdsa (default: the scheme of the key, or rsassa/ecdsa)")
+						fs.StringVar(&signOpts.Hash, "hash", "", "Hash algorithm: sha256, sha384 or sha512 (default: the hash of the key scheme, or the one matching the key)")
+						fs.StringVar(&signOpts.Format, "format", options.SignatureFormatDER, "ECDSA signature format: der or raw (r||s)")
+						cli.AuthFlags(fs, &signOpts.AuthOpts)
+					},
+					Run: func(env *cli.Env) error {
+						tpm, err := env.TPM()
+						if err != nil {
+							return err
+						}
+						if err := signCommand(tpm, signOpts); err != nil {
+							return fmt.Errorf("error signing message: %w", err)
+						}
+						fmt.Fprintf(env.Stdout, "Signature saved to %s 🚀\n", signOpts.OutputFilePath)
+						return nil
+					},
+				},
+				{
+					Name:  "verify",
+					Usage: "Verify a signature with a public key",
+					Flags: func(fs *flag.FlagSet) {
+						fs.StringVar(&verifyOpts.PublicKeyPath, "pubkey", "", "Path to the public key file")
+						fs.StringVar(&verifyOpts.Message, "message", "", "Message to verify")
+						fs.StringVar(&verifyOpts.SignaturePath, "signature", "", "Path to the signature file")
+						fs.StringVar(&verifyOpts.Scheme, "scheme", "", "Signing scheme: rsassa (default for RSA keys), rsapss or ecdsa")
+						fs.StringVar(&verifyOpts.Hash, "hash", "", "Hash algorithm: sha256, sha384 or sha512 (default: sha256, or the hash matching the curve of an ECC key)")
+						fs.StringVar(&verifyOpts.Format, "format", options.SignatureFormatDER, "ECDSA signature format: der or raw (r||s)")
+					},
+					Run: func(env *cli.Env) error {
+						if err := verifyCommand(verifyOpts); err != nil {
+							return fmt.Errorf("error verifying signature: %w", err)
+						}
+						fmt.Fprintln(env.Stdout, "Signature verified successfully 🚀")
+						return nil
+					},
+				},
+			},
+		},
 	}
-	return nil
 }
 
 func createCommand(tpm transport.TPM, opts *options.CreateKeyOpts) error {
 	if err := opts.CheckAndSetDefaults(); err != nil {
 		return err
 	}
+	policy, err := tpmutil.AuthPolicy(&opts.AuthOpts)
+	if err != nil {
+		return err
+	}
 
-	var template tpm2.TPMTPublic
-	switch opts.GetKeyType() {
-	case options.Decrypt:
-		template = tpmutil.RSAEncryptTemplate
-	case options.Signer:
-		template = tpmutil.ECCSignerTemplate
-	case options.RestrictedSigner:
-		template = tpmutil.ECCRestrictedSignerTemplate
-	default:
+	template, ok := tpmutil.AsymTemplatesByKeyType[opts.GetKeyType()]
+	if !ok {
 		return fmt.Errorf("unknown key type %q. Expected 'decrypt', 'signer' or 'restricted-signer'", opts.GetKeyType())
 	}
+	if opts.Alg != "" {
+		alg, err := tpmutil.ParseKeyAlg(opts.Alg)
+		if err != nil {
+			return fmt.Errorf("invalid input: %w", err)
+		}
+		if template, err = tpmutil.AsymTemplate(opts.GetKeyType(), alg); err != nil {
+			return err
+		}
+		if err := tpmutil.CheckTemplateSupported(tpm, template); err != nil {
+			return fmt.Errorf("%s: %w", alg, err)
+		}
+	}
+	template, err = tpmutil.KeyTemplate(opts, template)
+	if err != nil {
+		return err
+	}
 
 	return tpmutil.CreateKey(tpm, tpmutil.CreateKeyConfig{
 		OutDir:           opts.OutputDir,
 		ParentTemplate:   tpmutil.ECCSRKTemplate,
 		OrdinaryTemplate: template,
 		CreatePublicKey:  true,
+		UserAuth:         opts.GetAuth(),
+		Policy:           policy,
 	})
 }
 
@@ -195,11 +207,7 @@ func decryptCommand(tpm transport.TPM, opts *options.AsymDecryptOpts) ([]byte, e
 		return nil, err
 	}
 
-	return decryptBlob(tpm,
-		tpmutil.ECCSRKTemplate,
-		opts.InputFilePath,
-		opts.KeyBlobPath,
-	)
+	return decryptBlob(tpm, tpmutil.ECCSRKTemplate, opts)
 }
 
 func signCommand(tpm transport.TPM, opts *options.SignOpts) error {
@@ -207,11 +215,7 @@ func signCommand(tpm transport.TPM, opts *options.SignOpts) error {
 		return err
 	}
 
-	signature, err := signBlob(tpm,
-		tpmutil.ECCSRKTemplate,
-		opts.Message,
-		opts.KeyBlobPath,
-	)
+	signature, err := signBlob(tpm, tpmutil.ECCSRKTemplate, opts)
 	if err != nil {
 		return err
 	}
@@ -226,29 +230,13 @@ func verifyCommand(opts *options.VerifyOpts) error {
 		return err
 	}
 
-	msgDigest := sha256.Sum256([]byte(opts.Message))
-
 	pubKey, err := pemutil.Read(opts.PublicKeyPath)
 	if err != nil {
 		return fmt.Errorf("error reading public key: %w", err)
 	}
-	ecdsaKey, ok := pubKey.(*ecdsa.PublicKey)
-	if !ok {
-		return fmt.Errorf("error converting public key to ECDSA public key")
-	}
-
 	sig, err := os.ReadFile(opts.SignaturePath)
 	if err != nil {
 		return fmt.Errorf("error reading signature file: %w", err)
 	}
-	ecdsaSig := new(ecdsaSignature)
-	_, err = asn1.Unmarshal(sig, ecdsaSig)
-	if err != nil {
-		return fmt.Errorf("error unmarshalling signature: %w", err)
-	}
-	valid := ecdsa.Verify(ecdsaKey, msgDigest[:], ecdsaSig.R, ecdsaSig.S)
-	if !valid {
-		return fmt.Errorf("signature verification failed")
-	}
-	return nil
+	return verifySignature(pubKey, []byte(opts.Message), sig, opts)
 }
@@ -1,12 +1,15 @@
-package main
+package pill05
 
 import (
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/google/go-tpm/tpm2"
 	"github.com/loicsikidi/go-tpm-kit/tpmtest"
 	"github.com/loicsikidi/tpm-pills/internal/options"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
 	"github.com/stretchr/testify/require"
 )
 
@@ -142,3 +145,219 @@ func TestRestrictedSignerWorkflow(t *testing.T) {
 	err = verifyCommand(verifyOpts)
 	require.NoError(t, err)
 }
+
+// TestCreateFromTemplate tests that a JSON template spec overrides the key type.
+func TestCreateFromTemplate(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	tempDir := t.TempDir()
+	templatePath := filepath.Join(tempDir, "rsa-encrypt.json")
+	encryptedPath := filepath.Join(tempDir, "blob.enc")
+	message := "Hello TPM Pills!"
+
+	spec, err := tpmutil.NewTemplateSpec(tpmutil.RSAEncryptTemplate)
+	require.NoError(t, err)
+	b, err := json.Marshal(spec)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(templatePath, b, 0644))
+
+	createOpts := &options.CreateKeyOpts{
+		OutputDir:    tempDir,
+		KeyType:      options.Signer.String(),
+		TemplatePath: templatePath,
+	}
+	require.NoError(t, createCommand(tpm, createOpts))
+
+	encryptOpts := &options.EncryptOpts{
+		PublicKeyPath:  filepath.Join(tempDir, "public.pem"),
+		Message:        message,
+		OutputFilePath: encryptedPath,
+	}
+	require.NoError(t, encryptCommand(encryptOpts))
+	decrypted, err := decryptCommand(tpm, &options.AsymDecryptOpts{
+		KeyBlobPath:   filepath.Join(tempDir, "key.tpm"),
+		InputFilePath: encryptedPath,
+	})
+	require.NoError(t, err)
+	require.Equal(t, message, string(decrypted))
+
+	createOpts.TemplatePath = filepath.Join(tempDir, "missing.json")
+	require.ErrorContains(t, createCommand(tpm, createOpts), "TemplatePath does not exist")
+}
+
+// TestAlgorithmMatrix tests the workflows with the key algorithms selected by --alg.
+func TestAlgorithmMatrix(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	message := "Hello TPM Pills!"
+
+	for _, alg := range []string{"ecc384", "ecc521", "ecc256:sha384"} {
+		t.Run("signer/"+alg, func(t *testing.T) {
+			tempDir := t.TempDir()
+			require.NoError(t, createCommand(tpm, &options.CreateKeyOpts{
+				OutputDir: tempDir,
+				KeyType:   options.Signer.String(),
+				Alg:       alg,
+			}))
+			signaturePath := filepath.Join(tempDir, "message.sig")
+			require.NoError(t, signCommand(tpm, &options.SignOpts{
+				KeyBlobPath:    filepath.Join(tempDir, "key.tpm"),
+				Message:        message,
+				OutputFilePath: signaturePath,
+			}))
+			require.NoError(t, verifyCommand(&options.VerifyOpts{
+				PublicKeyPath: filepath.Join(tempDir, "public.pem"),
+				Message:       message,
+				SignaturePath: signaturePath,
+			}))
+		})
+	}
+
+	t.Run("decrypt/rsa2048:sha512", func(t *testing.T) {
+		tempDir := t.TempDir()
+		encryptedPath := filepath.Join(tempDir, "blob.enc")
+		require.NoError(t, createCommand(tpm, &options.CreateKeyOpts{
+			OutputDir: tempDir,
+			KeyType:   options.Decrypt.String(),
+			Alg:       "rsa2048:sha512",
+		}))
+		require.NoError(t, encryptCommand(&options.EncryptOpts{
+			PublicKeyPath:  filepath.Join(tempDir, "public.pem"),
+			Message:        message,
+			OutputFilePath: encryptedPath,
+		}))
+		decrypted, err := decryptCommand(tpm, &options.AsymDecryptOpts{
+			KeyBlobPath:   filepath.Join(tempDir, "key.tpm"),
+			InputFilePath: encryptedPath,
+		})
+		require.NoError(t, err)
+		require.Equal(t, message, string(decrypted))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		opts := &options.CreateKeyOpts{OutputDir: t.TempDir(), KeyType: options.Decrypt.String(), Alg: "ecc384"}
+		require.ErrorContains(t, createCommand(tpm, opts), "decrypt keys must be RSA keys")
+		opts.Alg = "dsa1024"
+		require.ErrorContains(t, createCommand(tpm, opts), "invalid key algorithm")
+		// the simulator is built without RSA 4096
+		opts.Alg = "rsa4096"
+		require.ErrorContains(t, createCommand(tpm, opts), "doesn't support")
+	})
+}
+
+// TestSigningSchemes tests the RSASSA, RSAPSS and ECDSA schemes and the signature formats.
+func TestSigningSchemes(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	message := "Hello TPM Pills!"
+
+	createKey := func(t *testing.T, kty options.KeyType, alg string) string {
+		tempDir := t.TempDir()
+		require.NoError(t, createCommand(tpm, &options.CreateKeyOpts{
+			OutputDir: tempDir,
+			KeyType:   kty.String(),
+			Alg:       alg,
+		}))
+		return tempDir
+	}
+	sign := func(dir string, opts options.SignOpts) error {
+		opts.KeyBlobPath = filepath.Join(dir, "key.tpm")
+		opts.Message = message
+		opts.OutputFilePath = filepath.Join(dir, "message.sig")
+		return signCommand(tpm, &opts)
+	}
+	verify := func(dir string, opts options.VerifyOpts) error {
+		opts.PublicKeyPath = filepath.Join(dir, "public.pem")
+		opts.Message = message
+		opts.SignaturePath = filepath.Join(dir, "message.sig")
+		return verifyCommand(&opts)
+	}
+
+	t.Run("rsa", func(t *testing.T) {
+		dir := createKey(t, options.Signer, "rsa2048")
+
+		require.NoError(t, sign(dir, options.SignOpts{}))
+		require.NoError(t, verify(dir, options.VerifyOpts{}))
+		require.Error(t, verify(dir, options.VerifyOpts{Scheme: "rsapss"}))
+
+		require.NoError(t, sign(dir, options.SignOpts{Scheme: "rsapss", Hash: "sha384"}))
+		require.NoError(t, verify(dir, options.VerifyOpts{Scheme: "rsapss", Hash: "sha384"}))
+		require.Error(t, verify(dir, options.VerifyOpts{Scheme: "rsapss"}))
+		require.ErrorContains(t, verify(dir, options.VerifyOpts{Scheme: "ecdsa"}), "RSA keys can't verify")
+
+		require.ErrorContains(t, sign(dir, options.SignOpts{Scheme: "ecdsa"}), "rsa keys can't sign with ecdsa")
+		require.ErrorContains(t, sign(dir, options.SignOpts{Scheme: "rsaes"}), "unsupported scheme")
+	})
+
+	t.Run("rsa name algorithm", func(t *testing.T) {
+		dir := createKey(t, options.Signer, "rsa2048:sha384")
+
+		// the name algorithm doesn't change the default hash, which verify infers from the key
+		require.NoError(t, sign(dir, options.SignOpts{}))
+		require.NoError(t, verify(dir, options.VerifyOpts{}))
+		require.Error(t, verify(dir, options.VerifyOpts{Hash: "sha384"}))
+
+		require.NoError(t, sign(dir, options.SignOpts{Hash: "sha384"}))
+		require.Error(t, verify(dir, options.VerifyOpts{}))
+		require.NoError(t, verify(dir, options.VerifyOpts{Hash: "sha384"}))
+	})
+
+	t.Run("restricted rsa", func(t *testing.T) {
+		dir := createKey(t, options.RestrictedSigner, "rsa2048")
+
+		require.NoError(t, sign(dir, options.SignOpts{}))
+		require.NoError(t, verify(dir, options.VerifyOpts{}))
+		require.ErrorContains(t, sign(dir, options.SignOpts{Scheme: "rsapss"}), "the key only signs with rsassa/sha256")
+	})
+
+	t.Run("ecdsa raw", func(t *testing.T) {
+		dir := createKey(t, options.Signer, "ecc384")
+
+		require.NoError(t, sign(dir, options.SignOpts{Format: options.SignatureFormatRaw}))
+		sig, err := os.ReadFile(filepath.Join(dir, "message.sig"))
+		require.NoError(t, err)
+		require.Len(t, sig, 2*48)
+		require.NoError(t, verify(dir, options.VerifyOpts{Format: options.SignatureFormatRaw}))
+		require.Error(t, verify(dir, options.VerifyOpts{}))
+
+		require.ErrorContains(t, sign(dir, options.SignOpts{Hash: "sha256"}), "the key only signs with ecdsa/sha384")
+		require.ErrorContains(t, sign(dir, options.SignOpts{Format: "pem"}), "unsupported Format")
+	})
+}
+
+// TestSignWithAuth tests a signer key protected by a password:
+// 1. Create the key with a password
+// 2. Signing with a wrong password fails (and counts as a dictionary attack failure)
+// 3. Signing with the password succeeds
+func TestSignWithAuth(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+
+	tempDir := t.TempDir()
+	keyPath := filepath.Join(tempDir, "key.tpm")
+	signaturePath := filepath.Join(tempDir, "message.sig")
+
+	// 1. Create signer key
+	createOpts := &options.CreateKeyOpts{
+		OutputDir: tempDir,
+		KeyType:   options.Signer.String(),
+		AuthOpts:  options.AuthOpts{Auth: "p@ssw0rd"},
+	}
+	require.NoError(t, createCommand(tpm, createOpts))
+
+	// 2. Sign with a wrong password
+	signOpts := &options.SignOpts{
+		KeyBlobPath:    keyPath,
+		Message:        "Hello TPM Pills!",
+		OutputFilePath: signaturePath,
+		AuthOpts:       options.AuthOpts{Auth: "wrong"},
+	}
+	err := signCommand(tpm, signOpts)
+	require.ErrorIs(t, err, tpm2.TPMRCAuthFail)
+	require.NoFileExists(t, signaturePath)
+
+	// 3. Sign with the password
+	signOpts.AuthOpts = options.AuthOpts{Auth: "p@ssw0rd"}
+	require.NoError(t, signCommand(tpm, signOpts))
+	require.NoError(t, verifyCommand(&options.VerifyOpts{
+		PublicKeyPath: filepath.Join(tempDir, "public.pem"),
+		Message:       signOpts.Message,
+		SignaturePath: signaturePath,
+	}))
+}
@@ -1,8 +1,10 @@
 //go:build !windows
 
-package main
+package pill05
 
 import (
+	"crypto"
+	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
@@ -10,9 +12,11 @@ import (
 	"fmt"
 	"math/big"
 	"os"
+	"slices"
 
 	"github.com/google/go-tpm/tpm2"
 	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/tpm-pills/internal/options"
 	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
 	"github.com/loicsikidi/tpm-pills/internal/utils"
 )
@@ -21,20 +25,32 @@ type ecdsaSignature struct {
 	R, S *big.Int
 }
 
-func decryptBlob(tpm transport.TPM, primaryTemplate tpm2.TPMTPublic, inPath, keyBlobPath string) ([]byte, error) {
+func decryptBlob(tpm transport.TPM, primaryTemplate tpm2.TPMTPublic, opts *options.AsymDecryptOpts) ([]byte, error) {
+	policy, err := tpmutil.AuthPolicy(&opts.AuthOpts)
+	if err != nil {
+		return nil, err
+	}
 	keyHandle, err := tpmutil.LoadKey(tpm, tpmutil.LoadKeyConfig{
 		ParentTemplate: primaryTemplate,
-		KeyBlobPath:    keyBlobPath,
+		KeyBlobPath:    opts.KeyBlobPath,
+		Auth:           opts.GetAuth(),
+		Policy:         policy,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to load key: %w", err)
 	}
 	defer keyHandle.Close()
 
-	ciphertext, _ := utils.ReadFile(inPath)
+	keyAuth, closer, err := tpmutil.AuthorizeKey(tpm, keyHandle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to authorize key: %w", err)
+	}
+	defer closer()
+
+	ciphertext, _ := utils.ReadFile(opts.InputFilePath)
 
 	decryptCmd := tpm2.RSADecrypt{
-		KeyHandle:  keyHandle,
+		KeyHandle:  keyAuth,
 		CipherText: tpm2.TPM2BPublicKeyRSA{Buffer: ciphertext},
 		InScheme: tpm2.TPMTRSADecrypt{
 			Scheme: tpm2.TPMAlgOAEP,
@@ -74,10 +90,16 @@ func encryptBlob(pub *rsa.PublicKey, message []byte) ([]byte, error) {
 	return ciphertext, nil
 }
 
-func signBlob(tpm transport.TPM, primaryTemplate tpm2.TPMTPublic, message, keyBlobPath string) ([]byte, error) {
+func signBlob(tpm transport.TPM, primaryTemplate tpm2.TPMTPublic, opts *options.SignOpts) ([]byte, error) {
+	policy, err := tpmutil.AuthPolicy(&opts.AuthOpts)
+	if err != nil {
+		return nil, err
+	}
 	keyHandle, err := tpmutil.LoadKey(tpm, tpmutil.LoadKeyConfig{
 		ParentTemplate: primaryTemplate,
-		KeyBlobPath:    keyBlobPath,
+		KeyBlobPath:    opts.KeyBlobPath,
+		Auth:           opts.GetAuth(),
+		Policy:         policy,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to load key: %w", err)
@@ -87,6 +109,14 @@ func signBlob(tpm transport.TPM, primaryTemplate tpm2.TPMTPublic, message, keyBl
 	if !keyHandle.HasPublic() {
 		return nil, fmt.Errorf("key handle does not have a public key")
 	}
+	scheme, err := signingScheme(keyHandle.Public(), opts.Scheme, opts.Hash)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := scheme.hash.Hash()
+	if err != nil {
+		return nil, err
+	}
 
 	var (
 		digest     tpm2.TPM2BDigest
@@ -94,8 +124,8 @@ func signBlob(tpm transport.TPM, primaryTemplate tpm2.TPMTPublic, message, keyBl
 	)
 	if keyHandle.Public().ObjectAttributes.Restricted {
 		rspHash, err := tpm2.Hash{
-			Data:      tpm2.TPM2BMaxBuffer{Buffer: []byte(message)},
-			HashAlg:   tpm2.TPMAlgSHA256,
+			Data:      tpm2.TPM2BMaxBuffer{Buffer: []byte(opts.Message)},
+			HashAlg:   scheme.hash,
 			Hierarchy: tpm2.TPMRHOwner,
 		}.Execute(tpm)
 		if err != nil {
@@ -104,9 +134,8 @@ func signBlob(tpm transport.TPM, primaryTemplate tpm2.TPMTPublic, message, keyBl
 		digest = rspHash.OutHash
 		validation = rspHash.Validation
 	} else {
-		msgDigest := sha256.Sum256([]byte(message))
 		digest = tpm2.TPM2BDigest{
-			Buffer: msgDigest[:],
+			Buffer: hashMessage(hash, []byte(opts.Message)),
 		}
 		// NULL ticket
 		validation = tpm2.TPMTTKHashCheck{
@@ -115,24 +144,66 @@ func signBlob(tpm transport.TPM, primaryTemplate tpm2.TPMTPublic, message, keyBl
 		}
 	}
 
+	keyAuth, closer, err := tpmutil.AuthorizeKey(tpm, keyHandle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to authorize key: %w", err)
+	}
+	defer closer()
+
 	signRsp, err := tpm2.Sign{
-		KeyHandle:  keyHandle,
+		KeyHandle:  keyAuth,
 		Digest:     digest,
+		InScheme:   scheme.tpmScheme(),
 		Validation: validation,
 	}.Execute(tpm)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute sign command: %w", err)
 	}
+	return encodeSignature(&signRsp.Signature, keyHandle.Public(), opts.Format)
+}
+
+// encodeSignature returns the signature as written in a signature file.
+// RSA signatures are written as is, ECDSA ones follow format (see [options.SignatureFormatDER]).
+func encodeSignature(signature *tpm2.TPMTSignature, pub *tpm2.TPMTPublic, format string) ([]byte, error) {
+	switch signature.SigAlg {
+	case tpm2.TPMAlgRSASSA:
+		rsaSig, err := signature.Signature.RSASSA()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get RSASSA signature: %w", err)
+		}
+		return rsaSig.Sig.Buffer, nil
+	case tpm2.TPMAlgRSAPSS:
+		rsaSig, err := signature.Signature.RSAPSS()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get RSAPSS signature: %w", err)
+		}
+		return rsaSig.Sig.Buffer, nil
+	}
 
-	eccSig, err := signRsp.Signature.Signature.ECDSA()
+	eccSig, err := signature.Signature.ECDSA()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get ECDSA signature: %w", err)
 	}
-
 	r := new(big.Int).SetBytes(eccSig.SignatureR.Buffer)
 	s := new(big.Int).SetBytes(eccSig.SignatureS.Buffer)
 
+	if format == options.SignatureFormatRaw {
+		eccDetail, err := pub.Parameters.ECCDetail()
+		if err != nil {
+			return nil, err
+		}
+		curve, err := eccDetail.CurveID.Curve()
+		if err != nil {
+			return nil, err
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		raw := make([]byte, 2*size)
+		r.FillBytes(raw[:size])
+		s.FillBytes(raw[size:])
+		return raw, nil
+	}
+
 	sig := ecdsaSignature{R: r, S: s}
 	der, err := asn1.Marshal(sig)
 	if err != nil {
@@ -140,3 +211,198 @@ func signBlob(tpm transport.TPM, primaryTemplate tpm2.TPMTPublic, message, keyBl
 	}
 	return der, nil
 }
+
+// signScheme is a signing scheme along with its hash algorithm.
+type signScheme struct {
+	alg  tpm2.TPMAlgID
+	hash tpm2.TPMAlgID
+}
+
+func (s signScheme) tpmScheme() tpm2.TPMTSigScheme {
+	return tpm2.TPMTSigScheme{
+		Scheme:  s.alg,
+		Details: tpm2.NewTPMUSigScheme(s.alg, &tpm2.TPMSSchemeHash{HashAlg: s.hash}),
+	}
+}
+
+// parseScheme parses the scheme and the hash requested by the user, both are optional.
+func parseScheme(schemeName, hashName string) (signScheme, error) {
+	var s signScheme
+	var err error
+	if schemeName != "" {
+		if s.alg, err = tpmutil.ParseAlg(schemeName); err != nil {
+			return s, fmt.Errorf("invalid input: invalid scheme: %w", err)
+		}
+		if !slices.Contains([]tpm2.TPMAlgID{tpm2.TPMAlgRSASSA, tpm2.TPMAlgRSAPSS, tpm2.TPMAlgECDSA}, s.alg) {
+			return s, fmt.Errorf("invalid input: unsupported scheme %q (expected rsassa, rsapss or ecdsa)", schemeName)
+		}
+	}
+	if hashName != "" {
+		if s.hash, err = tpmutil.ParseAlg(hashName); err != nil {
+			return s, fmt.Errorf("invalid input: invalid hash: %w", err)
+		}
+		if _, err := s.hash.Hash(); err != nil {
+			return s, fmt.Errorf("invalid input: unsupported hash %q", hashName)
+		}
+	}
+	return s, nil
+}
+
+// signingScheme resolves the scheme used to sign with a key.
+//
+// A key bound to a scheme (e.g. a restricted key) only signs with it, otherwise
+// the scheme defaults to rsassa or ecdsa and the hash to the one verify infers from the public key:
+// SHA-256 for RSA keys and the hash matching the curve of ECC keys (see [tpmutil.CurveHash]).
+func signingScheme(pub *tpm2.TPMTPublic, schemeName, hashName string) (signScheme, error) {
+	requested, err := parseScheme(schemeName, hashName)
+	if err != nil {
+		return signScheme{}, err
+	}
+
+	var keyScheme signScheme
+	var supported []tpm2.TPMAlgID
+	defaultHash := tpm2.TPMAlgSHA256
+	switch pub.Type {
+	case tpm2.TPMAlgRSA:
+		rsaDetail, err := pub.Parameters.RSADetail()
+		if err != nil {
+			return signScheme{}, err
+		}
+		keyScheme.alg = rsaDetail.Scheme.Scheme
+		switch keyScheme.alg {
+		case tpm2.TPMAlgRSASSA:
+			details, err := rsaDetail.Scheme.Details.RSASSA()
+			if err != nil {
+				return signScheme{}, err
+			}
+			keyScheme.hash = details.HashAlg
+		case tpm2.TPMAlgRSAPSS:
+			details, err := rsaDetail.Scheme.Details.RSAPSS()
+			if err != nil {
+				return signScheme{}, err
+			}
+			keyScheme.hash = details.HashAlg
+		}
+		supported = []tpm2.TPMAlgID{tpm2.TPMAlgRSASSA, tpm2.TPMAlgRSAPSS}
+	case tpm2.TPMAlgECC:
+		eccDetail, err := pub.Parameters.ECCDetail()
+		if err != nil {
+			return signScheme{}, err
+		}
+		keyScheme.alg = eccDetail.Scheme.Scheme
+		defaultHash = tpmutil.CurveHash(eccDetail.CurveID)
+		if keyScheme.alg == tpm2.TPMAlgECDSA {
+			details, err := eccDetail.Scheme.Details.ECDSA()
+			if err != nil {
+				return signScheme{}, err
+			}
+			keyScheme.hash = details.HashAlg
+		}
+		supported = []tpm2.TPMAlgID{tpm2.TPMAlgECDSA}
+	default:
+		return signScheme{}, fmt.Errorf("unsupported signing key type %s", tpmutil.AlgName(pub.Type))
+	}
+
+	if keyScheme.alg != 0 && keyScheme.alg != tpm2.TPMAlgNull {
+		if !slices.Contains(supported, keyScheme.alg) {
+			return signScheme{}, fmt.Errorf("unsupported key scheme %s", tpmutil.AlgName(keyScheme.alg))
+		}
+		if (requested.alg != 0 && requested.alg != keyScheme.alg) || (requested.hash != 0 && requested.hash != keyScheme.hash) {
+			return signScheme{}, fmt.Errorf("invalid input: the key only signs with %s/%s", tpmutil.AlgName(keyScheme.alg), tpmutil.AlgName(keyScheme.hash))
+		}
+		return keyScheme, nil
+	}
+
+	if requested.alg == 0 {
+		requested.alg = supported[0]
+	} else if !slices.Contains(supported, requested.alg) {
+		return signScheme{}, fmt.Errorf("invalid input: %s keys can't sign with %s", tpmutil.AlgName(pub.Type), tpmutil.AlgName(requested.alg))
+	}
+	if requested.hash == 0 {
+		requested.hash = defaultHash
+	}
+	return requested, nil
+}
+
+// verifySignature checks the signature of a message, the scheme is keyed off the type of pub.
+func verifySignature(pub crypto.PublicKey, message, sig []byte, opts *options.VerifyOpts) error {
+	requested, err := parseScheme(opts.Scheme, opts.Hash)
+	if err != nil {
+		return err
+	}
+
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		hash, err := verifyHash(requested.hash, crypto.SHA256)
+		if err != nil {
+			return err
+		}
+		digest := hashMessage(hash, message)
+		switch requested.alg {
+		case 0, tpm2.TPMAlgRSASSA:
+			err = rsa.VerifyPKCS1v15(key, hash, digest, sig)
+		case tpm2.TPMAlgRSAPSS:
+			err = rsa.VerifyPSS(key, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto})
+		default:
+			return fmt.Errorf("invalid input: RSA keys can't verify %s signatures", opts.Scheme)
+		}
+		if err != nil {
+			return fmt.Errorf("signature verification failed: %w", err)
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if requested.alg != 0 && requested.alg != tpm2.TPMAlgECDSA {
+			return fmt.Errorf("invalid input: ECC keys can't verify %s signatures", opts.Scheme)
+		}
+		hash, err := verifyHash(requested.hash, curveHash(key))
+		if err != nil {
+			return err
+		}
+		var r, s *big.Int
+		if opts.Format == options.SignatureFormatRaw {
+			size := (key.Curve.Params().BitSize + 7) / 8
+			if len(sig) != 2*size {
+				return fmt.Errorf("invalid raw signature: got %d bytes, want %d", len(sig), 2*size)
+			}
+			r, s = new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		} else {
+			ecdsaSig := new(ecdsaSignature)
+			if _, err := asn1.Unmarshal(sig, ecdsaSig); err != nil {
+				return fmt.Errorf("error unmarshalling signature: %w", err)
+			}
+			r, s = ecdsaSig.R, ecdsaSig.S
+		}
+		if !ecdsa.Verify(key, hashMessage(hash, message), r, s) {
+			return fmt.Errorf("signature verification failed")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported public key type %T", pub)
+	}
+}
+
+func verifyHash(hashAlg tpm2.TPMAlgID, fallback crypto.Hash) (crypto.Hash, error) {
+	if hashAlg == 0 {
+		return fallback, nil
+	}
+	return hashAlg.Hash()
+}
+
+func hashMessage(hash crypto.Hash, message []byte) []byte {
+	h := hash.New()
+	h.Write(message)
+	return h.Sum(nil)
+}
+
+// curveHash returns the hash matching the strength of an ECDSA key, which is the hash of its signing
+// scheme (see [tpmutil.KeyAlg.SigningHash]) whatever its name algorithm.
+func curveHash(pub *ecdsa.PublicKey) crypto.Hash {
+	switch pub.Curve.Params().BitSize {
+	case 384:
+		return crypto.SHA384
+	case 521:
+		return crypto.SHA512
+	default:
+		return crypto.SHA256
+	}
+}
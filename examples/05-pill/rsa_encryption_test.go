@@ -1,4 +1,4 @@
-package main
+package pill05
 
 import (
 	"bytes"
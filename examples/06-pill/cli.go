@@ -1,6 +1,7 @@
 //go:build !windows
 
-package main
+// Package pill06 holds the commands of pill #6: symmetric encryption, sealing and HMAC.
+package pill06
 
 import (
 	"crypto/aes"
@@ -12,21 +13,14 @@ import (
 
 	"github.com/google/go-tpm/tpm2"
 	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/tpm-pills/internal/cli"
 	"github.com/loicsikidi/tpm-pills/internal/options"
 	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
 	"github.com/loicsikidi/tpm-pills/internal/utils"
 )
 
-var useTPM bool
-
-func main() {
-	if err := run(); err != nil {
-		fmt.Fprintf(os.Stderr, "%v\n", err)
-		os.Exit(1)
-	}
-}
-
-func run() error {
+// Commands returns the commands introduced by pill #6.
+func Commands() []*cli.Command {
 	createOpts := &options.CreateKeyOpts{
 		KeyType: options.Decrypt.String(),
 	}
@@ -36,135 +30,160 @@ func run() error {
 	unsealOpts := &options.UnsealOpts{}
 	hmacOpts := &options.HMACOpts{}
 
-	createCmd := flag.NewFlagSet("create", flag.ExitOnError)
-	encryptCmd := flag.NewFlagSet("encrypt", flag.ExitOnError)
-	decryptCmd := flag.NewFlagSet("decrypt", flag.ExitOnError)
-
-	// Define flags for the create subcommand
-	createCmd.StringVar(&createOpts.OutputDir, "out", "", "Output directory for the created key")
-	createCmd.BoolVar(&useTPM, "use-real-tpm", false, "Use real TPM instead of swtpm")
-
-	// Define flags for the encrypt subcommand
-	encryptCmd.StringVar(&encryptOpts.KeyBlobPath, "key", "", "Path to TPM key blob file")
-	encryptCmd.StringVar(&encryptOpts.Message, "message", "", "Message to encrypt")
-	encryptCmd.StringVar(&encryptOpts.OutputFilePath, "output", "", "Output file for the encrypted message")
-	encryptCmd.BoolVar(&useTPM, "use-real-tpm", false, "Use real TPM instead of swtpm")
-
-	// Define flags for the decrypt subcommand
-	decryptCmd.StringVar(&decryptOpts.KeyBlobPath, "key", "", "Path to TPM key blob file")
-	decryptCmd.StringVar(&decryptOpts.InputFilePath, "in", "", "Input file to decrypt")
-	decryptCmd.BoolVar(&useTPM, "use-real-tpm", false, "Use real TPM instead of swtpm")
-
-	// Define flags for the seal subcommand
-	sealCmd := flag.NewFlagSet("seal", flag.ExitOnError)
-	sealCmd.StringVar(&sealOpts.Message, "message", "", "Message to seal")
-	sealCmd.StringVar(&sealOpts.OutputFilePath, "output", "", "Output file for the sealed message")
-	sealCmd.BoolVar(&useTPM, "use-real-tpm", false, "Use real TPM instead of swtpm")
-
-	// Define flags for the unseal subcommand
-	unsealCmd := flag.NewFlagSet("unseal", flag.ExitOnError)
-	unsealCmd.StringVar(&unsealOpts.InputFilePath, "in", "", "Input file to unseal")
-	unsealCmd.BoolVar(&useTPM, "use-real-tpm", false, "Use real TPM instead of swtpm")
-
-	hmacCmd := flag.NewFlagSet("hmac", flag.ExitOnError)
-	hmacCmd.StringVar(&hmacOpts.Data, "data", "", "Data to compute HMAC for")
-	hmacCmd.BoolVar(&useTPM, "use-real-tpm", false, "Use real TPM instead of swtpm")
-
-	if len(os.Args) < 2 {
-		flag.Usage()
-		return fmt.Errorf("missing subcommand")
-	}
-
-	switch subcmd := os.Args[1]; subcmd {
-	// commands involving a TPM
-	case "create", "encrypt", "decrypt", "seal", "unseal", "hmac":
-		switch subcmd {
-		case "create":
-			createCmd.Parse(os.Args[2:])
-		case "decrypt":
-			decryptCmd.Parse(os.Args[2:])
-		case "encrypt":
-			encryptCmd.Parse(os.Args[2:])
-		case "seal":
-			sealCmd.Parse(os.Args[2:])
-		case "unseal":
-			unsealCmd.Parse(os.Args[2:])
-		case "hmac":
-			hmacCmd.Parse(os.Args[2:])
-		}
-
-		var device tpmutil.Device
-		if useTPM {
-			device = tpmutil.LINUX
-		} else {
-			device = tpmutil.SWTPM
-		}
-
-		tpm, err := tpmutil.OpenTPM(device)
-		if err != nil {
-			return fmt.Errorf("can't open tpm: %w", err)
-		}
-		defer tpm.Close()
-
-		if subcmd == "create" {
-			if err := createCommand(tpm, createOpts); err != nil {
-				return fmt.Errorf("error creating key: %w", err)
-			}
-			fmt.Println("Ordinary key created successfully 🚀")
-		}
-		if subcmd == "encrypt" {
-			if err := encryptCommand(tpm, encryptOpts); err != nil {
-				return fmt.Errorf("error encrypting message: %w", err)
-			}
-			fmt.Printf("Encrypted message saved to %s 🚀\n", encryptOpts.OutputFilePath)
-		}
-		if subcmd == "decrypt" {
-			secret, err := decryptCommand(tpm, decryptOpts)
-			if err != nil {
-				return fmt.Errorf("error decrypting blob: %w", err)
-			}
-			fmt.Printf("Decrypted message: %q 🚀\n", secret)
-		}
-		if subcmd == "seal" {
-			if err := sealCommand(tpm, sealOpts); err != nil {
-				return fmt.Errorf("error sealing message: %w", err)
-			}
-			fmt.Printf("Sealed message saved to %s 🚀\n", sealOpts.OutputFilePath)
-		}
-		if subcmd == "unseal" {
-			unsealedData, err := unsealCommand(tpm, unsealOpts)
-			if err != nil {
-				return fmt.Errorf("error unsealing message: %w", err)
-			}
-			fmt.Printf("Unsealed message: %q 🚀\n", string(unsealedData))
-		}
-		if subcmd == "hmac" {
-			result, err := hmacCommand(tpm, hmacOpts)
-			if err != nil {
-				return fmt.Errorf("error computing HMAC: %w", err)
-			}
-			fmt.Printf("HMAC result: %q 🚀\n", hex.EncodeToString(result))
-
-		}
-	case "cleanup":
-		if err := os.RemoveAll(tpmutil.SWTPM_ROOT_STATE); err != nil {
-			return fmt.Errorf("error cleaning state: %w", err)
-		}
-		fmt.Println("State cleaned successfully 🚀")
-	default:
-		return fmt.Errorf("unknown subcommand %q. Expected 'create', 'encrypt', 'decrypt' or 'cleanup'", subcmd)
+	return []*cli.Command{
+		{
+			Name:  "sym",
+			Usage: "Perform crypto operations with symmetric keys",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "create",
+					Usage: "Create an AES key",
+					Flags: func(fs *flag.FlagSet) {
+						fs.StringVar(&createOpts.OutputDir, "out", "", "Output directory for the created key")
+						fs.StringVar(&createOpts.TemplatePath, "template", "", "JSON template spec of the key (see 'template export')")
+						fs.StringVar(&createOpts.Attributes, "attributes", "", "Object attributes overriding the template's (e.g. 'fixedtpm|fixedparent|sensitivedataorigin|userwithauth|sign')")
+						cli.AuthFlags(fs, &createOpts.AuthOpts)
+					},
+					Run: func(env *cli.Env) error {
+						tpm, err := env.TPM()
+						if err != nil {
+							return err
+						}
+						if err := createCommand(tpm, createOpts); err != nil {
+							return fmt.Errorf("error creating key: %w", err)
+						}
+						fmt.Fprintln(env.Stdout, "Ordinary key created successfully 🚀")
+						return nil
+					},
+				},
+				{
+					Name:  "encrypt",
+					Usage: "Encrypt a message with a TPM key",
+					Flags: func(fs *flag.FlagSet) {
+						fs.StringVar(&encryptOpts.KeyBlobPath, "key", "", "Path to TPM key blob file")
+						fs.StringVar(&encryptOpts.Message, "message", "", "Message to encrypt")
+						fs.StringVar(&encryptOpts.OutputFilePath, "output", "", "Output file for the encrypted message")
+						cli.AuthFlags(fs, &encryptOpts.AuthOpts)
+					},
+					Run: func(env *cli.Env) error {
+						tpm, err := env.TPM()
+						if err != nil {
+							return err
+						}
+						if err := encryptCommand(tpm, encryptOpts); err != nil {
+							return fmt.Errorf("error encrypting message: %w", err)
+						}
+						fmt.Fprintf(env.Stdout, "Encrypted message saved to %s 🚀\n", encryptOpts.OutputFilePath)
+						return nil
+					},
+				},
+				{
+					Name:  "decrypt",
+					Usage: "Decrypt a blob with a TPM key",
+					Flags: func(fs *flag.FlagSet) {
+						fs.StringVar(&decryptOpts.KeyBlobPath, "key", "", "Path to TPM key blob file")
+						fs.StringVar(&decryptOpts.InputFilePath, "in", "", "Input file to decrypt")
+						cli.AuthFlags(fs, &decryptOpts.AuthOpts)
+					},
+					Run: func(env *cli.Env) error {
+						tpm, err := env.TPM()
+						if err != nil {
+							return err
+						}
+						secret, err := decryptCommand(tpm, decryptOpts)
+						if err != nil {
+							return fmt.Errorf("error decrypting blob: %w", err)
+						}
+						fmt.Fprintf(env.Stdout, "Decrypted message: %q 🚀\n", secret)
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "seal",
+			Usage: "Seal a message into the TPM",
+			Flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&sealOpts.Message, "message", "", "Message to seal")
+				fs.StringVar(&sealOpts.OutputFilePath, "output", "", "Output file for the sealed message")
+				fs.StringVar(&sealOpts.PCRs, "pcrs", "", "Bind the sealed message to the values of these PCRs (e.g. 'sha256:0,7,16')")
+				fs.StringVar(&sealOpts.PCRValues, "pcr-values", "", "Expected hex values of --pcrs separated by commas (default: current values)")
+				fs.StringVar(&sealOpts.PCRValuesFile, "pcr-values-file", "", "JSON file holding the expected PCR values, as printed by 'pcr read|compute --format json'")
+				cli.AuthFlags(fs, &sealOpts.AuthOpts)
+			},
+			Run: func(env *cli.Env) error {
+				tpm, err := env.TPM()
+				if err != nil {
+					return err
+				}
+				if err := sealCommand(tpm, sealOpts); err != nil {
+					return fmt.Errorf("error sealing message: %w", err)
+				}
+				fmt.Fprintf(env.Stdout, "Sealed message saved to %s 🚀\n", sealOpts.OutputFilePath)
+				return nil
+			},
+		},
+		{
+			Name:  "unseal",
+			Usage: "Unseal a message previously sealed",
+			Flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&unsealOpts.InputFilePath, "in", "", "Input file to unseal")
+				cli.AuthFlags(fs, &unsealOpts.AuthOpts)
+			},
+			Run: func(env *cli.Env) error {
+				tpm, err := env.TPM()
+				if err != nil {
+					return err
+				}
+				unsealedData, err := unsealCommand(tpm, unsealOpts)
+				if err != nil {
+					return fmt.Errorf("error unsealing message: %w", err)
+				}
+				fmt.Fprintf(env.Stdout, "Unsealed message: %q 🚀\n", string(unsealedData))
+				return nil
+			},
+		},
+		{
+			Name:  "hmac",
+			Usage: "Compute a HMAC with a TPM key",
+			Flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&hmacOpts.Data, "data", "", "Data to compute HMAC for")
+				cli.AuthFlags(fs, &hmacOpts.AuthOpts)
+			},
+			Run: func(env *cli.Env) error {
+				tpm, err := env.TPM()
+				if err != nil {
+					return err
+				}
+				result, err := hmacCommand(tpm, hmacOpts)
+				if err != nil {
+					return fmt.Errorf("error computing HMAC: %w", err)
+				}
+				fmt.Fprintf(env.Stdout, "HMAC result: %q 🚀\n", hex.EncodeToString(result))
+				return nil
+			},
+		},
 	}
-	return nil
 }
 
 func createCommand(tpm transport.TPM, opts *options.CreateKeyOpts) error {
 	if err := opts.CheckAndSetDefaults(); err != nil {
 		return err
 	}
+	policy, err := tpmutil.AuthPolicy(&opts.AuthOpts)
+	if err != nil {
+		return err
+	}
+	template, err := tpmutil.KeyTemplate(opts, tpmutil.SymTemplatesByKeyType[opts.GetKeyType()])
+	if err != nil {
+		return err
+	}
 	return tpmutil.CreateKey(tpm, tpmutil.CreateKeyConfig{
 		OutDir:           opts.OutputDir,
 		ParentTemplate:   tpmutil.ECCSRKTemplate,
-		OrdinaryTemplate: tpmutil.SymTemplatesByKeyType[opts.GetKeyType()],
+		OrdinaryTemplate: template,
+		UserAuth:         opts.GetAuth(),
+		Policy:           policy,
 	})
 }
 
@@ -177,10 +196,16 @@ func encryptCommand(tpm transport.TPM, opts *options.SymEncryptOpts) error {
 	if err := opts.CheckAndSetDefaults(); err != nil {
 		return err
 	}
+	policy, err := tpmutil.AuthPolicy(&opts.AuthOpts)
+	if err != nil {
+		return err
+	}
 
 	keyHandle, err := tpmutil.LoadKey(tpm, tpmutil.LoadKeyConfig{
 		ParentTemplate: tpmutil.ECCSRKTemplate,
 		KeyBlobPath:    opts.KeyBlobPath,
+		Auth:           opts.GetAuth(),
+		Policy:         policy,
 	})
 	if err != nil {
 		return fmt.Errorf("error loading key blob: %v", err)
@@ -217,10 +242,16 @@ func decryptCommand(tpm transport.TPM, opts *options.DecryptOpts) ([]byte, error
 	if err := opts.CheckAndSetDefaults(); err != nil {
 		return nil, err
 	}
+	policy, err := tpmutil.AuthPolicy(&opts.AuthOpts)
+	if err != nil {
+		return nil, err
+	}
 
 	keyHandle, err := tpmutil.LoadKey(tpm, tpmutil.LoadKeyConfig{
 		ParentTemplate: tpmutil.ECCSRKTemplate,
 		KeyBlobPath:    opts.KeyBlobPath,
+		Auth:           opts.GetAuth(),
+		Policy:         policy,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("error loading key blob: %v", err)
@@ -247,21 +278,77 @@ func sealCommand(tpm transport.TPM, opts *options.SealOpts) error {
 	if err := opts.CheckAndSetDefaults(); err != nil {
 		return err
 	}
+	policy, err := tpmutil.AuthPolicy(&opts.AuthOpts)
+	if err != nil {
+		return err
+	}
+	if opts.PCRs != "" || opts.PCRValuesFile != "" {
+		if policy, err = pcrPolicy(opts, policy); err != nil {
+			return err
+		}
+	}
 
 	return tpmutil.Seal(tpm, tpmutil.SealConfig{
 		ParentTemplate: tpmutil.ECCSRKTemplate,
 		Message:        []byte(opts.Message),
 		OutputFilePath: opts.OutputFilePath,
+		UserAuth:       opts.GetAuth(),
+		Policy:         policy,
 	})
 }
 
+// pcrPolicy prepends to policy a PolicyPCR binding the sealed object to opts.PCRs.
+// Without policy, a password given to seal is still required to unseal.
+func pcrPolicy(opts *options.SealOpts, policy tpmutil.Policy) (tpmutil.Policy, error) {
+	var (
+		sels   []tpmutil.PCRSelection
+		values tpmutil.PCRValues
+		err    error
+	)
+	if opts.PCRValuesFile != "" {
+		if values, err = tpmutil.LoadPCRValues(opts.PCRValuesFile); err != nil {
+			return nil, err
+		}
+		sels = values.Selection()
+	}
+	if opts.PCRs != "" {
+		if sels, err = tpmutil.ParsePCRSelection(opts.PCRs); err != nil {
+			return nil, fmt.Errorf("invalid input: %w", err)
+		}
+	}
+	if len(sels) == 0 {
+		return nil, fmt.Errorf("invalid input: no PCR selected")
+	}
+
+	pcr := tpmutil.PolicyPCR{Selection: sels}
+	switch {
+	case opts.PCRValues != "":
+		pcr.Values, err = tpmutil.ParsePCRValues(opts.PCRValues, sels)
+	case values != nil:
+		pcr.Values, err = values.Values(sels)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid input: %w", err)
+	}
+	if policy == nil && len(opts.GetAuth()) > 0 {
+		policy = tpmutil.Policy{tpmutil.PolicyAuthValue{}}
+	}
+	return append(tpmutil.Policy{pcr}, policy...), nil
+}
+
 func unsealCommand(tpm transport.TPM, opts *options.UnsealOpts) ([]byte, error) {
 	if err := opts.CheckAndSetDefaults(); err != nil {
 		return nil, err
 	}
+	policy, err := tpmutil.AuthPolicy(&opts.AuthOpts)
+	if err != nil {
+		return nil, err
+	}
 	keyHandle, err := tpmutil.LoadKey(tpm, tpmutil.LoadKeyConfig{
 		ParentTemplate: tpmutil.ECCSRKTemplate,
 		KeyBlobPath:    opts.InputFilePath,
+		Auth:           opts.GetAuth(),
+		Policy:         policy,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("error loading key blob: %v", err)
@@ -281,6 +368,10 @@ func hmacCommand(tpm transport.TPM, opts *options.HMACOpts) ([]byte, error) {
 	if err := opts.CheckAndSetDefaults(); err != nil {
 		return nil, err
 	}
+	policy, err := tpmutil.AuthPolicy(&opts.AuthOpts)
+	if err != nil {
+		return nil, err
+	}
 
 	hmacTemplate, err := tpmutil.NewHMACKeyTemplate(tpm2.TPMAlgSHA256)
 	if err != nil {
@@ -290,5 +381,7 @@ func hmacCommand(tpm transport.TPM, opts *options.HMACOpts) ([]byte, error) {
 	return tpmutil.HMAC(tpm, tpmutil.HMACConfig{
 		KeyTemplate: hmacTemplate,
 		Data:        []byte(opts.Data),
+		UserAuth:    opts.GetAuth(),
+		Policy:      policy,
 	})
 }
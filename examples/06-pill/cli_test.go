@@ -1,14 +1,20 @@
-package main
+package pill06
 
 import (
 	"crypto/aes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/google/go-tpm/tpm2"
 	"github.com/loicsikidi/go-tpm-kit/tpmtest"
 	"github.com/loicsikidi/tpm-pills/internal/options"
+	"github.com/loicsikidi/tpm-pills/internal/tpmreplay"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
 	"github.com/stretchr/testify/require"
 )
 
@@ -66,7 +72,7 @@ func TestEncryptDecryptWorkflow(t *testing.T) {
 // 1. Seal a message
 // 2. Unseal the message
 func TestSealUnsealWorkflow(t *testing.T) {
-	tpm := tpmtest.OpenSimulator(t)
+	tpm := tpmreplay.Open(t, "testdata/TestSealUnsealWorkflow.json")
 
 	tempDir := t.TempDir()
 	sealedPath := filepath.Join(tempDir, "sealed_key.tpm")
@@ -90,6 +96,237 @@ func TestSealUnsealWorkflow(t *testing.T) {
 	require.Equal(t, message, string(unsealed))
 }
 
+// TestSealUnsealWithAuth tests that a sealed message protected by a password:
+// 1. Can't be unsealed without the password or with a wrong one
+// 2. Can be unsealed with the password read from a file
+func TestSealUnsealWithAuth(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+
+	tempDir := t.TempDir()
+	sealedPath := filepath.Join(tempDir, "sealed_key.tpm")
+	authPath := filepath.Join(tempDir, "auth.txt")
+	require.NoError(t, os.WriteFile(authPath, []byte("p@ssw0rd\n"), 0600))
+	message := "sealed secret"
+
+	sealOpts := &options.SealOpts{
+		Message:        message,
+		OutputFilePath: sealedPath,
+		AuthOpts:       options.AuthOpts{Auth: "p@ssw0rd"},
+	}
+	require.NoError(t, sealCommand(tpm, sealOpts))
+
+	// 1. Unseal without or with a wrong password
+	for _, auth := range []options.AuthOpts{{}, {Auth: "wrong"}} {
+		_, err := unsealCommand(tpm, &options.UnsealOpts{
+			InputFilePath: sealedPath,
+			AuthOpts:      auth,
+		})
+		require.ErrorContains(t, err, "TPM_RC_BAD_AUTH")
+	}
+
+	// 2. Unseal with the password file (the trailing newline is ignored)
+	unsealed, err := unsealCommand(tpm, &options.UnsealOpts{
+		InputFilePath: sealedPath,
+		AuthOpts:      options.AuthOpts{AuthFile: authPath},
+	})
+	require.NoError(t, err)
+	require.Equal(t, message, string(unsealed))
+
+	_, err = unsealCommand(tpm, &options.UnsealOpts{
+		InputFilePath: sealedPath,
+		AuthOpts:      options.AuthOpts{Auth: "p@ssw0rd", AuthFile: authPath},
+	})
+	require.ErrorContains(t, err, "mutually exclusive")
+}
+
+// TestSealUnsealWithPolicy tests a sealed message bound to a policy:
+// 1. Seal with a policy requiring TPM2_Unseal and the password
+// 2. Unseal without the policy saved along with the sealed object or with a wrong password
+// 3. Unseal with the policy and the password
+func TestSealUnsealWithPolicy(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+
+	tempDir := t.TempDir()
+	sealedPath := filepath.Join(tempDir, "sealed_key.tpm")
+	policyPath := filepath.Join(tempDir, "policy.json")
+	policy := `[{"commandCode": "Unseal"}, {"password": true}]`
+	require.NoError(t, os.WriteFile(policyPath, []byte(policy), 0644))
+	message := "sealed secret"
+
+	// 1. Seal with a policy requiring TPM2_Unseal and the password
+	require.NoError(t, sealCommand(tpm, &options.SealOpts{
+		Message:        message,
+		OutputFilePath: sealedPath,
+		AuthOpts:       options.AuthOpts{Auth: "p@ssw0rd", PolicyPath: policyPath},
+	}))
+
+	// 2. Unseal without the policy saved along with the sealed object or with a wrong password
+	savedPolicyPath := filepath.Join(tempDir, "sealed_key.policy.json")
+	require.FileExists(t, savedPolicyPath)
+	require.NoError(t, os.Remove(savedPolicyPath))
+	_, err := unsealCommand(tpm, &options.UnsealOpts{
+		InputFilePath: sealedPath,
+		AuthOpts:      options.AuthOpts{Auth: "p@ssw0rd"},
+	})
+	require.ErrorContains(t, err, "TPM_RC_AUTH_UNAVAILABLE")
+
+	_, err = unsealCommand(tpm, &options.UnsealOpts{
+		InputFilePath: sealedPath,
+		AuthOpts:      options.AuthOpts{Auth: "wrong", PolicyPath: policyPath},
+	})
+	require.ErrorContains(t, err, "TPM_RC_BAD_AUTH")
+
+	// 3. Unseal with the policy and the password
+	unsealed, err := unsealCommand(tpm, &options.UnsealOpts{
+		InputFilePath: sealedPath,
+		AuthOpts:      options.AuthOpts{Auth: "p@ssw0rd", PolicyPath: policyPath},
+	})
+	require.NoError(t, err)
+	require.Equal(t, message, string(unsealed))
+}
+
+// TestSealUnsealWithPCRs tests a sealed message bound to PCR values:
+// 1. Seal bound to the current values of PCRs 0, 7 and 16
+// 2. Unseal while the PCRs are unchanged
+// 3. Extend PCR 16 and fail to unseal
+// 4. Seal bound to supplied PCR values, in the command line or in a JSON file
+// 5. Reseal without PCRs to the same path, the saved policy is removed
+func TestSealUnsealWithPCRs(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+
+	tempDir := t.TempDir()
+	sealedPath := filepath.Join(tempDir, "sealed_key.tpm")
+	message := "sealed secret"
+
+	// 1. Seal bound to the current values of PCRs 0, 7 and 16
+	require.NoError(t, sealCommand(tpm, &options.SealOpts{
+		Message:        message,
+		OutputFilePath: sealedPath,
+		PCRs:           "sha256:0,7,16",
+		AuthOpts:       options.AuthOpts{Auth: "p@ssw0rd"},
+	}))
+	require.FileExists(t, filepath.Join(tempDir, "sealed_key.policy.json"))
+
+	// 2. Unseal while the PCRs are unchanged, the password is still required
+	_, err := unsealCommand(tpm, &options.UnsealOpts{InputFilePath: sealedPath})
+	require.ErrorContains(t, err, "TPM_RC_BAD_AUTH")
+
+	unsealed, err := unsealCommand(tpm, &options.UnsealOpts{
+		InputFilePath: sealedPath,
+		AuthOpts:      options.AuthOpts{Auth: "p@ssw0rd"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, message, string(unsealed))
+
+	// 3. Extend PCR 16 and fail to unseal
+	digest := sha256.Sum256([]byte("boot event"))
+	_, err = tpm2.PCRExtend{
+		PCRHandle: tpm2.AuthHandle{Handle: 16, Auth: tpm2.PasswordAuth(nil)},
+		Digests: tpm2.TPMLDigestValues{
+			Digests: []tpm2.TPMTHA{{HashAlg: tpm2.TPMAlgSHA256, Digest: digest[:]}},
+		},
+	}.Execute(tpm)
+	require.NoError(t, err)
+
+	_, err = unsealCommand(tpm, &options.UnsealOpts{
+		InputFilePath: sealedPath,
+		AuthOpts:      options.AuthOpts{Auth: "p@ssw0rd"},
+	})
+	require.ErrorContains(t, err, "PCR values don't match the policy: sha256:16 changed")
+	require.NotContains(t, err.Error(), "sha256:7")
+
+	// 4. Seal bound to supplied PCR values
+	sels, err := tpmutil.ParsePCRSelection("sha256:7,16")
+	require.NoError(t, err)
+	values, err := tpmutil.ReadPCRs(tpm, sels)
+	require.NoError(t, err)
+	hexValues := []string{hex.EncodeToString(values[0]), hex.EncodeToString(values[1])}
+
+	require.NoError(t, sealCommand(tpm, &options.SealOpts{
+		Message:        message,
+		OutputFilePath: sealedPath,
+		PCRs:           "sha256:7,16",
+		PCRValues:      strings.Join(hexValues, ","),
+	}))
+	unsealed, err = unsealCommand(tpm, &options.UnsealOpts{InputFilePath: sealedPath})
+	require.NoError(t, err)
+	require.Equal(t, message, string(unsealed))
+
+	valuesPath := filepath.Join(tempDir, "pcrs.json")
+	b, err := json.Marshal(tpmutil.NewPCRValues(sels, values))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(valuesPath, b, 0644))
+	require.NoError(t, sealCommand(tpm, &options.SealOpts{
+		Message:        message,
+		OutputFilePath: sealedPath,
+		PCRValuesFile:  valuesPath,
+	}))
+	unsealed, err = unsealCommand(tpm, &options.UnsealOpts{InputFilePath: sealedPath})
+	require.NoError(t, err)
+	require.Equal(t, message, string(unsealed))
+
+	err = sealCommand(tpm, &options.SealOpts{
+		Message:        message,
+		OutputFilePath: sealedPath,
+		PCRs:           "sha256:7,16",
+		PCRValues:      hexValues[0],
+	})
+	require.ErrorContains(t, err, "expected 2 values")
+
+	// 5. Reseal without PCRs to the same path, the saved policy is removed
+	require.NoError(t, sealCommand(tpm, &options.SealOpts{
+		Message:        message,
+		OutputFilePath: sealedPath,
+	}))
+	require.NoFileExists(t, filepath.Join(tempDir, "sealed_key.policy.json"))
+	unsealed, err = unsealCommand(tpm, &options.UnsealOpts{InputFilePath: sealedPath})
+	require.NoError(t, err)
+	require.Equal(t, message, string(unsealed))
+}
+
+// TestKeyWithPCRPolicy tests a key bound to the current PCR values by its policy:
+// 1. Create the key, the policy is saved along with it, PCR values included
+// 2. Use the key while the PCRs are unchanged
+// 3. Extend PCR 16 and fail to use the key
+func TestKeyWithPCRPolicy(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+
+	tempDir := t.TempDir()
+	keyPath := filepath.Join(tempDir, "key.tpm")
+	policyPath := filepath.Join(tempDir, "policy.json")
+	require.NoError(t, os.WriteFile(policyPath, []byte(`[{"pcrs": "sha256:16"}]`), 0644))
+
+	// 1. Create the key, the policy is saved along with it, PCR values included
+	require.NoError(t, createCommand(tpm, &options.CreateKeyOpts{
+		OutputDir: tempDir,
+		KeyType:   options.Decrypt.String(),
+		AuthOpts:  options.AuthOpts{PolicyPath: policyPath},
+	}))
+	saved, err := tpmutil.LoadPolicy(filepath.Join(tempDir, "key.policy.json"))
+	require.NoError(t, err)
+	require.Len(t, saved, 1)
+	require.NotNil(t, saved[0].(tpmutil.PolicyPCR).Values)
+
+	// 2. Use the key while the PCRs are unchanged
+	encryptOpts := &options.SymEncryptOpts{
+		KeyBlobPath:    keyPath,
+		Message:        "secret message",
+		OutputFilePath: filepath.Join(tempDir, "blob.enc"),
+	}
+	require.NoError(t, encryptCommand(tpm, encryptOpts))
+
+	// 3. Extend PCR 16 and fail to use the key
+	digest := sha256.Sum256([]byte("boot event"))
+	_, err = tpm2.PCRExtend{
+		PCRHandle: tpm2.AuthHandle{Handle: 16, Auth: tpm2.PasswordAuth(nil)},
+		Digests: tpm2.TPMLDigestValues{
+			Digests: []tpm2.TPMTHA{{HashAlg: tpm2.TPMAlgSHA256, Digest: digest[:]}},
+		},
+	}.Execute(tpm)
+	require.NoError(t, err)
+	require.ErrorContains(t, encryptCommand(tpm, encryptOpts), "PCR values don't match the policy: sha256:16 changed")
+}
+
 // TestHMACWorkflow tests the HMAC computation workflow:
 // 1. Compute HMAC
 func TestHMACWorkflow(t *testing.T) {
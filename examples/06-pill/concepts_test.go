@@ -1,4 +1,4 @@
-package main
+package pill06
 
 import (
 	"bytes"
@@ -1,6 +1,7 @@
 //go:build !windows
 
-package main
+// Package pill07 holds the commands of pill #7: persist, read and unpersist a key.
+package pill07
 
 import (
 	"crypto"
@@ -13,96 +14,80 @@ import (
 	"github.com/google/go-tpm/tpm2"
 	"github.com/google/go-tpm/tpm2/transport"
 	"github.com/loicsikidi/go-tpm-kit/tpmcrypto"
+	"github.com/loicsikidi/tpm-pills/internal/cli"
 	"github.com/loicsikidi/tpm-pills/internal/options"
 	"github.com/loicsikidi/tpm-pills/internal/pemutil"
 	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
 )
 
-var useTPM bool
-
-func main() {
-	if err := run(); err != nil {
-		fmt.Fprintf(os.Stderr, "%v\n", err)
-		os.Exit(1)
-	}
-}
-
-func run() error {
+// Commands returns the commands introduced by pill #7.
+func Commands() []*cli.Command {
 	persistOpts := &options.PersistOpts{}
 	readOpts := &options.ReadPersistedOpts{}
 	unpersistOpts := &options.UnpersistOpts{}
 
-	persistCmd := flag.NewFlagSet("persist", flag.ExitOnError)
-	persistCmd.StringVar(&persistOpts.Handle, "handle", "", "Target persistent handle (default: 0x81000010)")
-	persistCmd.StringVar(&persistOpts.OutputDir, "out", "", "Output directory for the created key")
-	persistCmd.BoolVar(&useTPM, "use-real-tpm", false, "Use real TPM instead of swtpm")
-
-	readCmd := flag.NewFlagSet("read", flag.ExitOnError)
-	readCmd.StringVar(&readOpts.Handle, "handle", "", "Target persistent handle (default: 0x81000010)")
-	readCmd.StringVar(&readOpts.PublicKeyPath, "pubkey", "", "Path to the public key file")
-	readCmd.BoolVar(&useTPM, "use-real-tpm", false, "Use real TPM instead of swtpm")
-
-	unpersistCmd := flag.NewFlagSet("unpersist", flag.ExitOnError)
-	unpersistCmd.StringVar(&unpersistOpts.Handle, "handle", "", "Target persistent handle (default: 0x81000010)")
-	unpersistCmd.BoolVar(&useTPM, "use-real-tpm", false, "Use real TPM instead of swtpm")
-
-	if len(os.Args) < 2 {
-		flag.Usage()
-		return fmt.Errorf("missing subcommand")
-	}
-
-	switch subcmd := os.Args[1]; subcmd {
-	case "persist", "read", "unpersist":
-		switch subcmd {
-		case "persist":
-			persistCmd.Parse(os.Args[2:])
-		case "read":
-			readCmd.Parse(os.Args[2:])
-		case "unpersist":
-			unpersistCmd.Parse(os.Args[2:])
-		}
-
-		var device tpmutil.Device
-		if useTPM {
-			device = tpmutil.LINUX
-		} else {
-			device = tpmutil.SWTPM
-		}
-
-		tpm, err := tpmutil.OpenTPM(device)
-		if err != nil {
-			return fmt.Errorf("can't open tpm: %w", err)
-		}
-		defer tpm.Close()
-
-		if subcmd == "persist" {
-			if err := persistCommand(tpm, persistOpts); err != nil {
-				return fmt.Errorf("error persisting key: %w", err)
-			}
-			fmt.Printf("Key persisted at handle %s\n", persistOpts.Handle)
-			fmt.Printf("Public key saved to %s 🚀\n", filepath.Join(persistOpts.OutputDir, "public.pem"))
-		}
-		if subcmd == "read" {
-			if err := readCommand(tpm, readOpts); err != nil {
-				return fmt.Errorf("error reading persisted key: %w", err)
-			}
-			fmt.Printf("Persisted key at handle %s matches the provided public key ✅\n", readOpts.Handle)
-		}
-		if subcmd == "unpersist" {
-			if err := unpersistCommand(tpm, unpersistOpts); err != nil {
-				return fmt.Errorf("error unpersisting key: %w", err)
-			}
-			fmt.Printf("Key at handle %s has been removed\n", unpersistOpts.Handle)
-		}
-	case "cleanup":
-		if err := os.RemoveAll(tpmutil.SWTPM_ROOT_STATE); err != nil {
-			return fmt.Errorf("error cleaning state: %w", err)
-		}
-		fmt.Println("State cleaned successfully")
-	default:
-		return fmt.Errorf("unknown subcommand %q. Expected 'persist', 'read', 'unpersist' or 'cleanup'", subcmd)
+	return []*cli.Command{
+		{
+			Name:  "persist",
+			Usage: "Create a key and persist it at a given handle",
+			Flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&persistOpts.Handle, "handle", "", "Target persistent handle (default: 0x81000010)")
+				fs.StringVar(&persistOpts.OutputDir, "out", "", "Output directory for the created key")
+				cli.AuthFlags(fs, &persistOpts.AuthOpts)
+			},
+			Run: func(env *cli.Env) error {
+				tpm, err := env.TPM()
+				if err != nil {
+					return err
+				}
+				if err := persistCommand(tpm, persistOpts); err != nil {
+					return fmt.Errorf("error persisting key: %w", err)
+				}
+				fmt.Fprintf(env.Stdout, "Key persisted at handle %s\n", persistOpts.Handle)
+				fmt.Fprintf(env.Stdout, "Public key saved to %s 🚀\n", filepath.Join(persistOpts.OutputDir, "public.pem"))
+				return nil
+			},
+		},
+		{
+			Name:  "read",
+			Usage: "Check that a persisted key matches a public key",
+			Flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&readOpts.Handle, "handle", "", "Target persistent handle (default: 0x81000010)")
+				fs.StringVar(&readOpts.PublicKeyPath, "pubkey", "", "Path to the public key file")
+			},
+			Run: func(env *cli.Env) error {
+				tpm, err := env.TPM()
+				if err != nil {
+					return err
+				}
+				pub, err := readCommand(tpm, readOpts)
+				if err != nil {
+					return fmt.Errorf("error reading persisted key: %w", err)
+				}
+				fmt.Fprintf(env.Stdout, "Persisted key at handle %s matches the provided public key ✅\n", readOpts.Handle)
+				fmt.Fprintf(env.Stdout, "Attributes: %s\n", tpmutil.FormatAttributes(pub.ObjectAttributes))
+				return nil
+			},
+		},
+		{
+			Name:  "unpersist",
+			Usage: "Remove a persisted key",
+			Flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&unpersistOpts.Handle, "handle", "", "Target persistent handle (default: 0x81000010)")
+			},
+			Run: func(env *cli.Env) error {
+				tpm, err := env.TPM()
+				if err != nil {
+					return err
+				}
+				if err := unpersistCommand(tpm, unpersistOpts); err != nil {
+					return fmt.Errorf("error unpersisting key: %w", err)
+				}
+				fmt.Fprintf(env.Stdout, "Key at handle %s has been removed\n", unpersistOpts.Handle)
+				return nil
+			},
+		},
 	}
-	return nil
 }
 
 // persistCommand creates an ECC ordinary key using [tpmutil.CreateKey], loads it
@@ -112,6 +97,10 @@ func persistCommand(tpm transport.TPM, opts *options.PersistOpts) error {
 	if err := opts.CheckAndSetDefaults(); err != nil {
 		return err
 	}
+	policy, err := tpmutil.AuthPolicy(&opts.AuthOpts)
+	if err != nil {
+		return err
+	}
 
 	handle, err := parseHandle(opts.Handle)
 	if err != nil {
@@ -124,6 +113,8 @@ func persistCommand(tpm transport.TPM, opts *options.PersistOpts) error {
 		ParentTemplate:   tpmutil.ECCSRKTemplate,
 		OrdinaryTemplate: tpmutil.ECCSignerTemplate,
 		CreatePublicKey:  true,
+		UserAuth:         opts.GetAuth(),
+		Policy:           policy,
 	}); err != nil {
 		return fmt.Errorf("failed to create key: %w", err)
 	}
@@ -156,14 +147,15 @@ func persistCommand(tpm transport.TPM, opts *options.PersistOpts) error {
 
 // readCommand loads the persisted key handle, extracts its public key,
 // and compares it with the public key file provided via --pubkey.
-func readCommand(tpm transport.TPM, opts *options.ReadPersistedOpts) error {
+// It returns the public area of the persisted key.
+func readCommand(tpm transport.TPM, opts *options.ReadPersistedOpts) (*tpm2.TPMTPublic, error) {
 	if err := opts.CheckAndSetDefaults(); err != nil {
-		return err
+		return nil, err
 	}
 
 	handle, err := parseHandle(opts.Handle)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// 1. Read the persisted handle
@@ -171,37 +163,37 @@ func readCommand(tpm transport.TPM, opts *options.ReadPersistedOpts) error {
 		Handle: tpmutil.NewHandle(handle),
 	})
 	if err != nil {
-		return fmt.Errorf("failed to get persisted key handle: %w", err)
+		return nil, fmt.Errorf("failed to get persisted key handle: %w", err)
 	}
 
 	// 2. Extract the public key from the persisted handle
 	persistedPub, err := tpmcrypto.PublicKey(persistedHandle.Public())
 	if err != nil {
-		return fmt.Errorf("failed to extract public key from persisted handle: %w", err)
+		return nil, fmt.Errorf("failed to extract public key from persisted handle: %w", err)
 	}
 
 	// 3. Read and parse the public key from the file
 	filePub, err := pemutil.Read(opts.PublicKeyPath)
 	if err != nil {
-		return fmt.Errorf("failed to read public key file: %w", err)
+		return nil, fmt.Errorf("failed to read public key file: %w", err)
 	}
 
 	// 4. Compare the public keys
 	filePubKey, ok := filePub.(crypto.PublicKey)
 	if !ok {
-		return fmt.Errorf("file key is not a valid public key")
+		return nil, fmt.Errorf("file key is not a valid public key")
 	}
 
 	pub, ok := persistedPub.(interface{ Equal(x crypto.PublicKey) bool })
 	if !ok {
-		return fmt.Errorf("invalid public key: Equal is not implemented")
+		return nil, fmt.Errorf("invalid public key: Equal is not implemented")
 	}
 
 	if !pub.Equal(filePubKey) {
-		return fmt.Errorf("public keys do not match")
+		return nil, fmt.Errorf("public keys do not match")
 	}
 
-	return nil
+	return persistedHandle.Public(), nil
 }
 
 // unpersistCommand removes a persisted key from the given handle using [tpm2.EvictControl].
@@ -1,4 +1,4 @@
-package main
+package pill07
 
 import (
 	"path/filepath"
@@ -6,6 +6,7 @@ import (
 
 	"github.com/google/go-tpm/tpm2/transport/simulator"
 	"github.com/loicsikidi/tpm-pills/internal/options"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
 	"github.com/stretchr/testify/require"
 )
 
@@ -33,8 +34,9 @@ func TestPersistReadUnpersistWorkflow(t *testing.T) {
 
 	// 2. Read and verify the persisted key matches
 	readOpts := &options.ReadPersistedOpts{Handle: testHandle, PublicKeyPath: pubkeyPath}
-	err = readCommand(tpm, readOpts)
+	pub, err := readCommand(tpm, readOpts)
 	require.NoError(t, err, "persisted key should match the saved public key")
+	require.Equal(t, "fixedtpm|fixedparent|sensitivedataorigin|userwithauth|sign", tpmutil.FormatAttributes(pub.ObjectAttributes))
 
 	// 3. Unpersist the key
 	unpersistOpts := &options.UnpersistOpts{Handle: testHandle}
@@ -42,7 +44,7 @@ func TestPersistReadUnpersistWorkflow(t *testing.T) {
 	require.NoError(t, err, "failed to unpersist key")
 
 	// 4. Verify the handle is no longer available
-	err = readCommand(tpm, readOpts)
+	_, err = readCommand(tpm, readOpts)
 	require.Error(t, err, "reading an unpersisted handle should fail")
 }
 
@@ -77,7 +79,7 @@ func TestReadMismatchingKey(t *testing.T) {
 
 	// 3. Read should fail because the file has the first key's pubkey, not the second's
 	readOpts := &options.ReadPersistedOpts{Handle: testHandle, PublicKeyPath: savedPubkeyPath}
-	err = readCommand(tpm, readOpts)
+	_, err = readCommand(tpm, readOpts)
 	require.Error(t, err, "read should fail when public keys do not match")
 	require.Contains(t, err.Error(), "do not match")
 
@@ -95,7 +97,7 @@ func TestReadMissingPubkeyFlag(t *testing.T) {
 	})
 
 	readOpts := &options.ReadPersistedOpts{Handle: testHandle}
-	err = readCommand(tpm, readOpts)
+	_, err = readCommand(tpm, readOpts)
 	require.Error(t, err, "read should fail without --pubkey")
 	require.Contains(t, err.Error(), "invalid input: PublicKeyPath is required")
 }
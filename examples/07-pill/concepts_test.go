@@ -1,4 +1,4 @@
-package main
+package pill07
 
 import (
 	"bytes"
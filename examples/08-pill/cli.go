@@ -0,0 +1,397 @@
+//go:build !windows
+
+// Package pill08 holds the commands of pill #8: define, write, read and undefine NV indices.
+package pill08
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/tpm-pills/internal/cli"
+	"github.com/loicsikidi/tpm-pills/internal/options"
+	"github.com/loicsikidi/tpm-pills/internal/tpminfo"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+	"github.com/loicsikidi/tpm-pills/internal/utils"
+)
+
+// Commands returns the commands introduced by pill #8.
+func Commands() []*cli.Command {
+	defineOpts := &options.NVDefineOpts{}
+	undefineOpts := &options.NVUndefineOpts{}
+	writeOpts := &options.NVWriteOpts{}
+	readOpts := &options.NVReadOpts{}
+	readPublicOpts := &options.NVReadPublicOpts{}
+
+	return []*cli.Command{
+		{
+			Name:  "nv",
+			Usage: "Store data in the non-volatile memory of the TPM",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "define",
+					Usage: "Define an NV index",
+					Flags: func(fs *flag.FlagSet) {
+						fs.StringVar(&defineOpts.Index, "index", "", "NV index handle (default: 0x01000010)")
+						fs.StringVar(&defineOpts.Type, "type", "", "Type of the index: 'ordinary' (default), 'counter', 'bits' or 'extend'")
+						fs.IntVar(&defineOpts.Size, "size", 0, "Size in bytes of an ordinary index")
+						fs.StringVar(&defineOpts.Hash, "hash", "", "Name algorithm of the index, also used by an extend index (default: sha256)")
+						fs.StringVar(&defineOpts.Attributes, "attributes", "", "Attributes of the index (default: 'ownerwrite|authwrite|ownerread|authread')")
+						fs.StringVar(&defineOpts.OwnerAuth, "owner-auth", "", "Password of the owner hierarchy")
+						indexAuthFlags(fs, &defineOpts.AuthOpts)
+					},
+					Run: func(env *cli.Env) error {
+						tpm, err := env.TPM()
+						if err != nil {
+							return err
+						}
+						pub, err := defineCommand(tpm, defineOpts)
+						if err != nil {
+							return fmt.Errorf("error defining NV index: %w", err)
+						}
+						fmt.Fprintf(env.Stdout, "NV index 0x%x defined: %s, %d bytes 🚀\n", uint32(pub.NVIndex), tpmutil.NVTypeName(pub.Attributes.NT), pub.DataSize)
+						return nil
+					},
+				},
+				{
+					Name:  "undefine",
+					Usage: "Remove an NV index",
+					Flags: func(fs *flag.FlagSet) {
+						fs.StringVar(&undefineOpts.Index, "index", "", "NV index handle (default: 0x01000010)")
+						fs.StringVar(&undefineOpts.OwnerAuth, "owner-auth", "", "Password of the owner hierarchy")
+					},
+					Run: func(env *cli.Env) error {
+						tpm, err := env.TPM()
+						if err != nil {
+							return err
+						}
+						if err := undefineCommand(tpm, undefineOpts); err != nil {
+							return fmt.Errorf("error undefining NV index: %w", err)
+						}
+						fmt.Fprintf(env.Stdout, "NV index %s has been removed\n", undefineOpts.Index)
+						return nil
+					},
+				},
+				{
+					Name:  "write",
+					Usage: "Write data to an ordinary index, increment a counter, set bits or extend an extend index",
+					Flags: func(fs *flag.FlagSet) {
+						fs.StringVar(&writeOpts.Index, "index", "", "NV index handle (default: 0x01000010)")
+						fs.StringVar(&writeOpts.Data, "data", "", "Data written to an ordinary index or extending an extend index")
+						fs.StringVar(&writeOpts.InputFilePath, "in", "", "File holding the data")
+						fs.StringVar(&writeOpts.Bits, "bits", "", "Bits set in a bits index (e.g. '0x5')")
+						fs.IntVar(&writeOpts.Offset, "offset", 0, "Offset of the data in an ordinary index")
+						nvAuthFlags(fs, &writeOpts.NVAuthOpts)
+					},
+					Run: func(env *cli.Env) error {
+						tpm, err := env.TPM()
+						if err != nil {
+							return err
+						}
+						nt, err := writeCommand(tpm, writeOpts)
+						if err != nil {
+							return fmt.Errorf("error writing NV index: %w", err)
+						}
+						switch nt {
+						case tpm2.TPMNTCounter:
+							fmt.Fprintf(env.Stdout, "NV index %s incremented 🚀\n", writeOpts.Index)
+						case tpm2.TPMNTExtend:
+							fmt.Fprintf(env.Stdout, "NV index %s extended 🚀\n", writeOpts.Index)
+						default:
+							fmt.Fprintf(env.Stdout, "NV index %s written 🚀\n", writeOpts.Index)
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "read",
+					Usage: "Read an NV index",
+					Flags: func(fs *flag.FlagSet) {
+						fs.StringVar(&readOpts.Index, "index", "", "NV index handle (default: 0x01000010)")
+						fs.IntVar(&readOpts.Size, "size", 0, "Number of bytes to read (default: up to the end of the index)")
+						fs.IntVar(&readOpts.Offset, "offset", 0, "Offset of the first byte to read")
+						fs.StringVar(&readOpts.OutputFilePath, "out", "", "Output file for the raw data (default: printed in hex)")
+						nvAuthFlags(fs, &readOpts.NVAuthOpts)
+					},
+					Run: func(env *cli.Env) error {
+						tpm, err := env.TPM()
+						if err != nil {
+							return err
+						}
+						data, nt, err := readCommand(tpm, readOpts)
+						if err != nil {
+							return fmt.Errorf("error reading NV index: %w", err)
+						}
+						switch {
+						case readOpts.OutputFilePath != "":
+							fmt.Fprintf(env.Stdout, "NV index %s saved to %s 🚀\n", readOpts.Index, readOpts.OutputFilePath)
+						case nt == tpm2.TPMNTCounter && len(data) == 8:
+							fmt.Fprintf(env.Stdout, "%d\n", binary.BigEndian.Uint64(data))
+						case nt == tpm2.TPMNTBits && len(data) == 8:
+							fmt.Fprintf(env.Stdout, "0x%x\n", binary.BigEndian.Uint64(data))
+						default:
+							fmt.Fprintf(env.Stdout, "%x\n", data)
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "readpublic",
+					Usage: "Display the public area of an NV index",
+					Flags: func(fs *flag.FlagSet) {
+						fs.StringVar(&readPublicOpts.Index, "index", "", "NV index handle (default: 0x01000010)")
+					},
+					Run: func(env *cli.Env) error {
+						tpm, err := env.TPM()
+						if err != nil {
+							return err
+						}
+						pub, name, err := readPublicCommand(tpm, readPublicOpts)
+						if err != nil {
+							return fmt.Errorf("error reading NV index: %w", err)
+						}
+						writePublic(env, pub, name)
+						return nil
+					},
+				},
+				{
+					Name:  "list",
+					Usage: "Display the public area of every NV index",
+					Run: func(env *cli.Env) error {
+						tpm, err := env.TPM()
+						if err != nil {
+							return err
+						}
+						indices, err := listCommand(tpm)
+						if err != nil {
+							return fmt.Errorf("error listing NV indices: %w", err)
+						}
+						for _, index := range indices {
+							writePublic(env, index.pub, index.name)
+						}
+						return nil
+					},
+				},
+			},
+		},
+	}
+}
+
+// indexAuthFlags registers the flags holding the password of an index: --auth, --auth-file and --auth-prompt.
+func indexAuthFlags(fs *flag.FlagSet, opts *options.AuthOpts) {
+	fs.StringVar(&opts.Auth, "auth", "", "Password of the index (visible in the process list, prefer --auth-file or --auth-prompt)")
+	fs.StringVar(&opts.AuthFile, "auth-file", "", "File holding the password of the index ('-' for stdin)")
+	fs.BoolVar(&opts.Prompt, "auth-prompt", false, "Prompt for the password of the index")
+}
+
+// nvAuthFlags registers the flags authorizing a command on an index: the password of the index
+// or, with --owner, the one of the owner hierarchy.
+func nvAuthFlags(fs *flag.FlagSet, opts *options.NVAuthOpts) {
+	fs.BoolVar(&opts.Owner, "owner", false, "Authorize with the owner hierarchy instead of the index")
+	fs.StringVar(&opts.OwnerAuth, "owner-auth", "", "Password of the owner hierarchy (requires --owner)")
+	indexAuthFlags(fs, &opts.AuthOpts)
+}
+
+// nvAuth returns the authorization selected by opts.
+func nvAuth(opts *options.NVAuthOpts) tpmutil.NVAuth {
+	if opts.Owner {
+		return tpmutil.NVAuth{Owner: true, Auth: []byte(opts.OwnerAuth)}
+	}
+	return tpmutil.NVAuth{Auth: opts.GetAuth()}
+}
+
+// defineCommand defines an NV index using [tpmutil.NVDefine].
+func defineCommand(tpm transport.TPM, opts *options.NVDefineOpts) (*tpm2.TPMSNVPublic, error) {
+	if err := opts.CheckAndSetDefaults(); err != nil {
+		return nil, err
+	}
+	index, err := parseIndex(opts.Index)
+	if err != nil {
+		return nil, err
+	}
+	nt, err := tpmutil.ParseNVType(opts.Type)
+	if err != nil {
+		return nil, fmt.Errorf("invalid input: %w", err)
+	}
+	hashAlg, err := tpmutil.ParseAlg(opts.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid input: invalid hash: %w", err)
+	}
+	cfg := tpmutil.NVDefineConfig{
+		Index:     index,
+		Type:      nt,
+		Size:      opts.Size,
+		HashAlg:   hashAlg,
+		Auth:      opts.GetAuth(),
+		OwnerAuth: []byte(opts.OwnerAuth),
+	}
+	if opts.Attributes != "" {
+		attrs, err := tpmutil.ParseNVAttributes(opts.Attributes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid input: %w", err)
+		}
+		cfg.Attributes = &attrs
+	}
+	return tpmutil.NVDefine(tpm, cfg)
+}
+
+// undefineCommand removes an NV index using [tpmutil.NVUndefine].
+func undefineCommand(tpm transport.TPM, opts *options.NVUndefineOpts) error {
+	if err := opts.CheckAndSetDefaults(); err != nil {
+		return err
+	}
+	index, err := parseIndex(opts.Index)
+	if err != nil {
+		return err
+	}
+	return tpmutil.NVUndefine(tpm, index, []byte(opts.OwnerAuth))
+}
+
+// writeCommand writes to an NV index according to its type and returns the type:
+//   - ordinary: the data is written at the given offset, in chunks if needed
+//   - counter: the counter is incremented
+//   - bits: the bits are ORed into the index
+//   - extend: the index is extended with the data
+func writeCommand(tpm transport.TPM, opts *options.NVWriteOpts) (tpm2.TPMNT, error) {
+	if err := opts.CheckAndSetDefaults(); err != nil {
+		return 0, err
+	}
+	index, err := parseIndex(opts.Index)
+	if err != nil {
+		return 0, err
+	}
+	pub, _, err := tpmutil.NVReadPublic(tpm, index)
+	if err != nil {
+		return 0, err
+	}
+	data := []byte(opts.Data)
+	if opts.InputFilePath != "" {
+		if data, err = utils.ReadFile(opts.InputFilePath); err != nil {
+			return 0, fmt.Errorf("failed to read input file: %w", err)
+		}
+	}
+
+	auth := nvAuth(&opts.NVAuthOpts)
+	nt := pub.Attributes.NT
+	switch nt {
+	case tpm2.TPMNTCounter:
+		if len(data) > 0 || opts.Bits != "" {
+			return 0, fmt.Errorf("invalid input: a counter index is incremented, it takes no data")
+		}
+		return nt, tpmutil.NVIncrement(tpm, index, auth)
+	case tpm2.TPMNTBits:
+		if opts.Bits == "" {
+			return 0, fmt.Errorf("invalid input: Bits is required for a bits index")
+		}
+		bits, err := strconv.ParseUint(opts.Bits, 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid input: invalid bits %q: %w", opts.Bits, err)
+		}
+		return nt, tpmutil.NVSetBits(tpm, index, auth, bits)
+	}
+
+	if opts.Bits != "" {
+		return 0, fmt.Errorf("invalid input: Bits is only supported by a bits index")
+	}
+	if len(data) == 0 {
+		return 0, fmt.Errorf("invalid input: Data or InputFilePath is required for a %s index", tpmutil.NVTypeName(nt))
+	}
+	if nt == tpm2.TPMNTExtend {
+		return nt, tpmutil.NVExtend(tpm, index, auth, data)
+	}
+	return nt, tpmutil.NVWrite(tpm, tpmutil.NVWriteConfig{
+		Index:  index,
+		Auth:   auth,
+		Data:   data,
+		Offset: opts.Offset,
+	})
+}
+
+// readCommand reads an NV index using [tpmutil.NVRead] and returns its data and its type.
+// The data is also saved to the output file, if any.
+func readCommand(tpm transport.TPM, opts *options.NVReadOpts) ([]byte, tpm2.TPMNT, error) {
+	if err := opts.CheckAndSetDefaults(); err != nil {
+		return nil, 0, err
+	}
+	index, err := parseIndex(opts.Index)
+	if err != nil {
+		return nil, 0, err
+	}
+	pub, _, err := tpmutil.NVReadPublic(tpm, index)
+	if err != nil {
+		return nil, 0, err
+	}
+	data, err := tpmutil.NVRead(tpm, tpmutil.NVReadConfig{
+		Index:  index,
+		Auth:   nvAuth(&opts.NVAuthOpts),
+		Size:   opts.Size,
+		Offset: opts.Offset,
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if opts.OutputFilePath != "" {
+		if err := os.WriteFile(opts.OutputFilePath, data, 0600); err != nil {
+			return nil, 0, fmt.Errorf("failed to write output file: %w", err)
+		}
+	}
+	return data, pub.Attributes.NT, nil
+}
+
+// readPublicCommand returns the public area and the Name of an NV index.
+func readPublicCommand(tpm transport.TPM, opts *options.NVReadPublicOpts) (*tpm2.TPMSNVPublic, *tpm2.TPM2BName, error) {
+	if err := opts.CheckAndSetDefaults(); err != nil {
+		return nil, nil, err
+	}
+	index, err := parseIndex(opts.Index)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tpmutil.NVReadPublic(tpm, index)
+}
+
+// nvIndex is an NV index listed by [listCommand].
+type nvIndex struct {
+	pub  *tpm2.TPMSNVPublic
+	name *tpm2.TPM2BName
+}
+
+// listCommand returns the public area of every NV index defined in the TPM.
+func listCommand(tpm transport.TPM) ([]nvIndex, error) {
+	handles, err := tpminfo.ReadHandles(tpm, tpm2.TPMHTNVIndex)
+	if err != nil {
+		return nil, err
+	}
+	indices := make([]nvIndex, 0, len(handles))
+	for _, h := range handles {
+		pub, name, err := tpmutil.NVReadPublic(tpm, h)
+		if err != nil {
+			return nil, err
+		}
+		indices = append(indices, nvIndex{pub: pub, name: name})
+	}
+	return indices, nil
+}
+
+// writePublic prints the public area of an NV index in the format of tpm2_nvreadpublic.
+func writePublic(env *cli.Env, pub *tpm2.TPMSNVPublic, name *tpm2.TPM2BName) {
+	fmt.Fprintf(env.Stdout, "0x%x:\n", uint32(pub.NVIndex))
+	fmt.Fprintf(env.Stdout, "  name: %x\n", name.Buffer)
+	fmt.Fprintf(env.Stdout, "  hash algorithm: %s\n", tpmutil.AlgName(pub.NameAlg))
+	fmt.Fprintf(env.Stdout, "  type: %s\n", tpmutil.NVTypeName(pub.Attributes.NT))
+	fmt.Fprintf(env.Stdout, "  attributes: %s\n", tpmutil.FormatNVAttributes(pub.Attributes))
+	fmt.Fprintf(env.Stdout, "  size: %d\n", pub.DataSize)
+}
+
+// parseIndex parses a hex string (e.g. "0x01000010") into a [tpm2.TPMHandle].
+func parseIndex(s string) (tpm2.TPMHandle, error) {
+	v, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid input: invalid NV index %q: %w", s, err)
+	}
+	return tpm2.TPMHandle(v), nil
+}
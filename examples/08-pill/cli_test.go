@@ -0,0 +1,175 @@
+package pill08
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport/simulator"
+	"github.com/loicsikidi/tpm-pills/internal/options"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+	"github.com/stretchr/testify/require"
+)
+
+const testIndex = "0x01000010"
+
+// TestDefineWriteReadUndefineWorkflow tests the full workflow of an ordinary index:
+// 1. Define an index protected by a password, larger than the NV buffer
+// 2. Write it from a file, in several chunks
+// 3. Read it back with the password of the index and as the owner
+// 4. Undefine it and verify it is no longer available
+func TestDefineWriteReadUndefineWorkflow(t *testing.T) {
+	tpm, err := simulator.OpenSimulator()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, tpm.Close())
+	})
+
+	bufferMax, err := tpmutil.NVBufferMax(tpm)
+	require.NoError(t, err)
+	size := 2 * bufferMax
+
+	// 1. Define the index
+	pub, err := defineCommand(tpm, &options.NVDefineOpts{
+		Index:    testIndex,
+		Size:     size,
+		AuthOpts: options.AuthOpts{Auth: "correct horse"},
+	})
+	require.NoError(t, err, "failed to define NV index")
+	require.Equal(t, tpm2.TPMNTOrdinary, pub.Attributes.NT)
+	require.EqualValues(t, size, pub.DataSize)
+
+	// 2. Write more data than a single TPM2_NV_Write accepts
+	data := make([]byte, size)
+	_, err = rand.Read(data)
+	require.NoError(t, err)
+	inPath := filepath.Join(t.TempDir(), "data.bin")
+	require.NoError(t, os.WriteFile(inPath, data, 0600))
+
+	writeOpts := &options.NVWriteOpts{Index: testIndex, InputFilePath: inPath}
+	writeOpts.Auth = "correct horse"
+	_, err = writeCommand(tpm, writeOpts)
+	require.NoError(t, err, "failed to write NV index")
+
+	// 3. Read it back, with the password of the index and as the owner
+	outPath := filepath.Join(t.TempDir(), "out.bin")
+	readOpts := &options.NVReadOpts{Index: testIndex, OutputFilePath: outPath}
+	readOpts.Auth = "correct horse"
+	got, _, err := readCommand(tpm, readOpts)
+	require.NoError(t, err, "failed to read NV index")
+	require.Equal(t, data, got)
+	saved, err := os.ReadFile(outPath)
+	require.NoError(t, err)
+	require.Equal(t, data, saved)
+
+	got, _, err = readCommand(tpm, &options.NVReadOpts{
+		Index:      testIndex,
+		Offset:     bufferMax - 2,
+		Size:       4,
+		NVAuthOpts: options.NVAuthOpts{Owner: true},
+	})
+	require.NoError(t, err, "failed to read NV index as the owner")
+	require.Equal(t, data[bufferMax-2:bufferMax+2], got)
+
+	readOpts = &options.NVReadOpts{Index: testIndex}
+	readOpts.Auth = "wrong"
+	_, _, err = readCommand(tpm, readOpts)
+	require.ErrorIs(t, err, tpm2.TPMRCAuthFail)
+
+	pub, _, err = readPublicCommand(tpm, &options.NVReadPublicOpts{Index: testIndex})
+	require.NoError(t, err)
+	require.Equal(t, "ownerwrite|authwrite|ownerread|authread|written", tpmutil.FormatNVAttributes(pub.Attributes))
+
+	indices, err := listCommand(tpm)
+	require.NoError(t, err)
+	require.Len(t, indices, 1)
+	require.Equal(t, pub.NVIndex, indices[0].pub.NVIndex)
+
+	// 4. Undefine the index
+	err = undefineCommand(tpm, &options.NVUndefineOpts{Index: testIndex})
+	require.NoError(t, err, "failed to undefine NV index")
+
+	_, _, err = readPublicCommand(tpm, &options.NVReadPublicOpts{Index: testIndex})
+	require.ErrorIs(t, err, tpm2.TPMRCHandle, "reading an undefined index should fail")
+}
+
+// TestWriteByType verifies that the write command increments a counter, sets the bits of
+// a bits index and extends an extend index.
+func TestWriteByType(t *testing.T) {
+	tpm, err := simulator.OpenSimulator()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, tpm.Close())
+	})
+
+	define := func(index, nt string) {
+		_, err := defineCommand(tpm, &options.NVDefineOpts{Index: index, Type: nt})
+		require.NoError(t, err, "failed to define %s NV index", nt)
+	}
+	write := func(opts *options.NVWriteOpts) {
+		_, err := writeCommand(tpm, opts)
+		require.NoError(t, err, "failed to write NV index %s", opts.Index)
+	}
+	read := func(index string) []byte {
+		data, _, err := readCommand(tpm, &options.NVReadOpts{Index: index})
+		require.NoError(t, err, "failed to read NV index %s", index)
+		return data
+	}
+
+	define("0x01000011", "counter")
+	write(&options.NVWriteOpts{Index: "0x01000011"})
+	first := binary.BigEndian.Uint64(read("0x01000011"))
+	write(&options.NVWriteOpts{Index: "0x01000011"})
+	require.Equal(t, first+1, binary.BigEndian.Uint64(read("0x01000011")))
+
+	define("0x01000012", "bits")
+	write(&options.NVWriteOpts{Index: "0x01000012", Bits: "0x3"})
+	write(&options.NVWriteOpts{Index: "0x01000012", Bits: "0x8"})
+	require.Equal(t, uint64(0xb), binary.BigEndian.Uint64(read("0x01000012")))
+
+	define("0x01000013", "extend")
+	write(&options.NVWriteOpts{Index: "0x01000013", Data: "event"})
+	expected := sha256.Sum256(append(make([]byte, sha256.Size), "event"...))
+	require.True(t, bytes.Equal(expected[:], read("0x01000013")))
+}
+
+// TestInvalidInputs verifies that the commands reject invalid inputs before reaching the TPM.
+func TestInvalidInputs(t *testing.T) {
+	tpm, err := simulator.OpenSimulator()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, tpm.Close())
+	})
+
+	_, err = defineCommand(tpm, &options.NVDefineOpts{})
+	require.ErrorContains(t, err, "invalid input: Size is required for an ordinary index")
+
+	_, err = defineCommand(tpm, &options.NVDefineOpts{Index: "0x81000010", Size: 8})
+	require.ErrorContains(t, err, "is not an NV index")
+
+	_, err = defineCommand(tpm, &options.NVDefineOpts{Type: "counter", Size: 16})
+	require.ErrorContains(t, err, "a counter index holds 8 bytes")
+
+	_, err = defineCommand(tpm, &options.NVDefineOpts{Type: "pcr"})
+	require.ErrorContains(t, err, "unknown NV type")
+
+	_, err = defineCommand(tpm, &options.NVDefineOpts{Size: 8})
+	require.NoError(t, err)
+
+	_, err = writeCommand(tpm, &options.NVWriteOpts{Data: "data", Bits: "0x1"})
+	require.ErrorContains(t, err, "mutually exclusive")
+
+	_, err = writeCommand(tpm, &options.NVWriteOpts{Data: "too long data"})
+	require.ErrorContains(t, err, "exceed the 8 bytes")
+
+	_, err = writeCommand(tpm, &options.NVWriteOpts{Bits: "0x1"})
+	require.ErrorContains(t, err, "Bits is only supported by a bits index")
+
+	_, _, err = readCommand(tpm, &options.NVReadOpts{NVAuthOpts: options.NVAuthOpts{Owner: true, AuthOpts: options.AuthOpts{Auth: "secret"}}})
+	require.ErrorContains(t, err, "Owner and the password of the index are mutually exclusive")
+}
@@ -0,0 +1,224 @@
+package pill08
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/loicsikidi/go-tpm-kit/tpmcrypto"
+	"github.com/loicsikidi/go-tpm-kit/tpmtest"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+)
+
+const conceptIndex = tpm2.TPMHandle(0x01000020)
+
+// TestNameChangesOnFirstWrite proves that NAME = nameAlg || Hash(nameAlg, nvPublic)
+// and that the Name of an index changes once it is written, as TPMA_NV_WRITTEN gets set.
+func TestNameChangesOnFirstWrite(t *testing.T) {
+	thetpm := tpmtest.OpenSimulator(t)
+
+	if _, err := tpmutil.NVDefine(thetpm, tpmutil.NVDefineConfig{Index: conceptIndex, Size: 16}); err != nil {
+		t.Fatalf("failed to define NV index: %v", err)
+	}
+	pub, before, err := tpmutil.NVReadPublic(thetpm, conceptIndex)
+	if err != nil {
+		t.Fatalf("failed to read NV public area: %v", err)
+	}
+	if pub.Attributes.Written {
+		t.Fatalf("expected a new index not to be written")
+	}
+
+	// Calculate the expected name: nameAlg (2 bytes) || Hash(nameAlg, nvPublic)
+	digest, err := tpmcrypto.GetDigestFromHashAlg(tpm2.Marshal(pub), pub.NameAlg)
+	if err != nil {
+		t.Fatalf("failed to get digest from hash algorithm: %v", err)
+	}
+	expectedName := binary.BigEndian.AppendUint16(nil, uint16(pub.NameAlg))
+	expectedName = append(expectedName, digest...)
+	if !bytes.Equal(expectedName, before.Buffer) {
+		t.Errorf("name mismatch:\nexpected: %x\ngot: %x", expectedName, before.Buffer)
+	}
+
+	if err := tpmutil.NVWrite(thetpm, tpmutil.NVWriteConfig{Index: conceptIndex, Data: []byte("hello")}); err != nil {
+		t.Fatalf("failed to write NV index: %v", err)
+	}
+	pub, after, err := tpmutil.NVReadPublic(thetpm, conceptIndex)
+	if err != nil {
+		t.Fatalf("failed to read NV public area: %v", err)
+	}
+	if !pub.Attributes.Written {
+		t.Errorf("expected TPMA_NV_WRITTEN to be set after a write")
+	}
+	if bytes.Equal(before.Buffer, after.Buffer) {
+		t.Errorf("expected the name to change after the first write, got %x twice", after.Buffer)
+	}
+
+	// a command using the stale name is rejected: the name is part of the HMAC of the session
+	_, err = tpm2.NVRead{
+		AuthHandle: tpm2.AuthHandle{Handle: conceptIndex, Name: *before, Auth: tpm2.HMAC(tpm2.TPMAlgSHA256, 16, tpm2.Auth(nil))},
+		NVIndex:    tpm2.NamedHandle{Handle: conceptIndex, Name: *before},
+		Size:       5,
+	}.Execute(thetpm)
+	if !errors.Is(err, tpm2.TPMRCAuthFail) {
+		t.Errorf("expected TPMRCAuthFail error with the stale name, got: %v", err)
+	}
+}
+
+// TestReadBeforeWrite proves that an index can't be read until it is written.
+func TestReadBeforeWrite(t *testing.T) {
+	thetpm := tpmtest.OpenSimulator(t)
+
+	for _, nt := range []tpm2.TPMNT{tpm2.TPMNTOrdinary, tpm2.TPMNTCounter, tpm2.TPMNTBits, tpm2.TPMNTExtend} {
+		index := conceptIndex + tpm2.TPMHandle(nt)
+		if _, err := tpmutil.NVDefine(thetpm, tpmutil.NVDefineConfig{Index: index, Type: nt, Size: sizeOf(nt)}); err != nil {
+			t.Fatalf("failed to define %s NV index: %v", tpmutil.NVTypeName(nt), err)
+		}
+		_, err := tpmutil.NVRead(thetpm, tpmutil.NVReadConfig{Index: index})
+		if !errors.Is(err, tpm2.TPMRCNVUninitialized) {
+			t.Errorf("expected TPMRCNVUninitialized error reading a new %s index, got: %v", tpmutil.NVTypeName(nt), err)
+		}
+	}
+}
+
+// TestCounter proves that a counter index only moves forward: it can't be written, only incremented.
+func TestCounter(t *testing.T) {
+	thetpm := tpmtest.OpenSimulator(t)
+
+	if _, err := tpmutil.NVDefine(thetpm, tpmutil.NVDefineConfig{Index: conceptIndex, Type: tpm2.TPMNTCounter}); err != nil {
+		t.Fatalf("failed to define NV index: %v", err)
+	}
+	err := tpmutil.NVWrite(thetpm, tpmutil.NVWriteConfig{Index: conceptIndex, Data: make([]byte, 8)})
+	if !errors.Is(err, tpm2.TPMRCAttributes) {
+		t.Errorf("expected TPMRCAttributes error writing a counter, got: %v", err)
+	}
+
+	counter := func() uint64 {
+		data, err := tpmutil.NVRead(thetpm, tpmutil.NVReadConfig{Index: conceptIndex})
+		if err != nil {
+			t.Fatalf("failed to read NV index: %v", err)
+		}
+		return binary.BigEndian.Uint64(data)
+	}
+	if err := tpmutil.NVIncrement(thetpm, conceptIndex, tpmutil.NVAuth{}); err != nil {
+		t.Fatalf("failed to increment NV index: %v", err)
+	}
+	// the first value isn't necessarily 1: the TPM starts a counter from the highest
+	// value ever reached by a counter, so that it never goes back to a previous value
+	first := counter()
+	if err := tpmutil.NVIncrement(thetpm, conceptIndex, tpmutil.NVAuth{}); err != nil {
+		t.Fatalf("failed to increment NV index: %v", err)
+	}
+	if got := counter(); got != first+1 {
+		t.Errorf("expected counter %d after an increment, got %d", first+1, got)
+	}
+}
+
+// TestSetBits proves that a bits index ORs the bits it is given: a bit can't be cleared.
+func TestSetBits(t *testing.T) {
+	thetpm := tpmtest.OpenSimulator(t)
+
+	if _, err := tpmutil.NVDefine(thetpm, tpmutil.NVDefineConfig{Index: conceptIndex, Type: tpm2.TPMNTBits}); err != nil {
+		t.Fatalf("failed to define NV index: %v", err)
+	}
+	for _, bits := range []uint64{0x1, 0x4, 0x0} {
+		if err := tpmutil.NVSetBits(thetpm, conceptIndex, tpmutil.NVAuth{}, bits); err != nil {
+			t.Fatalf("failed to set bits 0x%x: %v", bits, err)
+		}
+	}
+	data, err := tpmutil.NVRead(thetpm, tpmutil.NVReadConfig{Index: conceptIndex})
+	if err != nil {
+		t.Fatalf("failed to read NV index: %v", err)
+	}
+	if got := binary.BigEndian.Uint64(data); got != 0x5 {
+		t.Errorf("expected bits 0x5, got 0x%x", got)
+	}
+}
+
+// TestExtendIndex proves that an extend index behaves like a PCR: new value = Hash(old value || data).
+func TestExtendIndex(t *testing.T) {
+	thetpm := tpmtest.OpenSimulator(t)
+
+	if _, err := tpmutil.NVDefine(thetpm, tpmutil.NVDefineConfig{Index: conceptIndex, Type: tpm2.TPMNTExtend}); err != nil {
+		t.Fatalf("failed to define NV index: %v", err)
+	}
+	var digests [][]byte
+	for _, event := range []string{"boot", "kernel"} {
+		digest := sha256.Sum256([]byte(event))
+		digests = append(digests, digest[:])
+		if err := tpmutil.NVExtend(thetpm, conceptIndex, tpmutil.NVAuth{}, digest[:]); err != nil {
+			t.Fatalf("failed to extend NV index: %v", err)
+		}
+	}
+
+	got, err := tpmutil.NVRead(thetpm, tpmutil.NVReadConfig{Index: conceptIndex})
+	if err != nil {
+		t.Fatalf("failed to read NV index: %v", err)
+	}
+	expected, err := tpmutil.ComputePCR(tpm2.TPMAlgSHA256, nil, digests...)
+	if err != nil {
+		t.Fatalf("failed to compute the expected value: %v", err)
+	}
+	if !bytes.Equal(expected, got) {
+		t.Errorf("extend index mismatch:\nexpected: %x\ngot: %x", expected, got)
+	}
+}
+
+// TestOwnerAndIndexAuth proves that the attributes of an index decide whether the owner hierarchy,
+// the password of the index, or both can authorize reads and writes.
+func TestOwnerAndIndexAuth(t *testing.T) {
+	thetpm := tpmtest.OpenSimulator(t)
+
+	password := []byte("correct horse")
+	owner := tpmutil.NVAuth{Owner: true}
+	index := tpmutil.NVAuth{Auth: password}
+
+	// the index can be written with its password only, and read by the owner only
+	attrs, err := tpmutil.ParseNVAttributes("authwrite|ownerread|no_da")
+	if err != nil {
+		t.Fatalf("failed to parse NV attributes: %v", err)
+	}
+	if _, err := tpmutil.NVDefine(thetpm, tpmutil.NVDefineConfig{Index: conceptIndex, Size: 8, Attributes: &attrs, Auth: password}); err != nil {
+		t.Fatalf("failed to define NV index: %v", err)
+	}
+
+	t.Run("index password", func(t *testing.T) {
+		if err := tpmutil.NVWrite(thetpm, tpmutil.NVWriteConfig{Index: conceptIndex, Auth: index, Data: []byte("by index")}); err != nil {
+			t.Errorf("expected write with the index password to succeed, got: %v", err)
+		}
+		wrong := tpmutil.NVAuth{Auth: []byte("wrong")}
+		err := tpmutil.NVWrite(thetpm, tpmutil.NVWriteConfig{Index: conceptIndex, Auth: wrong, Data: []byte("by index")})
+		// no_da: a wrong password doesn't increment the dictionary attack counter
+		if !errors.Is(err, tpm2.TPMRCBadAuth) {
+			t.Errorf("expected TPMRCBadAuth error with a wrong password, got: %v", err)
+		}
+		_, err = tpmutil.NVRead(thetpm, tpmutil.NVReadConfig{Index: conceptIndex, Auth: index})
+		if !errors.Is(err, tpm2.TPMRCAuthUnavailable) {
+			t.Errorf("expected TPMRCAuthUnavailable error reading with the index password, got: %v", err)
+		}
+	})
+
+	t.Run("owner", func(t *testing.T) {
+		err := tpmutil.NVWrite(thetpm, tpmutil.NVWriteConfig{Index: conceptIndex, Auth: owner, Data: []byte("by owner")})
+		if !errors.Is(err, tpm2.TPMRCNVAuthorization) {
+			t.Errorf("expected TPMRCNVAuthorization error writing as the owner, got: %v", err)
+		}
+		data, err := tpmutil.NVRead(thetpm, tpmutil.NVReadConfig{Index: conceptIndex, Auth: owner})
+		if err != nil {
+			t.Fatalf("expected read as the owner to succeed, got: %v", err)
+		}
+		if string(data) != "by index" {
+			t.Errorf("expected %q, got %q", "by index", data)
+		}
+	})
+}
+
+// sizeOf returns the size to define an index of type nt with.
+func sizeOf(nt tpm2.TPMNT) int {
+	if nt == tpm2.TPMNTOrdinary {
+		return 32
+	}
+	return 0
+}
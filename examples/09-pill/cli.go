@@ -0,0 +1,194 @@
+//go:build !windows
+
+// Package pill09 holds the commands of pill #9: certify a key with a restricted signing key
+// and verify the attestation offline.
+package pill09
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/tpm-pills/internal/attest"
+	"github.com/loicsikidi/tpm-pills/internal/cli"
+	"github.com/loicsikidi/tpm-pills/internal/options"
+	"github.com/loicsikidi/tpm-pills/internal/pemutil"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+)
+
+// Files written by 'certify' and read by 'verify-certify'.
+const (
+	// attestFileName holds the TPMS_ATTEST structure signed by the TPM.
+	attestFileName = "attest.bin"
+	// signatureFileName holds the TPMT_SIGNATURE of the attestation.
+	signatureFileName = "attest.sig"
+	// subjectFileName holds the TPM2B_PUBLIC of the certified key.
+	subjectFileName = "subject.pub"
+)
+
+// Commands returns the commands introduced by pill #9.
+func Commands() []*cli.Command {
+	certifyOpts := &options.CertifyOpts{}
+	verifyOpts := &options.VerifyCertifyOpts{}
+
+	return []*cli.Command{
+		{
+			Name:  "certify",
+			Usage: "Certify a key with a restricted signing key",
+			Flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&certifyOpts.KeyBlobPath, "key", "", "Path to the TPM key blob file of the key to certify")
+				fs.StringVar(&certifyOpts.SignerBlobPath, "signer", "", "Path to the TPM key blob file of the restricted signing key")
+				fs.StringVar(&certifyOpts.SignerAuth, "signer-auth", "", "Password of the restricted signing key")
+				fs.StringVar(&certifyOpts.Nonce, "nonce", "", "Hex-encoded nonce supplied by the verifier")
+				fs.StringVar(&certifyOpts.OutputDir, "out", "", "Output directory for the attestation, its signature and the public area of the key")
+				cli.AuthFlags(fs, &certifyOpts.AuthOpts)
+			},
+			Run: func(env *cli.Env) error {
+				tpm, err := env.TPM()
+				if err != nil {
+					return err
+				}
+				name, err := certifyCommand(tpm, certifyOpts)
+				if err != nil {
+					return fmt.Errorf("error certifying key: %w", err)
+				}
+				fmt.Fprintf(env.Stdout, "Key %x certified\n", name.Buffer)
+				fmt.Fprintf(env.Stdout, "Attestation saved to %s 🚀\n", certifyOpts.OutputDir)
+				return nil
+			},
+		},
+		{
+			Name:  "verify-certify",
+			Usage: "Verify a key attestation offline",
+			Flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&verifyOpts.PublicKeyPath, "pubkey", "", "Path to the public key file of the restricted signing key")
+				fs.StringVar(&verifyOpts.Nonce, "nonce", "", "Hex-encoded nonce given to 'certify'")
+				fs.StringVar(&verifyOpts.InputDir, "in", "", "Directory holding the files written by 'certify'")
+			},
+			Run: func(env *cli.Env) error {
+				subject, err := verifyCertifyCommand(verifyOpts)
+				if err != nil {
+					return fmt.Errorf("error verifying attestation: %w", err)
+				}
+				fmt.Fprintln(env.Stdout, "Attestation verified successfully 🚀")
+				fmt.Fprintf(env.Stdout, "Certified key: %s, %s\n", tpmutil.AlgName(subject.Type), tpmutil.FormatAttributes(subject.ObjectAttributes))
+				return nil
+			},
+		},
+	}
+}
+
+// certifyCommand has the restricted signing key certify the key, and returns its Name.
+func certifyCommand(tpm transport.TPM, opts *options.CertifyOpts) (*tpm2.TPM2BName, error) {
+	if err := opts.CheckAndSetDefaults(); err != nil {
+		return nil, err
+	}
+	policy, err := tpmutil.AuthPolicy(&opts.AuthOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	signerHandle, err := tpmutil.LoadKey(tpm, tpmutil.LoadKeyConfig{
+		ParentTemplate: tpmutil.ECCSRKTemplate,
+		KeyBlobPath:    opts.SignerBlobPath,
+		Auth:           []byte(opts.SignerAuth),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signing key: %w", err)
+	}
+	defer signerHandle.Close()
+	// only a restricted key guarantees that what it signs with TPM_GENERATED_VALUE comes from the TPM
+	if attrs := signerHandle.Public().ObjectAttributes; !attrs.Restricted || !attrs.SignEncrypt {
+		return nil, fmt.Errorf("invalid input: the signer must be a restricted signing key")
+	}
+
+	keyHandle, err := tpmutil.LoadKey(tpm, tpmutil.LoadKeyConfig{
+		ParentTemplate: tpmutil.ECCSRKTemplate,
+		KeyBlobPath:    opts.KeyBlobPath,
+		Auth:           opts.GetAuth(),
+		Policy:         policy,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load key: %w", err)
+	}
+	defer keyHandle.Close()
+
+	keyAuth, keyCloser, err := tpmutil.AuthorizeKey(tpm, keyHandle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to authorize key: %w", err)
+	}
+	defer keyCloser()
+	signerAuth, signerCloser, err := tpmutil.AuthorizeKey(tpm, signerHandle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to authorize signing key: %w", err)
+	}
+	defer signerCloser()
+
+	rsp, err := tpm2.Certify{
+		ObjectHandle:   keyAuth,
+		SignHandle:     signerAuth,
+		QualifyingData: tpm2.TPM2BData{Buffer: opts.GetNonce()},
+		// the scheme of the restricted key is used
+		InScheme: tpm2.TPMTSigScheme{Scheme: tpm2.TPMAlgNull},
+	}.Execute(tpm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute certify command: %w", err)
+	}
+
+	for name, content := range map[string][]byte{
+		attestFileName:    rsp.CertifyInfo.Bytes(),
+		signatureFileName: tpm2.Marshal(rsp.Signature),
+		subjectFileName:   tpm2.Marshal(tpm2.New2B(*keyHandle.Public())),
+	} {
+		path := filepath.Join(opts.OutputDir, name)
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", path, err)
+		}
+	}
+	return tpm2.ObjectName(keyHandle.Public())
+}
+
+// verifyCertifyCommand verifies the attestation written by 'certify', and returns the
+// public area of the certified key.
+func verifyCertifyCommand(opts *options.VerifyCertifyOpts) (*tpm2.TPMTPublic, error) {
+	if err := opts.CheckAndSetDefaults(); err != nil {
+		return nil, err
+	}
+
+	signer, err := pemutil.Read(opts.PublicKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading public key: %w", err)
+	}
+	attestation, err := os.ReadFile(filepath.Join(opts.InputDir, attestFileName))
+	if err != nil {
+		return nil, fmt.Errorf("error reading attestation: %w", err)
+	}
+	b, err := os.ReadFile(filepath.Join(opts.InputDir, signatureFileName))
+	if err != nil {
+		return nil, fmt.Errorf("error reading signature: %w", err)
+	}
+	sig, err := tpm2.Unmarshal[tpm2.TPMTSignature](b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal signature: %w", err)
+	}
+	b, err = os.ReadFile(filepath.Join(opts.InputDir, subjectFileName))
+	if err != nil {
+		return nil, fmt.Errorf("error reading public area of the key: %w", err)
+	}
+	subject2B, err := tpm2.Unmarshal[tpm2.TPM2BPublic](b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal public area of the key: %w", err)
+	}
+	subject, err := subject2B.Contents()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the public area of the key: %w", err)
+	}
+
+	if _, err := attest.VerifyCertify(signer, attestation, sig, opts.GetNonce(), subject); err != nil {
+		return nil, err
+	}
+	return subject, nil
+}
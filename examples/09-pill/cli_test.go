@@ -0,0 +1,117 @@
+package pill09
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport/simulator"
+	"github.com/loicsikidi/tpm-pills/internal/attest"
+	"github.com/loicsikidi/tpm-pills/internal/keytest"
+	"github.com/loicsikidi/tpm-pills/internal/options"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+	"github.com/stretchr/testify/require"
+)
+
+const testNonce = "0102030405060708"
+
+// TestCertifyVerifyWorkflow tests the full workflow of a key attestation:
+// 1. Create a restricted signing key and a key protected by a password
+// 2. Certify the key with the nonce of the verifier
+// 3. Verify the attestation offline
+// 4. Verify that another nonce or another key is rejected
+func TestCertifyVerifyWorkflow(t *testing.T) {
+	tpm, err := simulator.OpenSimulator()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, tpm.Close())
+	})
+
+	// 1. Create the keys
+	signerDir := keytest.Create(t, tpm, tpmutil.ECCRestrictedSignerTemplate, nil)
+	keyDir := keytest.Create(t, tpm, tpmutil.ECCSignerTemplate, []byte("correct horse"))
+
+	// 2. Certify the key
+	outDir := t.TempDir()
+	certifyOpts := &options.CertifyOpts{
+		KeyBlobPath:    filepath.Join(keyDir, "key.tpm"),
+		SignerBlobPath: filepath.Join(signerDir, "key.tpm"),
+		OutputDir:      outDir,
+		NonceOpts:      options.NonceOpts{Nonce: testNonce},
+		AuthOpts:       options.AuthOpts{Auth: "correct horse"},
+	}
+	name, err := certifyCommand(tpm, certifyOpts)
+	require.NoError(t, err, "failed to certify key")
+	for _, file := range []string{attestFileName, signatureFileName, subjectFileName} {
+		require.FileExists(t, filepath.Join(outDir, file))
+	}
+
+	// 3. Verify the attestation
+	verifyOpts := &options.VerifyCertifyOpts{
+		PublicKeyPath: filepath.Join(signerDir, "public.pem"),
+		InputDir:      outDir,
+		NonceOpts:     options.NonceOpts{Nonce: testNonce},
+	}
+	subject, err := verifyCertifyCommand(verifyOpts)
+	require.NoError(t, err, "failed to verify attestation")
+	subjectName, err := tpm2.ObjectName(subject)
+	require.NoError(t, err)
+	require.Equal(t, name.Buffer, subjectName.Buffer)
+	require.True(t, subject.ObjectAttributes.FixedTPM)
+
+	// 4. Another nonce or another key is rejected
+	verifyOpts.Nonce = "0807060504030201"
+	_, err = verifyCertifyCommand(verifyOpts)
+	require.ErrorIs(t, err, attest.ErrNonce)
+
+	otherDir := keytest.Create(t, tpm, tpmutil.ECCSignerTemplate, nil)
+	other, err := tpmutil.LoadKey(tpm, tpmutil.LoadKeyConfig{
+		ParentTemplate: tpmutil.ECCSRKTemplate,
+		KeyBlobPath:    filepath.Join(otherDir, "key.tpm"),
+	})
+	require.NoError(t, err)
+	defer other.Close()
+	require.NoError(t, os.WriteFile(filepath.Join(outDir, subjectFileName), tpm2.Marshal(tpm2.New2B(*other.Public())), 0644))
+	verifyOpts.Nonce = testNonce
+	_, err = verifyCertifyCommand(verifyOpts)
+	require.ErrorIs(t, err, attest.ErrName)
+
+	// the attestation isn't signed by the key itself
+	verifyOpts.PublicKeyPath = filepath.Join(keyDir, "public.pem")
+	_, err = verifyCertifyCommand(verifyOpts)
+	require.ErrorIs(t, err, attest.ErrSignature)
+}
+
+// TestInvalidInputs verifies that the commands reject invalid inputs.
+func TestInvalidInputs(t *testing.T) {
+	tpm, err := simulator.OpenSimulator()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, tpm.Close())
+	})
+
+	signerDir := keytest.Create(t, tpm, tpmutil.ECCSignerTemplate, nil)
+	keyDir := keytest.Create(t, tpm, tpmutil.ECCSignerTemplate, nil)
+	keyPath := filepath.Join(keyDir, "key.tpm")
+
+	_, err = certifyCommand(tpm, &options.CertifyOpts{KeyBlobPath: keyPath, NonceOpts: options.NonceOpts{Nonce: testNonce}})
+	require.ErrorContains(t, err, "invalid input: SignerBlobPath is required")
+
+	_, err = certifyCommand(tpm, &options.CertifyOpts{KeyBlobPath: keyPath, SignerBlobPath: keyPath})
+	require.ErrorContains(t, err, "invalid input: Nonce is required")
+
+	_, err = certifyCommand(tpm, &options.CertifyOpts{KeyBlobPath: keyPath, SignerBlobPath: keyPath, NonceOpts: options.NonceOpts{Nonce: "nonce"}})
+	require.ErrorContains(t, err, "invalid input: Nonce must be hex-encoded")
+
+	_, err = certifyCommand(tpm, &options.CertifyOpts{
+		KeyBlobPath:    keyPath,
+		SignerBlobPath: filepath.Join(signerDir, "key.tpm"),
+		OutputDir:      t.TempDir(),
+		NonceOpts:      options.NonceOpts{Nonce: testNonce},
+	})
+	require.ErrorContains(t, err, "invalid input: the signer must be a restricted signing key")
+
+	_, err = verifyCertifyCommand(&options.VerifyCertifyOpts{NonceOpts: options.NonceOpts{Nonce: testNonce}})
+	require.ErrorContains(t, err, "invalid input: PublicKeyPath is required")
+}
@@ -0,0 +1,133 @@
+package pill09
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/go-tpm-kit/tpmcrypto"
+	"github.com/loicsikidi/go-tpm-kit/tpmtest"
+	"github.com/loicsikidi/tpm-pills/internal/attest"
+	"github.com/loicsikidi/tpm-pills/internal/keytest"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+)
+
+// loadKey creates a key from template and loads it.
+func loadKey(t *testing.T, thetpm transport.TPM, template tpm2.TPMTPublic) tpmutil.HandleCloser {
+	t.Helper()
+	dir := keytest.Create(t, thetpm, template, nil)
+	handle, err := tpmutil.LoadKey(thetpm, tpmutil.LoadKeyConfig{
+		ParentTemplate: tpmutil.ECCSRKTemplate,
+		KeyBlobPath:    filepath.Join(dir, "key.tpm"),
+	})
+	if err != nil {
+		t.Fatalf("failed to load key: %v", err)
+	}
+	t.Cleanup(func() { handle.Close() })
+	return handle
+}
+
+// TestRestrictedKeyRefusesForgedAttestation proves why a verifier trusts an attestation signed
+// by a restricted key: such a key only signs a digest computed by the TPM with a ticket, and
+// TPM2_Hash doesn't deliver a ticket for data starting with TPM_GENERATED_VALUE.
+// An unrestricted key signs any digest, hence it could sign a forged attestation.
+func TestRestrictedKeyRefusesForgedAttestation(t *testing.T) {
+	thetpm := tpmtest.OpenSimulator(t)
+
+	forged := tpm2.Marshal(tpm2.TPMSAttest{
+		Magic: tpm2.TPMGeneratedValue,
+		Type:  tpm2.TPMSTAttestCertify,
+		Attested: tpm2.NewTPMUAttest(tpm2.TPMSTAttestCertify, &tpm2.TPMSCertifyInfo{
+			Name: tpm2.TPM2BName{Buffer: []byte("a key which isn't in a TPM")},
+		}),
+	})
+	rspHash, err := tpm2.Hash{
+		Data:      tpm2.TPM2BMaxBuffer{Buffer: forged},
+		HashAlg:   tpm2.TPMAlgSHA256,
+		Hierarchy: tpm2.TPMRHOwner,
+	}.Execute(thetpm)
+	if err != nil {
+		t.Fatalf("failed to execute hash command: %v", err)
+	}
+	if rspHash.Validation.Hierarchy != tpm2.TPMRHNull {
+		t.Fatalf("expected a NULL ticket for data starting with TPM_GENERATED_VALUE, got hierarchy 0x%x", uint32(rspHash.Validation.Hierarchy))
+	}
+
+	restricted := loadKey(t, thetpm, tpmutil.ECCRestrictedSignerTemplate)
+	_, err = tpm2.Sign{
+		KeyHandle:  tpmutil.AuthHandle(restricted),
+		Digest:     rspHash.OutHash,
+		Validation: rspHash.Validation,
+	}.Execute(thetpm)
+	if !errors.Is(err, tpm2.TPMRCTicket) {
+		t.Errorf("expected TPMRCTicket error signing a forged attestation with a restricted key, got: %v", err)
+	}
+
+	unrestricted := loadKey(t, thetpm, tpmutil.ECCSignerTemplate)
+	rspSign, err := tpm2.Sign{
+		KeyHandle:  tpmutil.AuthHandle(unrestricted),
+		Digest:     rspHash.OutHash,
+		Validation: rspHash.Validation,
+	}.Execute(thetpm)
+	if err != nil {
+		t.Fatalf("expected an unrestricted key to sign a forged attestation, got: %v", err)
+	}
+	pub, err := tpmcrypto.PublicKey(unrestricted.Public())
+	if err != nil {
+		t.Fatalf("failed to get public key: %v", err)
+	}
+	// the forgery verifies: the signature alone doesn't prove the attestation comes from the TPM
+	if err := attest.VerifySignature(pub, forged, &rspSign.Signature); err != nil {
+		t.Errorf("expected the forged attestation to verify, got: %v", err)
+	}
+}
+
+// TestCertifiedName proves that the Name certified by TPM2_Certify is
+// nameAlg || Hash(nameAlg, TPMT_PUBLIC), which binds every attribute of the key.
+func TestCertifiedName(t *testing.T) {
+	thetpm := tpmtest.OpenSimulator(t)
+
+	signer := loadKey(t, thetpm, tpmutil.ECCRestrictedSignerTemplate)
+	key := loadKey(t, thetpm, tpmutil.ECCSignerTemplate)
+	rsp, err := tpm2.Certify{
+		ObjectHandle: tpmutil.AuthHandle(key),
+		SignHandle:   tpmutil.AuthHandle(signer),
+		InScheme:     tpm2.TPMTSigScheme{Scheme: tpm2.TPMAlgNull},
+	}.Execute(thetpm)
+	if err != nil {
+		t.Fatalf("failed to execute certify command: %v", err)
+	}
+	info, err := attest.Parse(rsp.CertifyInfo.Bytes())
+	if err != nil {
+		t.Fatalf("failed to parse attestation: %v", err)
+	}
+	certify, err := info.Attested.Certify()
+	if err != nil {
+		t.Fatalf("failed to get certify information: %v", err)
+	}
+
+	digest, err := tpmcrypto.GetDigestFromHashAlg(tpm2.Marshal(key.Public()), key.Public().NameAlg)
+	if err != nil {
+		t.Fatalf("failed to get digest from hash algorithm: %v", err)
+	}
+	expectedName := binary.BigEndian.AppendUint16(nil, uint16(key.Public().NameAlg))
+	expectedName = append(expectedName, digest...)
+	if !bytes.Equal(expectedName, certify.Name.Buffer) {
+		t.Errorf("name mismatch:\nexpected: %x\ngot: %x", expectedName, certify.Name.Buffer)
+	}
+
+	// the certified Name changes with a single attribute
+	other := *key.Public()
+	other.ObjectAttributes.NoDA = !other.ObjectAttributes.NoDA
+	otherName, err := tpm2.ObjectName(&other)
+	if err != nil {
+		t.Fatalf("failed to compute name: %v", err)
+	}
+	if bytes.Equal(otherName.Buffer, certify.Name.Buffer) {
+		t.Errorf("expected the name to change with the attributes, got %x twice", otherName.Buffer)
+	}
+}
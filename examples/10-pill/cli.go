@@ -0,0 +1,226 @@
+//go:build !windows
+
+// Package pill10 holds the commands of pill #10: quote PCRs with an attestation key
+// and verify the quotes offline.
+package pill10
+
+import (
+	"crypto"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/tpm-pills/internal/attest"
+	"github.com/loicsikidi/tpm-pills/internal/cli"
+	"github.com/loicsikidi/tpm-pills/internal/options"
+	"github.com/loicsikidi/tpm-pills/internal/pemutil"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+)
+
+// Files written by 'quote' and read by 'verify-quote'.
+const (
+	// quoteFileName holds the TPMS_ATTEST structure signed by the TPM.
+	quoteFileName = "quote.bin"
+	// signatureFileName holds the TPMT_SIGNATURE of the quote.
+	signatureFileName = "quote.sig"
+	// pcrsFileName holds the values of the quoted PCRs in JSON, as printed by 'pcr read'.
+	pcrsFileName = "pcrs.json"
+)
+
+// Commands returns the commands introduced by pill #10.
+func Commands() []*cli.Command {
+	quoteOpts := &options.QuoteOpts{}
+	verifyOpts := &options.VerifyQuoteOpts{}
+
+	return []*cli.Command{
+		{
+			Name:  "quote",
+			Usage: "Sign the values of PCRs with an attestation key",
+			Flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&quoteOpts.KeyBlobPath, "key", "", "Path to the TPM key blob file of the restricted signing key")
+				fs.StringVar(&quoteOpts.PCRs, "pcrs", "", "PCRs to quote (default: 'sha256:0,1,2,3,4,5,6,7')")
+				fs.StringVar(&quoteOpts.Nonce, "nonce", "", "Hex-encoded nonce supplied by the verifier")
+				fs.StringVar(&quoteOpts.OutputDir, "out", "", "Output directory for the quote, its signature and the PCR values")
+				cli.AuthFlags(fs, &quoteOpts.AuthOpts)
+			},
+			Run: func(env *cli.Env) error {
+				tpm, err := env.TPM()
+				if err != nil {
+					return err
+				}
+				sels, err := quoteCommand(tpm, quoteOpts)
+				if err != nil {
+					return fmt.Errorf("error quoting PCRs: %w", err)
+				}
+				fmt.Fprintf(env.Stdout, "PCRs %s quoted\n", tpmutil.FormatPCRSelection(sels))
+				fmt.Fprintf(env.Stdout, "Quote saved to %s 🚀\n", quoteOpts.OutputDir)
+				return nil
+			},
+		},
+		{
+			Name:  "verify-quote",
+			Usage: "Verify a quote offline",
+			Flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&verifyOpts.PublicKeyPath, "pubkey", "", "Path to the public key file of the attestation key")
+				fs.StringVar(&verifyOpts.Nonce, "nonce", "", "Hex-encoded nonce given to 'quote'")
+				fs.StringVar(&verifyOpts.InputDir, "in", "", "Directory holding the files written by 'quote'")
+				fs.StringVar(&verifyOpts.PCRValuesFile, "pcr-values-file", "", "Expected PCR values in JSON, as printed by 'pcr read' or 'pcr compute' (default: the values saved by 'quote')")
+				fs.StringVar(&verifyOpts.PreviousDir, "previous", "", "Directory holding a previous quote signed by the same key, to detect a reboot in between")
+			},
+			Run: func(env *cli.Env) error {
+				quote, err := verifyQuoteCommand(verifyOpts)
+				if err != nil {
+					return fmt.Errorf("error verifying quote: %w", err)
+				}
+				clock := quote.Attest.ClockInfo
+				fmt.Fprintln(env.Stdout, "Quote verified successfully 🚀")
+				fmt.Fprintf(env.Stdout, "PCRs: %s\n", tpmutil.FormatPCRSelection(quote.PCRs))
+				fmt.Fprintf(env.Stdout, "Clock: %dms, reset count: %d, restart count: %d\n", clock.Clock, clock.ResetCount, clock.RestartCount)
+				return nil
+			},
+		},
+	}
+}
+
+// quoteCommand quotes the selected PCRs, and returns the selection.
+func quoteCommand(tpm transport.TPM, opts *options.QuoteOpts) ([]tpmutil.PCRSelection, error) {
+	if err := opts.CheckAndSetDefaults(); err != nil {
+		return nil, err
+	}
+	sels, err := tpmutil.ParsePCRSelection(opts.PCRs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid input: %w", err)
+	}
+	policy, err := tpmutil.AuthPolicy(&opts.AuthOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	keyHandle, err := tpmutil.LoadKey(tpm, tpmutil.LoadKeyConfig{
+		ParentTemplate: tpmutil.ECCSRKTemplate,
+		KeyBlobPath:    opts.KeyBlobPath,
+		Auth:           opts.GetAuth(),
+		Policy:         policy,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load key: %w", err)
+	}
+	defer keyHandle.Close()
+	// only a restricted key guarantees that what it signs with TPM_GENERATED_VALUE comes from the TPM
+	if attrs := keyHandle.Public().ObjectAttributes; !attrs.Restricted || !attrs.SignEncrypt {
+		return nil, fmt.Errorf("invalid input: the key must be a restricted signing key")
+	}
+
+	keyAuth, closer, err := tpmutil.AuthorizeKey(tpm, keyHandle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to authorize key: %w", err)
+	}
+	defer closer()
+
+	// unlike TPM2_Sign, no ticket is needed: the TPM builds the structure it signs
+	rsp, err := tpm2.Quote{
+		SignHandle:     keyAuth,
+		QualifyingData: tpm2.TPM2BData{Buffer: opts.GetNonce()},
+		// the scheme of the restricted key is used
+		InScheme:  tpm2.TPMTSigScheme{Scheme: tpm2.TPMAlgNull},
+		PCRSelect: tpmutil.PCRSelectionList(sels),
+	}.Execute(tpm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute quote command: %w", err)
+	}
+	// the values are read after the quote: the verification fails if a PCR was extended in between
+	values, err := tpmutil.ReadPCRValues(tpm, sels)
+	if err != nil {
+		return nil, err
+	}
+	pcrs, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode PCR values: %w", err)
+	}
+
+	for name, content := range map[string][]byte{
+		quoteFileName:     rsp.Quoted.Bytes(),
+		signatureFileName: tpm2.Marshal(rsp.Signature),
+		pcrsFileName:      append(pcrs, '\n'),
+	} {
+		path := filepath.Join(opts.OutputDir, name)
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", path, err)
+		}
+	}
+	return sels, nil
+}
+
+// verifyQuoteCommand verifies the quote written by 'quote', and checks that no reboot
+// happened since the previous quote, if any.
+func verifyQuoteCommand(opts *options.VerifyQuoteOpts) (*attest.Quote, error) {
+	if err := opts.CheckAndSetDefaults(); err != nil {
+		return nil, err
+	}
+	pcrValuesFile := opts.PCRValuesFile
+	if pcrValuesFile == "" {
+		pcrValuesFile = filepath.Join(opts.InputDir, pcrsFileName)
+	}
+	values, err := tpmutil.LoadPCRValues(pcrValuesFile)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := pemutil.Read(opts.PublicKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading public key: %w", err)
+	}
+
+	quoted, sig, err := readQuote(opts.InputDir)
+	if err != nil {
+		return nil, err
+	}
+	quote, err := attest.VerifyQuote(signer, quoted, sig, opts.GetNonce(), values)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.PreviousDir != "" {
+		previous, err := readPreviousQuote(signer, opts.PreviousDir)
+		if err != nil {
+			return nil, err
+		}
+		if err := attest.CheckSuccession(previous.Attest, quote.Attest); err != nil {
+			return nil, err
+		}
+	}
+	return quote, nil
+}
+
+// readPreviousQuote reads a quote written by 'quote' in dir and checks its signature.
+// Its nonce isn't checked: it was verified when the quote was received.
+func readPreviousQuote(signer crypto.PublicKey, dir string) (*attest.Quote, error) {
+	quoted, sig, err := readQuote(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := attest.VerifySignature(signer, quoted, sig); err != nil {
+		return nil, fmt.Errorf("previous quote: %w", err)
+	}
+	return attest.ParseQuote(quoted)
+}
+
+// readQuote reads a quote and its signature written by 'quote' in dir.
+func readQuote(dir string) ([]byte, *tpm2.TPMTSignature, error) {
+	quoted, err := os.ReadFile(filepath.Join(dir, quoteFileName))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading quote: %w", err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, signatureFileName))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading signature: %w", err)
+	}
+	sig, err := tpm2.Unmarshal[tpm2.TPMTSignature](b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal signature: %w", err)
+	}
+	return quoted, sig, nil
+}
@@ -0,0 +1,119 @@
+package pill10
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport/simulator"
+	"github.com/loicsikidi/tpm-pills/internal/attest"
+	"github.com/loicsikidi/tpm-pills/internal/keytest"
+	"github.com/loicsikidi/tpm-pills/internal/options"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+	"github.com/stretchr/testify/require"
+)
+
+const testNonce = "0102030405060708"
+
+// TestQuoteVerifyWorkflow tests the full workflow of a remote attestation:
+// 1. Create an attestation key (a restricted signing key)
+// 2. Quote PCRs with the nonce of the verifier
+// 3. Verify the quote offline, with the saved PCR values and with expected ones
+// 4. Quote again and verify the new quote follows the previous one
+func TestQuoteVerifyWorkflow(t *testing.T) {
+	tpm, err := simulator.OpenSimulator()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, tpm.Close())
+	})
+
+	// 1. Create the attestation key
+	akDir := keytest.Create(t, tpm, tpmutil.ECCRestrictedSignerTemplate, nil)
+
+	// 2. Quote PCRs
+	quoteOpts := &options.QuoteOpts{
+		KeyBlobPath: filepath.Join(akDir, "key.tpm"),
+		PCRs:        "sha256:0,7,16",
+		OutputDir:   t.TempDir(),
+		NonceOpts:   options.NonceOpts{Nonce: testNonce},
+	}
+	sels, err := quoteCommand(tpm, quoteOpts)
+	require.NoError(t, err, "failed to quote PCRs")
+	require.Equal(t, "sha256:0,7,16", tpmutil.FormatPCRSelection(sels))
+	for _, file := range []string{quoteFileName, signatureFileName, pcrsFileName} {
+		require.FileExists(t, filepath.Join(quoteOpts.OutputDir, file))
+	}
+
+	// 3. Verify the quote
+	verifyOpts := &options.VerifyQuoteOpts{
+		PublicKeyPath: filepath.Join(akDir, "public.pem"),
+		InputDir:      quoteOpts.OutputDir,
+		NonceOpts:     options.NonceOpts{Nonce: testNonce},
+	}
+	quote, err := verifyQuoteCommand(verifyOpts)
+	require.NoError(t, err, "failed to verify quote")
+	require.Equal(t, "sha256:0,7,16", tpmutil.FormatPCRSelection(quote.PCRs))
+
+	// the verifier expects PCR 16 to hold an event the platform didn't extend
+	values, err := tpmutil.LoadPCRValues(filepath.Join(quoteOpts.OutputDir, pcrsFileName))
+	require.NoError(t, err)
+	event := sha256.Sum256([]byte("event"))
+	values[tpm2.TPMAlgSHA256][16], err = tpmutil.ComputePCR(tpm2.TPMAlgSHA256, nil, event[:])
+	require.NoError(t, err)
+	b, err := json.Marshal(values)
+	require.NoError(t, err)
+	expectedPath := filepath.Join(t.TempDir(), "expected.json")
+	require.NoError(t, os.WriteFile(expectedPath, b, 0644))
+	verifyOpts.PCRValuesFile = expectedPath
+	_, err = verifyQuoteCommand(verifyOpts)
+	require.ErrorIs(t, err, attest.ErrPCRDigest)
+
+	// once PCR 16 is extended, a new quote matches the expected values
+	require.NoError(t, tpmutil.ExtendPCR(tpm, 16, tpm2.TPMTHA{HashAlg: tpm2.TPMAlgSHA256, Digest: event[:]}))
+
+	// 4. Quote again, and verify it follows the previous quote
+	previousDir := quoteOpts.OutputDir
+	quoteOpts.OutputDir = t.TempDir()
+	quoteOpts.Nonce = "0807060504030201"
+	_, err = quoteCommand(tpm, quoteOpts)
+	require.NoError(t, err, "failed to quote PCRs")
+
+	verifyOpts.InputDir = quoteOpts.OutputDir
+	verifyOpts.Nonce = "0807060504030201"
+	verifyOpts.PreviousDir = previousDir
+	next, err := verifyQuoteCommand(verifyOpts)
+	require.NoError(t, err, "failed to verify the next quote against the expected values")
+	require.GreaterOrEqual(t, next.Attest.ClockInfo.Clock, quote.Attest.ClockInfo.Clock)
+}
+
+// TestInvalidInputs verifies that the commands reject invalid inputs.
+func TestInvalidInputs(t *testing.T) {
+	tpm, err := simulator.OpenSimulator()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, tpm.Close())
+	})
+
+	keyDir := keytest.Create(t, tpm, tpmutil.ECCSignerTemplate, nil)
+	keyPath := filepath.Join(keyDir, "key.tpm")
+
+	_, err = quoteCommand(tpm, &options.QuoteOpts{KeyBlobPath: keyPath, OutputDir: t.TempDir()})
+	require.ErrorContains(t, err, "invalid input: Nonce is required")
+
+	_, err = quoteCommand(tpm, &options.QuoteOpts{KeyBlobPath: keyPath, OutputDir: t.TempDir(), PCRs: "sha256:24", NonceOpts: options.NonceOpts{Nonce: testNonce}})
+	require.ErrorContains(t, err, "invalid input")
+
+	_, err = quoteCommand(tpm, &options.QuoteOpts{KeyBlobPath: keyPath, OutputDir: t.TempDir(), NonceOpts: options.NonceOpts{Nonce: testNonce}})
+	require.ErrorContains(t, err, "invalid input: the key must be a restricted signing key")
+
+	_, err = verifyQuoteCommand(&options.VerifyQuoteOpts{
+		PublicKeyPath: filepath.Join(keyDir, "public.pem"),
+		InputDir:      t.TempDir(),
+		PreviousDir:   filepath.Join(t.TempDir(), "missing"),
+		NonceOpts:     options.NonceOpts{Nonce: testNonce},
+	})
+	require.ErrorContains(t, err, "invalid input: PreviousDir does not exist")
+}
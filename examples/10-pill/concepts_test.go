@@ -0,0 +1,174 @@
+package pill10
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/go-tpm-kit/tpmtest"
+	"github.com/loicsikidi/tpm-pills/internal/attest"
+	"github.com/loicsikidi/tpm-pills/internal/keytest"
+	"github.com/loicsikidi/tpm-pills/internal/pemutil"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+)
+
+// quote quotes sels with the attestation key saved in dir.
+func quote(t *testing.T, thetpm transport.TPM, dir string, sels []tpmutil.PCRSelection) *tpm2.QuoteResponse {
+	t.Helper()
+	ak, err := tpmutil.LoadKey(thetpm, tpmutil.LoadKeyConfig{
+		ParentTemplate: tpmutil.ECCSRKTemplate,
+		KeyBlobPath:    filepath.Join(dir, "key.tpm"),
+	})
+	if err != nil {
+		t.Fatalf("failed to load key: %v", err)
+	}
+	defer ak.Close()
+	rsp, err := tpm2.Quote{
+		SignHandle: tpmutil.AuthHandle(ak),
+		InScheme:   tpm2.TPMTSigScheme{Scheme: tpm2.TPMAlgNull},
+		PCRSelect:  tpmutil.PCRSelectionList(sels),
+	}.Execute(thetpm)
+	if err != nil {
+		t.Fatalf("failed to execute quote command: %v", err)
+	}
+	return rsp
+}
+
+// TestQuotePCRDigest proves that the PCR digest of a quote is the hash of the concatenation
+// of the quoted PCR values, in the order of the selection.
+func TestQuotePCRDigest(t *testing.T) {
+	thetpm := tpmtest.OpenSimulator(t)
+
+	sels, err := tpmutil.ParsePCRSelection("sha256:0,7,16")
+	if err != nil {
+		t.Fatalf("failed to parse PCR selection: %v", err)
+	}
+	rsp := quote(t, thetpm, keytest.Create(t, thetpm, tpmutil.ECCRestrictedSignerTemplate, nil), sels)
+	info, err := attest.ParseQuote(rsp.Quoted.Bytes())
+	if err != nil {
+		t.Fatalf("failed to parse quote: %v", err)
+	}
+	quoteInfo, err := info.Attest.Attested.Quote()
+	if err != nil {
+		t.Fatalf("failed to get quote information: %v", err)
+	}
+
+	values, err := tpmutil.ReadPCRs(thetpm, sels)
+	if err != nil {
+		t.Fatalf("failed to read PCRs: %v", err)
+	}
+	expected, err := tpmutil.PCRDigest(tpm2.TPMAlgSHA256, values)
+	if err != nil {
+		t.Fatalf("failed to compute PCR digest: %v", err)
+	}
+	if !bytes.Equal(expected, quoteInfo.PCRDigest.Buffer) {
+		t.Errorf("PCR digest mismatch:\nexpected: %x\ngot: %x", expected, quoteInfo.PCRDigest.Buffer)
+	}
+}
+
+// TestClockOfConsecutiveQuotes proves that the quotes of the same boot share their reset and restart
+// counts while their clock moves forward: a verifier comparing two quotes hence rejects an older quote
+// replayed as the latest one (see [attest.CheckSuccession]).
+func TestClockOfConsecutiveQuotes(t *testing.T) {
+	thetpm := tpmtest.OpenSimulator(t)
+
+	sels := []tpmutil.PCRSelection{{Hash: tpm2.TPMAlgSHA256, PCRs: []int{16}}}
+	dir := keytest.Create(t, thetpm, tpmutil.ECCRestrictedSignerTemplate, nil)
+	first, err := attest.ParseQuote(quote(t, thetpm, dir, sels).Quoted.Bytes())
+	if err != nil {
+		t.Fatalf("failed to parse quote: %v", err)
+	}
+	// the clock of the TPM counts milliseconds
+	time.Sleep(10 * time.Millisecond)
+	second, err := attest.ParseQuote(quote(t, thetpm, dir, sels).Quoted.Bytes())
+	if err != nil {
+		t.Fatalf("failed to parse quote: %v", err)
+	}
+
+	prev, next := first.Attest.ClockInfo, second.Attest.ClockInfo
+	if next.ResetCount != prev.ResetCount || next.RestartCount != prev.RestartCount {
+		t.Errorf("expected the same reset and restart counts within a boot, got %+v then %+v", prev, next)
+	}
+	if next.Clock <= prev.Clock {
+		t.Errorf("expected the clock to move forward, got %d then %d", prev.Clock, next.Clock)
+	}
+	if err := attest.CheckSuccession(first.Attest, second.Attest); err != nil {
+		t.Errorf("expected the second quote to follow the first one, got: %v", err)
+	}
+	if err := attest.CheckSuccession(second.Attest, first.Attest); !errors.Is(err, attest.ErrClock) {
+		t.Errorf("expected ErrClock error, got: %v", err)
+	}
+}
+
+// openSwtpm starts swtpm with its state in stateDir, the test is skipped when swtpm isn't installed.
+func openSwtpm(t *testing.T, stateDir string) transport.TPMCloser {
+	t.Helper()
+	for _, bin := range []string{"swtpm", "swtpm_setup"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s is required to reboot a TPM: %v", bin, err)
+		}
+	}
+	thetpm, err := tpmutil.OpenTPM(tpmutil.Device("swtpm:" + stateDir))
+	if err != nil {
+		t.Fatalf("failed to open swtpm: %v", err)
+	}
+	return thetpm
+}
+
+// TestRebootBetweenQuotes proves that a reboot shows in the clock information of a quote:
+// the reset count is incremented, and the PCRs are back to their initial value.
+// A verifier comparing two quotes hence detects that the platform booted again in between.
+//
+// Unlike the simulator, swtpm keeps the persistent state of the TPM in a directory: stopping it
+// and starting it again on the same directory reboots the TPM, as each command of the pill does.
+func TestRebootBetweenQuotes(t *testing.T) {
+	stateDir := t.TempDir()
+	thetpm := openSwtpm(t, stateDir)
+	t.Cleanup(func() { thetpm.Close() })
+
+	sels := []tpmutil.PCRSelection{{Hash: tpm2.TPMAlgSHA256, PCRs: []int{16}}}
+	dir := keytest.Create(t, thetpm, tpmutil.ECCRestrictedSignerTemplate, nil)
+	if err := tpmutil.PCREvent(thetpm, 16, []byte("boot")); err != nil {
+		t.Fatalf("failed to extend PCR: %v", err)
+	}
+	before, err := attest.ParseQuote(quote(t, thetpm, dir, sels).Quoted.Bytes())
+	if err != nil {
+		t.Fatalf("failed to parse quote: %v", err)
+	}
+
+	// an orderly shutdown, then swtpm starts the TPM again with TPM2_Startup(CLEAR)
+	if _, err := (tpm2.Shutdown{ShutdownType: tpm2.TPMSUClear}).Execute(thetpm); err != nil {
+		t.Fatalf("failed to shut down the TPM: %v", err)
+	}
+	thetpm.Close()
+	thetpm = openSwtpm(t, stateDir)
+
+	rsp := quote(t, thetpm, dir, sels)
+	after, err := attest.ParseQuote(rsp.Quoted.Bytes())
+	if err != nil {
+		t.Fatalf("failed to parse quote: %v", err)
+	}
+
+	// the AK belongs to the owner hierarchy: its counts are offset, only their difference tells
+	if got := after.Attest.ClockInfo.ResetCount - before.Attest.ClockInfo.ResetCount; got != 1 {
+		t.Errorf("expected the reset count to move by 1 after a reboot, got %d", got)
+	}
+	if err := attest.CheckSuccession(before.Attest, after.Attest); !errors.Is(err, attest.ErrReboot) {
+		t.Errorf("expected ErrReboot error, got: %v", err)
+	}
+
+	// PCR 16 is back to zero: the event extended before the reboot is gone
+	zero := tpmutil.NewPCRValues(sels, [][]byte{make([]byte, 32)})
+	signer, err := pemutil.Read(filepath.Join(dir, "public.pem"))
+	if err != nil {
+		t.Fatalf("failed to read public key: %v", err)
+	}
+	if _, err := attest.VerifyQuote(signer, rsp.Quoted.Bytes(), &rsp.Signature, nil, zero); err != nil {
+		t.Errorf("expected PCR 16 to be zero after a reboot, got: %v", err)
+	}
+}
@@ -0,0 +1,387 @@
+//go:build !windows
+
+// Package pill11 holds the commands of pill #11: enroll an attestation key with the
+// Endorsement Key (EK) of the TPM through credential activation.
+package pill11
+
+import (
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/tpm-pills/internal/cli"
+	"github.com/loicsikidi/tpm-pills/internal/credential"
+	"github.com/loicsikidi/tpm-pills/internal/options"
+	"github.com/loicsikidi/tpm-pills/internal/pemutil"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+)
+
+// Files written by 'ek', 'enroll' and 'make-credential'.
+const (
+	// ekFileName holds the TPM2B_PUBLIC of the EK.
+	ekFileName = "ek.pub"
+	// ekCertFileName holds the PEM certificate of the EK.
+	ekCertFileName = "ek.pem"
+	// akFileName holds the TPM2B_PUBLIC of the attestation key.
+	akFileName = "ak.pub"
+	// credentialFileName holds the TPM2B_ID_OBJECT protecting the secret.
+	credentialFileName = "credential.bin"
+	// seedFileName holds the TPM2B_ENCRYPTED_SECRET, the seed of the credential wrapped to the EK.
+	seedFileName = "seed.bin"
+)
+
+// Commands returns the commands introduced by pill #11.
+func Commands() []*cli.Command {
+	readEKOpts := &options.ReadEKOpts{}
+	enrollOpts := &options.EnrollOpts{}
+	makeCredentialOpts := &options.MakeCredentialOpts{}
+	activateOpts := &options.ActivateOpts{}
+
+	return []*cli.Command{
+		{
+			Name:  "ek",
+			Usage: "Export the EK and its certificate, read from the NV index defined by the manufacturer",
+			Flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&readEKOpts.OutputDir, "out", "", "Output directory for the public area of the EK and its certificate")
+				fs.StringVar(&readEKOpts.CAPath, "ca", "", "Path to the PEM certificate of the CA the EK certificate must chain to")
+				cli.EKFlags(fs, &readEKOpts.EKOpts)
+			},
+			Run: func(env *cli.Env) error {
+				tpm, err := env.TPM()
+				if err != nil {
+					return err
+				}
+				info, err := readEKCommand(tpm, readEKOpts)
+				if err != nil {
+					return fmt.Errorf("error reading EK: %w", err)
+				}
+				fmt.Fprintf(env.Stdout, "EK: %x\n", info.name.Buffer)
+				if info.cert == nil {
+					fmt.Fprintf(env.Stdout, "No EK certificate at NV index 0x%x\n", uint32(info.certIndex))
+				} else {
+					fmt.Fprintf(env.Stdout, "EK certificate issued by %q, valid until %s\n", info.cert.Issuer, info.cert.NotAfter.Format("2006-01-02"))
+					if readEKOpts.CAPath != "" {
+						fmt.Fprintln(env.Stdout, "EK certificate verified successfully ✅")
+					}
+				}
+				fmt.Fprintf(env.Stdout, "EK saved to %s 🚀\n", readEKOpts.OutputDir)
+				return nil
+			},
+		},
+		{
+			Name:  "enroll",
+			Usage: "Export the public areas of the EK and of an attestation key for enrollment",
+			Flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&enrollOpts.KeyBlobPath, "key", "", "Path to the TPM key blob file of the attestation key")
+				fs.StringVar(&enrollOpts.OutputDir, "out", "", "Output directory for the public areas of the EK and of the attestation key")
+				cli.EKFlags(fs, &enrollOpts.EKOpts)
+			},
+			Run: func(env *cli.Env) error {
+				tpm, err := env.TPM()
+				if err != nil {
+					return err
+				}
+				name, err := enrollCommand(tpm, enrollOpts)
+				if err != nil {
+					return fmt.Errorf("error enrolling key: %w", err)
+				}
+				fmt.Fprintf(env.Stdout, "Attestation key %x ready for enrollment\n", name.Buffer)
+				fmt.Fprintf(env.Stdout, "Public areas saved to %s 🚀\n", enrollOpts.OutputDir)
+				return nil
+			},
+		},
+		{
+			Name:  "make-credential",
+			Usage: "Protect a secret for the TPM holding an EK, without a TPM",
+			Flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&makeCredentialOpts.InputDir, "in", "", "Directory holding the files written by 'enroll'")
+				fs.StringVar(&makeCredentialOpts.Secret, "secret", "", "Hex-encoded secret to protect (default: 32 random bytes)")
+				fs.StringVar(&makeCredentialOpts.OutputDir, "out", "", "Output directory for the credential and its seed")
+			},
+			Run: func(env *cli.Env) error {
+				secret, err := makeCredentialCommand(makeCredentialOpts)
+				if err != nil {
+					return fmt.Errorf("error making credential: %w", err)
+				}
+				fmt.Fprintf(env.Stdout, "Secret: %x\n", secret)
+				fmt.Fprintf(env.Stdout, "Credential saved to %s 🚀\n", makeCredentialOpts.OutputDir)
+				return nil
+			},
+		},
+		{
+			Name:  "activate",
+			Usage: "Recover the secret of a credential with the EK and the attestation key",
+			Flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&activateOpts.KeyBlobPath, "key", "", "Path to the TPM key blob file of the attestation key")
+				fs.StringVar(&activateOpts.InputDir, "in", "", "Directory holding the files written by 'make-credential'")
+				cli.EKFlags(fs, &activateOpts.EKOpts)
+				cli.AuthFlags(fs, &activateOpts.AuthOpts)
+			},
+			Run: func(env *cli.Env) error {
+				tpm, err := env.TPM()
+				if err != nil {
+					return err
+				}
+				secret, err := activateCommand(tpm, activateOpts)
+				if err != nil {
+					return fmt.Errorf("error activating credential: %w", err)
+				}
+				fmt.Fprintln(env.Stdout, "Credential activated successfully 🚀")
+				fmt.Fprintf(env.Stdout, "Secret: %x\n", secret)
+				return nil
+			},
+		},
+	}
+}
+
+// ekInfo is the result of 'ek'.
+type ekInfo struct {
+	name tpm2.TPM2BName
+	// cert is nil when the TPM holds no certificate at certIndex.
+	cert      *x509.Certificate
+	certIndex tpm2.TPMHandle
+}
+
+// readEKCommand writes the public area of the EK and its certificate, if the manufacturer
+// provisioned one.
+//
+// The certificate is checked against the EK and, when a CA is given, verified against it:
+// it proves that the EK lives in a genuine TPM.
+func readEKCommand(tpm transport.TPM, opts *options.ReadEKOpts) (*ekInfo, error) {
+	if err := opts.CheckAndSetDefaults(); err != nil {
+		return nil, err
+	}
+	profile, err := tpmutil.LookupEKProfile(opts.EKType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid input: %w", err)
+	}
+
+	rsp, closer, err := tpmutil.CreateEK(tpm, profile.Template, []byte(opts.EndorsementAuth))
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+	ek, err := rsp.OutPublic.Contents()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode EK: %w", err)
+	}
+	path := filepath.Join(opts.OutputDir, ekFileName)
+	if err := os.WriteFile(path, tpm2.Marshal(rsp.OutPublic), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	info := &ekInfo{name: rsp.Name, certIndex: profile.CertIndex}
+
+	info.cert, err = tpmutil.ReadEKCertificate(tpm, profile.CertIndex)
+	switch {
+	case errors.Is(err, tpmutil.ErrNoEKCertificate) && opts.CAPath == "":
+		return info, nil
+	case err != nil:
+		return nil, err
+	}
+	if err := tpmutil.CheckEKCertificate(info.cert, ek); err != nil {
+		return nil, err
+	}
+	if opts.CAPath != "" {
+		if err := verifyEKCertificate(info.cert, opts.CAPath); err != nil {
+			return nil, err
+		}
+	}
+	b, err := pemutil.SerializePEMToBytes(info.cert)
+	if err != nil {
+		return nil, err
+	}
+	path = filepath.Join(opts.OutputDir, ekCertFileName)
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return info, nil
+}
+
+// verifyEKCertificate verifies that cert chains to the CA certificate at caPath.
+func verifyEKCertificate(cert *x509.Certificate, caPath string) error {
+	v, err := pemutil.Read(caPath)
+	if err != nil {
+		return fmt.Errorf("error reading CA certificate: %w", err)
+	}
+	ca, ok := v.(*x509.Certificate)
+	if !ok {
+		return fmt.Errorf("invalid input: %s isn't a certificate", caPath)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	// the extended key usage of EK certificates, tcg-kp-EKCertificate, is unknown to crypto/x509
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+		return fmt.Errorf("failed to verify EK certificate: %w", err)
+	}
+	return nil
+}
+
+// enrollCommand writes the public areas of the EK and of the attestation key, and returns
+// the Name of the attestation key.
+func enrollCommand(tpm transport.TPM, opts *options.EnrollOpts) (*tpm2.TPM2BName, error) {
+	if err := opts.CheckAndSetDefaults(); err != nil {
+		return nil, err
+	}
+	profile, err := tpmutil.LookupEKProfile(opts.EKType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid input: %w", err)
+	}
+
+	ek, closer, err := tpmutil.CreateEK(tpm, profile.Template, []byte(opts.EndorsementAuth))
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+	akHandle, err := tpmutil.LoadKey(tpm, tpmutil.LoadKeyConfig{
+		ParentTemplate: tpmutil.ECCSRKTemplate,
+		KeyBlobPath:    opts.KeyBlobPath,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load key: %w", err)
+	}
+	defer akHandle.Close()
+
+	for name, content := range map[string][]byte{
+		ekFileName: tpm2.Marshal(ek.OutPublic),
+		akFileName: tpm2.Marshal(tpm2.New2B(*akHandle.Public())),
+	} {
+		path := filepath.Join(opts.OutputDir, name)
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", path, err)
+		}
+	}
+	return tpm2.ObjectName(akHandle.Public())
+}
+
+// makeCredentialCommand protects a secret for the TPM holding the EK written by 'enroll',
+// bound to the Name of the attestation key, and returns the secret.
+func makeCredentialCommand(opts *options.MakeCredentialOpts) ([]byte, error) {
+	if err := opts.CheckAndSetDefaults(); err != nil {
+		return nil, err
+	}
+	ek, err := readPublic(filepath.Join(opts.InputDir, ekFileName))
+	if err != nil {
+		return nil, fmt.Errorf("error reading EK: %w", err)
+	}
+	ak, err := readPublic(filepath.Join(opts.InputDir, akFileName))
+	if err != nil {
+		return nil, fmt.Errorf("error reading attestation key: %w", err)
+	}
+	// the credential only proves the attestation key lives in the TPM of the EK if it can't leave it
+	if attrs := ak.ObjectAttributes; !attrs.Restricted || !attrs.SignEncrypt || !attrs.FixedTPM {
+		return nil, fmt.Errorf("invalid input: the attestation key must be a restricted signing key with fixedTPM")
+	}
+	name, err := tpm2.ObjectName(ak)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute the Name of the attestation key: %w", err)
+	}
+
+	idObject, encSecret, err := credential.MakeCredential(ek, *name, opts.GetSecret())
+	if err != nil {
+		return nil, err
+	}
+	for name, content := range map[string][]byte{
+		credentialFileName: tpm2.Marshal(idObject),
+		seedFileName:       tpm2.Marshal(encSecret),
+	} {
+		path := filepath.Join(opts.OutputDir, name)
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", path, err)
+		}
+	}
+	return opts.GetSecret(), nil
+}
+
+// activateCommand recovers the secret of the credential written by 'make-credential'.
+//
+// The TPM only releases it when the EK unwraps the seed, and the attestation key has the
+// Name the credential is bound to.
+func activateCommand(tpm transport.TPM, opts *options.ActivateOpts) ([]byte, error) {
+	if err := opts.CheckAndSetDefaults(); err != nil {
+		return nil, err
+	}
+	profile, err := tpmutil.LookupEKProfile(opts.EKType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid input: %w", err)
+	}
+	policy, err := tpmutil.AuthPolicy(&opts.AuthOpts)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(filepath.Join(opts.InputDir, credentialFileName))
+	if err != nil {
+		return nil, fmt.Errorf("error reading credential: %w", err)
+	}
+	idObject, err := tpm2.Unmarshal[tpm2.TPM2BIDObject](b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal credential: %w", err)
+	}
+	b, err = os.ReadFile(filepath.Join(opts.InputDir, seedFileName))
+	if err != nil {
+		return nil, fmt.Errorf("error reading seed: %w", err)
+	}
+	encSecret, err := tpm2.Unmarshal[tpm2.TPM2BEncryptedSecret](b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal seed: %w", err)
+	}
+
+	ek, ekCloser, err := tpmutil.CreateEK(tpm, profile.Template, []byte(opts.EndorsementAuth))
+	if err != nil {
+		return nil, err
+	}
+	defer ekCloser()
+	akHandle, err := tpmutil.LoadKey(tpm, tpmutil.LoadKeyConfig{
+		ParentTemplate: tpmutil.ECCSRKTemplate,
+		KeyBlobPath:    opts.KeyBlobPath,
+		Auth:           opts.GetAuth(),
+		Policy:         policy,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load key: %w", err)
+	}
+	defer akHandle.Close()
+
+	akAuth, akCloser, err := tpmutil.AuthorizeKey(tpm, akHandle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to authorize key: %w", err)
+	}
+	defer akCloser()
+	ekSession, ekSessionCloser, err := tpmutil.EKSession(tpm, profile.Template, []byte(opts.EndorsementAuth))
+	if err != nil {
+		return nil, err
+	}
+	defer ekSessionCloser()
+
+	rsp, err := tpm2.ActivateCredential{
+		ActivateHandle: akAuth,
+		KeyHandle: tpm2.AuthHandle{
+			Handle: ek.ObjectHandle,
+			Name:   ek.Name,
+			Auth:   ekSession,
+		},
+		CredentialBlob: *idObject,
+		Secret:         *encSecret,
+	}.Execute(tpm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute activate credential command: %w", err)
+	}
+	return rsp.CertInfo.Buffer, nil
+}
+
+// readPublic reads a TPM2B_PUBLIC written by 'enroll'.
+func readPublic(path string) (*tpm2.TPMTPublic, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := tpm2.Unmarshal[tpm2.TPM2BPublic](b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal public area: %w", err)
+	}
+	return pub.Contents()
+}
@@ -0,0 +1,183 @@
+package pill11
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport/simulator"
+	"github.com/loicsikidi/tpm-pills/internal/ektest"
+	"github.com/loicsikidi/tpm-pills/internal/keytest"
+	"github.com/loicsikidi/tpm-pills/internal/options"
+	"github.com/loicsikidi/tpm-pills/internal/pemutil"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "00112233445566778899aabbccddeeff"
+
+// writeCA writes the certificate of a new test CA, and returns the CA and the path of its certificate.
+func writeCA(t *testing.T) (*ektest.CA, string) {
+	t.Helper()
+	ca, err := ektest.NewCA()
+	require.NoError(t, err)
+	b, err := pemutil.SerializePEMToBytes(ca.Certificate)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(path, b, 0644))
+	return ca, path
+}
+
+// TestReadEK tests that 'ek' exports the EK, and its certificate once the manufacturer
+// (here, a test CA) provisioned it.
+func TestReadEK(t *testing.T) {
+	tpm, err := simulator.OpenSimulator()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, tpm.Close())
+	})
+	ca, caPath := writeCA(t)
+	_, otherCAPath := writeCA(t)
+
+	for _, ekType := range tpmutil.EKTypes() {
+		t.Run(ekType, func(t *testing.T) {
+			// a simulator comes without EK certificates
+			dir := t.TempDir()
+			info, err := readEKCommand(tpm, &options.ReadEKOpts{OutputDir: dir, EKOpts: options.EKOpts{EKType: ekType}})
+			require.NoError(t, err)
+			require.Nil(t, info.cert)
+			require.FileExists(t, filepath.Join(dir, ekFileName))
+			require.NoFileExists(t, filepath.Join(dir, ekCertFileName))
+
+			_, err = readEKCommand(tpm, &options.ReadEKOpts{OutputDir: dir, CAPath: caPath, EKOpts: options.EKOpts{EKType: ekType}})
+			require.ErrorIs(t, err, tpmutil.ErrNoEKCertificate)
+
+			profile, err := tpmutil.LookupEKProfile(ekType)
+			require.NoError(t, err)
+			want, err := ca.Provision(tpm, profile)
+			require.NoError(t, err)
+
+			info, err = readEKCommand(tpm, &options.ReadEKOpts{OutputDir: dir, CAPath: caPath, EKOpts: options.EKOpts{EKType: ekType}})
+			require.NoError(t, err)
+			require.True(t, info.cert.Equal(want))
+			got, err := pemutil.Read(filepath.Join(dir, ekCertFileName))
+			require.NoError(t, err)
+			require.Equal(t, want.Raw, got.(*x509.Certificate).Raw)
+
+			_, err = readEKCommand(tpm, &options.ReadEKOpts{OutputDir: t.TempDir(), CAPath: otherCAPath, EKOpts: options.EKOpts{EKType: ekType}})
+			require.ErrorContains(t, err, "failed to verify EK certificate")
+		})
+	}
+}
+
+// TestEnrollmentWorkflow tests the full workflow of a device enrollment:
+// 1. Create an attestation key (a restricted signing key)
+// 2. Export the public areas of the EK and of the attestation key
+// 3. Protect a secret for the EK and the attestation key, without a TPM
+// 4. Recover the secret with the TPM
+func TestEnrollmentWorkflow(t *testing.T) {
+	tpm, err := simulator.OpenSimulator()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, tpm.Close())
+	})
+
+	for _, ekType := range tpmutil.EKTypes() {
+		t.Run(ekType, func(t *testing.T) {
+			// 1. Create the attestation key
+			akDir := keytest.Create(t, tpm, tpmutil.ECCRestrictedSignerTemplate, nil)
+			akPath := filepath.Join(akDir, "key.tpm")
+
+			// 2. Export the public areas
+			enrollOpts := &options.EnrollOpts{
+				KeyBlobPath: akPath,
+				OutputDir:   t.TempDir(),
+				EKOpts:      options.EKOpts{EKType: ekType},
+			}
+			_, err := enrollCommand(tpm, enrollOpts)
+			require.NoError(t, err, "failed to enroll key")
+			require.FileExists(t, filepath.Join(enrollOpts.OutputDir, ekFileName))
+			require.FileExists(t, filepath.Join(enrollOpts.OutputDir, akFileName))
+
+			// 3. Make the credential
+			makeCredentialOpts := &options.MakeCredentialOpts{
+				InputDir:  enrollOpts.OutputDir,
+				Secret:    testSecret,
+				OutputDir: t.TempDir(),
+			}
+			secret, err := makeCredentialCommand(makeCredentialOpts)
+			require.NoError(t, err, "failed to make credential")
+			require.Equal(t, testSecret, hex.EncodeToString(secret))
+
+			// 4. Activate the credential
+			got, err := activateCommand(tpm, &options.ActivateOpts{
+				KeyBlobPath: akPath,
+				InputDir:    makeCredentialOpts.OutputDir,
+				EKOpts:      options.EKOpts{EKType: ekType},
+			})
+			require.NoError(t, err, "failed to activate credential")
+			require.Equal(t, secret, got)
+
+			// another attestation key doesn't have the Name the credential is bound to
+			otherAK := keytest.Create(t, tpm, tpmutil.ECCRestrictedSignerTemplate, nil)
+			_, err = activateCommand(tpm, &options.ActivateOpts{
+				KeyBlobPath: filepath.Join(otherAK, "key.tpm"),
+				InputDir:    makeCredentialOpts.OutputDir,
+				EKOpts:      options.EKOpts{EKType: ekType},
+			})
+			require.ErrorIs(t, err, tpm2.TPMRCIntegrity)
+		})
+	}
+}
+
+// TestRandomSecret verifies that 'make-credential' generates a secret when none is given.
+func TestRandomSecret(t *testing.T) {
+	tpm, err := simulator.OpenSimulator()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, tpm.Close())
+	})
+
+	akPath := filepath.Join(keytest.Create(t, tpm, tpmutil.ECCRestrictedSignerTemplate, nil), "key.tpm")
+	dir := t.TempDir()
+	_, err = enrollCommand(tpm, &options.EnrollOpts{KeyBlobPath: akPath, OutputDir: dir})
+	require.NoError(t, err)
+
+	secret, err := makeCredentialCommand(&options.MakeCredentialOpts{InputDir: dir, OutputDir: dir})
+	require.NoError(t, err)
+	require.Len(t, secret, 32)
+
+	got, err := activateCommand(tpm, &options.ActivateOpts{KeyBlobPath: akPath, InputDir: dir})
+	require.NoError(t, err)
+	require.Equal(t, secret, got)
+}
+
+// TestInvalidInputs verifies that the commands reject invalid inputs.
+func TestInvalidInputs(t *testing.T) {
+	tpm, err := simulator.OpenSimulator()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, tpm.Close())
+	})
+
+	signerPath := filepath.Join(keytest.Create(t, tpm, tpmutil.ECCSignerTemplate, nil), "key.tpm")
+
+	_, err = enrollCommand(tpm, &options.EnrollOpts{KeyBlobPath: signerPath, OutputDir: t.TempDir(), EKOpts: options.EKOpts{EKType: "dsa"}})
+	require.ErrorContains(t, err, "invalid input: invalid EK type")
+
+	// only a restricted signing key can be enrolled
+	dir := t.TempDir()
+	_, err = enrollCommand(tpm, &options.EnrollOpts{KeyBlobPath: signerPath, OutputDir: dir})
+	require.NoError(t, err)
+	_, err = makeCredentialCommand(&options.MakeCredentialOpts{InputDir: dir, OutputDir: t.TempDir(), Secret: testSecret})
+	require.ErrorContains(t, err, "invalid input: the attestation key must be a restricted signing key")
+
+	_, err = makeCredentialCommand(&options.MakeCredentialOpts{InputDir: dir, OutputDir: t.TempDir(), Secret: "not hex"})
+	require.ErrorContains(t, err, "invalid input: Secret must be hex-encoded")
+
+	_, err = activateCommand(tpm, &options.ActivateOpts{KeyBlobPath: signerPath, InputDir: filepath.Join(t.TempDir(), "missing")})
+	require.ErrorContains(t, err, "invalid input: InputDir does not exist")
+}
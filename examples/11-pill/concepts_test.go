@@ -0,0 +1,171 @@
+package pill11
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpm2/transport/simulator"
+	"github.com/loicsikidi/go-tpm-kit/tpmtest"
+	"github.com/loicsikidi/tpm-pills/internal/credential"
+	"github.com/loicsikidi/tpm-pills/internal/keytest"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+)
+
+// openTPM opens a new simulated TPM: each one is manufactured with its own seeds,
+// hence two TPMs have different EKs. Only one simulator can be open at a time.
+func openTPM(t *testing.T) transport.TPMCloser {
+	t.Helper()
+	thetpm, err := simulator.OpenSimulator()
+	if err != nil {
+		t.Fatalf("failed to open simulator: %v", err)
+	}
+	// closing an already closed simulator only returns an error
+	t.Cleanup(func() { thetpm.Close() })
+	return thetpm
+}
+
+// createEK creates the ECC EK of the TPM, and returns its handle and a cleanup function.
+func createEK(t *testing.T, thetpm transport.TPM) (*tpm2.CreatePrimaryResponse, func()) {
+	t.Helper()
+	ek, closer, err := tpmutil.CreateEK(thetpm, tpmutil.ECCEKTemplate, nil)
+	if err != nil {
+		t.Fatalf("failed to create EK: %v", err)
+	}
+	return ek, closer
+}
+
+// createAK creates and loads an attestation key, and returns its handle.
+func createAK(t *testing.T, thetpm transport.TPM) tpmutil.HandleCloser {
+	t.Helper()
+	dir := keytest.Create(t, thetpm, tpmutil.ECCRestrictedSignerTemplate, nil)
+	ak, err := tpmutil.LoadKey(thetpm, tpmutil.LoadKeyConfig{
+		ParentTemplate: tpmutil.ECCSRKTemplate,
+		KeyBlobPath:    filepath.Join(dir, "key.tpm"),
+	})
+	if err != nil {
+		t.Fatalf("failed to load key: %v", err)
+	}
+	t.Cleanup(func() { ak.Close() })
+	return ak
+}
+
+// activate runs TPM2_ActivateCredential with the EK and the attestation key.
+func activate(t *testing.T, thetpm transport.TPM, ek *tpm2.CreatePrimaryResponse, ak tpmutil.Handle, idObject *tpm2.TPM2BIDObject, encSecret *tpm2.TPM2BEncryptedSecret) ([]byte, error) {
+	t.Helper()
+	session, closer, err := tpmutil.EKSession(thetpm, tpmutil.ECCEKTemplate, nil)
+	if err != nil {
+		t.Fatalf("failed to start EK session: %v", err)
+	}
+	defer closer()
+	rsp, err := tpm2.ActivateCredential{
+		ActivateHandle: tpmutil.AuthHandle(ak),
+		KeyHandle:      tpm2.AuthHandle{Handle: ek.ObjectHandle, Name: ek.Name, Auth: session},
+		CredentialBlob: *idObject,
+		Secret:         *encSecret,
+	}.Execute(thetpm)
+	if err != nil {
+		return nil, err
+	}
+	return rsp.CertInfo.Buffer, nil
+}
+
+// ekPublic returns the public area of the EK.
+func ekPublic(t *testing.T, ek *tpm2.CreatePrimaryResponse) *tpm2.TPMTPublic {
+	t.Helper()
+	pub, err := ek.OutPublic.Contents()
+	if err != nil {
+		t.Fatalf("failed to decode EK: %v", err)
+	}
+	return pub
+}
+
+// TestOnlyTheTPMOfTheEKActivates proves that a credential made for the EK of a TPM can't be
+// activated by another TPM, even when it is bound to an attestation key of that other TPM.
+func TestOnlyTheTPMOfTheEKActivates(t *testing.T) {
+	secret := []byte("enrollment secret")
+
+	// the server only knows the EK of the first TPM
+	tpmA := openTPM(t)
+	ek, closer := createEK(t, tpmA)
+	ekA := ekPublic(t, ek)
+	closer()
+	tpmA.Close()
+
+	// the second TPM claims its attestation key lives in the first TPM
+	tpmB := openTPM(t)
+	ak := createAK(t, tpmB)
+	ekB, closer := createEK(t, tpmB)
+	defer closer()
+	if bytes.Equal(tpm2.Marshal(ekPublic(t, ekB)), tpm2.Marshal(ekA)) {
+		t.Fatalf("expected the two TPMs to have different EKs")
+	}
+
+	idObject, encSecret, err := credential.MakeCredential(ekA, ak.Name(), secret)
+	if err != nil {
+		t.Fatalf("failed to make credential: %v", err)
+	}
+	if _, err := activate(t, tpmB, ekB, ak, idObject, encSecret); err == nil {
+		t.Errorf("expected a TPM without the EK of the credential to fail activating it")
+	}
+
+	// the same credential made for its own EK is activated
+	idObject, encSecret, err = credential.MakeCredential(ekPublic(t, ekB), ak.Name(), secret)
+	if err != nil {
+		t.Fatalf("failed to make credential: %v", err)
+	}
+	got, err := activate(t, tpmB, ekB, ak, idObject, encSecret)
+	if err != nil {
+		t.Fatalf("failed to activate credential: %v", err)
+	}
+	if !bytes.Equal(got, secret) {
+		t.Errorf("activated secret = %q, want %q", got, secret)
+	}
+}
+
+// TestCredentialBoundToAKName proves that the TPM checks the Name of the attestation key
+// the credential is bound to: the HMAC protecting the credential covers it.
+func TestCredentialBoundToAKName(t *testing.T) {
+	thetpm := tpmtest.OpenSimulator(t)
+	// the simulator holds 3 objects: the keys are created before the EK is loaded
+	ak, otherAK := createAK(t, thetpm), createAK(t, thetpm)
+	ek, closer := createEK(t, thetpm)
+	defer closer()
+
+	idObject, encSecret, err := credential.MakeCredential(ekPublic(t, ek), otherAK.Name(), []byte("enrollment secret"))
+	if err != nil {
+		t.Fatalf("failed to make credential: %v", err)
+	}
+	_, err = activate(t, thetpm, ek, ak, idObject, encSecret)
+	if !errors.Is(err, tpm2.TPMRCIntegrity) {
+		t.Errorf("expected TPMRCIntegrity error, got: %v", err)
+	}
+}
+
+// TestEKIsDerivedFromTheEndorsementSeed proves that the EK isn't stored by the TPM: it is derived
+// again from the endorsement seed and the template. This is why the certificate issued for the EK
+// at manufacturing still matches the EK created today, and why each template gives another key.
+func TestEKIsDerivedFromTheEndorsementSeed(t *testing.T) {
+	thetpm := tpmtest.OpenSimulator(t)
+	ek, closer := createEK(t, thetpm)
+	first := ekPublic(t, ek)
+	closer()
+
+	ek, closer = createEK(t, thetpm)
+	defer closer()
+	if !bytes.Equal(tpm2.Marshal(ekPublic(t, ek)), tpm2.Marshal(first)) {
+		t.Errorf("expected the same template to give the same EK")
+	}
+
+	highRange, highRangeCloser, err := tpmutil.CreateEK(thetpm, tpmutil.ECCEKHighRangeTemplate, nil)
+	if err != nil {
+		t.Fatalf("failed to create EK: %v", err)
+	}
+	defer highRangeCloser()
+	if bytes.Equal(tpm2.Marshal(ekPublic(t, highRange)), tpm2.Marshal(first)) {
+		t.Errorf("expected the high-range template to give another EK")
+	}
+}
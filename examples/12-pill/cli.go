@@ -0,0 +1,211 @@
+//go:build !windows
+
+// Package pill12 holds the commands of pill #12: provision the TPM with persistent keys,
+// following the TCG TPM v2.0 Provisioning Guidance.
+package pill12
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/tpm-pills/internal/cli"
+	"github.com/loicsikidi/tpm-pills/internal/options"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+)
+
+// Commands returns the commands introduced by pill #12.
+func Commands() []*cli.Command {
+	provisionOpts := &options.ProvisionOpts{}
+
+	return []*cli.Command{
+		{
+			Name:  "provision",
+			Usage: "Persist the SRK, the EK and an attestation key at the handles of the TCG provisioning guidance",
+			Flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&provisionOpts.OwnerAuth, "owner-auth", "", "Password of the owner hierarchy")
+				cli.EKFlags(fs, &provisionOpts.EKOpts)
+			},
+			Run: func(env *cli.Env) error {
+				tpm, err := env.TPM()
+				if err != nil {
+					return err
+				}
+				// the objects provisioned before an error are reported as well
+				objects, err := provisionCommand(tpm, provisionOpts)
+				for _, o := range objects {
+					status := "already provisioned ✅"
+					if o.created {
+						status = "created 🚀"
+					}
+					fmt.Fprintf(env.Stdout, "%s at handle 0x%x: %s\n", o.label, uint32(o.handle), status)
+					fmt.Fprintf(env.Stdout, "  Name: %x\n", o.name.Buffer)
+				}
+				if err != nil {
+					return fmt.Errorf("error provisioning TPM: %w", err)
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// provisioned is an object persisted by 'provision'.
+type provisioned struct {
+	label  string
+	handle tpm2.TPMHandle
+	name   tpm2.TPM2BName
+	// created is false when the object was already persisted.
+	created bool
+}
+
+// provisionCommand persists the SRK, the EK and an attestation key, and returns them.
+//
+// It is idempotent: an object already persisted at its handle is verified, and kept.
+// A handle holding another object is an error, the object is never replaced.
+func provisionCommand(tpm transport.TPM, opts *options.ProvisionOpts) ([]provisioned, error) {
+	if err := opts.CheckAndSetDefaults(); err != nil {
+		return nil, err
+	}
+	profile, err := tpmutil.LookupEKProfile(opts.EKType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid input: %w", err)
+	}
+	ownerAuth := []byte(opts.OwnerAuth)
+
+	var objects []provisioned
+	// 1. The SRK is created from the template every command uses as a parent: the keys they
+	// created are children of the persisted SRK as well
+	srk, err := tpm2.CreatePrimary{
+		PrimaryHandle: tpm2.AuthHandle{Handle: tpm2.TPMRHOwner, Auth: tpm2.PasswordAuth(ownerAuth)},
+		InPublic:      tpm2.New2B(tpmutil.ECCSRKTemplate),
+	}.Execute(tpm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SRK: %w", err)
+	}
+	o, err := persistPrimary(tpm, ownerAuth, "SRK", tpmutil.SRKHandle, srk)
+	tpm2.FlushContext{FlushHandle: srk.ObjectHandle}.Execute(tpm)
+	if err != nil {
+		return nil, err
+	}
+	objects = append(objects, *o)
+
+	// 2. The EK
+	ek, closer, err := tpmutil.CreateEK(tpm, profile.Template, []byte(opts.EndorsementAuth))
+	if err != nil {
+		return objects, err
+	}
+	o, err = persistPrimary(tpm, ownerAuth, "EK", tpmutil.EKHandle, ek)
+	closer()
+	if err != nil {
+		return objects, err
+	}
+	objects = append(objects, *o)
+
+	// 3. The attestation key, a child of the persisted SRK
+	o, err = persistAK(tpm, ownerAuth)
+	if err != nil {
+		return objects, err
+	}
+	return append(objects, *o), nil
+}
+
+// persistPrimary persists the primary key rsp at handle with the password of the owner hierarchy,
+// unless it is already persisted there.
+//
+// A primary key is derived from the seed of its hierarchy and its template: the persisted key
+// is the same when it has the same Name.
+func persistPrimary(tpm transport.TPM, ownerAuth []byte, label string, handle tpm2.TPMHandle, rsp *tpm2.CreatePrimaryResponse) (*provisioned, error) {
+	o := &provisioned{label: label, handle: handle, name: rsp.Name}
+	existing, err := tpmutil.ReadPersisted(tpm, handle)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		if !bytes.Equal(existing.Name.Buffer, rsp.Name.Buffer) {
+			return nil, fmt.Errorf("handle 0x%x holds another object than the %s, remove it with 'unpersist --handle 0x%x'", uint32(handle), label, uint32(handle))
+		}
+		return o, nil
+	}
+	if err := evictControl(tpm, ownerAuth, tpm2.NamedHandle{Handle: rsp.ObjectHandle, Name: rsp.Name}, handle); err != nil {
+		return nil, err
+	}
+	o.created = true
+	return o, nil
+}
+
+// persistAK creates an attestation key under the persisted SRK, and persists it at [tpmutil.AKHandle]
+// with the password of the owner hierarchy, unless an attestation key is already persisted there.
+//
+// Unlike a primary key, an ordinary key is random: the persisted key is verified with its attributes
+// and its qualified name, which proves its parent is the SRK.
+func persistAK(tpm transport.TPM, ownerAuth []byte) (*provisioned, error) {
+	srk, err := tpmutil.ReadPersisted(tpm, tpmutil.SRKHandle)
+	if err != nil {
+		return nil, err
+	}
+	if srk == nil {
+		return nil, fmt.Errorf("no SRK at handle 0x%x", uint32(tpmutil.SRKHandle))
+	}
+
+	existing, err := tpmutil.ReadPersisted(tpm, tpmutil.AKHandle)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		pub, err := existing.OutPublic.Contents()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode public area: %w", err)
+		}
+		if attrs := pub.ObjectAttributes; !attrs.Restricted || !attrs.SignEncrypt || !attrs.FixedTPM {
+			return nil, fmt.Errorf("handle 0x%x holds a key that isn't a restricted signing key with fixedTPM", uint32(tpmutil.AKHandle))
+		}
+		qualifiedName, err := tpmutil.QualifiedName(srk.QualifiedName, existing.Name)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(qualifiedName.Buffer, existing.QualifiedName.Buffer) {
+			return nil, fmt.Errorf("handle 0x%x holds a key that isn't a child of the SRK", uint32(tpmutil.AKHandle))
+		}
+		return &provisioned{label: "AK", handle: tpmutil.AKHandle, name: existing.Name}, nil
+	}
+
+	// the SRK has no password of its own: the password of the owner hierarchy only authorizes its creation
+	parent := tpm2.AuthHandle{Handle: tpmutil.SRKHandle, Name: srk.Name, Auth: tpm2.PasswordAuth(nil)}
+	created, err := tpm2.Create{
+		ParentHandle: parent,
+		InPublic:     tpm2.New2B(tpmutil.ECCRestrictedSignerTemplate),
+	}.Execute(tpm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AK: %w", err)
+	}
+	ak, err := tpm2.Load{
+		ParentHandle: parent,
+		InPrivate:    created.OutPrivate,
+		InPublic:     created.OutPublic,
+	}.Execute(tpm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AK: %w", err)
+	}
+	defer tpm2.FlushContext{FlushHandle: ak.ObjectHandle}.Execute(tpm)
+
+	if err := evictControl(tpm, ownerAuth, tpm2.NamedHandle{Handle: ak.ObjectHandle, Name: ak.Name}, tpmutil.AKHandle); err != nil {
+		return nil, err
+	}
+	return &provisioned{label: "AK", handle: tpmutil.AKHandle, name: ak.Name, created: true}, nil
+}
+
+// evictControl persists the transient object at handle with [tpm2.EvictControl], authorized by the owner hierarchy.
+func evictControl(tpm transport.TPM, ownerAuth []byte, object tpm2.NamedHandle, handle tpm2.TPMHandle) error {
+	_, err := tpm2.EvictControl{
+		Auth:             tpm2.AuthHandle{Handle: tpm2.TPMRHOwner, Auth: tpm2.PasswordAuth(ownerAuth)},
+		ObjectHandle:     &object,
+		PersistentHandle: handle,
+	}.Execute(tpm)
+	if err != nil {
+		return fmt.Errorf("failed to persist key at handle 0x%x: %w", uint32(handle), err)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package pill12
+
+import (
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpm2/transport/simulator"
+	"github.com/loicsikidi/tpm-pills/internal/options"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+	"github.com/stretchr/testify/require"
+)
+
+// openTPM opens a simulated TPM, closed at the end of the test.
+func openTPM(t *testing.T) transport.TPM {
+	t.Helper()
+	tpm, err := simulator.OpenSimulator()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, tpm.Close())
+	})
+	return tpm
+}
+
+// TestProvisionIsIdempotent tests that a second provisioning verifies and keeps the objects
+// persisted by the first one.
+func TestProvisionIsIdempotent(t *testing.T) {
+	tpm := openTPM(t)
+
+	first, err := provisionCommand(tpm, &options.ProvisionOpts{})
+	require.NoError(t, err)
+	require.Len(t, first, 3)
+	for i, handle := range []tpm2.TPMHandle{tpmutil.SRKHandle, tpmutil.EKHandle, tpmutil.AKHandle} {
+		require.Equal(t, handle, first[i].handle)
+		require.True(t, first[i].created, "expected %s to be created", first[i].label)
+
+		persisted, err := tpmutil.ReadPersisted(tpm, handle)
+		require.NoError(t, err)
+		require.NotNil(t, persisted)
+		require.Equal(t, first[i].name.Buffer, persisted.Name.Buffer)
+	}
+
+	second, err := provisionCommand(tpm, &options.ProvisionOpts{})
+	require.NoError(t, err)
+	require.Len(t, second, 3)
+	for i := range second {
+		require.False(t, second[i].created, "expected %s to be kept", second[i].label)
+		require.Equal(t, first[i].name, second[i].name)
+	}
+}
+
+// TestProvisionRefusesOtherObjects tests that 'provision' never replaces an object persisted
+// at one of its handles.
+func TestProvisionRefusesOtherObjects(t *testing.T) {
+	tpm := openTPM(t)
+	_, err := provisionCommand(tpm, &options.ProvisionOpts{})
+	require.NoError(t, err)
+
+	// the EK of another type isn't the persisted one
+	objects, err := provisionCommand(tpm, &options.ProvisionOpts{EKOpts: options.EKOpts{EKType: "ecc"}})
+	require.ErrorContains(t, err, "handle 0x81010001 holds another object than the EK")
+	require.Len(t, objects, 1, "expected the SRK to be reported")
+	require.False(t, objects[0].created)
+
+	// an ordinary signing key isn't an attestation key
+	evict(t, tpm, tpmutil.AKHandle)
+	persistChild(t, tpm, tpmutil.ECCSRKTemplate, tpmutil.ECCSignerTemplate, tpmutil.AKHandle)
+	_, err = provisionCommand(tpm, &options.ProvisionOpts{})
+	require.ErrorContains(t, err, "isn't a restricted signing key")
+
+	// an attestation key under another parent isn't bound to the SRK
+	evict(t, tpm, tpmutil.AKHandle)
+	persistChild(t, tpm, tpm2.RSASRKTemplate, tpmutil.ECCRestrictedSignerTemplate, tpmutil.AKHandle)
+	_, err = provisionCommand(tpm, &options.ProvisionOpts{})
+	require.ErrorContains(t, err, "isn't a child of the SRK")
+}
+
+// TestProvisionWithOwnerAuth tests that the objects are persisted with the password of the owner hierarchy.
+func TestProvisionWithOwnerAuth(t *testing.T) {
+	tpm := openTPM(t)
+	ownerAuth := "owner password"
+	_, err := tpm2.HierarchyChangeAuth{
+		AuthHandle: tpm2.TPMRHOwner,
+		NewAuth:    tpm2.TPM2BAuth{Buffer: []byte(ownerAuth)},
+	}.Execute(tpm)
+	require.NoError(t, err)
+
+	_, err = provisionCommand(tpm, &options.ProvisionOpts{})
+	require.ErrorContains(t, err, "failed to create SRK")
+
+	objects, err := provisionCommand(tpm, &options.ProvisionOpts{OwnerAuth: ownerAuth})
+	require.NoError(t, err)
+	require.Len(t, objects, 3)
+	for _, o := range objects {
+		require.True(t, o.created, "expected %s to be created", o.label)
+	}
+}
+
+// TestInvalidInputs verifies that the command rejects invalid inputs.
+func TestInvalidInputs(t *testing.T) {
+	tpm := openTPM(t)
+	_, err := provisionCommand(tpm, &options.ProvisionOpts{EKOpts: options.EKOpts{EKType: "dsa"}})
+	require.ErrorContains(t, err, "invalid input: invalid EK type")
+}
+
+// evict removes the object persisted at handle.
+func evict(t *testing.T, tpm transport.TPM, handle tpm2.TPMHandle) {
+	t.Helper()
+	persisted, err := tpmutil.ReadPersisted(tpm, handle)
+	require.NoError(t, err)
+	_, err = tpm2.EvictControl{
+		Auth:             tpm2.TPMRHOwner,
+		ObjectHandle:     &tpm2.NamedHandle{Handle: handle, Name: persisted.Name},
+		PersistentHandle: handle,
+	}.Execute(tpm)
+	require.NoError(t, err)
+}
+
+// persistChild creates a key from template under a transient primary key created from parentTemplate,
+// and persists it at handle.
+func persistChild(t *testing.T, tpm transport.TPM, parentTemplate, template tpm2.TPMTPublic, handle tpm2.TPMHandle) {
+	t.Helper()
+	srk, err := tpm2.CreatePrimary{
+		PrimaryHandle: tpm2.TPMRHOwner,
+		InPublic:      tpm2.New2B(parentTemplate),
+	}.Execute(tpm)
+	require.NoError(t, err)
+	defer tpm2.FlushContext{FlushHandle: srk.ObjectHandle}.Execute(tpm)
+	parent := tpm2.NamedHandle{Handle: srk.ObjectHandle, Name: srk.Name}
+	created, err := tpm2.Create{ParentHandle: parent, InPublic: tpm2.New2B(template)}.Execute(tpm)
+	require.NoError(t, err)
+	key, err := tpm2.Load{ParentHandle: parent, InPrivate: created.OutPrivate, InPublic: created.OutPublic}.Execute(tpm)
+	require.NoError(t, err)
+	defer tpm2.FlushContext{FlushHandle: key.ObjectHandle}.Execute(tpm)
+	_, err = tpm2.EvictControl{
+		Auth:             tpm2.TPMRHOwner,
+		ObjectHandle:     &tpm2.NamedHandle{Handle: key.ObjectHandle, Name: key.Name},
+		PersistentHandle: handle,
+	}.Execute(tpm)
+	require.NoError(t, err)
+}
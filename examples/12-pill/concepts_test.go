@@ -0,0 +1,96 @@
+package pill12
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport/simulator"
+	"github.com/loicsikidi/tpm-pills/internal/options"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+)
+
+// TestPersistedSRKIsTheTransientSRK proves that the persisted SRK is the key every command
+// creates on the fly from the same template: a key created before the provisioning is loaded
+// under the persisted SRK.
+func TestPersistedSRKIsTheTransientSRK(t *testing.T) {
+	thetpm, err := simulator.OpenSimulator()
+	if err != nil {
+		t.Fatalf("failed to open simulator: %v", err)
+	}
+	defer thetpm.Close()
+
+	// the key is created under a transient SRK, as every command does
+	transient, err := tpm2.CreatePrimary{
+		PrimaryHandle: tpm2.TPMRHOwner,
+		InPublic:      tpm2.New2B(tpmutil.ECCSRKTemplate),
+	}.Execute(thetpm)
+	if err != nil {
+		t.Fatalf("failed to create SRK: %v", err)
+	}
+	key, err := tpm2.Create{
+		ParentHandle: tpm2.NamedHandle{Handle: transient.ObjectHandle, Name: transient.Name},
+		InPublic:     tpm2.New2B(tpmutil.ECCSignerTemplate),
+	}.Execute(thetpm)
+	if err != nil {
+		t.Fatalf("failed to create key: %v", err)
+	}
+	tpm2.FlushContext{FlushHandle: transient.ObjectHandle}.Execute(thetpm)
+
+	if _, err := provisionCommand(thetpm, &options.ProvisionOpts{}); err != nil {
+		t.Fatalf("failed to provision TPM: %v", err)
+	}
+	srk, err := tpmutil.ReadPersisted(thetpm, tpmutil.SRKHandle)
+	if err != nil {
+		t.Fatalf("failed to read SRK: %v", err)
+	}
+	rsp, err := tpm2.Load{
+		ParentHandle: tpm2.NamedHandle{Handle: tpmutil.SRKHandle, Name: srk.Name},
+		InPrivate:    key.OutPrivate,
+		InPublic:     key.OutPublic,
+	}.Execute(thetpm)
+	if err != nil {
+		t.Fatalf("failed to load key under the persisted SRK: %v", err)
+	}
+	tpm2.FlushContext{FlushHandle: rsp.ObjectHandle}.Execute(thetpm)
+}
+
+// TestClearEvictsTheOwnerObjects proves that TPM2_Clear evicts the persisted objects and changes
+// the storage seed: provisioning again creates another SRK, and another AK. The endorsement seed
+// is kept: the EK, and its certificate, survive a change of owner.
+func TestClearEvictsTheOwnerObjects(t *testing.T) {
+	thetpm, err := simulator.OpenSimulator()
+	if err != nil {
+		t.Fatalf("failed to open simulator: %v", err)
+	}
+	defer thetpm.Close()
+
+	before, err := provisionCommand(thetpm, &options.ProvisionOpts{})
+	if err != nil {
+		t.Fatalf("failed to provision TPM: %v", err)
+	}
+	if _, err := (tpm2.Clear{
+		AuthHandle: tpm2.AuthHandle{Handle: tpm2.TPMRHPlatform, Auth: tpm2.PasswordAuth(nil)},
+	}).Execute(thetpm); err != nil {
+		t.Fatalf("failed to clear TPM: %v", err)
+	}
+	for _, handle := range []tpm2.TPMHandle{tpmutil.SRKHandle, tpmutil.EKHandle, tpmutil.AKHandle} {
+		if rsp, err := tpmutil.ReadPersisted(thetpm, handle); err != nil || rsp != nil {
+			t.Errorf("expected handle 0x%x to be evicted, got: %v, %v", uint32(handle), rsp, err)
+		}
+	}
+
+	after, err := provisionCommand(thetpm, &options.ProvisionOpts{})
+	if err != nil {
+		t.Fatalf("failed to provision TPM: %v", err)
+	}
+	for i, o := range after {
+		if !o.created {
+			t.Errorf("expected %s to be created again", o.label)
+		}
+		sameKey := bytes.Equal(o.name.Buffer, before[i].name.Buffer)
+		if wantSameKey := o.handle == tpmutil.EKHandle; sameKey != wantSameKey {
+			t.Errorf("%s is the same key after clear: %v, want %v", o.label, sameKey, wantSameKey)
+		}
+	}
+}
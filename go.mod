@@ -7,12 +7,12 @@ require (
 	github.com/google/go-tpm v0.9.8
 	github.com/loicsikidi/go-tpm-kit v0.5.1-0.20260219215753-aec6ad519b7a
 	github.com/stretchr/testify v1.11.1
+	golang.org/x/sys v0.41.0
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/google/go-tpm-tools v0.3.13-0.20230620182252-4639ecce2aba // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	golang.org/x/sys v0.41.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
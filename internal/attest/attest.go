@@ -0,0 +1,137 @@
+// Package attest verifies TPM attestations offline, without a TPM.
+//
+// An attestation is a TPMS_ATTEST structure signed by a TPM key. Its magic tells it
+// was generated by a TPM: a restricted signing key refuses to sign external data
+// starting with TPM_GENERATED_VALUE, hence a verifier can trust the content of an
+// attestation signed by such a key.
+package attest
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+)
+
+var (
+	// ErrSignature is returned when the signature of an attestation doesn't verify.
+	ErrSignature = errors.New("invalid signature")
+	// ErrMagic is returned when an attestation doesn't start with TPM_GENERATED_VALUE.
+	ErrMagic = errors.New("attestation not generated by a TPM")
+	// ErrType is returned when an attestation isn't of the expected type.
+	ErrType = errors.New("unexpected attestation type")
+	// ErrNonce is returned when the qualifying data of an attestation isn't the nonce of the verifier.
+	ErrNonce = errors.New("nonce mismatch")
+	// ErrName is returned when the certified Name isn't the one of the expected object.
+	ErrName = errors.New("certified name mismatch")
+)
+
+// VerifySignature checks sig over message with the public key of the signer.
+// The scheme and the hash are taken from sig: RSASSA, RSAPSS and ECDSA are supported.
+func VerifySignature(signer crypto.PublicKey, message []byte, sig *tpm2.TPMTSignature) error {
+	hashAlg, err := signatureHash(sig)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSignature, err)
+	}
+	hash, err := hashAlg.Hash()
+	if err != nil {
+		return fmt.Errorf("%w: unsupported hash algorithm %s", ErrSignature, tpmutil.AlgName(hashAlg))
+	}
+	h := hash.New()
+	h.Write(message)
+	digest := h.Sum(nil)
+
+	// signatureHash already decoded the signature, the algorithm is supported
+	switch sig.SigAlg {
+	case tpm2.TPMAlgRSASSA, tpm2.TPMAlgRSAPSS:
+		key, ok := signer.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: %s signature for a %T key", ErrSignature, tpmutil.AlgName(sig.SigAlg), signer)
+		}
+		if sig.SigAlg == tpm2.TPMAlgRSASSA {
+			rsaSig, _ := sig.Signature.RSASSA()
+			err = rsa.VerifyPKCS1v15(key, hash, digest, rsaSig.Sig.Buffer)
+		} else {
+			rsaSig, _ := sig.Signature.RSAPSS()
+			err = rsa.VerifyPSS(key, hash, digest, rsaSig.Sig.Buffer, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto})
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrSignature, err)
+		}
+	case tpm2.TPMAlgECDSA:
+		key, ok := signer.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: %s signature for a %T key", ErrSignature, tpmutil.AlgName(sig.SigAlg), signer)
+		}
+		eccSig, _ := sig.Signature.ECDSA()
+		r := new(big.Int).SetBytes(eccSig.SignatureR.Buffer)
+		s := new(big.Int).SetBytes(eccSig.SignatureS.Buffer)
+		if !ecdsa.Verify(key, digest, r, s) {
+			return fmt.Errorf("%w: ECDSA verification failed", ErrSignature)
+		}
+	}
+	return nil
+}
+
+// Parse decodes a TPMS_ATTEST after checking its magic.
+func Parse(attest []byte) (*tpm2.TPMSAttest, error) {
+	if len(attest) < 4 || tpm2.TPMGenerated(binary.BigEndian.Uint32(attest)) != tpm2.TPMGeneratedValue {
+		return nil, ErrMagic
+	}
+	info, err := tpm2.Unmarshal[tpm2.TPMSAttest](attest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse attestation: %w", err)
+	}
+	return info, nil
+}
+
+// Verify checks that attest is an attestation of type typ signed by signer over
+// the nonce of the verifier, and returns its content.
+func Verify(signer crypto.PublicKey, attest []byte, sig *tpm2.TPMTSignature, typ tpm2.TPMISTAttest, nonce []byte) (*tpm2.TPMSAttest, error) {
+	if err := VerifySignature(signer, attest, sig); err != nil {
+		return nil, err
+	}
+	info, err := Parse(attest)
+	if err != nil {
+		return nil, err
+	}
+	if info.Type != typ {
+		return nil, fmt.Errorf("%w: got 0x%04x, want 0x%04x", ErrType, uint16(info.Type), uint16(typ))
+	}
+	if !bytes.Equal(info.ExtraData.Buffer, nonce) {
+		return nil, fmt.Errorf("%w: got %x, want %x", ErrNonce, info.ExtraData.Buffer, nonce)
+	}
+	return info, nil
+}
+
+// signatureHash returns the hash algorithm of the scheme of sig.
+func signatureHash(sig *tpm2.TPMTSignature) (tpm2.TPMIAlgHash, error) {
+	switch sig.SigAlg {
+	case tpm2.TPMAlgRSASSA:
+		rsaSig, err := sig.Signature.RSASSA()
+		if err != nil {
+			return 0, err
+		}
+		return rsaSig.Hash, nil
+	case tpm2.TPMAlgRSAPSS:
+		rsaSig, err := sig.Signature.RSAPSS()
+		if err != nil {
+			return 0, err
+		}
+		return rsaSig.Hash, nil
+	case tpm2.TPMAlgECDSA:
+		eccSig, err := sig.Signature.ECDSA()
+		if err != nil {
+			return 0, err
+		}
+		return eccSig.Hash, nil
+	}
+	return 0, fmt.Errorf("unsupported signature algorithm %s", tpmutil.AlgName(sig.SigAlg))
+}
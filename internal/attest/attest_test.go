@@ -0,0 +1,201 @@
+package attest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+// signAttest signs attest in software as a TPM would, with the scheme sigAlg.
+func signAttest(t *testing.T, key crypto.Signer, sigAlg tpm2.TPMAlgID, attest []byte) *tpm2.TPMTSignature {
+	t.Helper()
+	digest := sha256.Sum256(attest)
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var sig []byte
+		var err error
+		if sigAlg == tpm2.TPMAlgRSAPSS {
+			sig, err = rsa.SignPSS(rand.Reader, k, crypto.SHA256, digest[:], nil)
+		} else {
+			sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		}
+		if err != nil {
+			t.Fatalf("failed to sign: %v", err)
+		}
+		return &tpm2.TPMTSignature{
+			SigAlg: sigAlg,
+			Signature: tpm2.NewTPMUSignature(sigAlg, &tpm2.TPMSSignatureRSA{
+				Hash: tpm2.TPMAlgSHA256,
+				Sig:  tpm2.TPM2BPublicKeyRSA{Buffer: sig},
+			}),
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatalf("failed to sign: %v", err)
+		}
+		return &tpm2.TPMTSignature{
+			SigAlg: tpm2.TPMAlgECDSA,
+			Signature: tpm2.NewTPMUSignature(tpm2.TPMAlgECDSA, &tpm2.TPMSSignatureECC{
+				Hash:       tpm2.TPMAlgSHA256,
+				SignatureR: tpm2.TPM2BECCParameter{Buffer: r.Bytes()},
+				SignatureS: tpm2.TPM2BECCParameter{Buffer: s.Bytes()},
+			}),
+		}
+	}
+	t.Fatalf("unsupported key %T", key)
+	return nil
+}
+
+// certifyInfo returns a TPMS_ATTEST as built by TPM2_Certify for subject.
+func certifyInfo(t *testing.T, subject *tpm2.TPMTPublic, nonce []byte) tpm2.TPMSAttest {
+	t.Helper()
+	name, err := tpm2.ObjectName(subject)
+	if err != nil {
+		t.Fatalf("failed to compute name: %v", err)
+	}
+	return tpm2.TPMSAttest{
+		Magic:     tpm2.TPMGeneratedValue,
+		Type:      tpm2.TPMSTAttestCertify,
+		ExtraData: tpm2.TPM2BData{Buffer: nonce},
+		Attested: tpm2.NewTPMUAttest(tpm2.TPMSTAttestCertify, &tpm2.TPMSCertifyInfo{
+			Name:          *name,
+			QualifiedName: *name,
+		}),
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	eccKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ECC key: %v", err)
+	}
+	message := []byte("attestation")
+
+	tests := []struct {
+		name   string
+		key    crypto.Signer
+		sigAlg tpm2.TPMAlgID
+	}{
+		{"rsassa", rsaKey, tpm2.TPMAlgRSASSA},
+		{"rsapss", rsaKey, tpm2.TPMAlgRSAPSS},
+		{"ecdsa", eccKey, tpm2.TPMAlgECDSA},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig := signAttest(t, tt.key, tt.sigAlg, message)
+			if err := VerifySignature(tt.key.Public(), message, sig); err != nil {
+				t.Errorf("VerifySignature() error = %v", err)
+			}
+			if err := VerifySignature(tt.key.Public(), []byte("tampered"), sig); !errors.Is(err, ErrSignature) {
+				t.Errorf("VerifySignature() of a tampered message error = %v, want %v", err, ErrSignature)
+			}
+		})
+	}
+
+	// the type of the key must match the signature
+	sig := signAttest(t, eccKey, tpm2.TPMAlgECDSA, message)
+	if err := VerifySignature(rsaKey.Public(), message, sig); !errors.Is(err, ErrSignature) {
+		t.Errorf("VerifySignature() with an RSA key error = %v, want %v", err, ErrSignature)
+	}
+}
+
+func TestVerifyCertify(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	subject := tpm2.ECCSRKTemplate
+	other := tpm2.RSASRKTemplate
+	nonce := []byte("nonce of the verifier")
+
+	tests := []struct {
+		name    string
+		modify  func(info *tpm2.TPMSAttest)
+		subject *tpm2.TPMTPublic
+		nonce   []byte
+		wantErr error
+	}{
+		{
+			name:    "ok",
+			subject: &subject,
+			nonce:   nonce,
+		},
+		{
+			name:    "wrong magic",
+			modify:  func(info *tpm2.TPMSAttest) { info.Magic = 0xdeadbeef },
+			subject: &subject,
+			nonce:   nonce,
+			wantErr: ErrMagic,
+		},
+		{
+			name: "wrong type",
+			modify: func(info *tpm2.TPMSAttest) {
+				info.Type = tpm2.TPMSTAttestQuote
+				info.Attested = tpm2.NewTPMUAttest(tpm2.TPMSTAttestQuote, &tpm2.TPMSQuoteInfo{})
+			},
+			subject: &subject,
+			nonce:   nonce,
+			wantErr: ErrType,
+		},
+		{
+			name:    "wrong nonce",
+			subject: &subject,
+			nonce:   []byte("replayed"),
+			wantErr: ErrNonce,
+		},
+		{
+			name:    "wrong subject",
+			subject: &other,
+			nonce:   nonce,
+			wantErr: ErrName,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := certifyInfo(t, &subject, nonce)
+			if tt.modify != nil {
+				tt.modify(&info)
+			}
+			b := tpm2.Marshal(info)
+			sig := signAttest(t, key, tpm2.TPMAlgECDSA, b)
+
+			_, err := VerifyCertify(key.Public(), b, sig, tt.nonce, tt.subject)
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("VerifyCertify() error = %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("VerifyCertify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsAnotherSigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	subject := tpm2.ECCSRKTemplate
+	b := tpm2.Marshal(certifyInfo(t, &subject, nil))
+	sig := signAttest(t, key, tpm2.TPMAlgECDSA, b)
+
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	if _, err := VerifyCertify(other.Public(), b, sig, nil, &subject); !errors.Is(err, ErrSignature) {
+		t.Errorf("VerifyCertify() with another signer error = %v, want %v", err, ErrSignature)
+	}
+}
@@ -0,0 +1,34 @@
+package attest
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+// VerifyCertify checks a TPM2_Certify attestation: attest must be signed by signer,
+// qualified by nonce and certify the Name of subject, the public area of the certified
+// object. It returns the certify information on success.
+//
+// The Name is the digest of the public area, so it binds every attribute of subject:
+// a verifier can check them (e.g. fixedTPM) once VerifyCertify succeeds.
+func VerifyCertify(signer crypto.PublicKey, attest []byte, sig *tpm2.TPMTSignature, nonce []byte, subject *tpm2.TPMTPublic) (*tpm2.TPMSCertifyInfo, error) {
+	info, err := Verify(signer, attest, sig, tpm2.TPMSTAttestCertify, nonce)
+	if err != nil {
+		return nil, err
+	}
+	certify, err := info.Attested.Certify()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get certify information: %w", err)
+	}
+	name, err := tpm2.ObjectName(subject)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute the name of the subject: %w", err)
+	}
+	if !bytes.Equal(certify.Name.Buffer, name.Buffer) {
+		return nil, fmt.Errorf("%w: got %x, want %x", ErrName, certify.Name.Buffer, name.Buffer)
+	}
+	return certify, nil
+}
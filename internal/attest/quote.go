@@ -0,0 +1,125 @@
+package attest
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"fmt"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+)
+
+var (
+	// ErrPCRDigest is returned when the PCR values don't match the digest of a quote.
+	ErrPCRDigest = errors.New("PCR digest mismatch")
+	// ErrReboot is returned when the TPM was reset (i.e. the platform rebooted) between two quotes.
+	ErrReboot = errors.New("TPM reset between quotes")
+	// ErrRestart is returned when the TPM was restarted (e.g. the platform resumed from
+	// hibernation) between two quotes.
+	ErrRestart = errors.New("TPM restart between quotes")
+	// ErrClock is returned when the clock or the counters of the TPM went backwards between two quotes.
+	ErrClock = errors.New("TPM clock went backwards")
+	// ErrSigner is returned when two quotes compared by [CheckSuccession] weren't signed by the same key.
+	ErrSigner = errors.New("quotes signed by different keys")
+)
+
+// Quote is a verified TPM2_Quote.
+type Quote struct {
+	// Attest is the content of the quote.
+	Attest *tpm2.TPMSAttest
+	// PCRs is the selection of quoted PCRs, in the order of their digest.
+	PCRs []tpmutil.PCRSelection
+}
+
+// ParseQuote decodes a TPMS_ATTEST holding a TPMS_QUOTE_INFO, without verifying it.
+func ParseQuote(attest []byte) (*Quote, error) {
+	info, err := Parse(attest)
+	if err != nil {
+		return nil, err
+	}
+	if info.Type != tpm2.TPMSTAttestQuote {
+		return nil, fmt.Errorf("%w: got 0x%04x, want 0x%04x", ErrType, uint16(info.Type), uint16(tpm2.TPMSTAttestQuote))
+	}
+	quote, _, err := newQuote(info)
+	return quote, err
+}
+
+func newQuote(info *tpm2.TPMSAttest) (*Quote, *tpm2.TPMSQuoteInfo, error) {
+	quoteInfo, err := info.Attested.Quote()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get quote information: %w", err)
+	}
+	return &Quote{Attest: info, PCRs: tpmutil.PCRSelectionFromList(quoteInfo.PCRSelect)}, quoteInfo, nil
+}
+
+// VerifyQuote checks a TPM2_Quote: attest must be signed by signer and qualified by nonce,
+// and its PCR digest must match the values of the quoted PCRs in values.
+//
+// The PCR digest is the hash of the concatenation of the quoted PCR values,
+// computed with the hash algorithm of the signing scheme.
+func VerifyQuote(signer crypto.PublicKey, attest []byte, sig *tpm2.TPMTSignature, nonce []byte, values tpmutil.PCRValues) (*Quote, error) {
+	info, err := Verify(signer, attest, sig, tpm2.TPMSTAttestQuote, nonce)
+	if err != nil {
+		return nil, err
+	}
+	quote, quoteInfo, err := newQuote(info)
+	if err != nil {
+		return nil, err
+	}
+
+	pcrValues, err := values.Values(quote.PCRs)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPCRDigest, err)
+	}
+	hashAlg, err := signatureHash(sig)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := tpmutil.PCRDigest(hashAlg, pcrValues)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute the PCR digest: %w", err)
+	}
+	if !bytes.Equal(digest, quoteInfo.PCRDigest.Buffer) {
+		return nil, fmt.Errorf("%w: got %x, want %x", ErrPCRDigest, digest, quoteInfo.PCRDigest.Buffer)
+	}
+	return quote, nil
+}
+
+// CheckSuccession checks that next was produced after previous by the same TPM,
+// without a reset or a restart in between.
+//
+// A TPM reset increments resetCount and a restart increments restartCount: both
+// mean that the PCRs may have been extended by a new boot since previous.
+// Within the same boot, the clock only moves forward.
+//
+// Both quotes must be signed by the same key, which is checked with their qualifiedSigner:
+// unless the key belongs to the endorsement or the platform hierarchy (e.g. an AK created
+// under the SRK), the TPM offsets both counts by a value derived from the key. Only their
+// differences are hence meaningful, not the counts themselves.
+func CheckSuccession(previous, next *tpm2.TPMSAttest) error {
+	if !bytes.Equal(previous.QualifiedSigner.Buffer, next.QualifiedSigner.Buffer) {
+		return fmt.Errorf("%w: %x, previously %x", ErrSigner, next.QualifiedSigner.Buffer, previous.QualifiedSigner.Buffer)
+	}
+	prev, cur := previous.ClockInfo, next.ClockInfo
+	resets, restarts := countDelta(prev.ResetCount, cur.ResetCount), countDelta(prev.RestartCount, cur.RestartCount)
+	switch {
+	case resets < 0:
+		return fmt.Errorf("%w: reset count moved by %d", ErrClock, resets)
+	case resets > 0:
+		return fmt.Errorf("%w: reset count moved by %d", ErrReboot, resets)
+	case restarts < 0:
+		return fmt.Errorf("%w: restart count moved by %d", ErrClock, restarts)
+	case restarts > 0:
+		return fmt.Errorf("%w: restart count moved by %d", ErrRestart, restarts)
+	case cur.Clock < prev.Clock:
+		return fmt.Errorf("%w: clock %d, previously %d", ErrClock, cur.Clock, prev.Clock)
+	}
+	return nil
+}
+
+// countDelta returns how much a count of the TPM moved from prev to cur. The offset applied to
+// the counts of a key may make them wrap around, their difference is hence computed modulo 2^32.
+func countDelta(prev, cur uint32) int32 {
+	return int32(cur - prev)
+}
@@ -0,0 +1,130 @@
+package attest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+)
+
+// quoteInfo returns a TPMS_ATTEST as built by TPM2_Quote for the PCRs sels holding values.
+func quoteInfo(t *testing.T, sels []tpmutil.PCRSelection, values [][]byte, nonce []byte) tpm2.TPMSAttest {
+	t.Helper()
+	digest, err := tpmutil.PCRDigest(tpm2.TPMAlgSHA256, values)
+	if err != nil {
+		t.Fatalf("failed to compute PCR digest: %v", err)
+	}
+	return tpm2.TPMSAttest{
+		Magic:     tpm2.TPMGeneratedValue,
+		Type:      tpm2.TPMSTAttestQuote,
+		ExtraData: tpm2.TPM2BData{Buffer: nonce},
+		Attested: tpm2.NewTPMUAttest(tpm2.TPMSTAttestQuote, &tpm2.TPMSQuoteInfo{
+			PCRSelect: tpmutil.PCRSelectionList(sels),
+			PCRDigest: tpm2.TPM2BDigest{Buffer: digest},
+		}),
+	}
+}
+
+func TestVerifyQuote(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	sels := []tpmutil.PCRSelection{{Hash: tpm2.TPMAlgSHA256, PCRs: []int{0, 7}}}
+	pcr0, pcr7 := sha256.Sum256([]byte("firmware")), sha256.Sum256([]byte("secure boot"))
+	values := [][]byte{pcr0[:], pcr7[:]}
+	nonce := []byte("nonce of the verifier")
+
+	b := tpm2.Marshal(quoteInfo(t, sels, values, nonce))
+	sig := signAttest(t, key, tpm2.TPMAlgECDSA, b)
+
+	tests := []struct {
+		name    string
+		values  tpmutil.PCRValues
+		wantErr error
+	}{
+		{
+			name:   "ok",
+			values: tpmutil.NewPCRValues(sels, values),
+		},
+		{
+			name:    "extended PCR",
+			values:  tpmutil.NewPCRValues(sels, [][]byte{pcr0[:], pcr0[:]}),
+			wantErr: ErrPCRDigest,
+		},
+		{
+			name:    "missing PCR",
+			values:  tpmutil.NewPCRValues(sels[:1], values[:1]),
+			wantErr: ErrPCRDigest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quote, err := VerifyQuote(key.Public(), b, sig, nonce, tt.values)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("VerifyQuote() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifyQuote() error = %v", err)
+			}
+			if got := tpmutil.FormatPCRSelection(quote.PCRs); got != "sha256:0,7" {
+				t.Errorf("VerifyQuote() PCRs = %q, want %q", got, "sha256:0,7")
+			}
+		})
+	}
+
+	if _, err := VerifyQuote(key.Public(), b, sig, []byte("replayed"), tpmutil.NewPCRValues(sels, values)); !errors.Is(err, ErrNonce) {
+		t.Errorf("VerifyQuote() with another nonce error = %v, want %v", err, ErrNonce)
+	}
+}
+
+func TestCheckSuccession(t *testing.T) {
+	previous := tpm2.TPMSClockInfo{Clock: 1000, ResetCount: 2, RestartCount: 1}
+	tests := []struct {
+		name    string
+		next    tpm2.TPMSClockInfo
+		wantErr error
+	}{
+		{name: "ok", next: tpm2.TPMSClockInfo{Clock: 2000, ResetCount: 2, RestartCount: 1}},
+		{name: "reboot", next: tpm2.TPMSClockInfo{Clock: 2000, ResetCount: 3}, wantErr: ErrReboot},
+		{name: "restart", next: tpm2.TPMSClockInfo{Clock: 2000, ResetCount: 2, RestartCount: 2}, wantErr: ErrRestart},
+		{name: "clock rollback", next: tpm2.TPMSClockInfo{Clock: 500, ResetCount: 2, RestartCount: 1}, wantErr: ErrClock},
+		{name: "reset count rollback", next: tpm2.TPMSClockInfo{Clock: 2000, ResetCount: 1, RestartCount: 1}, wantErr: ErrClock},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckSuccession(&tpm2.TPMSAttest{ClockInfo: previous}, &tpm2.TPMSAttest{ClockInfo: tt.next})
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("CheckSuccession() error = %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("CheckSuccession() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// the counts of a key outside the endorsement and platform hierarchies are offset, and may wrap around
+	wrapped := tpm2.TPMSClockInfo{Clock: 1000, ResetCount: 0xffffffff, RestartCount: 1}
+	next := tpm2.TPMSClockInfo{Clock: 2000, ResetCount: 0, RestartCount: 1}
+	if err := CheckSuccession(&tpm2.TPMSAttest{ClockInfo: wrapped}, &tpm2.TPMSAttest{ClockInfo: next}); !errors.Is(err, ErrReboot) {
+		t.Errorf("CheckSuccession() with a wrapped reset count error = %v, want %v", err, ErrReboot)
+	}
+
+	// the counts of quotes signed by different keys can't be compared
+	signer := func(name string) tpm2.TPM2BName { return tpm2.TPM2BName{Buffer: []byte(name)} }
+	err := CheckSuccession(
+		&tpm2.TPMSAttest{QualifiedSigner: signer("ak"), ClockInfo: previous},
+		&tpm2.TPMSAttest{QualifiedSigner: signer("other"), ClockInfo: tpm2.TPMSClockInfo{Clock: 2000, ResetCount: 2, RestartCount: 1}},
+	)
+	if !errors.Is(err, ErrSigner) {
+		t.Errorf("CheckSuccession() with another signer error = %v, want %v", err, ErrSigner)
+	}
+}
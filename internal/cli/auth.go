@@ -0,0 +1,23 @@
+//go:build !windows
+
+package cli
+
+import (
+	"flag"
+
+	"github.com/loicsikidi/tpm-pills/internal/options"
+)
+
+// AuthFlags registers the flags authorizing the use of a key: --auth, --auth-file, --auth-prompt and --policy.
+func AuthFlags(fs *flag.FlagSet, opts *options.AuthOpts) {
+	fs.StringVar(&opts.Auth, "auth", "", "Password of the key (visible in the process list, prefer --auth-file or --auth-prompt)")
+	fs.StringVar(&opts.AuthFile, "auth-file", "", "File holding the password of the key ('-' for stdin)")
+	fs.BoolVar(&opts.Prompt, "auth-prompt", false, "Prompt for the password of the key")
+	fs.StringVar(&opts.PolicyPath, "policy", "", "JSON file holding the policy required to use the key")
+}
+
+// EKFlags registers the flags selecting the Endorsement Key: --ek-type and --endorsement-auth.
+func EKFlags(fs *flag.FlagSet, opts *options.EKOpts) {
+	fs.StringVar(&opts.EKType, "ek-type", "", "Type of the EK: 'rsa', 'ecc', 'rsa-high' or 'ecc-high' (default: 'rsa')")
+	fs.StringVar(&opts.EndorsementAuth, "endorsement-auth", "", "Password of the endorsement hierarchy")
+}
@@ -0,0 +1,312 @@
+//go:build !windows
+
+// Package cli provides the subcommand registry behind the tpm-pills command.
+//
+// Each pill exposes its commands as a list of [Command] which are registered into an [App].
+// The App takes care of global flags, TPM opening and error reporting, so a pill only has
+// to describe its own flags and logic.
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+)
+
+// Command describes a tpm-pills command or a group of commands.
+type Command struct {
+	// Name is the word used to invoke the command.
+	Name string
+	// Usage is a one-line description displayed in help messages.
+	Usage string
+	// Flags registers the flags specific to the command.
+	Flags func(fs *flag.FlagSet)
+	// Run executes the command. It must be nil for a group of commands.
+	Run func(env *Env) error
+	// Subcommands holds the commands of a group (e.g. 'key create').
+	Subcommands []*Command
+}
+
+func (c *Command) isGroup() bool {
+	return c.Run == nil
+}
+
+// GlobalOpts holds the flags shared by every command.
+type GlobalOpts struct {
+	// Device is the TPM device selector (see [tpmutil.ParseDevice]).
+	Device string
+	// Trace is the file where TPM commands are traced as JSON lines ('-' for stderr).
+	Trace string
+	// Deprecated: use Device instead.
+	UseRealTPM bool
+}
+
+// register binds the global flags to fs. Current values are used as defaults
+// so that a flag set before the subcommand isn't reset by the subcommand flag set.
+func (o *GlobalOpts) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.Device, "device", o.Device, "TPM device: swtpm:[state dir], sim:, dev:[path] or tcp:[host][:port] (default: $"+tpmutil.DeviceEnvVar+" or swtpm:)")
+	fs.StringVar(&o.Trace, "trace", o.Trace, "Write a JSON-lines trace of the TPM commands to the given file ('-' for stderr)")
+	fs.BoolVar(&o.UseRealTPM, "use-real-tpm", o.UseRealTPM, "Deprecated: use --device dev:/dev/tpmrm0 instead")
+}
+
+func (o *GlobalOpts) device() (tpmutil.Device, error) {
+	if o.UseRealTPM && o.Device == "" {
+		return tpmutil.LINUX, nil
+	}
+	return tpmutil.SelectDevice(o.Device, tpmutil.SWTPM)
+}
+
+// Env is the execution environment handed to [Command.Run].
+type Env struct {
+	// Stdout is where commands print their results.
+	Stdout io.Writer
+	// Args holds the positional arguments left after flag parsing.
+	Args []string
+
+	opts   *GlobalOpts
+	open   func(tpmutil.Device) (transport.TPMCloser, error)
+	stderr io.Writer
+	tpm    transport.TPMCloser
+	trace  *os.File
+	// retrier is set when the TPM retries transient warnings (see [tpmutil.OpenTPM]).
+	retrier *tpmutil.Retrier
+}
+
+// Device returns the TPM device selected by the global flags.
+func (e *Env) Device() (tpmutil.Device, error) {
+	return e.opts.device()
+}
+
+// TPM returns a connection to the TPM selected by the global flags.
+//
+// The connection is opened on first use and closed by the [App] once the command returns.
+func (e *Env) TPM() (transport.TPM, error) {
+	if e.tpm == nil {
+		device, err := e.Device()
+		if err != nil {
+			return nil, err
+		}
+		tpm, err := e.open(device)
+		if err != nil {
+			return nil, fmt.Errorf("can't open tpm: %w", err)
+		}
+		e.retrier, _ = tpm.(*tpmutil.Retrier)
+		if tpm, err = e.traceTPM(tpm); err != nil {
+			return nil, err
+		}
+		e.tpm = tpm
+	}
+	return e.tpm, nil
+}
+
+// traceTPM wraps tpm with a [tpmutil.Tracer] when tracing is enabled.
+func (e *Env) traceTPM(tpm transport.TPMCloser) (transport.TPMCloser, error) {
+	switch e.opts.Trace {
+	case "":
+		return tpm, nil
+	case "-":
+		return tpmutil.NewTracer(tpm, e.stderr), nil
+	}
+	f, err := os.OpenFile(e.opts.Trace, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		tpm.Close()
+		return nil, fmt.Errorf("failed to open trace file: %w", err)
+	}
+	e.trace = f
+	return tpmutil.NewTracer(tpm, f), nil
+}
+
+func (e *Env) close() error {
+	if e.tpm == nil {
+		return nil
+	}
+	err := e.tpm.Close()
+	if e.trace != nil {
+		if closeErr := e.trace.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// App is a registry of commands sharing the same global flags.
+type App struct {
+	// Name is the program name displayed in help messages.
+	Name string
+	// Stdout and Stderr default to [os.Stdout] and [os.Stderr].
+	Stdout io.Writer
+	Stderr io.Writer
+	// OpenTPM opens the TPM requested by a command (default: [tpmutil.OpenTPM]).
+	OpenTPM func(tpmutil.Device) (transport.TPMCloser, error)
+
+	commands []*Command
+	opts     GlobalOpts
+}
+
+// New returns an [App] which already holds the 'cleanup', 'state', 'info', 'template', 'pcr' and 'da' commands.
+func New(name string) *App {
+	app := &App{
+		Name:    name,
+		Stdout:  os.Stdout,
+		Stderr:  os.Stderr,
+		OpenTPM: tpmutil.OpenTPM,
+	}
+	app.Register(cleanupCommand(), stateCommand(), infoCommand(), templateCommand(), pcrCommand(), daCommand())
+	return app
+}
+
+// Register adds commands to the registry.
+//
+// Groups sharing the same name are merged, which lets several pills contribute
+// to the same group. Registering the same command twice is a programming error and panics.
+func (a *App) Register(cmds ...*Command) {
+	a.commands = register(a.commands, cmds, "")
+}
+
+func register(into, cmds []*Command, prefix string) []*Command {
+	for _, cmd := range cmds {
+		idx := slices.IndexFunc(into, func(c *Command) bool { return c.Name == cmd.Name })
+		if idx < 0 {
+			// copy the group so merging never mutates the caller's command
+			c := *cmd
+			c.Subcommands = register(nil, cmd.Subcommands, prefix+cmd.Name+" ")
+			into = append(into, &c)
+			continue
+		}
+		existing := into[idx]
+		if !existing.isGroup() || !cmd.isGroup() {
+			panic(fmt.Sprintf("cli: command %q registered twice", prefix+cmd.Name))
+		}
+		existing.Subcommands = register(existing.Subcommands, cmd.Subcommands, prefix+cmd.Name+" ")
+	}
+	return into
+}
+
+// Main runs the application with the process arguments and exits on error.
+func (a *App) Main() {
+	if err := a.Run(os.Args[1:]); err != nil {
+		fmt.Fprintf(a.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+// Run parses the arguments, resolves the targeted command and executes it.
+func (a *App) Run(args []string) error {
+	a.opts = GlobalOpts{}
+	root := a.newFlagSet(a.Name)
+	root.Usage = func() { a.printUsage(a.Name, a.commands) }
+	if err := root.Parse(args); err != nil {
+		return ignoreHelp(err)
+	}
+
+	cmds, path, rest := a.commands, a.Name, root.Args()
+	for {
+		if len(rest) == 0 {
+			a.printUsage(path, cmds)
+			return fmt.Errorf("missing subcommand")
+		}
+		idx := slices.IndexFunc(cmds, func(c *Command) bool { return c.Name == rest[0] })
+		if idx < 0 {
+			return fmt.Errorf("unknown subcommand %q. Expected %s", rest[0], expected(cmds))
+		}
+		cmd := cmds[idx]
+		path, rest = path+" "+cmd.Name, rest[1:]
+		if cmd.isGroup() {
+			cmds = cmd.Subcommands
+			continue
+		}
+		return a.exec(cmd, path, rest)
+	}
+}
+
+func (a *App) exec(cmd *Command, path string, args []string) error {
+	fs := a.newFlagSet(path)
+	if cmd.Flags != nil {
+		cmd.Flags(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		return ignoreHelp(err)
+	}
+
+	env := &Env{
+		Stdout: a.Stdout,
+		Args:   fs.Args(),
+		opts:   &a.opts,
+		open:   a.OpenTPM,
+		stderr: a.Stderr,
+	}
+	err := cmd.Run(env)
+	if env.retrier != nil {
+		if stats := env.retrier.Stats(); stats.Retries > 0 {
+			fmt.Fprintf(a.Stderr, "TPM was busy: %d retry(ies) over %d command(s)\n", stats.Retries, stats.Commands)
+		}
+	}
+	if closeErr := env.close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("can't close tpm: %w", closeErr)
+	}
+	return err
+}
+
+// newFlagSet returns a flag set which already holds the global flags, so they
+// can be set either before or after the subcommand.
+func (a *App) newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(a.Stderr)
+	a.opts.register(fs)
+	return fs
+}
+
+func (a *App) printUsage(path string, cmds []*Command) {
+	fmt.Fprintf(a.Stderr, "Usage: %s [flags] <command> [flags]\n\nCommands:\n", path)
+	for _, cmd := range cmds {
+		fmt.Fprintf(a.Stderr, "  %-12s %s\n", cmd.Name, cmd.Usage)
+	}
+}
+
+func ignoreHelp(err error) error {
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	return err
+}
+
+// expected formats command names as "'a', 'b' or 'c'".
+func expected(cmds []*Command) string {
+	names := make([]string, len(cmds))
+	for i, cmd := range cmds {
+		names[i] = fmt.Sprintf("'%s'", cmd.Name)
+	}
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+func cleanupCommand() *Command {
+	return &Command{
+		Name:  "cleanup",
+		Usage: "Remove the swtpm state",
+		Run: func(env *Env) error {
+			device, err := env.Device()
+			if err != nil {
+				return err
+			}
+			stateDir := tpmutil.SWTPM_ROOT_STATE
+			if dir, ok := device.SwtpmStateDir(); ok && device != tpmutil.SWTPM {
+				stateDir = dir
+			}
+			if err := os.RemoveAll(stateDir); err != nil {
+				return fmt.Errorf("error cleaning state: %w", err)
+			}
+			fmt.Fprintln(env.Stdout, "State cleaned successfully 🚀")
+			return nil
+		},
+	}
+}
@@ -0,0 +1,210 @@
+//go:build !windows
+
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeTPM struct {
+	closed bool
+}
+
+func (f *fakeTPM) Send(input []byte) ([]byte, error) { return nil, nil }
+func (f *fakeTPM) Close() error                      { f.closed = true; return nil }
+
+// newTestApp returns an App whose TPM opening is recorded instead of performed.
+func newTestApp(t *testing.T) (*App, *[]tpmutil.Device, *fakeTPM) {
+	t.Helper()
+	var opened []tpmutil.Device
+	tpm := &fakeTPM{}
+	app := New("tpm-pills")
+	app.Stdout = &bytes.Buffer{}
+	app.Stderr = &bytes.Buffer{}
+	app.OpenTPM = func(d tpmutil.Device) (transport.TPMCloser, error) {
+		opened = append(opened, d)
+		return tpm, nil
+	}
+	return app, &opened, tpm
+}
+
+func TestRunDispatch(t *testing.T) {
+	app, opened, tpm := newTestApp(t)
+
+	var gotMessage string
+	app.Register(&Command{
+		Name: "key",
+		Subcommands: []*Command{
+			{
+				Name:  "create",
+				Flags: func(fs *flag.FlagSet) { fs.StringVar(&gotMessage, "message", "", "") },
+				Run: func(env *Env) error {
+					_, err := env.TPM()
+					return err
+				},
+			},
+		},
+	})
+
+	err := app.Run([]string{"key", "create", "--message", "hello"})
+	require.NoError(t, err)
+	require.Equal(t, "hello", gotMessage)
+	require.Equal(t, []tpmutil.Device{tpmutil.SWTPM}, *opened)
+	require.True(t, tpm.closed, "TPM should be closed once the command returns")
+}
+
+func TestRunGlobalFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		env  string
+		args []string
+		want tpmutil.Device
+	}{
+		{"default", "", []string{"seal"}, tpmutil.SWTPM},
+		{"before subcommand", "", []string{"--device", "sim:", "seal"}, tpmutil.TPM_SIMULATOR},
+		{"after subcommand", "", []string{"seal", "--device", "dev:/dev/tpm0"}, "dev:/dev/tpm0"},
+		{"custom swtpm state", "", []string{"seal", "--device", "swtpm:./state"}, "swtpm:./state"},
+		{"environment", "sim:", []string{"seal"}, tpmutil.TPM_SIMULATOR},
+		{"flag wins over environment", "sim:", []string{"seal", "--device", "swtpm:"}, tpmutil.SWTPM},
+		{"deprecated use-real-tpm", "", []string{"seal", "--use-real-tpm"}, tpmutil.LINUX},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(tpmutil.DeviceEnvVar, tt.env)
+			app, opened, _ := newTestApp(t)
+			app.Register(&Command{
+				Name: "seal",
+				Run: func(env *Env) error {
+					_, err := env.TPM()
+					return err
+				},
+			})
+
+			require.NoError(t, app.Run(tt.args))
+			require.Equal(t, []tpmutil.Device{tt.want}, *opened)
+		})
+	}
+}
+
+func TestRunWithoutTPM(t *testing.T) {
+	app, opened, _ := newTestApp(t)
+	app.Register(&Command{
+		Name: "verify",
+		Run:  func(env *Env) error { return nil },
+	})
+
+	require.NoError(t, app.Run([]string{"verify"}))
+	require.Empty(t, *opened, "TPM should only be opened on demand")
+}
+
+func TestRunTrace(t *testing.T) {
+	app, _, tpm := newTestApp(t)
+	app.Register(&Command{
+		Name: "random",
+		Run: func(env *Env) error {
+			tpm, err := env.TPM()
+			if err != nil {
+				return err
+			}
+			// the fake TPM doesn't answer, only the command matters
+			tpm2.GetRandom{BytesRequested: 8}.Execute(tpm)
+			return nil
+		},
+	})
+
+	traceFile := filepath.Join(t.TempDir(), "trace.jsonl")
+	require.NoError(t, app.Run([]string{"--trace", traceFile, "random"}))
+	require.True(t, tpm.closed)
+
+	content, err := os.ReadFile(traceFile)
+	require.NoError(t, err)
+	var entry tpmutil.TraceEntry
+	require.NoError(t, json.Unmarshal(content, &entry))
+	require.Equal(t, "TPM2_GetRandom", entry.Command)
+
+	stderr := app.Stderr.(*bytes.Buffer)
+	require.NoError(t, app.Run([]string{"random", "--trace", "-"}))
+	require.Contains(t, stderr.String(), `"command":"TPM2_GetRandom"`)
+}
+
+func TestRunReportsRetries(t *testing.T) {
+	app, _, tpm := newTestApp(t)
+	app.OpenTPM = func(d tpmutil.Device) (transport.TPMCloser, error) {
+		return tpmutil.NewRetrier(tpmutil.NewFaultInjector(tpm,
+			tpmutil.Fault{Nth: 1, ResponseCode: tpm2.TPMRCRetry},
+		), tpmutil.RetryPolicy{InitialBackoff: time.Millisecond})
+	}
+	app.Register(&Command{
+		Name: "random",
+		Run: func(env *Env) error {
+			tpm, err := env.TPM()
+			if err != nil {
+				return err
+			}
+			// the fake TPM doesn't answer, only the retry matters
+			tpm2.GetRandom{BytesRequested: 8}.Execute(tpm)
+			return nil
+		},
+	})
+
+	require.NoError(t, app.Run([]string{"random"}))
+	require.Contains(t, app.Stderr.(*bytes.Buffer).String(), "TPM was busy: 1 retry(ies) over 1 command(s)")
+}
+
+func TestRunErrors(t *testing.T) {
+	app, _, _ := newTestApp(t)
+	app.Register(&Command{
+		Name: "key",
+		Subcommands: []*Command{
+			{Name: "create", Run: func(env *Env) error {
+				_, err := env.TPM()
+				return err
+			}},
+			{Name: "load", Run: func(env *Env) error { return nil }},
+		},
+	})
+
+	err := app.Run(nil)
+	require.EqualError(t, err, "missing subcommand")
+
+	err = app.Run([]string{"key"})
+	require.EqualError(t, err, "missing subcommand")
+
+	err = app.Run([]string{"unknown"})
+	require.EqualError(t, err, `unknown subcommand "unknown". Expected 'cleanup', 'state', 'info', 'template', 'pcr', 'da' or 'key'`)
+
+	err = app.Run([]string{"key", "delete"})
+	require.EqualError(t, err, `unknown subcommand "delete". Expected 'create' or 'load'`)
+
+	err = app.Run([]string{"key", "create", "--unknown-flag"})
+	require.Error(t, err)
+
+	err = app.Run([]string{"key", "create", "--device", "usb:"})
+	require.EqualError(t, err, `invalid device "usb:": unsupported scheme "usb"`)
+}
+
+func TestRegisterMergesGroups(t *testing.T) {
+	app, _, _ := newTestApp(t)
+	noop := func(env *Env) error { return nil }
+
+	app.Register(&Command{Name: "key", Subcommands: []*Command{{Name: "create", Run: noop}}})
+	app.Register(&Command{Name: "key", Subcommands: []*Command{{Name: "load", Run: noop}}})
+
+	require.NoError(t, app.Run([]string{"key", "create"}))
+	require.NoError(t, app.Run([]string{"key", "load"}))
+
+	require.Panics(t, func() {
+		app.Register(&Command{Name: "key", Subcommands: []*Command{{Name: "load", Run: noop}}})
+	}, "registering the same command twice should panic")
+}
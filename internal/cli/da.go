@@ -0,0 +1,139 @@
+//go:build !windows
+
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+)
+
+func daCommand() *Command {
+	var (
+		format          string
+		lockoutAuth     string
+		maxAuthFail     *uint32
+		lockoutInterval *uint32
+		lockoutRecovery *uint32
+	)
+	lockoutAuthFlag := func(fs *flag.FlagSet) {
+		fs.StringVar(&lockoutAuth, "lockout-auth", "", "Password of the lockout hierarchy")
+	}
+	return &Command{
+		Name:  "da",
+		Usage: "Inspect and manage the dictionary attack protection",
+		Subcommands: []*Command{
+			{
+				Name:  "read",
+				Usage: "Display the lockout counter and the dictionary attack parameters",
+				Flags: func(fs *flag.FlagSet) {
+					fs.StringVar(&format, "format", "text", "Output format: 'text' or 'json'")
+				},
+				Run: func(env *Env) error {
+					if err := checkFormat(format); err != nil {
+						return err
+					}
+					tpm, err := env.TPM()
+					if err != nil {
+						return err
+					}
+					params, err := tpmutil.ReadDAParameters(tpm)
+					if err != nil {
+						return err
+					}
+					return writeDAParameters(env.Stdout, format, params)
+				},
+			},
+			{
+				Name:  "set",
+				Usage: "Change the dictionary attack parameters, the others keep their current value",
+				Flags: func(fs *flag.FlagSet) {
+					maxAuthFail, lockoutInterval, lockoutRecovery = nil, nil, nil
+					uintFlag(fs, &maxAuthFail, "max-tries", "Number of authorization failures before the lockout")
+					uintFlag(fs, &lockoutInterval, "recovery-time", "Seconds decrementing the lockout counter by one (0 disables the protection)")
+					uintFlag(fs, &lockoutRecovery, "lockout-recovery", "Seconds before the lockout hierarchy can be used after a failure (0: until the next TPM reset)")
+					lockoutAuthFlag(fs)
+				},
+				Run: func(env *Env) error {
+					if maxAuthFail == nil && lockoutInterval == nil && lockoutRecovery == nil {
+						return fmt.Errorf("invalid input: expected --max-tries, --recovery-time or --lockout-recovery")
+					}
+					tpm, err := env.TPM()
+					if err != nil {
+						return err
+					}
+					params, err := tpmutil.ReadDAParameters(tpm)
+					if err != nil {
+						return err
+					}
+					for _, p := range []struct{ flag, value *uint32 }{
+						{maxAuthFail, &params.MaxAuthFail},
+						{lockoutInterval, &params.LockoutInterval},
+						{lockoutRecovery, &params.LockoutRecovery},
+					} {
+						if p.flag != nil {
+							*p.value = *p.flag
+						}
+					}
+					if err := tpmutil.SetDAParameters(tpm, *params, []byte(lockoutAuth)); err != nil {
+						return err
+					}
+					fmt.Fprintln(env.Stdout, "Dictionary attack parameters changed 🚀")
+					return nil
+				},
+			},
+			{
+				Name:  "reset",
+				Usage: "Reset the lockout counter and leave the lockout",
+				Flags: lockoutAuthFlag,
+				Run: func(env *Env) error {
+					tpm, err := env.TPM()
+					if err != nil {
+						return err
+					}
+					if err := tpmutil.ResetDALockout(tpm, []byte(lockoutAuth)); err != nil {
+						return err
+					}
+					fmt.Fprintln(env.Stdout, "Lockout counter reset 🚀")
+					return nil
+				},
+			},
+		},
+	}
+}
+
+// uintFlag registers a uint32 flag leaving *p nil unless it is given.
+func uintFlag(fs *flag.FlagSet, p **uint32, name, usage string) {
+	fs.Func(name, usage, func(s string) error {
+		v, err := strconv.ParseUint(s, 0, 32)
+		if err != nil {
+			return fmt.Errorf("expected a 32-bit unsigned integer")
+		}
+		u := uint32(v)
+		*p = &u
+		return nil
+	})
+}
+
+// writeDAParameters prints params as a table, or in JSON.
+func writeDAParameters(w io.Writer, format string, params *tpmutil.DAParameters) error {
+	if format == "json" {
+		b, err := json.MarshalIndent(params, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode dictionary attack parameters: %w", err)
+		}
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Lockout counter\t%d/%d\n", params.LockoutCounter, params.MaxAuthFail)
+	fmt.Fprintf(tw, "In lockout\t%t\n", params.InLockout)
+	fmt.Fprintf(tw, "Recovery time\t%ds\n", params.LockoutInterval)
+	fmt.Fprintf(tw, "Lockout recovery\t%ds\n", params.LockoutRecovery)
+	return tw.Flush()
+}
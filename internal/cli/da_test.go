@@ -0,0 +1,47 @@
+//go:build !windows
+
+package cli
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDACommands(t *testing.T) {
+	app, stdout := newSimulatorApp(t)
+
+	readDA := func() tpmutil.DAParameters {
+		stdout.Reset()
+		require.NoError(t, app.Run([]string{"da", "read", "--format", "json"}))
+		var params tpmutil.DAParameters
+		require.NoError(t, json.Unmarshal(stdout.Bytes(), &params))
+		return params
+	}
+
+	before := readDA()
+	require.NoError(t, app.Run([]string{"da", "set", "--max-tries", "5"}))
+	after := readDA()
+	require.EqualValues(t, 5, after.MaxAuthFail)
+	// the other parameters are unchanged
+	require.Equal(t, before.LockoutInterval, after.LockoutInterval)
+	require.Equal(t, before.LockoutRecovery, after.LockoutRecovery)
+
+	require.NoError(t, app.Run([]string{"da", "set", "--recovery-time", "60", "--lockout-recovery", "120"}))
+	after = readDA()
+	require.Equal(t, tpmutil.DAParameters{MaxAuthFail: 5, LockoutInterval: 60, LockoutRecovery: 120}, after)
+
+	require.NoError(t, app.Run([]string{"da", "reset"}))
+	stdout.Reset()
+	require.NoError(t, app.Run([]string{"da", "read"}))
+	require.Contains(t, stdout.String(), "Lockout counter   0/5")
+
+	err := app.Run([]string{"da", "set"})
+	require.ErrorContains(t, err, "expected --max-tries, --recovery-time or --lockout-recovery")
+	err = app.Run([]string{"da", "set", "--max-tries", "-1"})
+	require.ErrorContains(t, err, "expected a 32-bit unsigned integer")
+	err = app.Run([]string{"da", "reset", "--lockout-auth", "wrong"})
+	require.ErrorContains(t, err, "TPM_RC_AUTH_FAIL")
+}
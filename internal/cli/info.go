@@ -0,0 +1,38 @@
+//go:build !windows
+
+package cli
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/loicsikidi/tpm-pills/internal/tpminfo"
+)
+
+func infoCommand() *Command {
+	var format string
+	return &Command{
+		Name:  "info",
+		Usage: "Display the capabilities of the TPM",
+		Flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&format, "format", "table", "Output format: 'table' or 'json'")
+		},
+		Run: func(env *Env) error {
+			if format != "table" && format != "json" {
+				return fmt.Errorf("invalid input: unsupported format %q. Expected 'table' or 'json'", format)
+			}
+			tpm, err := env.TPM()
+			if err != nil {
+				return err
+			}
+			info, err := tpminfo.Read(tpm)
+			if err != nil {
+				return err
+			}
+			if format == "json" {
+				return info.WriteJSON(env.Stdout)
+			}
+			return info.WriteTable(env.Stdout)
+		},
+	}
+}
@@ -0,0 +1,231 @@
+//go:build !windows
+
+package cli
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+)
+
+// defaultPCRSelection selects every PCR of the SHA-256 bank.
+const defaultPCRSelection = "sha256:0,1,2,3,4,5,6,7,8,9,10,11,12,13,14,15,16,17,18,19,20,21,22,23"
+
+func pcrCommand() *Command {
+	var (
+		format  string
+		data    string
+		initial string
+		digests bool
+	)
+	formatFlag := func(fs *flag.FlagSet) {
+		fs.StringVar(&format, "format", "text", "Output format: 'text' or 'json'")
+	}
+	return &Command{
+		Name:  "pcr",
+		Usage: "Read, extend and reset PCRs or compute their expected values",
+		Subcommands: []*Command{
+			{
+				Name:  "read",
+				Usage: "Read the PCRs of [selection] (e.g. 'sha256:0,7+sha1:0', default: every SHA-256 PCR)",
+				Flags: formatFlag,
+				Run: func(env *Env) error {
+					if err := checkFormat(format); err != nil {
+						return err
+					}
+					selection := defaultPCRSelection
+					if len(env.Args) > 0 {
+						selection = env.Args[0]
+					}
+					sels, err := tpmutil.ParsePCRSelection(selection)
+					if err != nil {
+						return fmt.Errorf("invalid input: %w", err)
+					}
+					tpm, err := env.TPM()
+					if err != nil {
+						return err
+					}
+					values, err := tpmutil.ReadPCRValues(tpm, sels)
+					if err != nil {
+						return err
+					}
+					return writePCRValues(env.Stdout, format, values)
+				},
+			},
+			{
+				Name:  "extend",
+				Usage: "Extend the PCR <pcr>:<bank>=<digest>[,<bank>=<digest>...], or <pcr> with --data",
+				Flags: func(fs *flag.FlagSet) {
+					fs.StringVar(&data, "data", "", "Data hashed by the TPM in every bank (TPM2_PCR_Event)")
+				},
+				Run: func(env *Env) error {
+					if len(env.Args) != 1 {
+						return fmt.Errorf("invalid input: expected <pcr>:<bank>=<digest> or <pcr> with --data")
+					}
+					idx, spec, hasDigests := strings.Cut(env.Args[0], ":")
+					if hasDigests == (data != "") {
+						return fmt.Errorf("invalid input: expected either digests or --data")
+					}
+					pcr, err := tpmutil.ParsePCRIndex(idx)
+					if err != nil {
+						return fmt.Errorf("invalid input: %w", err)
+					}
+					tpm, err := env.TPM()
+					if err != nil {
+						return err
+					}
+					if hasDigests {
+						digests, err := tpmutil.ParsePCRDigests(spec)
+						if err != nil {
+							return fmt.Errorf("invalid input: %w", err)
+						}
+						if err := tpmutil.ExtendPCR(tpm, pcr, digests...); err != nil {
+							return err
+						}
+					} else if err := tpmutil.PCREvent(tpm, pcr, []byte(data)); err != nil {
+						return err
+					}
+					fmt.Fprintf(env.Stdout, "PCR %d extended 🚀\n", pcr)
+					return nil
+				},
+			},
+			{
+				Name:  "reset",
+				Usage: "Reset the PCR <pcr> of every bank (16 or 23)",
+				Run: func(env *Env) error {
+					if len(env.Args) != 1 {
+						return fmt.Errorf("invalid input: expected a PCR index")
+					}
+					pcr, err := strconv.Atoi(env.Args[0])
+					if err != nil {
+						return fmt.Errorf("invalid input: PCR %q is not an index", env.Args[0])
+					}
+					tpm, err := env.TPM()
+					if err != nil {
+						return err
+					}
+					if err := tpmutil.ResetPCR(tpm, pcr); err != nil {
+						return err
+					}
+					fmt.Fprintf(env.Stdout, "PCR %d reset 🚀\n", pcr)
+					return nil
+				},
+			},
+			{
+				Name:  "compute",
+				Usage: "Compute the value of the PCRs of <selection> after extending them with the digests of <event>...",
+				Flags: func(fs *flag.FlagSet) {
+					formatFlag(fs)
+					fs.StringVar(&initial, "initial", "", "Initial hex value of the PCRs, 'current' to read it from the TPM (default: zero)")
+					fs.BoolVar(&digests, "digests", false, "Events are hex digests instead of data")
+				},
+				Run: func(env *Env) error {
+					if err := checkFormat(format); err != nil {
+						return err
+					}
+					if len(env.Args) == 0 {
+						return fmt.Errorf("invalid input: expected a PCR selection followed by events")
+					}
+					sels, err := tpmutil.ParsePCRSelection(env.Args[0])
+					if err != nil {
+						return fmt.Errorf("invalid input: %w", err)
+					}
+					initials, err := initialPCRValues(env, sels, initial)
+					if err != nil {
+						return err
+					}
+					var values [][]byte
+					for _, sel := range sels {
+						events, err := eventDigests(sel, env.Args[1:], digests)
+						if err != nil {
+							return err
+						}
+						for _, pcr := range sel.PCRs {
+							value, err := tpmutil.ComputePCR(sel.Hash, initials[sel.Hash][pcr], events...)
+							if err != nil {
+								return fmt.Errorf("invalid input: %w", err)
+							}
+							values = append(values, value)
+						}
+					}
+					return writePCRValues(env.Stdout, format, tpmutil.NewPCRValues(sels, values))
+				},
+			},
+		},
+	}
+}
+
+func checkFormat(format string) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("invalid input: unsupported format %q. Expected 'text' or 'json'", format)
+	}
+	return nil
+}
+
+// initialPCRValues returns the initial values of 'pcr compute': zero (nil), the current
+// values of the PCRs or the same hex value for every PCR.
+func initialPCRValues(env *Env, sels []tpmutil.PCRSelection, initial string) (tpmutil.PCRValues, error) {
+	switch initial {
+	case "":
+		return tpmutil.PCRValues{}, nil
+	case "current":
+		tpm, err := env.TPM()
+		if err != nil {
+			return nil, err
+		}
+		return tpmutil.ReadPCRValues(tpm, sels)
+	}
+	value, err := hex.DecodeString(initial)
+	if err != nil {
+		return nil, fmt.Errorf("invalid input: initial value %q is not hex", initial)
+	}
+	var values [][]byte
+	for _, sel := range sels {
+		for range sel.PCRs {
+			values = append(values, value)
+		}
+	}
+	return tpmutil.NewPCRValues(sels, values), nil
+}
+
+// eventDigests returns the digests extended in the bank of sel for events, either hex digests or data.
+func eventDigests(sel tpmutil.PCRSelection, events []string, isDigest bool) ([][]byte, error) {
+	digests := make([][]byte, len(events))
+	for i, event := range events {
+		var err error
+		if isDigest {
+			digests[i], err = hex.DecodeString(event)
+		} else {
+			digests[i], err = tpmutil.EventDigest(sel.Hash, []byte(event))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid input: event %q: %w", event, err)
+		}
+	}
+	return digests, nil
+}
+
+// writePCRValues prints values in the format of tpm2_pcrread, or in JSON.
+func writePCRValues(w io.Writer, format string, values tpmutil.PCRValues) error {
+	if format == "json" {
+		b, err := json.MarshalIndent(values, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode PCR values: %w", err)
+		}
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	}
+	for _, sel := range values.Selection() {
+		fmt.Fprintf(w, "%s:\n", tpmutil.AlgName(sel.Hash))
+		for _, pcr := range sel.PCRs {
+			fmt.Fprintf(w, "  %-2d: 0x%X\n", pcr, values[sel.Hash][pcr])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+//go:build !windows
+
+package cli
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/go-tpm-kit/tpmtest"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+	"github.com/stretchr/testify/require"
+)
+
+type nopCloser struct {
+	transport.TPM
+}
+
+func (nopCloser) Close() error { return nil }
+
+// newSimulatorApp returns an App whose commands share the same simulator.
+func newSimulatorApp(t *testing.T) (*App, *bytes.Buffer) {
+	t.Helper()
+	tpm := tpmtest.OpenSimulator(t)
+	stdout := &bytes.Buffer{}
+	app := New("tpm-pills")
+	app.Stdout = stdout
+	app.Stderr = &bytes.Buffer{}
+	app.OpenTPM = func(tpmutil.Device) (transport.TPMCloser, error) {
+		return nopCloser{tpm}, nil
+	}
+	return app, stdout
+}
+
+// runJSON runs args and decodes the PCR values printed in JSON.
+func runJSON(t *testing.T, app *App, stdout *bytes.Buffer, args ...string) tpmutil.PCRValues {
+	t.Helper()
+	stdout.Reset()
+	require.NoError(t, app.Run(args))
+	var values tpmutil.PCRValues
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &values))
+	return values
+}
+
+func TestPCRCommands(t *testing.T) {
+	app, stdout := newSimulatorApp(t)
+
+	// every SHA-256 PCR by default
+	values := runJSON(t, app, stdout, "pcr", "read", "--format", "json")
+	require.Len(t, values[tpm2.TPMAlgSHA256], tpmutil.NumPCRs)
+
+	// the expected value after two events matches the PCR extended by the TPM
+	expected := runJSON(t, app, stdout, "pcr", "compute", "--format", "json", "--initial", "current", "sha256:16+sha1:16", "boot", "kernel")
+	require.NoError(t, app.Run([]string{"pcr", "extend", "--data", "boot", "16"}))
+	require.NoError(t, app.Run([]string{"pcr", "extend", "--data", "kernel", "16"}))
+	values = runJSON(t, app, stdout, "pcr", "read", "--format", "json", "sha256:16+sha1:16")
+	require.Equal(t, expected, values)
+
+	// digests are extended as is
+	digest := sha256.Sum256([]byte("kernel"))
+	expected = runJSON(t, app, stdout, "pcr", "compute", "--format", "json", "--digests", "sha256:16", hex.EncodeToString(digest[:]))
+	require.NoError(t, app.Run([]string{"pcr", "reset", "16"}))
+	require.NoError(t, app.Run([]string{"pcr", "extend", "16:sha256=" + hex.EncodeToString(digest[:])}))
+	values = runJSON(t, app, stdout, "pcr", "read", "--format", "json", "sha256:16")
+	require.Equal(t, expected, values)
+
+	stdout.Reset()
+	require.NoError(t, app.Run([]string{"pcr", "read", "sha256:16"}))
+	require.Equal(t, fmt.Sprintf("sha256:\n  16: 0x%X\n", expected[tpm2.TPMAlgSHA256][16]), stdout.String())
+}
+
+func TestPCRCommandErrors(t *testing.T) {
+	app, _ := newSimulatorApp(t)
+
+	tests := []struct {
+		args    []string
+		wantErr string
+	}{
+		{[]string{"pcr", "read", "sha256:24"}, "is not between 0 and 23"},
+		{[]string{"pcr", "read", "--format", "yaml"}, "unsupported format"},
+		{[]string{"pcr", "extend", "16"}, "expected either digests or --data"},
+		{[]string{"pcr", "extend", "--data", "event", "16:sha256=00"}, "expected either digests or --data"},
+		{[]string{"pcr", "extend", "16:sha256=00"}, "expected a 32-byte hex digest"},
+		{[]string{"pcr", "reset", "7"}, "only PCRs 16 and 23 can"},
+		{[]string{"pcr", "compute", "--digests", "sha256:16", "00"}, "expected 32 bytes"},
+		{[]string{"pcr", "compute"}, "expected a PCR selection"},
+	}
+	for _, tt := range tests {
+		err := app.Run(tt.args)
+		require.ErrorContains(t, err, tt.wantErr, "%v", tt.args)
+	}
+}
@@ -0,0 +1,132 @@
+//go:build !windows
+
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/loicsikidi/tpm-pills/internal/swtpmstate"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+)
+
+func stateCommand() *Command {
+	var force bool
+	store := swtpmstate.Store{Dir: tpmutil.SWTPM_SNAPSHOTS}
+	return &Command{
+		Name:  "state",
+		Usage: "Manage named snapshots of the swtpm state",
+		Subcommands: []*Command{
+			{
+				Name:  "save",
+				Usage: "Save the swtpm state as <name>",
+				Flags: func(fs *flag.FlagSet) {
+					fs.BoolVar(&force, "force", false, "Replace an existing snapshot")
+				},
+				Run: func(env *Env) error {
+					name, stateDir, err := snapshotArgs(env)
+					if err != nil {
+						return err
+					}
+					if err := store.Save(name, stateDir, force); err != nil {
+						return err
+					}
+					fmt.Fprintf(env.Stdout, "State saved as %q 🚀\n", name)
+					return nil
+				},
+			},
+			{
+				Name:  "restore",
+				Usage: "Restore the swtpm state saved as <name>",
+				Run: func(env *Env) error {
+					name, stateDir, err := snapshotArgs(env)
+					if err != nil {
+						return err
+					}
+					if err := store.Restore(name, stateDir); err != nil {
+						return err
+					}
+					fmt.Fprintf(env.Stdout, "State %q restored 🚀\n", name)
+					return nil
+				},
+			},
+			{
+				Name:  "list",
+				Usage: "List the saved snapshots",
+				Run: func(env *Env) error {
+					snapshots, err := store.List()
+					if err != nil {
+						return err
+					}
+					if len(snapshots) == 0 {
+						fmt.Fprintln(env.Stdout, "No snapshot")
+						return nil
+					}
+					w := tabwriter.NewWriter(env.Stdout, 0, 0, 2, ' ', 0)
+					fmt.Fprintln(w, "NAME\tSAVED AT\tSIZE")
+					for _, s := range snapshots {
+						fmt.Fprintf(w, "%s\t%s\t%d B\n", s.Name, s.Created.Format("2006-01-02 15:04:05"), s.Size)
+					}
+					return w.Flush()
+				},
+			},
+			{
+				Name:  "diff",
+				Usage: "Compare the snapshot <name> with the swtpm state or with the snapshot <other>",
+				Run: func(env *Env) error {
+					name, stateDir, err := snapshotArgs(env)
+					if err != nil {
+						return err
+					}
+					from, err := store.Files(name)
+					if err != nil {
+						return err
+					}
+					var to swtpmstate.Files
+					if len(env.Args) > 1 {
+						to, err = store.Files(env.Args[1])
+					} else {
+						to, err = swtpmstate.ReadState(stateDir)
+					}
+					if err != nil {
+						return err
+					}
+
+					changes := swtpmstate.Diff(from, to)
+					if len(changes) == 0 {
+						fmt.Fprintln(env.Stdout, "No difference")
+						return nil
+					}
+					for _, c := range changes {
+						fmt.Fprintf(env.Stdout, "%s %s (%s)\n", changeSymbols[c.Kind], c.Path, c.Kind)
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
+
+var changeSymbols = map[swtpmstate.ChangeKind]string{
+	swtpmstate.Added:    "+",
+	swtpmstate.Removed:  "-",
+	swtpmstate.Modified: "~",
+}
+
+// snapshotArgs returns the snapshot name passed as first argument and the state directory of the selected swtpm.
+func snapshotArgs(env *Env) (name, stateDir string, err error) {
+	if len(env.Args) == 0 {
+		return "", "", errors.New("missing snapshot name")
+	}
+	device, err := env.Device()
+	if err != nil {
+		return "", "", err
+	}
+	stateDir, ok := device.SwtpmStateDir()
+	if !ok {
+		return "", "", fmt.Errorf("state commands only support swtpm devices (got %s)", device)
+	}
+	return env.Args[0], stateDir, nil
+}
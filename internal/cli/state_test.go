@@ -0,0 +1,64 @@
+//go:build !windows
+
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateCommands(t *testing.T) {
+	t.Chdir(t.TempDir())
+	permall := filepath.Join("state", "tpm2-00.permall")
+	require.NoError(t, os.MkdirAll("state", 0755))
+	require.NoError(t, os.WriteFile(permall, []byte("provisioned"), 0644))
+
+	app, opened, _ := newTestApp(t)
+	stdout := app.Stdout.(*bytes.Buffer)
+	run := func(args ...string) (string, error) {
+		stdout.Reset()
+		err := app.Run(append([]string{"--device", "swtpm:state"}, args...))
+		return stdout.String(), err
+	}
+
+	out, err := run("state", "save", "provisioned")
+	require.NoError(t, err)
+	require.Contains(t, out, `State saved as "provisioned"`)
+	_, err = run("state", "save", "provisioned")
+	require.ErrorContains(t, err, "already exists")
+	_, err = run("state", "save", "--force", "provisioned")
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(permall, []byte("altered"), 0644))
+	out, err = run("state", "diff", "provisioned")
+	require.NoError(t, err)
+	require.Equal(t, "~ tpm2-00.permall (modified)\n", out)
+
+	out, err = run("state", "list")
+	require.NoError(t, err)
+	require.Contains(t, out, "provisioned")
+
+	_, err = run("state", "restore", "provisioned")
+	require.NoError(t, err)
+	content, err := os.ReadFile(permall)
+	require.NoError(t, err)
+	require.Equal(t, "provisioned", string(content))
+
+	out, err = run("state", "diff", "provisioned")
+	require.NoError(t, err)
+	require.Equal(t, "No difference\n", out)
+	require.Empty(t, *opened, "state commands must not open the TPM")
+}
+
+func TestStateCommandErrors(t *testing.T) {
+	t.Chdir(t.TempDir())
+	app, _, _ := newTestApp(t)
+
+	require.ErrorContains(t, app.Run([]string{"state", "save"}), "missing snapshot name")
+	require.ErrorContains(t, app.Run([]string{"--device", "sim:", "state", "save", "s"}), "only support swtpm devices")
+	require.ErrorContains(t, app.Run([]string{"state", "restore", "missing"}), "snapshot not found")
+}
@@ -0,0 +1,67 @@
+//go:build !windows
+
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+)
+
+func templateCommand() *Command {
+	var out string
+	return &Command{
+		Name:  "template",
+		Usage: "Export the built-in key templates as JSON specs",
+		Subcommands: []*Command{
+			{
+				Name:  "list",
+				Usage: "List the built-in templates",
+				Run: func(env *Env) error {
+					for _, name := range tpmutil.BuiltinTemplateNames() {
+						fmt.Fprintln(env.Stdout, name)
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "export",
+				Usage: "Export the built-in template <name> (usable with --template)",
+				Flags: func(fs *flag.FlagSet) {
+					fs.StringVar(&out, "out", "", "Output file (default: stdout)")
+				},
+				Run: func(env *Env) error {
+					if len(env.Args) != 1 {
+						return fmt.Errorf("invalid input: expected a template name (%s)", strings.Join(tpmutil.BuiltinTemplateNames(), ", "))
+					}
+					template, ok := tpmutil.BuiltinTemplates[env.Args[0]]
+					if !ok {
+						return fmt.Errorf("invalid input: unknown template %q (expected %s)", env.Args[0], strings.Join(tpmutil.BuiltinTemplateNames(), ", "))
+					}
+					spec, err := tpmutil.NewTemplateSpec(template)
+					if err != nil {
+						return fmt.Errorf("failed to describe template: %w", err)
+					}
+					b, err := json.MarshalIndent(spec, "", "  ")
+					if err != nil {
+						return fmt.Errorf("failed to encode template: %w", err)
+					}
+					b = append(b, '\n')
+					if out == "" {
+						_, err = env.Stdout.Write(b)
+						return err
+					}
+					if err := os.WriteFile(out, b, 0644); err != nil {
+						return fmt.Errorf("failed to write template: %w", err)
+					}
+					fmt.Fprintf(env.Stdout, "Template %q exported to %s 🚀\n", env.Args[0], out)
+					return nil
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,40 @@
+//go:build !windows
+
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateCommands(t *testing.T) {
+	app, opened, _ := newTestApp(t)
+	stdout := app.Stdout.(*bytes.Buffer)
+
+	require.NoError(t, app.Run([]string{"template", "list"}))
+	require.Contains(t, stdout.String(), "ecc-signer\n")
+
+	stdout.Reset()
+	require.NoError(t, app.Run([]string{"template", "export", "ecc-signer"}))
+	var spec tpmutil.TemplateSpec
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &spec))
+	require.Equal(t, "ecc", spec.Type)
+	require.Equal(t, "nist_p256", spec.Curve)
+	require.Equal(t, &tpmutil.SchemeSpec{Alg: "ecdsa", Hash: "sha256"}, spec.Scheme)
+
+	out := filepath.Join(t.TempDir(), "seal.json")
+	require.NoError(t, app.Run([]string{"template", "export", "--out", out, "seal"}))
+	_, err := tpmutil.LoadTemplate(out)
+	require.NoError(t, err)
+
+	err = app.Run([]string{"template", "export", "unknown"})
+	require.ErrorContains(t, err, `unknown template "unknown"`)
+	err = app.Run([]string{"template", "export"})
+	require.ErrorContains(t, err, "expected a template name")
+	require.Empty(t, *opened, "template commands must not open the TPM")
+}
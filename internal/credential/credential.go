@@ -0,0 +1,200 @@
+// Package credential implements TPM2_MakeCredential in software: a server, without a TPM,
+// protects a secret so that only the TPM holding an Endorsement Key (EK) can recover it with
+// TPM2_ActivateCredential, and only if the key named by the server is loaded in that TPM.
+//
+// The steps follow the "Credential Protection" section of the TPM 2.0 specification (Part 1):
+//
+//  1. a seed is generated and wrapped to the EK (RSA-OAEP or ECDH, with the "IDENTITY" label)
+//  2. a symmetric key derived from the seed and the Name of the key encrypts the secret
+//  3. an HMAC key derived from the seed protects the integrity of the encrypted secret and of the Name
+package credential
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+// Labels used to wrap the seed and to derive keys from it.
+const (
+	labelIdentity  = "IDENTITY"
+	labelStorage   = "STORAGE"
+	labelIntegrity = "INTEGRITY"
+)
+
+// ErrEK is returned when a key can't protect a credential.
+var ErrEK = errors.New("invalid endorsement key")
+
+// MakeCredential protects secret for the TPM holding ek, so that TPM2_ActivateCredential
+// releases it only when the key named name is loaded in this TPM.
+//
+// It returns the credential blob (TPM2B_ID_OBJECT) and the wrapped seed (TPM2B_ENCRYPTED_SECRET),
+// the two inputs of TPM2_ActivateCredential.
+func MakeCredential(ek *tpm2.TPMTPublic, name tpm2.TPM2BName, secret []byte) (*tpm2.TPM2BIDObject, *tpm2.TPM2BEncryptedSecret, error) {
+	return makeCredential(rand.Reader, ek, name, secret)
+}
+
+func makeCredential(random io.Reader, ek *tpm2.TPMTPublic, name tpm2.TPM2BName, secret []byte) (*tpm2.TPM2BIDObject, *tpm2.TPM2BEncryptedSecret, error) {
+	if !ek.ObjectAttributes.Restricted || !ek.ObjectAttributes.Decrypt {
+		return nil, nil, fmt.Errorf("%w: must be a restricted decryption key", ErrEK)
+	}
+	h, err := ek.NameAlg.Hash()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrEK, err)
+	}
+	if len(secret) == 0 || len(secret) > h.Size() {
+		return nil, nil, fmt.Errorf("invalid input: secret must be between 1 and %d bytes", h.Size())
+	}
+	if len(name.Buffer) == 0 {
+		return nil, nil, fmt.Errorf("invalid input: name is required")
+	}
+	keyBits, err := symKeyBits(ek)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	seed, encSecret, err := wrapSeed(random, ek)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the secret is encrypted as a TPM2B_DIGEST, with a zero IV: the key is never reused
+	symKey := tpm2.KDFa(h, seed, labelStorage, name.Buffer, nil, keyBits)
+	block, err := aes.NewCipher(symKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	encIdentity := tpm2.Marshal(tpm2.TPM2BDigest{Buffer: secret})
+	cipher.NewCFBEncrypter(block, make([]byte, block.BlockSize())).XORKeyStream(encIdentity, encIdentity)
+
+	hmacKey := tpm2.KDFa(h, seed, labelIntegrity, nil, nil, h.Size()*8)
+	mac := hmac.New(h.New, hmacKey)
+	mac.Write(encIdentity)
+	mac.Write(name.Buffer)
+
+	// TPMS_ID_OBJECT: the size of encIdentity is encrypted, hence the manual marshalling
+	idObject := tpm2.Marshal(tpm2.TPM2BDigest{Buffer: mac.Sum(nil)})
+	idObject = append(idObject, encIdentity...)
+	return &tpm2.TPM2BIDObject{Buffer: idObject}, &tpm2.TPM2BEncryptedSecret{Buffer: encSecret}, nil
+}
+
+// symKeyBits returns the key size of the symmetric algorithm of ek, used to encrypt the secret.
+// The algorithm must be AES in CFB mode.
+func symKeyBits(ek *tpm2.TPMTPublic) (int, error) {
+	var sym tpm2.TPMTSymDefObject
+	switch ek.Type {
+	case tpm2.TPMAlgRSA:
+		parms, err := ek.Parameters.RSADetail()
+		if err != nil {
+			return 0, fmt.Errorf("%w: %v", ErrEK, err)
+		}
+		sym = parms.Symmetric
+	case tpm2.TPMAlgECC:
+		parms, err := ek.Parameters.ECCDetail()
+		if err != nil {
+			return 0, fmt.Errorf("%w: %v", ErrEK, err)
+		}
+		sym = parms.Symmetric
+	default:
+		return 0, fmt.Errorf("%w: unsupported key type 0x%04x", ErrEK, uint16(ek.Type))
+	}
+	if sym.Algorithm != tpm2.TPMAlgAES {
+		return 0, fmt.Errorf("%w: unsupported symmetric algorithm 0x%04x", ErrEK, uint16(sym.Algorithm))
+	}
+	mode, err := sym.Mode.AES()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrEK, err)
+	}
+	if *mode != tpm2.TPMAlgCFB {
+		return 0, fmt.Errorf("%w: unsupported symmetric mode 0x%04x", ErrEK, uint16(*mode))
+	}
+	keyBits, err := sym.KeyBits.AES()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrEK, err)
+	}
+	return int(*keyBits), nil
+}
+
+// wrapSeed generates a seed and wraps it to ek, and returns the seed and the wrapped seed.
+//
+// With an RSA key, the seed is random and encrypted with RSA-OAEP. With an ECC key, the seed
+// is derived with KDFe from an ECDH exchange with an ephemeral key, whose public point is sent
+// in place of the seed.
+func wrapSeed(random io.Reader, ek *tpm2.TPMTPublic) (seed, encSecret []byte, err error) {
+	h, err := ek.NameAlg.Hash()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrEK, err)
+	}
+	switch ek.Type {
+	case tpm2.TPMAlgRSA:
+		parms, err := ek.Parameters.RSADetail()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrEK, err)
+		}
+		unique, err := ek.Unique.RSA()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrEK, err)
+		}
+		pub, err := tpm2.RSAPub(parms, unique)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrEK, err)
+		}
+		seed = make([]byte, h.Size())
+		if _, err := io.ReadFull(random, seed); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate seed: %w", err)
+		}
+		// unlike KDFa, OAEP expects the terminating zero of the label
+		encSecret, err = rsa.EncryptOAEP(h.New(), random, pub, seed, []byte(labelIdentity+"\x00"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to wrap seed: %w", err)
+		}
+		return seed, encSecret, nil
+	case tpm2.TPMAlgECC:
+		parms, err := ek.Parameters.ECCDetail()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrEK, err)
+		}
+		unique, err := ek.Unique.ECC()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrEK, err)
+		}
+		pub, err := tpm2.ECDHPub(parms, unique)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrEK, err)
+		}
+		ephemeral, err := pub.Curve().GenerateKey(random)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+		}
+		// z is the x coordinate of the shared point
+		z, err := ephemeral.ECDH(pub)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to wrap seed: %w", err)
+		}
+		ephX, ephY := coordinates(ephemeral.PublicKey())
+		ekX, _ := coordinates(pub)
+		seed = tpm2.KDFe(h, z, labelIdentity, ephX, ekX, h.Size()*8)
+		encSecret = tpm2.Marshal(tpm2.TPMSECCPoint{
+			X: tpm2.TPM2BECCParameter{Buffer: ephX},
+			Y: tpm2.TPM2BECCParameter{Buffer: ephY},
+		})
+		return seed, encSecret, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: unsupported key type 0x%04x", ErrEK, uint16(ek.Type))
+	}
+}
+
+// coordinates returns the x and y coordinates of pub.
+func coordinates(pub *ecdh.PublicKey) (x, y []byte) {
+	// skip the leading 0x04 of the uncompressed point
+	point := pub.Bytes()[1:]
+	return point[:len(point)/2], point[len(point)/2:]
+}
@@ -0,0 +1,150 @@
+package credential
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+// softwareEK returns an EK in software, and its public area built from the TCG template.
+func softwareEK(t *testing.T, typ tpm2.TPMAlgID) (crypto.PrivateKey, *tpm2.TPMTPublic) {
+	t.Helper()
+	switch typ {
+	case tpm2.TPMAlgRSA:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("failed to generate key: %v", err)
+		}
+		ek := tpm2.RSAEKTemplate
+		ek.Unique = tpm2.NewTPMUPublicID(tpm2.TPMAlgRSA, &tpm2.TPM2BPublicKeyRSA{Buffer: key.N.Bytes()})
+		return key, &ek
+	case tpm2.TPMAlgECC:
+		key, err := ecdh.P256().GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("failed to generate key: %v", err)
+		}
+		x, y := coordinates(key.PublicKey())
+		ek := tpm2.ECCEKTemplate
+		ek.Unique = tpm2.NewTPMUPublicID(tpm2.TPMAlgECC, &tpm2.TPMSECCPoint{
+			X: tpm2.TPM2BECCParameter{Buffer: x},
+			Y: tpm2.TPM2BECCParameter{Buffer: y},
+		})
+		return key, &ek
+	}
+	t.Fatalf("unsupported key type %v", typ)
+	return nil, nil
+}
+
+// activate recovers the secret in software as TPM2_ActivateCredential would.
+func activate(t *testing.T, key crypto.PrivateKey, name []byte, idObject *tpm2.TPM2BIDObject, encSecret *tpm2.TPM2BEncryptedSecret) ([]byte, error) {
+	t.Helper()
+	var seed []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		seed, err = rsa.DecryptOAEP(sha256.New(), nil, k, encSecret.Buffer, []byte("IDENTITY\x00"))
+		if err != nil {
+			return nil, err
+		}
+	case *ecdh.PrivateKey:
+		point, err := tpm2.Unmarshal[tpm2.TPMSECCPoint](encSecret.Buffer)
+		if err != nil {
+			return nil, err
+		}
+		ephemeral, err := ecdh.P256().NewPublicKey(append([]byte{0x04}, append(point.X.Buffer, point.Y.Buffer...)...))
+		if err != nil {
+			return nil, err
+		}
+		z, err := k.ECDH(ephemeral)
+		if err != nil {
+			return nil, err
+		}
+		x, _ := coordinates(k.PublicKey())
+		seed = tpm2.KDFe(crypto.SHA256, z, "IDENTITY", point.X.Buffer, x, 256)
+	}
+
+	integrity, err := tpm2.Unmarshal[tpm2.TPM2BDigest](idObject.Buffer)
+	if err != nil {
+		return nil, err
+	}
+	encIdentity := idObject.Buffer[2+len(integrity.Buffer):]
+	mac := hmac.New(sha256.New, tpm2.KDFa(crypto.SHA256, seed, "INTEGRITY", nil, nil, 256))
+	mac.Write(encIdentity)
+	mac.Write(name)
+	if !hmac.Equal(mac.Sum(nil), integrity.Buffer) {
+		return nil, errors.New("integrity check failed")
+	}
+
+	block, err := aes.NewCipher(tpm2.KDFa(crypto.SHA256, seed, "STORAGE", name, nil, 128))
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(encIdentity))
+	cipher.NewCFBDecrypter(block, make([]byte, aes.BlockSize)).XORKeyStream(plain, encIdentity)
+	secret, err := tpm2.Unmarshal[tpm2.TPM2BDigest](plain)
+	if err != nil {
+		return nil, err
+	}
+	return secret.Buffer, nil
+}
+
+func TestMakeCredential(t *testing.T) {
+	name := tpm2.TPM2BName{Buffer: append([]byte{0x00, 0x0b}, bytes.Repeat([]byte{0xaa}, 32)...)}
+	otherName := tpm2.TPM2BName{Buffer: append([]byte{0x00, 0x0b}, bytes.Repeat([]byte{0xbb}, 32)...)}
+	secret := []byte("enrollment secret")
+
+	tests := []struct {
+		name string
+		typ  tpm2.TPMAlgID
+	}{
+		{name: "rsa", typ: tpm2.TPMAlgRSA},
+		{name: "ecc", typ: tpm2.TPMAlgECC},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, ek := softwareEK(t, tt.typ)
+			idObject, encSecret, err := MakeCredential(ek, name, secret)
+			if err != nil {
+				t.Fatalf("MakeCredential() error = %v", err)
+			}
+			got, err := activate(t, key, name.Buffer, idObject, encSecret)
+			if err != nil {
+				t.Fatalf("failed to activate credential: %v", err)
+			}
+			if !bytes.Equal(got, secret) {
+				t.Errorf("activated secret = %q, want %q", got, secret)
+			}
+			if _, err := activate(t, key, otherName.Buffer, idObject, encSecret); err == nil {
+				t.Error("expected activation with another name to fail")
+			}
+		})
+	}
+}
+
+func TestMakeCredentialInvalidInputs(t *testing.T) {
+	_, ek := softwareEK(t, tpm2.TPMAlgECC)
+	name := tpm2.TPM2BName{Buffer: append([]byte{0x00, 0x0b}, make([]byte, 32)...)}
+
+	signer := *ek
+	signer.ObjectAttributes.Decrypt = false
+	signer.ObjectAttributes.SignEncrypt = true
+	if _, _, err := MakeCredential(&signer, name, []byte("secret")); !errors.Is(err, ErrEK) {
+		t.Errorf("MakeCredential() with a signing key error = %v, want %v", err, ErrEK)
+	}
+	if _, _, err := MakeCredential(ek, name, make([]byte, 33)); err == nil {
+		t.Error("expected MakeCredential() to reject a secret larger than the name algorithm digest")
+	}
+	if _, _, err := MakeCredential(ek, tpm2.TPM2BName{}, []byte("secret")); err == nil {
+		t.Error("expected MakeCredential() to reject an empty name")
+	}
+}
@@ -0,0 +1,151 @@
+// Package ektest provisions EK certificates in simulated TPMs, as manufacturers do in real ones.
+//
+// The certificates are issued by a local test CA: they must never be trusted outside of tests.
+package ektest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/go-tpm-kit/tpmcrypto"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+)
+
+// oidEKCertificate is the extended key usage of EK certificates (tcg-kp-EKCertificate).
+var oidEKCertificate = asn1.ObjectIdentifier{2, 23, 133, 8, 1}
+
+// certValidity is the validity of the certificates issued by the test CA.
+const certValidity = 24 * time.Hour
+
+// CA is a test CA issuing EK certificates.
+type CA struct {
+	// Certificate is the self-signed certificate of the CA.
+	Certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+}
+
+// NewCA creates a test CA with a self-signed ECDSA P-256 certificate.
+func NewCA() (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate CA key: %w", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tpm-pills test EK CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(certValidity),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+	return &CA{Certificate: cert, key: key}, nil
+}
+
+// Issue issues a certificate for the public key of ek.
+//
+// As the TCG EK Credential Profile requires, the key usage matches the type of the EK:
+// an RSA EK decrypts (keyEncipherment), an ECC EK derives a shared secret (keyAgreement).
+func (ca *CA) Issue(ek *tpm2.TPMTPublic) (*x509.Certificate, error) {
+	pub, err := tpmcrypto.PublicKey(ek)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get EK public key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	keyUsage := x509.KeyUsageKeyEncipherment
+	if ek.Type == tpm2.TPMAlgECC {
+		keyUsage = x509.KeyUsageKeyAgreement
+	}
+	template := &x509.Certificate{
+		SerialNumber:       serial,
+		Subject:            pkix.Name{CommonName: "tpm-pills test EK"},
+		NotBefore:          time.Now().Add(-time.Minute),
+		NotAfter:           time.Now().Add(certValidity),
+		KeyUsage:           keyUsage,
+		UnknownExtKeyUsage: []asn1.ObjectIdentifier{oidEKCertificate},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Certificate, pub, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create EK certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse EK certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// Provision creates the EK of profile, issues its certificate and writes it to the NV index
+// of the profile, where [tpmutil.ReadEKCertificate] finds it.
+func (ca *CA) Provision(tpm transport.TPM, profile tpmutil.EKProfile) (*x509.Certificate, error) {
+	rsp, closer, err := tpmutil.CreateEK(tpm, profile.Template, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+	ek, err := rsp.OutPublic.Contents()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode EK: %w", err)
+	}
+	cert, err := ca.Issue(ek)
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteEKCertificate(tpm, profile.CertIndex, cert.Raw); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// WriteEKCertificate defines index in the platform hierarchy and writes the DER certificate to it.
+//
+// The attributes are those of the TCG EK Credential Profile: only the platform writes the index,
+// while the owner, and anyone through its empty auth value, reads it.
+func WriteEKCertificate(tpm transport.TPM, index tpm2.TPMHandle, der []byte) error {
+	nvPublic := tpm2.TPMSNVPublic{
+		NVIndex: index,
+		NameAlg: tpm2.TPMAlgSHA256,
+		Attributes: tpm2.TPMANV{
+			PPWrite:        true,
+			WriteDefine:    true,
+			PPRead:         true,
+			OwnerRead:      true,
+			AuthRead:       true,
+			NoDA:           true,
+			PlatformCreate: true,
+			NT:             tpm2.TPMNTOrdinary,
+		},
+		DataSize: uint16(len(der)),
+	}
+	if _, err := (tpm2.NVDefineSpace{
+		AuthHandle: tpm2.AuthHandle{Handle: tpm2.TPMRHPlatform, Auth: tpm2.PasswordAuth(nil)},
+		PublicInfo: tpm2.New2B(nvPublic),
+	}).Execute(tpm); err != nil {
+		return fmt.Errorf("failed to define NV index 0x%x: %w", uint32(index), err)
+	}
+	return tpmutil.NVWrite(tpm, tpmutil.NVWriteConfig{
+		Index: index,
+		Auth:  tpmutil.NVAuth{Platform: true},
+		Data:  der,
+	})
+}
@@ -0,0 +1,80 @@
+package ektest
+
+import (
+	"crypto/x509"
+	"testing"
+
+	"github.com/loicsikidi/go-tpm-kit/tpmtest"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+)
+
+func TestProvision(t *testing.T) {
+	ca, err := NewCA()
+	if err != nil {
+		t.Fatalf("NewCA() error = %v", err)
+	}
+	for _, typ := range tpmutil.EKTypes() {
+		t.Run(typ, func(t *testing.T) {
+			tpm := tpmtest.OpenSimulator(t)
+			profile, err := tpmutil.LookupEKProfile(typ)
+			if err != nil {
+				t.Fatalf("LookupEKProfile() error = %v", err)
+			}
+			want, err := ca.Provision(tpm, profile)
+			if err != nil {
+				t.Fatalf("Provision() error = %v", err)
+			}
+
+			cert, err := tpmutil.ReadEKCertificate(tpm, profile.CertIndex)
+			if err != nil {
+				t.Fatalf("ReadEKCertificate() error = %v", err)
+			}
+			if !cert.Equal(want) {
+				t.Errorf("ReadEKCertificate() returned another certificate")
+			}
+			roots := x509.NewCertPool()
+			roots.AddCert(ca.Certificate)
+			if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+				t.Errorf("failed to verify EK certificate: %v", err)
+			}
+
+			rsp, closer, err := tpmutil.CreateEK(tpm, profile.Template, nil)
+			if err != nil {
+				t.Fatalf("CreateEK() error = %v", err)
+			}
+			defer closer()
+			ek, err := rsp.OutPublic.Contents()
+			if err != nil {
+				t.Fatalf("failed to decode EK: %v", err)
+			}
+			if err := tpmutil.CheckEKCertificate(cert, ek); err != nil {
+				t.Errorf("CheckEKCertificate() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckEKCertificateMismatch(t *testing.T) {
+	ca, err := NewCA()
+	if err != nil {
+		t.Fatalf("NewCA() error = %v", err)
+	}
+	tpm := tpmtest.OpenSimulator(t)
+	cert, err := ca.Provision(tpm, tpmutil.EKProfilesByType["ecc"])
+	if err != nil {
+		t.Fatalf("Provision() error = %v", err)
+	}
+	// the low-range and the high-range EKs are two different keys
+	rsp, closer, err := tpmutil.CreateEK(tpm, tpmutil.ECCEKHighRangeTemplate, nil)
+	if err != nil {
+		t.Fatalf("CreateEK() error = %v", err)
+	}
+	defer closer()
+	ek, err := rsp.OutPublic.Contents()
+	if err != nil {
+		t.Fatalf("failed to decode EK: %v", err)
+	}
+	if err := tpmutil.CheckEKCertificate(cert, ek); err == nil {
+		t.Errorf("CheckEKCertificate() error = nil, want an error")
+	}
+}
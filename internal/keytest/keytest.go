@@ -0,0 +1,27 @@
+// Package keytest creates the keys used by the tests of the attestation pills.
+package keytest
+
+import (
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+)
+
+// Create creates a key from template under the SRK (see [tpmutil.ECCSRKTemplate]) protected by auth,
+// and returns the new temporary directory holding its blob ('key.tpm') and its public key ('public.pem').
+func Create(t testing.TB, tpm transport.TPM, template tpm2.TPMTPublic, auth []byte) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := tpmutil.CreateKey(tpm, tpmutil.CreateKeyConfig{
+		OutDir:           dir,
+		ParentTemplate:   tpmutil.ECCSRKTemplate,
+		OrdinaryTemplate: template,
+		CreatePublicKey:  true,
+		UserAuth:         auth,
+	}); err != nil {
+		t.Fatalf("failed to create key: %v", err)
+	}
+	return dir
+}
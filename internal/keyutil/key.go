@@ -7,12 +7,18 @@ import (
 	"github.com/google/go-tpm/tpm2"
 )
 
+// PublicKey returns the public key held by a TPM2B_PUBLIC (see [FromPublic]).
 func PublicKey(tpm2public *tpm2.TPM2BPublic) (crypto.PublicKey, error) {
 	pub, err := tpm2public.Contents()
 	if err != nil {
 		return nil, err
 	}
+	return FromPublic(pub)
+}
 
+// FromPublic returns the public key held by a TPMT_PUBLIC: an [*rsa.PublicKey]
+// of any size or an [*ecdsa.PublicKey] on the NIST P-256, P-384 or P-521 curve.
+func FromPublic(pub *tpm2.TPMTPublic) (crypto.PublicKey, error) {
 	switch pub.Type {
 	case tpm2.TPMAlgRSA:
 		rsaDetail, err := pub.Parameters.RSADetail()
@@ -27,6 +33,9 @@ func PublicKey(tpm2public *tpm2.TPM2BPublic) (crypto.PublicKey, error) {
 		if err != nil {
 			return nil, err
 		}
+		if rsaPub.N.BitLen() != int(rsaDetail.KeyBits) {
+			return nil, fmt.Errorf("invalid RSA public key: %d-bit modulus for a %d-bit key", rsaPub.N.BitLen(), rsaDetail.KeyBits)
+		}
 		return rsaPub, nil
 	case tpm2.TPMAlgECC:
 		eccDetail, err := pub.Parameters.ECCDetail()
@@ -41,8 +50,12 @@ func PublicKey(tpm2public *tpm2.TPM2BPublic) (crypto.PublicKey, error) {
 		if err != nil {
 			return nil, err
 		}
+		// ECDH checks that the point is on the curve
+		if _, err := eccPub.ECDH(); err != nil {
+			return nil, fmt.Errorf("invalid ECC public key: %w", err)
+		}
 		return eccPub, nil
 	default:
-		return nil, fmt.Errorf("unrecognized key type: %T", pub.Type)
+		return nil, fmt.Errorf("unrecognized key type: 0x%04x", uint16(pub.Type))
 	}
 }
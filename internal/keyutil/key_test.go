@@ -0,0 +1,107 @@
+package keyutil
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/loicsikidi/tpm-pills/internal/pemutil"
+)
+
+func rsaPublic(t *testing.T, bits int) (*rsa.PublicKey, *tpm2.TPMTPublic) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() error = %v", err)
+	}
+	return &key.PublicKey, &tpm2.TPMTPublic{
+		Type:       tpm2.TPMAlgRSA,
+		NameAlg:    tpm2.TPMAlgSHA256,
+		Parameters: tpm2.NewTPMUPublicParms(tpm2.TPMAlgRSA, &tpm2.TPMSRSAParms{KeyBits: tpm2.TPMKeyBits(bits)}),
+		Unique:     tpm2.NewTPMUPublicID(tpm2.TPMAlgRSA, &tpm2.TPM2BPublicKeyRSA{Buffer: key.N.Bytes()}),
+	}
+}
+
+func eccPublic(t *testing.T, curve elliptic.Curve, curveID tpm2.TPMECCCurve) (*ecdsa.PublicKey, *tpm2.TPMTPublic) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() error = %v", err)
+	}
+	return &key.PublicKey, &tpm2.TPMTPublic{
+		Type:       tpm2.TPMAlgECC,
+		NameAlg:    tpm2.TPMAlgSHA256,
+		Parameters: tpm2.NewTPMUPublicParms(tpm2.TPMAlgECC, &tpm2.TPMSECCParms{CurveID: curveID}),
+		Unique: tpm2.NewTPMUPublicID(tpm2.TPMAlgECC, &tpm2.TPMSECCPoint{
+			X: tpm2.TPM2BECCParameter{Buffer: key.X.Bytes()},
+			Y: tpm2.TPM2BECCParameter{Buffer: key.Y.Bytes()},
+		}),
+	}
+}
+
+func TestFromPublic(t *testing.T) {
+	type public interface {
+		Equal(crypto.PublicKey) bool
+	}
+	tests := map[string]func(t *testing.T) (public, *tpm2.TPMTPublic){
+		"rsa2048": func(t *testing.T) (public, *tpm2.TPMTPublic) { return rsaPublic(t, 2048) },
+		"rsa3072": func(t *testing.T) (public, *tpm2.TPMTPublic) { return rsaPublic(t, 3072) },
+		"rsa4096": func(t *testing.T) (public, *tpm2.TPMTPublic) { return rsaPublic(t, 4096) },
+		"ecc256": func(t *testing.T) (public, *tpm2.TPMTPublic) {
+			return eccPublic(t, elliptic.P256(), tpm2.TPMECCNistP256)
+		},
+		"ecc384": func(t *testing.T) (public, *tpm2.TPMTPublic) {
+			return eccPublic(t, elliptic.P384(), tpm2.TPMECCNistP384)
+		},
+		"ecc521": func(t *testing.T) (public, *tpm2.TPMTPublic) {
+			return eccPublic(t, elliptic.P521(), tpm2.TPMECCNistP521)
+		},
+	}
+	for name, newKey := range tests {
+		t.Run(name, func(t *testing.T) {
+			want, pub := newKey(t)
+			b2 := tpm2.New2B(*pub)
+			got, err := PublicKey(&b2)
+			if err != nil {
+				t.Fatalf("PublicKey() error = %v", err)
+			}
+			if !want.Equal(got) {
+				t.Fatalf("PublicKey() = %v, want %v", got, want)
+			}
+
+			b, err := pemutil.SerializePEMToBytes(got)
+			if err != nil {
+				t.Fatalf("SerializePEMToBytes() error = %v", err)
+			}
+			parsed, err := pemutil.Parse(b)
+			if err != nil {
+				t.Fatalf("pemutil.Parse() error = %v", err)
+			}
+			if !want.Equal(parsed) {
+				t.Errorf("PEM round trip = %v, want %v", parsed, want)
+			}
+		})
+	}
+}
+
+func TestFromPublicErrors(t *testing.T) {
+	_, rsaPub := rsaPublic(t, 2048)
+	rsaPub.Parameters = tpm2.NewTPMUPublicParms(tpm2.TPMAlgRSA, &tpm2.TPMSRSAParms{KeyBits: 3072})
+	if _, err := FromPublic(rsaPub); err == nil {
+		t.Errorf("FromPublic() with a mismatching modulus error = nil, want an error")
+	}
+
+	_, eccPub := eccPublic(t, elliptic.P256(), tpm2.TPMECCNistP256)
+	eccPub.Parameters = tpm2.NewTPMUPublicParms(tpm2.TPMAlgECC, &tpm2.TPMSECCParms{CurveID: tpm2.TPMECCNistP384})
+	if _, err := FromPublic(eccPub); err == nil {
+		t.Errorf("FromPublic() with a point off the curve error = nil, want an error")
+	}
+
+	if _, err := FromPublic(&tpm2.TPMTPublic{Type: tpm2.TPMAlgKeyedHash}); err == nil {
+		t.Errorf("FromPublic() with a keyedhash error = nil, want an error")
+	}
+}
@@ -1,6 +1,9 @@
 package options
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -24,6 +27,9 @@ const (
 	defaultEncryptedFileName = "blob.enc"
 	defaultSignedFileName    = "message.sig"
 	defaultHandleStr         = "0x81000010"
+	defaultNVIndexStr        = "0x01000010"
+	defaultQuotedPCRs        = "sha256:0,1,2,3,4,5,6,7"
+	defaultEKType            = "rsa"
 )
 
 var validKeyTypes = []KeyType{
@@ -66,10 +72,83 @@ func FromStringToKeyType(s string) KeyType {
 	return -1
 }
 
+// readPassword prompts for a password, it's a variable so that tests can replace it.
+var readPassword = utils.ReadPassword
+
+// AuthOpts holds the authorization of a key: its authorization value (i.e. its password) and its policy.
+// At most one of Auth, AuthFile and Prompt can be set, none of them means an empty password.
+type AuthOpts struct {
+	// Auth is the password itself.
+	Auth string
+	// AuthFile is a file holding the password ('-' for stdin), a trailing newline is ignored.
+	AuthFile string
+	// Prompt asks for the password on the terminal.
+	Prompt bool
+	// PolicyPath is a JSON policy required to use the key (see tpmutil.Policy).
+	PolicyPath string
+	auth       []byte
+}
+
+// checkAndSetDefaults resolves the password. When confirm is true (i.e. a key is being created),
+// a prompted password is asked twice.
+func (o *AuthOpts) checkAndSetDefaults(confirm bool) error {
+	set := 0
+	for _, isSet := range []bool{o.Auth != "", o.AuthFile != "", o.Prompt} {
+		if isSet {
+			set++
+		}
+	}
+	if set > 1 {
+		return fmt.Errorf("invalid input: Auth, AuthFile and Prompt are mutually exclusive")
+	}
+	if o.PolicyPath != "" && !utils.FileExists(o.PolicyPath) {
+		return fmt.Errorf("invalid input: PolicyPath does not exist")
+	}
+
+	switch {
+	case o.Auth != "":
+		o.auth = []byte(o.Auth)
+	case o.AuthFile != "":
+		data, err := utils.ReadFile(o.AuthFile)
+		if err != nil {
+			return fmt.Errorf("invalid input: failed to read AuthFile: %w", err)
+		}
+		o.auth = bytes.TrimRight(data, "\r\n")
+	case o.Prompt:
+		auth, err := readPassword("Enter the key password: ")
+		if err != nil {
+			return err
+		}
+		if confirm {
+			again, err := readPassword("Confirm the key password: ")
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(auth, again) {
+				return fmt.Errorf("invalid input: passwords don't match")
+			}
+		}
+		o.auth = auth
+	}
+	return nil
+}
+
+// GetAuth returns the password resolved by CheckAndSetDefaults.
+func (o *AuthOpts) GetAuth() []byte {
+	return o.auth
+}
+
 type CreateKeyOpts struct {
 	OutputDir string
 	KeyType   string
-	kty       KeyType
+	// Alg is the key algorithm (e.g. 'rsa3072' or 'ecc384:sha384'), the default depends on KeyType.
+	Alg string
+	// TemplatePath is a JSON template spec which takes precedence over KeyType.
+	TemplatePath string
+	// Attributes overrides the attributes of the template (e.g. 'fixedtpm|fixedparent|sign').
+	Attributes string
+	kty        KeyType
+	AuthOpts
 }
 
 func (o *CreateKeyOpts) CheckAndSetDefaults() error {
@@ -87,7 +166,10 @@ func (o *CreateKeyOpts) CheckAndSetDefaults() error {
 	if o.kty == UnspecifiedKeyType {
 		o.kty = Signer
 	}
-	return nil
+	if o.TemplatePath != "" && !utils.FileExists(o.TemplatePath) {
+		return fmt.Errorf("invalid input: TemplatePath does not exist")
+	}
+	return o.AuthOpts.checkAndSetDefaults(true)
 }
 
 func (o *CreateKeyOpts) GetKeyType() KeyType {
@@ -124,6 +206,7 @@ type SymEncryptOpts struct {
 	KeyBlobPath    string
 	Message        string
 	OutputFilePath string
+	AuthOpts
 }
 
 func (o *SymEncryptOpts) CheckAndSetDefaults() error {
@@ -146,12 +229,13 @@ func (o *SymEncryptOpts) CheckAndSetDefaults() error {
 	if !utils.DirExists(filepath.Dir(o.OutputFilePath)) {
 		return fmt.Errorf("invalid input: OutputFilePath parent directory does not exist")
 	}
-	return nil
+	return o.AuthOpts.checkAndSetDefaults(false)
 }
 
 type DecryptOpts struct {
 	InputFilePath string
 	KeyBlobPath   string
+	AuthOpts
 }
 
 func (o *DecryptOpts) CheckAndSetDefaults() error {
@@ -171,12 +255,13 @@ func (o *DecryptOpts) CheckAndSetDefaults() error {
 	if !utils.FileExists(o.KeyBlobPath) {
 		return fmt.Errorf("invalid input: KeyBlobPath does not exist")
 	}
-	return nil
+	return o.AuthOpts.checkAndSetDefaults(false)
 }
 
 type AsymDecryptOpts struct {
 	InputFilePath string
 	KeyBlobPath   string
+	AuthOpts
 }
 
 func (o *AsymDecryptOpts) CheckAndSetDefaults() error {
@@ -196,6 +281,24 @@ func (o *AsymDecryptOpts) CheckAndSetDefaults() error {
 	if !utils.FileExists(o.KeyBlobPath) {
 		return fmt.Errorf("invalid input: KeyBlobPath does not exist")
 	}
+	return o.AuthOpts.checkAndSetDefaults(false)
+}
+
+// Signature formats, only ECDSA signatures differ: DER is an ASN.1 sequence of r and s
+// while raw is the concatenation of r and s (IEEE P1363). RSA signatures are written as is.
+const (
+	SignatureFormatDER = "der"
+	SignatureFormatRaw = "raw"
+)
+
+func checkSignatureFormat(format *string) error {
+	switch *format {
+	case "":
+		*format = SignatureFormatDER
+	case SignatureFormatDER, SignatureFormatRaw:
+	default:
+		return fmt.Errorf("invalid input: unsupported Format %q (expected %q or %q)", *format, SignatureFormatDER, SignatureFormatRaw)
+	}
 	return nil
 }
 
@@ -203,6 +306,13 @@ type SignOpts struct {
 	KeyBlobPath    string
 	Message        string
 	OutputFilePath string
+	// Scheme is the signing scheme: 'rsassa', 'rsapss' or 'ecdsa' (default: the scheme of the key).
+	Scheme string
+	// Hash is the hash algorithm of the scheme (default: the hash of the key scheme, SHA-256 for RSA keys
+	// or the hash matching the curve of ECC keys).
+	Hash   string
+	Format string
+	AuthOpts
 }
 
 func (o *SignOpts) CheckAndSetDefaults() error {
@@ -225,13 +335,21 @@ func (o *SignOpts) CheckAndSetDefaults() error {
 	if !utils.DirExists(filepath.Dir(o.OutputFilePath)) {
 		return fmt.Errorf("invalid input: OutputFilePath parent directory does not exist")
 	}
-	return nil
+	if err := checkSignatureFormat(&o.Format); err != nil {
+		return err
+	}
+	return o.AuthOpts.checkAndSetDefaults(false)
 }
 
 type VerifyOpts struct {
 	PublicKeyPath string
 	Message       string
 	SignaturePath string
+	// Scheme is the signing scheme: 'rsassa' (default for RSA keys), 'rsapss' or 'ecdsa'.
+	Scheme string
+	// Hash is the hash algorithm of the scheme (default: sha256, or the hash matching the curve of an ECC key).
+	Hash   string
+	Format string
 }
 
 func (o *VerifyOpts) CheckAndSetDefaults() error {
@@ -254,12 +372,20 @@ func (o *VerifyOpts) CheckAndSetDefaults() error {
 	if !utils.FileExists(o.SignaturePath) {
 		return fmt.Errorf("invalid input: SignaturePath does not exist")
 	}
-	return nil
+	return checkSignatureFormat(&o.Format)
 }
 
 type SealOpts struct {
 	Message        string
 	OutputFilePath string
+	// PCRs binds the sealed object to the values of these PCRs (e.g. 'sha256:0,7,16').
+	PCRs string
+	// PCRValues holds the expected hex values of PCRs separated by commas, the current ones by default.
+	PCRValues string
+	// PCRValuesFile holds the expected PCR values in JSON, as printed by 'pcr read' or 'pcr compute'.
+	// Without PCRs, every PCR of the file is selected.
+	PCRValuesFile string
+	AuthOpts
 }
 
 func (o *SealOpts) CheckAndSetDefaults() error {
@@ -276,11 +402,23 @@ func (o *SealOpts) CheckAndSetDefaults() error {
 	if !utils.DirExists(filepath.Dir(o.OutputFilePath)) {
 		return fmt.Errorf("invalid input: OutputFilePath parent directory does not exist")
 	}
-	return nil
+	if o.PCRValues != "" && o.PCRs == "" {
+		return fmt.Errorf("invalid input: PCRValues requires PCRs")
+	}
+	if o.PCRValuesFile != "" {
+		if o.PCRValues != "" {
+			return fmt.Errorf("invalid input: PCRValues and PCRValuesFile are mutually exclusive")
+		}
+		if !utils.FileExists(o.PCRValuesFile) {
+			return fmt.Errorf("invalid input: PCRValuesFile does not exist")
+		}
+	}
+	return o.AuthOpts.checkAndSetDefaults(true)
 }
 
 type UnsealOpts struct {
 	InputFilePath string
+	AuthOpts
 }
 
 func (o *UnsealOpts) CheckAndSetDefaults() error {
@@ -294,23 +432,25 @@ func (o *UnsealOpts) CheckAndSetDefaults() error {
 	if !utils.FileExists(o.InputFilePath) {
 		return fmt.Errorf("invalid input: InputFilePath does not exist")
 	}
-	return nil
+	return o.AuthOpts.checkAndSetDefaults(false)
 }
 
 type HMACOpts struct {
 	Data string
+	AuthOpts
 }
 
 func (o *HMACOpts) CheckAndSetDefaults() error {
 	if len(o.Data) == 0 {
 		return fmt.Errorf("invalid input: Data is required")
 	}
-	return nil
+	return o.AuthOpts.checkAndSetDefaults(false)
 }
 
 type PersistOpts struct {
 	Handle    string
 	OutputDir string
+	AuthOpts
 }
 
 func (o *PersistOpts) CheckAndSetDefaults() error {
@@ -324,7 +464,7 @@ func (o *PersistOpts) CheckAndSetDefaults() error {
 		}
 		o.OutputDir = dir
 	}
-	return nil
+	return o.AuthOpts.checkAndSetDefaults(true)
 }
 
 type ReadPersistedOpts struct {
@@ -355,3 +495,463 @@ func (o *UnpersistOpts) CheckAndSetDefaults() error {
 	}
 	return nil
 }
+
+type NVDefineOpts struct {
+	Index string
+	// Type is the type of the index: 'ordinary' (default), 'counter', 'bits' or 'extend'.
+	Type string
+	// Size is the size of an ordinary index, the other types have a fixed size.
+	Size int
+	// Hash is the name algorithm of the index, also used by extend indices (default: sha256).
+	Hash string
+	// Attributes overrides the attributes of the index (e.g. 'ownerwrite|ownerread|authwrite|authread').
+	Attributes string
+	// OwnerAuth is the password of the owner hierarchy.
+	OwnerAuth string
+	AuthOpts
+}
+
+func (o *NVDefineOpts) CheckAndSetDefaults() error {
+	if o.Index == "" {
+		o.Index = defaultNVIndexStr
+	}
+	if o.Type == "" {
+		o.Type = "ordinary"
+	}
+	if o.Hash == "" {
+		o.Hash = "sha256"
+	}
+	if o.Size < 0 {
+		return fmt.Errorf("invalid input: Size must be positive")
+	}
+	if o.Type == "ordinary" && o.Size == 0 {
+		return fmt.Errorf("invalid input: Size is required for an ordinary index")
+	}
+	return o.AuthOpts.checkAndSetDefaults(true)
+}
+
+type NVUndefineOpts struct {
+	Index string
+	// OwnerAuth is the password of the owner hierarchy.
+	OwnerAuth string
+}
+
+func (o *NVUndefineOpts) CheckAndSetDefaults() error {
+	if o.Index == "" {
+		o.Index = defaultNVIndexStr
+	}
+	return nil
+}
+
+// NVAuthOpts selects the authorization of a command on an NV index: the password of
+// the index (AuthOpts) or, with Owner, the one of the owner hierarchy.
+type NVAuthOpts struct {
+	Owner bool
+	// OwnerAuth is the password of the owner hierarchy.
+	OwnerAuth string
+	AuthOpts
+}
+
+func (o *NVAuthOpts) checkAndSetDefaults() error {
+	if o.Owner && (o.Auth != "" || o.AuthFile != "" || o.Prompt) {
+		return fmt.Errorf("invalid input: Owner and the password of the index are mutually exclusive")
+	}
+	if !o.Owner && o.OwnerAuth != "" {
+		return fmt.Errorf("invalid input: OwnerAuth requires Owner")
+	}
+	return o.AuthOpts.checkAndSetDefaults(false)
+}
+
+type NVWriteOpts struct {
+	Index string
+	// Data is written to an ordinary index or extends an extend index.
+	Data string
+	// InputFilePath holds the data, as an alternative to Data.
+	InputFilePath string
+	// Bits are set in a bits index (e.g. '0x5').
+	Bits string
+	// Offset is the position of the data in an ordinary index.
+	Offset int
+	NVAuthOpts
+}
+
+func (o *NVWriteOpts) CheckAndSetDefaults() error {
+	if o.Index == "" {
+		o.Index = defaultNVIndexStr
+	}
+	set := 0
+	for _, isSet := range []bool{o.Data != "", o.InputFilePath != "", o.Bits != ""} {
+		if isSet {
+			set++
+		}
+	}
+	if set > 1 {
+		return fmt.Errorf("invalid input: Data, InputFilePath and Bits are mutually exclusive")
+	}
+	if o.InputFilePath != "" && !utils.FileExists(o.InputFilePath) {
+		return fmt.Errorf("invalid input: InputFilePath does not exist")
+	}
+	if o.Offset < 0 {
+		return fmt.Errorf("invalid input: Offset must be positive")
+	}
+	return o.NVAuthOpts.checkAndSetDefaults()
+}
+
+type NVReadOpts struct {
+	Index string
+	// Size is the number of bytes to read (default: up to the end of the index).
+	Size int
+	// Offset is the position of the first byte to read.
+	Offset int
+	// OutputFilePath receives the raw data, which is printed in hex otherwise.
+	OutputFilePath string
+	NVAuthOpts
+}
+
+func (o *NVReadOpts) CheckAndSetDefaults() error {
+	if o.Index == "" {
+		o.Index = defaultNVIndexStr
+	}
+	if o.Size < 0 || o.Offset < 0 {
+		return fmt.Errorf("invalid input: Size and Offset must be positive")
+	}
+	return o.NVAuthOpts.checkAndSetDefaults()
+}
+
+type NVReadPublicOpts struct {
+	Index string
+}
+
+func (o *NVReadPublicOpts) CheckAndSetDefaults() error {
+	if o.Index == "" {
+		o.Index = defaultNVIndexStr
+	}
+	return nil
+}
+
+// NonceOpts holds the nonce of a verifier, which an attestation must be qualified with
+// to prove it is fresh.
+type NonceOpts struct {
+	// Nonce is the hex-encoded nonce.
+	Nonce string
+	nonce []byte
+}
+
+func (o *NonceOpts) checkAndSetDefaults() error {
+	if o.Nonce == "" {
+		return fmt.Errorf("invalid input: Nonce is required")
+	}
+	nonce, err := hex.DecodeString(o.Nonce)
+	if err != nil {
+		return fmt.Errorf("invalid input: Nonce must be hex-encoded: %w", err)
+	}
+	o.nonce = nonce
+	return nil
+}
+
+// GetNonce returns the nonce decoded by CheckAndSetDefaults.
+func (o *NonceOpts) GetNonce() []byte {
+	return o.nonce
+}
+
+type CertifyOpts struct {
+	// KeyBlobPath is the key to certify.
+	KeyBlobPath string
+	// SignerBlobPath is the restricted signing key certifying the key.
+	SignerBlobPath string
+	// SignerAuth is the password of the signing key.
+	SignerAuth string
+	// OutputDir receives the attestation, its signature and the public area of the key.
+	OutputDir string
+	NonceOpts
+	AuthOpts
+}
+
+func (o *CertifyOpts) CheckAndSetDefaults() error {
+	dir, err := utils.FallbackDir()
+	if err != nil {
+		return err
+	}
+	if o.KeyBlobPath == "" {
+		o.KeyBlobPath = filepath.Join(dir, defaultKeyFileName)
+	}
+	if !utils.FileExists(o.KeyBlobPath) {
+		return fmt.Errorf("invalid input: KeyBlobPath does not exist")
+	}
+	if o.SignerBlobPath == "" {
+		return fmt.Errorf("invalid input: SignerBlobPath is required")
+	}
+	if !utils.FileExists(o.SignerBlobPath) {
+		return fmt.Errorf("invalid input: SignerBlobPath does not exist")
+	}
+	if o.OutputDir == "" {
+		o.OutputDir = dir
+	}
+	if !utils.DirExists(o.OutputDir) {
+		return fmt.Errorf("invalid input: OutputDir does not exist")
+	}
+	if err := o.NonceOpts.checkAndSetDefaults(); err != nil {
+		return err
+	}
+	return o.AuthOpts.checkAndSetDefaults(false)
+}
+
+type VerifyCertifyOpts struct {
+	// PublicKeyPath is the public key of the signing key.
+	PublicKeyPath string
+	// InputDir holds the attestation, its signature and the public area of the certified key.
+	InputDir string
+	NonceOpts
+}
+
+func (o *VerifyCertifyOpts) CheckAndSetDefaults() error {
+	if o.PublicKeyPath == "" {
+		return fmt.Errorf("invalid input: PublicKeyPath is required")
+	}
+	if !utils.FileExists(o.PublicKeyPath) {
+		return fmt.Errorf("invalid input: PublicKeyPath does not exist")
+	}
+	if o.InputDir == "" {
+		dir, err := utils.FallbackDir()
+		if err != nil {
+			return err
+		}
+		o.InputDir = dir
+	}
+	if !utils.DirExists(o.InputDir) {
+		return fmt.Errorf("invalid input: InputDir does not exist")
+	}
+	return o.NonceOpts.checkAndSetDefaults()
+}
+
+type QuoteOpts struct {
+	// KeyBlobPath is the restricted signing key (i.e. the attestation key) signing the quote.
+	KeyBlobPath string
+	// PCRs selects the quoted PCRs (default: 'sha256:0,1,2,3,4,5,6,7').
+	PCRs string
+	// OutputDir receives the quote, its signature and the values of the quoted PCRs.
+	OutputDir string
+	NonceOpts
+	AuthOpts
+}
+
+func (o *QuoteOpts) CheckAndSetDefaults() error {
+	dir, err := utils.FallbackDir()
+	if err != nil {
+		return err
+	}
+	if o.KeyBlobPath == "" {
+		o.KeyBlobPath = filepath.Join(dir, defaultKeyFileName)
+	}
+	if !utils.FileExists(o.KeyBlobPath) {
+		return fmt.Errorf("invalid input: KeyBlobPath does not exist")
+	}
+	if o.PCRs == "" {
+		o.PCRs = defaultQuotedPCRs
+	}
+	if o.OutputDir == "" {
+		o.OutputDir = dir
+	}
+	if !utils.DirExists(o.OutputDir) {
+		return fmt.Errorf("invalid input: OutputDir does not exist")
+	}
+	if err := o.NonceOpts.checkAndSetDefaults(); err != nil {
+		return err
+	}
+	return o.AuthOpts.checkAndSetDefaults(false)
+}
+
+type VerifyQuoteOpts struct {
+	// PublicKeyPath is the public key of the attestation key.
+	PublicKeyPath string
+	// InputDir holds the quote, its signature and the values of the quoted PCRs.
+	InputDir string
+	// PCRValuesFile holds the expected PCR values in JSON (default: the values saved along with the quote).
+	PCRValuesFile string
+	// PreviousDir holds a previous quote signed by the same key, to detect a reboot in between.
+	PreviousDir string
+	NonceOpts
+}
+
+func (o *VerifyQuoteOpts) CheckAndSetDefaults() error {
+	if o.PublicKeyPath == "" {
+		return fmt.Errorf("invalid input: PublicKeyPath is required")
+	}
+	if !utils.FileExists(o.PublicKeyPath) {
+		return fmt.Errorf("invalid input: PublicKeyPath does not exist")
+	}
+	if o.InputDir == "" {
+		dir, err := utils.FallbackDir()
+		if err != nil {
+			return err
+		}
+		o.InputDir = dir
+	}
+	if !utils.DirExists(o.InputDir) {
+		return fmt.Errorf("invalid input: InputDir does not exist")
+	}
+	if o.PCRValuesFile != "" && !utils.FileExists(o.PCRValuesFile) {
+		return fmt.Errorf("invalid input: PCRValuesFile does not exist")
+	}
+	if o.PreviousDir != "" && !utils.DirExists(o.PreviousDir) {
+		return fmt.Errorf("invalid input: PreviousDir does not exist")
+	}
+	return o.NonceOpts.checkAndSetDefaults()
+}
+
+// EKOpts selects the Endorsement Key (EK) of the TPM.
+type EKOpts struct {
+	// EKType is the type of the EK: 'rsa' (default), 'ecc', or their high-range variants 'rsa-high' and 'ecc-high'.
+	EKType string
+	// EndorsementAuth is the password of the endorsement hierarchy.
+	EndorsementAuth string
+}
+
+func (o *EKOpts) checkAndSetDefaults() {
+	if o.EKType == "" {
+		o.EKType = defaultEKType
+	}
+}
+
+type EnrollOpts struct {
+	// KeyBlobPath is the attestation key to enroll.
+	KeyBlobPath string
+	// OutputDir receives the public areas of the EK and of the attestation key.
+	OutputDir string
+	EKOpts
+}
+
+func (o *EnrollOpts) CheckAndSetDefaults() error {
+	dir, err := utils.FallbackDir()
+	if err != nil {
+		return err
+	}
+	if o.KeyBlobPath == "" {
+		o.KeyBlobPath = filepath.Join(dir, defaultKeyFileName)
+	}
+	if !utils.FileExists(o.KeyBlobPath) {
+		return fmt.Errorf("invalid input: KeyBlobPath does not exist")
+	}
+	if o.OutputDir == "" {
+		o.OutputDir = dir
+	}
+	if !utils.DirExists(o.OutputDir) {
+		return fmt.Errorf("invalid input: OutputDir does not exist")
+	}
+	o.EKOpts.checkAndSetDefaults()
+	return nil
+}
+
+type ReadEKOpts struct {
+	EKOpts
+	// OutputDir receives the public area of the EK and its certificate, if any.
+	OutputDir string
+	// CAPath is the PEM certificate of the CA the EK certificate must chain to (optional).
+	CAPath string
+}
+
+func (o *ReadEKOpts) CheckAndSetDefaults() error {
+	dir, err := utils.FallbackDir()
+	if err != nil {
+		return err
+	}
+	if o.OutputDir == "" {
+		o.OutputDir = dir
+	}
+	if !utils.DirExists(o.OutputDir) {
+		return fmt.Errorf("invalid input: OutputDir does not exist")
+	}
+	if o.CAPath != "" && !utils.FileExists(o.CAPath) {
+		return fmt.Errorf("invalid input: CAPath does not exist")
+	}
+	o.EKOpts.checkAndSetDefaults()
+	return nil
+}
+
+type MakeCredentialOpts struct {
+	// InputDir holds the public areas of the EK and of the attestation key.
+	InputDir string
+	// Secret is the hex-encoded secret to protect (default: 32 random bytes).
+	Secret string
+	// OutputDir receives the credential and the wrapped seed.
+	OutputDir string
+	secret    []byte
+}
+
+func (o *MakeCredentialOpts) CheckAndSetDefaults() error {
+	dir, err := utils.FallbackDir()
+	if err != nil {
+		return err
+	}
+	if o.InputDir == "" {
+		o.InputDir = dir
+	}
+	if !utils.DirExists(o.InputDir) {
+		return fmt.Errorf("invalid input: InputDir does not exist")
+	}
+	if o.OutputDir == "" {
+		o.OutputDir = dir
+	}
+	if !utils.DirExists(o.OutputDir) {
+		return fmt.Errorf("invalid input: OutputDir does not exist")
+	}
+	if o.Secret == "" {
+		o.secret = make([]byte, 32)
+		if _, err := rand.Read(o.secret); err != nil {
+			return fmt.Errorf("failed to generate secret: %w", err)
+		}
+		return nil
+	}
+	secret, err := hex.DecodeString(o.Secret)
+	if err != nil {
+		return fmt.Errorf("invalid input: Secret must be hex-encoded: %w", err)
+	}
+	o.secret = secret
+	return nil
+}
+
+// GetSecret returns the secret decoded, or generated, by CheckAndSetDefaults.
+func (o *MakeCredentialOpts) GetSecret() []byte {
+	return o.secret
+}
+
+type ActivateOpts struct {
+	// KeyBlobPath is the attestation key named in the credential.
+	KeyBlobPath string
+	// InputDir holds the credential and the wrapped seed.
+	InputDir string
+	EKOpts
+	AuthOpts
+}
+
+func (o *ActivateOpts) CheckAndSetDefaults() error {
+	dir, err := utils.FallbackDir()
+	if err != nil {
+		return err
+	}
+	if o.KeyBlobPath == "" {
+		o.KeyBlobPath = filepath.Join(dir, defaultKeyFileName)
+	}
+	if !utils.FileExists(o.KeyBlobPath) {
+		return fmt.Errorf("invalid input: KeyBlobPath does not exist")
+	}
+	if o.InputDir == "" {
+		o.InputDir = dir
+	}
+	if !utils.DirExists(o.InputDir) {
+		return fmt.Errorf("invalid input: InputDir does not exist")
+	}
+	o.EKOpts.checkAndSetDefaults()
+	return o.AuthOpts.checkAndSetDefaults(false)
+}
+
+type ProvisionOpts struct {
+	// OwnerAuth is the password of the owner hierarchy.
+	OwnerAuth string
+	EKOpts
+}
+
+func (o *ProvisionOpts) CheckAndSetDefaults() error {
+	o.EKOpts.checkAndSetDefaults()
+	return nil
+}
@@ -22,6 +22,11 @@ func SerializePEM(in any) (*pem.Block, error) {
 			Type:  "PUBLIC KEY",
 			Bytes: b,
 		}
+	case *x509.Certificate:
+		p = &pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: k.Raw,
+		}
 	default:
 		return nil, fmt.Errorf("cannot serialize type '%T', value '%v'", k, k)
 	}
@@ -53,6 +58,12 @@ func Parse(b []byte) (any, error) {
 			return nil, fmt.Errorf("error parsing public key: %w", err)
 		}
 		return pub, nil
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing certificate: %w", err)
+		}
+		return cert, nil
 	default:
 		return nil, fmt.Errorf("error decoding: contains an unexpected header %q", block.Type)
 	}
@@ -0,0 +1,357 @@
+// Package swtpmstate archives and restores the state directory of a swtpm instance,
+// which lets a reader rewind the TPM to a named point (e.g. "after provisioning").
+//
+// Snapshots are stored as gzipped tarballs named <name>.tar.gz in a [Store].
+package swtpmstate
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const snapshotExt = ".tar.gz"
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ErrSnapshotNotFound is returned when a snapshot doesn't exist in the store.
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// Store is a directory holding snapshots.
+type Store struct {
+	Dir string
+}
+
+// Snapshot describes a snapshot of a [Store].
+type Snapshot struct {
+	Name    string
+	Created time.Time
+	Size    int64
+}
+
+// Files maps the path of each file of a state directory to the SHA-256 of its content.
+type Files map[string]string
+
+// Save archives stateDir as the snapshot name. An existing snapshot is only replaced if overwrite is true.
+func (s Store) Save(name, stateDir string, overwrite bool) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil && !overwrite {
+		return fmt.Errorf("snapshot %q already exists", name)
+	}
+	if info, err := os.Stat(stateDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("invalid input: state directory %q does not exist", stateDir)
+	}
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+
+	// write into a temporary file first, so that a failure never corrupts an existing snapshot
+	tmp, err := os.CreateTemp(s.Dir, name+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := archive(tmp, stateDir); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to archive state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	return nil
+}
+
+// Restore replaces the content of stateDir by the snapshot name.
+//
+// swtpm must not be running while its state is restored.
+func (s Store) Restore(name, stateDir string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	f, err := s.open(name, path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// extract next to the state directory and swap, so that a failure leaves the state untouched
+	parent := filepath.Dir(filepath.Clean(stateDir))
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	tmp, err := os.MkdirTemp(parent, ".restore-*")
+	if err != nil {
+		return fmt.Errorf("failed to restore snapshot: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+	if err := extract(f, tmp); err != nil {
+		return fmt.Errorf("failed to extract snapshot %q: %w", name, err)
+	}
+	if err := os.RemoveAll(stateDir); err != nil {
+		return fmt.Errorf("failed to remove state: %w", err)
+	}
+	if err := os.Rename(tmp, stateDir); err != nil {
+		return fmt.Errorf("failed to restore snapshot: %w", err)
+	}
+	return nil
+}
+
+// List returns the snapshots of the store sorted by name.
+func (s Store) List() ([]Snapshot, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	var snapshots []Snapshot
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), snapshotExt)
+		if !ok || entry.IsDir() || !validName.MatchString(name) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list snapshots: %w", err)
+		}
+		snapshots = append(snapshots, Snapshot{Name: name, Created: info.ModTime(), Size: info.Size()})
+	}
+	return snapshots, nil
+}
+
+// Files returns the files of the snapshot name.
+func (s Store) Files(name string) (Files, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := s.open(name, path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	files := Files{}
+	err = walkArchive(f, func(hdr *tar.Header, r io.Reader) error {
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+		sum, err := hashReader(r)
+		if err != nil {
+			return err
+		}
+		files[hdr.Name] = sum
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %q: %w", name, err)
+	}
+	return files, nil
+}
+
+// ReadState returns the files of a state directory.
+func ReadState(stateDir string) (Files, error) {
+	files := Files{}
+	err := filepath.WalkDir(stateDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		sum, err := hashReader(f)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(stateDir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = sum
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state: %w", err)
+	}
+	return files, nil
+}
+
+// ChangeKind describes how a file differs between two states.
+type ChangeKind string
+
+const (
+	Added    ChangeKind = "added"
+	Removed  ChangeKind = "removed"
+	Modified ChangeKind = "modified"
+)
+
+// Change is a file which differs between two states.
+type Change struct {
+	Path string
+	Kind ChangeKind
+}
+
+// Diff returns the changes needed to go from the state from to the state to, sorted by path.
+func Diff(from, to Files) []Change {
+	var changes []Change
+	for path, sum := range from {
+		toSum, ok := to[path]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Path: path, Kind: Removed})
+		case toSum != sum:
+			changes = append(changes, Change{Path: path, Kind: Modified})
+		}
+	}
+	for path := range to {
+		if _, ok := from[path]; !ok {
+			changes = append(changes, Change{Path: path, Kind: Added})
+		}
+	}
+	slices.SortFunc(changes, func(a, b Change) int { return strings.Compare(a.Path, b.Path) })
+	return changes
+}
+
+func (s Store) path(name string) (string, error) {
+	if !validName.MatchString(name) {
+		return "", fmt.Errorf("invalid input: snapshot name %q must only contain letters, digits, '.', '_' or '-'", name)
+	}
+	return filepath.Join(s.Dir, name+snapshotExt), nil
+}
+
+func (s Store) open(name, path string) (*os.File, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %q", ErrSnapshotNotFound, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	return f, nil
+}
+
+func archive(w io.Writer, dir string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			// sockets and pid files of a running swtpm aren't part of the state
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func extract(r io.Reader, dir string) error {
+	return walkArchive(r, func(hdr *tar.Header, r io.Reader) error {
+		name := filepath.FromSlash(hdr.Name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("invalid path %q in archive", hdr.Name)
+		}
+		target := filepath.Join(dir, name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			return os.MkdirAll(target, 0755)
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, hdr.FileInfo().Mode().Perm())
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, r); err != nil {
+				f.Close()
+				return err
+			}
+			return f.Close()
+		default:
+			return fmt.Errorf("unsupported entry %q in archive", hdr.Name)
+		}
+	})
+}
+
+func walkArchive(r io.Reader, fn func(hdr *tar.Header, r io.Reader) error) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func hashReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
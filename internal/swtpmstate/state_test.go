@@ -0,0 +1,143 @@
+package swtpmstate
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func writeState(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSaveRestore(t *testing.T) {
+	root := t.TempDir()
+	stateDir := filepath.Join(root, "state")
+	store := Store{Dir: filepath.Join(root, "snapshots")}
+	writeState(t, stateDir, map[string]string{
+		"tpm2-00.permall": "provisioned",
+		"sub/extra":       "extra",
+	})
+
+	if err := store.Save("provisioned", stateDir, false); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if err := store.Save("provisioned", stateDir, false); err == nil {
+		t.Error("Save() of an existing snapshot error = nil, want error")
+	}
+	if err := store.Save("provisioned", stateDir, true); err != nil {
+		t.Errorf("Save() with overwrite error = %v", err)
+	}
+
+	// alter the state, then rewind
+	if err := os.WriteFile(filepath.Join(stateDir, "tpm2-00.permall"), []byte("altered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeState(t, stateDir, map[string]string{"new": "new"})
+	if err := store.Restore("provisioned", stateDir); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(stateDir, "tpm2-00.permall"))
+	if err != nil || string(got) != "provisioned" {
+		t.Errorf("restored content = %q (%v), want %q", got, err, "provisioned")
+	}
+	if _, err := os.Stat(filepath.Join(stateDir, "new")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("file created after the snapshot still exists (err = %v)", err)
+	}
+	if _, err := os.Stat(filepath.Join(stateDir, "sub", "extra")); err != nil {
+		t.Errorf("nested file not restored: %v", err)
+	}
+}
+
+func TestRestoreMissingSnapshot(t *testing.T) {
+	store := Store{Dir: t.TempDir()}
+	stateDir := filepath.Join(t.TempDir(), "state")
+	writeState(t, stateDir, map[string]string{"tpm2-00.permall": "current"})
+
+	if err := store.Restore("missing", stateDir); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Fatalf("Restore() error = %v, want %v", err, ErrSnapshotNotFound)
+	}
+	if got, _ := os.ReadFile(filepath.Join(stateDir, "tpm2-00.permall")); string(got) != "current" {
+		t.Errorf("state altered by a failed restore: %q", got)
+	}
+}
+
+func TestInvalidName(t *testing.T) {
+	store := Store{Dir: t.TempDir()}
+	for _, name := range []string{"", "../escape", "a/b", ".hidden"} {
+		if err := store.Save(name, t.TempDir(), false); err == nil {
+			t.Errorf("Save(%q) error = nil, want error", name)
+		}
+	}
+}
+
+func TestListAndDiff(t *testing.T) {
+	root := t.TempDir()
+	stateDir := filepath.Join(root, "state")
+	store := Store{Dir: filepath.Join(root, "snapshots")}
+
+	if snapshots, err := store.List(); err != nil || len(snapshots) != 0 {
+		t.Fatalf("List() of an empty store = %v, %v", snapshots, err)
+	}
+
+	writeState(t, stateDir, map[string]string{"permall": "1", "volatile": "v"})
+	if err := store.Save("before", stateDir, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(stateDir, "volatile")); err != nil {
+		t.Fatal(err)
+	}
+	writeState(t, stateDir, map[string]string{"permall": "2", "added": "a"})
+	if err := store.Save("after", stateDir, false); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots, err := store.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	var names []string
+	for _, s := range snapshots {
+		names = append(names, s.Name)
+	}
+	if !slices.Equal(names, []string{"after", "before"}) {
+		t.Errorf("List() names = %v, want [after before]", names)
+	}
+
+	before, err := store.Files("before")
+	if err != nil {
+		t.Fatalf("Files() error = %v", err)
+	}
+	current, err := ReadState(stateDir)
+	if err != nil {
+		t.Fatalf("ReadState() error = %v", err)
+	}
+	want := []Change{
+		{Path: "added", Kind: Added},
+		{Path: "permall", Kind: Modified},
+		{Path: "volatile", Kind: Removed},
+	}
+	if got := Diff(before, current); !slices.Equal(got, want) {
+		t.Errorf("Diff() = %v, want %v", got, want)
+	}
+
+	after, err := store.Files("after")
+	if err != nil {
+		t.Fatalf("Files() error = %v", err)
+	}
+	if got := Diff(after, current); len(got) != 0 {
+		t.Errorf("Diff() of identical states = %v, want none", got)
+	}
+}
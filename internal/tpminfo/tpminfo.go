@@ -0,0 +1,355 @@
+// Package tpminfo inspects the capabilities of a TPM using TPM2_GetCapability.
+package tpminfo
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+)
+
+// Info gathers the capabilities of a TPM.
+type Info struct {
+	Manufacturer    string    `json:"manufacturer"`
+	VendorString    string    `json:"vendor_string"`
+	FirmwareVersion string    `json:"firmware_version"`
+	Family          string    `json:"family"`
+	Level           uint32    `json:"level"`
+	Revision        string    `json:"revision"`
+	SpecYear        uint32    `json:"spec_year"`
+	SpecDayOfYear   uint32    `json:"spec_day_of_year"`
+	Algorithms      []string  `json:"algorithms"`
+	Commands        []string  `json:"commands"`
+	ECCCurves       []string  `json:"ecc_curves"`
+	PCRBanks        []PCRBank `json:"pcr_banks"`
+	Buffers         Buffers   `json:"buffers"`
+	Handles         Handles   `json:"handles"`
+}
+
+// PCRBank is a PCR bank allocated in the TPM.
+type PCRBank struct {
+	Hash string `json:"hash"`
+	// PCRs is the number of PCRs allocated in the bank.
+	PCRs int `json:"pcrs"`
+}
+
+// Buffers holds the maximum sizes (in bytes) accepted by the TPM.
+type Buffers struct {
+	InputBuffer       uint32 `json:"input_buffer"`
+	MaxCommandSize    uint32 `json:"max_command_size"`
+	MaxResponseSize   uint32 `json:"max_response_size"`
+	MaxDigest         uint32 `json:"max_digest"`
+	MaxObjectContext  uint32 `json:"max_object_context"`
+	MaxSessionContext uint32 `json:"max_session_context"`
+	NVBufferMax       uint32 `json:"nv_buffer_max"`
+	MaxCapBuffer      uint32 `json:"max_cap_buffer"`
+}
+
+// Handles counts the handles currently defined in the TPM.
+type Handles struct {
+	Loaded          int    `json:"loaded"`
+	TransientAvail  uint32 `json:"transient_avail"`
+	Persistent      int    `json:"persistent"`
+	PersistentAvail uint32 `json:"persistent_avail"`
+	NVIndexes       int    `json:"nv_indexes"`
+}
+
+// Read collects the capabilities of tpm.
+func Read(tpm transport.TPM) (*Info, error) {
+	props, err := readProperties(tpm)
+	if err != nil {
+		return nil, err
+	}
+	fw1, fw2 := props[tpm2.TPMPTFirmwareVersion1], props[tpm2.TPMPTFirmwareVersion2]
+	revision := props[tpm2.TPMPTRevision]
+	info := &Info{
+		Manufacturer: asciiString(props[tpm2.TPMPTManufacturer]),
+		VendorString: asciiString(
+			props[tpm2.TPMPTVendorString1], props[tpm2.TPMPTVendorString2],
+			props[tpm2.TPMPTVendorString3], props[tpm2.TPMPTVendorString4],
+		),
+		FirmwareVersion: fmt.Sprintf("%d.%d.%d.%d", fw1>>16, fw1&0xffff, fw2>>16, fw2&0xffff),
+		Family:          asciiString(props[tpm2.TPMPTFamilyIndicator]),
+		Level:           props[tpm2.TPMPTLevel],
+		Revision:        fmt.Sprintf("%d.%02d", revision/100, revision%100),
+		SpecYear:        props[tpm2.TPMPTYear],
+		SpecDayOfYear:   props[tpm2.TPMPTDayofYear],
+		Buffers: Buffers{
+			InputBuffer:       props[tpm2.TPMPTInputBuffer],
+			MaxCommandSize:    props[tpm2.TPMPTMaxCommandSize],
+			MaxResponseSize:   props[tpm2.TPMPTMaxResponseSize],
+			MaxDigest:         props[tpm2.TPMPTMaxDigest],
+			MaxObjectContext:  props[tpm2.TPMPTMaxObjectContext],
+			MaxSessionContext: props[tpm2.TPMPTMaxSessionContext],
+			NVBufferMax:       props[tpm2.TPMPTNVBufferMax],
+			MaxCapBuffer:      props[tpm2.TPMPTMaxCapBuffer],
+		},
+		Handles: Handles{
+			TransientAvail:  props[tpm2.TPMPTHRTransientAvail],
+			PersistentAvail: props[tpm2.TPMPTHRPersistentAvail],
+		},
+	}
+
+	if info.Algorithms, err = readAlgorithms(tpm); err != nil {
+		return nil, err
+	}
+	if info.Commands, err = readCommands(tpm); err != nil {
+		return nil, err
+	}
+	if info.ECCCurves, err = readCurves(tpm); err != nil {
+		return nil, err
+	}
+	if info.PCRBanks, err = readPCRBanks(tpm); err != nil {
+		return nil, err
+	}
+	for ht, count := range map[tpm2.TPMHT]*int{
+		tpm2.TPMHTTransient:  &info.Handles.Loaded,
+		tpm2.TPMHTPersistent: &info.Handles.Persistent,
+		tpm2.TPMHTNVIndex:    &info.Handles.NVIndexes,
+	} {
+		handles, err := ReadHandles(tpm, ht)
+		if err != nil {
+			return nil, err
+		}
+		*count = len(handles)
+	}
+	return info, nil
+}
+
+// ReadHandles lists the handles of the given type (e.g. [tpm2.TPMHTPersistent]).
+func ReadHandles(tpm transport.TPM, ht tpm2.TPMHT) ([]tpm2.TPMHandle, error) {
+	var handles []tpm2.TPMHandle
+	err := getCapability(tpm, tpm2.TPMCapHandles, uint32(ht)<<24, func(data tpm2.TPMUCapabilities) (uint32, error) {
+		list, err := data.Handles()
+		if err != nil {
+			return 0, err
+		}
+		for _, h := range list.Handle {
+			// the TPM answers with the following handle types once the requested one is exhausted
+			if tpm2.TPMHT(h>>24) != ht {
+				return 0, errStop
+			}
+			handles = append(handles, h)
+		}
+		return next(list.Handle, func(h tpm2.TPMHandle) uint32 { return uint32(h) })
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read handles: %w", err)
+	}
+	return handles, nil
+}
+
+func readProperties(tpm transport.TPM) (map[tpm2.TPMPT]uint32, error) {
+	props := make(map[tpm2.TPMPT]uint32)
+	// fixed properties (PT_FIXED) then variable ones (PT_VAR)
+	for _, first := range []tpm2.TPMPT{tpm2.TPMPTFamilyIndicator, tpm2.TPMPTPermanent} {
+		group := uint32(first) &^ 0xff
+		err := getCapability(tpm, tpm2.TPMCapTPMProperties, uint32(first), func(data tpm2.TPMUCapabilities) (uint32, error) {
+			list, err := data.TPMProperties()
+			if err != nil {
+				return 0, err
+			}
+			for _, p := range list.TPMProperty {
+				if uint32(p.Property)&^0xff != group {
+					return 0, errStop
+				}
+				props[p.Property] = p.Value
+			}
+			return next(list.TPMProperty, func(p tpm2.TPMSTaggedProperty) uint32 { return uint32(p.Property) })
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read properties: %w", err)
+		}
+	}
+	return props, nil
+}
+
+func readAlgorithms(tpm transport.TPM) ([]string, error) {
+	var algs []string
+	err := getCapability(tpm, tpm2.TPMCapAlgs, 0, func(data tpm2.TPMUCapabilities) (uint32, error) {
+		list, err := data.Algorithms()
+		if err != nil {
+			return 0, err
+		}
+		for _, p := range list.AlgProperties {
+			algs = append(algs, tpmutil.AlgName(p.Alg))
+		}
+		return next(list.AlgProperties, func(p tpm2.TPMSAlgProperty) uint32 { return uint32(p.Alg) })
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read algorithms: %w", err)
+	}
+	return algs, nil
+}
+
+func readCommands(tpm transport.TPM) ([]string, error) {
+	var cmds []string
+	err := getCapability(tpm, tpm2.TPMCapCommands, uint32(tpm2.TPMCCNVUndefineSpaceSpecial), func(data tpm2.TPMUCapabilities) (uint32, error) {
+		list, err := data.Command()
+		if err != nil {
+			return 0, err
+		}
+		for _, attrs := range list.CommandAttributes {
+			if attrs.V {
+				cmds = append(cmds, fmt.Sprintf("vendor(0x%04x)", attrs.CommandIndex))
+				continue
+			}
+			cmds = append(cmds, tpmutil.CommandName(tpm2.TPMCC(attrs.CommandIndex)))
+		}
+		return next(list.CommandAttributes, func(attrs tpm2.TPMACC) uint32 { return uint32(attrs.CommandIndex) })
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commands: %w", err)
+	}
+	return cmds, nil
+}
+
+func readCurves(tpm transport.TPM) ([]string, error) {
+	var curves []string
+	err := getCapability(tpm, tpm2.TPMCapECCCurves, 0, func(data tpm2.TPMUCapabilities) (uint32, error) {
+		list, err := data.ECCCurves()
+		if err != nil {
+			return 0, err
+		}
+		for _, c := range list.ECCCurves {
+			curves = append(curves, tpmutil.CurveName(c))
+		}
+		return next(list.ECCCurves, func(c tpm2.TPMECCCurve) uint32 { return uint32(c) })
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ecc curves: %w", err)
+	}
+	return curves, nil
+}
+
+func readPCRBanks(tpm transport.TPM) ([]PCRBank, error) {
+	rsp, err := tpm2.GetCapability{
+		Capability:    tpm2.TPMCapPCRs,
+		PropertyCount: 1,
+	}.Execute(tpm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pcr banks: %w", err)
+	}
+	selections, err := rsp.CapabilityData.Data.AssignedPCR()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pcr banks: %w", err)
+	}
+	var banks []PCRBank
+	for _, s := range selections.PCRSelections {
+		count := 0
+		for _, b := range s.PCRSelect {
+			count += bits.OnesCount8(b)
+		}
+		banks = append(banks, PCRBank{Hash: tpmutil.AlgName(s.Hash), PCRs: count})
+	}
+	return banks, nil
+}
+
+// errStop ends the enumeration of a capability.
+var errStop = errors.New("stop")
+
+// maxPropertyCount is the number of values requested at once.
+const maxPropertyCount = 64
+
+// getCapability enumerates a capability starting at property.
+// parse handles a page and returns the property following its last value.
+func getCapability(tpm transport.TPM, capability tpm2.TPMCap, property uint32, parse func(tpm2.TPMUCapabilities) (uint32, error)) error {
+	for {
+		rsp, err := tpm2.GetCapability{
+			Capability:    capability,
+			Property:      property,
+			PropertyCount: maxPropertyCount,
+		}.Execute(tpm)
+		if err != nil {
+			return err
+		}
+		nextProperty, err := parse(rsp.CapabilityData.Data)
+		if errors.Is(err, errStop) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !rsp.MoreData {
+			return nil
+		}
+		property = nextProperty
+	}
+}
+
+// next returns the property following the last value of a page.
+func next[T any](values []T, property func(T) uint32) (uint32, error) {
+	if len(values) == 0 {
+		return 0, errStop
+	}
+	return property(values[len(values)-1]) + 1, nil
+}
+
+// asciiString decodes properties holding 4 ASCII characters each.
+func asciiString(values ...uint32) string {
+	var b []byte
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return strings.TrimSpace(strings.TrimRight(string(b), "\x00"))
+}
+
+// WriteJSON writes the capabilities as indented JSON.
+func (i *Info) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(i)
+}
+
+// WriteTable writes the capabilities as a human readable table.
+func (i *Info) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	rows := [][2]string{
+		{"Manufacturer", i.Manufacturer},
+		{"Vendor", i.VendorString},
+		{"Firmware version", i.FirmwareVersion},
+		{"Specification", fmt.Sprintf("%s level %d revision %s (%d, day %d)", i.Family, i.Level, i.Revision, i.SpecYear, i.SpecDayOfYear)},
+		{"Algorithms", strings.Join(i.Algorithms, ", ")},
+		{"ECC curves", strings.Join(i.ECCCurves, ", ")},
+		{"PCR banks", formatBanks(i.PCRBanks)},
+		{"Commands", fmt.Sprintf("%d supported", len(i.Commands))},
+		{"Input buffer", fmt.Sprintf("%d B", i.Buffers.InputBuffer)},
+		{"Max command size", fmt.Sprintf("%d B", i.Buffers.MaxCommandSize)},
+		{"Max response size", fmt.Sprintf("%d B", i.Buffers.MaxResponseSize)},
+		{"Max digest", fmt.Sprintf("%d B", i.Buffers.MaxDigest)},
+		{"Max object context", fmt.Sprintf("%d B", i.Buffers.MaxObjectContext)},
+		{"Max session context", fmt.Sprintf("%d B", i.Buffers.MaxSessionContext)},
+		{"NV buffer max", fmt.Sprintf("%d B", i.Buffers.NVBufferMax)},
+		{"Max capability buffer", fmt.Sprintf("%d B", i.Buffers.MaxCapBuffer)},
+		{"Loaded objects", fmt.Sprintf("%d (%d more available)", i.Handles.Loaded, i.Handles.TransientAvail)},
+		{"Persistent objects", fmt.Sprintf("%d (%d more available)", i.Handles.Persistent, i.Handles.PersistentAvail)},
+		{"NV indexes", fmt.Sprintf("%d", i.Handles.NVIndexes)},
+	}
+	for _, row := range rows {
+		fmt.Fprintf(tw, "%s\t%s\n", row[0], row[1])
+	}
+	for n, cmd := range i.Commands {
+		label := ""
+		if n == 0 {
+			label = "Command list"
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", label, cmd)
+	}
+	return tw.Flush()
+}
+
+func formatBanks(banks []PCRBank) string {
+	var s []string
+	for _, b := range banks {
+		s = append(s, fmt.Sprintf("%s (%d PCRs)", b.Hash, b.PCRs))
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,94 @@
+package tpminfo
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/loicsikidi/go-tpm-kit/tpmtest"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+)
+
+func TestRead(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+
+	// one persistent object so that the handle counts aren't trivially zero
+	srk, err := tpm2.CreatePrimary{
+		PrimaryHandle: tpm2.TPMRHOwner,
+		InPublic:      tpm2.New2B(tpmutil.ECCSRKTemplate),
+	}.Execute(tpm)
+	if err != nil {
+		t.Fatalf("CreatePrimary() error = %v", err)
+	}
+	persistent := tpm2.TPMHandle(0x81000001)
+	if _, err := (tpm2.EvictControl{
+		Auth:             tpm2.TPMRHOwner,
+		ObjectHandle:     &tpm2.NamedHandle{Handle: srk.ObjectHandle, Name: srk.Name},
+		PersistentHandle: persistent,
+	}).Execute(tpm); err != nil {
+		t.Fatalf("EvictControl() error = %v", err)
+	}
+	t.Cleanup(func() {
+		tpm2.EvictControl{
+			Auth:             tpm2.TPMRHOwner,
+			ObjectHandle:     &tpm2.NamedHandle{Handle: persistent, Name: srk.Name},
+			PersistentHandle: persistent,
+		}.Execute(tpm)
+	})
+
+	info, err := Read(tpm)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if info.Family != "2.0" {
+		t.Errorf("Family = %q, want 2.0", info.Family)
+	}
+	if info.Manufacturer == "" || info.Revision == "" {
+		t.Errorf("Manufacturer = %q, Revision = %q, want non-empty values", info.Manufacturer, info.Revision)
+	}
+	for _, alg := range []string{"rsa", "ecc", "sha256", "aes"} {
+		if !slices.Contains(info.Algorithms, alg) {
+			t.Errorf("Algorithms = %v, want %s", info.Algorithms, alg)
+		}
+	}
+	for _, cmd := range []string{"TPM2_CreatePrimary", "TPM2_GetCapability", "TPM2_Quote"} {
+		if !slices.Contains(info.Commands, cmd) {
+			t.Errorf("Commands doesn't contain %s", cmd)
+		}
+	}
+	if !slices.Contains(info.ECCCurves, "nist_p256") {
+		t.Errorf("ECCCurves = %v, want nist_p256", info.ECCCurves)
+	}
+	if !slices.ContainsFunc(info.PCRBanks, func(b PCRBank) bool { return b.Hash == "sha256" && b.PCRs == 24 }) {
+		t.Errorf("PCRBanks = %v, want a sha256 bank with 24 PCRs", info.PCRBanks)
+	}
+	if info.Buffers.MaxDigest != 64 || info.Buffers.InputBuffer == 0 {
+		t.Errorf("Buffers = %+v, want MaxDigest = 64 and a non-zero input buffer", info.Buffers)
+	}
+	if info.Handles.Persistent != 1 || info.Handles.Loaded != 1 {
+		t.Errorf("Handles = %+v, want 1 persistent and 1 loaded object", info.Handles)
+	}
+
+	var table bytes.Buffer
+	if err := info.WriteTable(&table); err != nil {
+		t.Fatalf("WriteTable() error = %v", err)
+	}
+	if !strings.Contains(table.String(), "Persistent objects") {
+		t.Errorf("WriteTable() output lacks the persistent objects:\n%s", table.String())
+	}
+
+	var out bytes.Buffer
+	if err := info.WriteJSON(&out); err != nil {
+		t.Fatalf("WriteJSON() error = %v", err)
+	}
+	var decoded Info
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if decoded.Family != info.Family || len(decoded.Commands) != len(info.Commands) {
+		t.Errorf("JSON round trip = %+v, want %+v", decoded, info)
+	}
+}
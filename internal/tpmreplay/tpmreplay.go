@@ -0,0 +1,57 @@
+// Package tpmreplay lets tests run against a checked-in transcript of a TPM session
+// instead of a live simulator.
+//
+// Transcripts are (re)recorded against the simulator by running the tests with
+// $TPM_PILLS_RECORD set:
+//
+//	TPM_PILLS_RECORD=1 go test ./...
+package tpmreplay
+
+import (
+	"os"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/go-tpm-kit/tpmtest"
+	"github.com/loicsikidi/tpm-pills/internal/tpmutil"
+)
+
+// RecordEnvVar is the environment variable which switches [Open] to recording mode.
+const RecordEnvVar = "TPM_PILLS_RECORD"
+
+// Open returns a TPM replaying the transcript stored at path.
+//
+// The test fails if it sends a command which diverges from the transcript or
+// if the transcript isn't fully replayed once the test completes.
+// In recording mode, the commands are sent to the simulator and the transcript
+// is written to path once the test succeeds.
+func Open(t testing.TB, path string) transport.TPM {
+	t.Helper()
+	if os.Getenv(RecordEnvVar) != "" {
+		recorder := tpmutil.NewRecorder(tpmtest.OpenSimulator(t))
+		t.Cleanup(func() {
+			if t.Failed() {
+				return
+			}
+			if err := recorder.Transcript().Save(path); err != nil {
+				t.Errorf("failed to save transcript: %v", err)
+			}
+		})
+		return recorder
+	}
+
+	transcript, err := tpmutil.LoadTranscript(path)
+	if err != nil {
+		t.Fatalf("%v (run the test with %s=1 to record it)", err, RecordEnvVar)
+	}
+	replayer := tpmutil.NewReplayer(transcript)
+	t.Cleanup(func() {
+		if t.Failed() {
+			return
+		}
+		if err := replayer.Close(); err != nil {
+			t.Errorf("%v (run the test with %s=1 to record it again)", err, RecordEnvVar)
+		}
+	})
+	return replayer
+}
@@ -0,0 +1,107 @@
+package tpmutil
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+// algNames follows the naming of tpm2-tools.
+var algNames = map[tpm2.TPMAlgID]string{
+	tpm2.TPMAlgRSA:          "rsa",
+	tpm2.TPMAlgTDES:         "tdes",
+	tpm2.TPMAlgSHA1:         "sha1",
+	tpm2.TPMAlgHMAC:         "hmac",
+	tpm2.TPMAlgAES:          "aes",
+	tpm2.TPMAlgMGF1:         "mgf1",
+	tpm2.TPMAlgKeyedHash:    "keyedhash",
+	tpm2.TPMAlgXOR:          "xor",
+	tpm2.TPMAlgSHA256:       "sha256",
+	tpm2.TPMAlgSHA384:       "sha384",
+	tpm2.TPMAlgSHA512:       "sha512",
+	tpm2.TPMAlgSHA256192:    "sha256_192",
+	tpm2.TPMAlgNull:         "null",
+	tpm2.TPMAlgSM3256:       "sm3_256",
+	tpm2.TPMAlgSM4:          "sm4",
+	tpm2.TPMAlgRSASSA:       "rsassa",
+	tpm2.TPMAlgRSAES:        "rsaes",
+	tpm2.TPMAlgRSAPSS:       "rsapss",
+	tpm2.TPMAlgOAEP:         "oaep",
+	tpm2.TPMAlgECDSA:        "ecdsa",
+	tpm2.TPMAlgECDH:         "ecdh",
+	tpm2.TPMAlgECDAA:        "ecdaa",
+	tpm2.TPMAlgSM2:          "sm2",
+	tpm2.TPMAlgECSchnorr:    "ecschnorr",
+	tpm2.TPMAlgECMQV:        "ecmqv",
+	tpm2.TPMAlgKDF1SP80056A: "kdf1_sp800_56a",
+	tpm2.TPMAlgKDF2:         "kdf2",
+	tpm2.TPMAlgKDF1SP800108: "kdf1_sp800_108",
+	tpm2.TPMAlgECC:          "ecc",
+	tpm2.TPMAlgSymCipher:    "symcipher",
+	tpm2.TPMAlgCamellia:     "camellia",
+	tpm2.TPMAlgSHA3256:      "sha3_256",
+	tpm2.TPMAlgSHA3384:      "sha3_384",
+	tpm2.TPMAlgSHA3512:      "sha3_512",
+	tpm2.TPMAlgCMAC:         "cmac",
+	tpm2.TPMAlgCTR:          "ctr",
+	tpm2.TPMAlgOFB:          "ofb",
+	tpm2.TPMAlgCBC:          "cbc",
+	tpm2.TPMAlgCFB:          "cfb",
+	tpm2.TPMAlgECB:          "ecb",
+	tpm2.TPMAlgCCM:          "ccm",
+	tpm2.TPMAlgGCM:          "gcm",
+	tpm2.TPMAlgEDDSA:        "eddsa",
+}
+
+var curveNames = map[tpm2.TPMECCCurve]string{
+	tpm2.TPMECCNistP192:        "nist_p192",
+	tpm2.TPMECCNistP224:        "nist_p224",
+	tpm2.TPMECCNistP256:        "nist_p256",
+	tpm2.TPMECCNistP384:        "nist_p384",
+	tpm2.TPMECCNistP521:        "nist_p521",
+	tpm2.TPMECCBNP256:          "bn_p256",
+	tpm2.TPMECCBNP638:          "bn_p638",
+	tpm2.TPMECCSM2P256:         "sm2_p256",
+	tpm2.TPMECCBrainpoolP256R1: "brainpool_p256r1",
+	tpm2.TPMECCBrainpoolP384R1: "brainpool_p384r1",
+	tpm2.TPMECCBrainpoolP512R1: "brainpool_p512r1",
+	tpm2.TPMECCCurve25519:      "curve25519",
+	tpm2.TPMECCCurve448:        "curve448",
+}
+
+// AlgName returns the name of an algorithm (e.g. 'sha256'), as used by tpm2-tools.
+func AlgName(alg tpm2.TPMAlgID) string {
+	if name, ok := algNames[alg]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04x", uint16(alg))
+}
+
+// CurveName returns the name of an ECC curve (e.g. 'nist_p256').
+func CurveName(curve tpm2.TPMECCCurve) string {
+	if name, ok := curveNames[curve]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04x", uint16(curve))
+}
+
+// ParseAlg returns the algorithm designated by name (e.g. 'sha256'), see [AlgName].
+func ParseAlg(name string) (tpm2.TPMAlgID, error) {
+	for alg, n := range algNames {
+		if strings.EqualFold(n, name) {
+			return alg, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown algorithm %q", name)
+}
+
+// ParseCurve returns the ECC curve designated by name (e.g. 'nist_p256'), see [CurveName].
+func ParseCurve(name string) (tpm2.TPMECCCurve, error) {
+	for curve, n := range curveNames {
+		if strings.EqualFold(n, name) {
+			return curve, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown curve %q", name)
+}
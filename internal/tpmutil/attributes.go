@@ -0,0 +1,152 @@
+package tpmutil
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+// objectAttributes names the attributes of [tpm2.TPMAObject] as tpm2-tools does.
+var objectAttributes = []struct {
+	name  string
+	field func(a *tpm2.TPMAObject) *bool
+}{
+	{"fixedtpm", func(a *tpm2.TPMAObject) *bool { return &a.FixedTPM }},
+	{"stclear", func(a *tpm2.TPMAObject) *bool { return &a.STClear }},
+	{"fixedparent", func(a *tpm2.TPMAObject) *bool { return &a.FixedParent }},
+	{"sensitivedataorigin", func(a *tpm2.TPMAObject) *bool { return &a.SensitiveDataOrigin }},
+	{"userwithauth", func(a *tpm2.TPMAObject) *bool { return &a.UserWithAuth }},
+	{"adminwithpolicy", func(a *tpm2.TPMAObject) *bool { return &a.AdminWithPolicy }},
+	{"noda", func(a *tpm2.TPMAObject) *bool { return &a.NoDA }},
+	{"encryptedduplication", func(a *tpm2.TPMAObject) *bool { return &a.EncryptedDuplication }},
+	{"restricted", func(a *tpm2.TPMAObject) *bool { return &a.Restricted }},
+	{"decrypt", func(a *tpm2.TPMAObject) *bool { return &a.Decrypt }},
+	{"sign", func(a *tpm2.TPMAObject) *bool { return &a.SignEncrypt }},
+	{"x509sign", func(a *tpm2.TPMAObject) *bool { return &a.X509Sign }},
+}
+
+// ParseAttributes parses object attributes written as tpm2-tools does,
+// either as names joined by '|' (e.g. 'fixedtpm|fixedparent|sensitivedataorigin|userwithauth|sign')
+// or as a raw TPMA_OBJECT value (e.g. '0x00060072').
+func ParseAttributes(s string) (tpm2.TPMAObject, error) {
+	var a tpm2.TPMAObject
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return a, fmt.Errorf("invalid attributes: empty")
+	}
+	if strings.HasPrefix(s, "0x") {
+		v, err := strconv.ParseUint(s[2:], 16, 32)
+		if err != nil {
+			return a, fmt.Errorf("invalid attributes %q: %w", s, err)
+		}
+		p, err := tpm2.Unmarshal[tpm2.TPMAObject](binary.BigEndian.AppendUint32(nil, uint32(v)))
+		if err != nil {
+			return a, fmt.Errorf("invalid attributes %q: %w", s, err)
+		}
+		return *p, nil
+	}
+	for _, name := range strings.Split(s, "|") {
+		if err := setAttribute(&a, strings.ToLower(strings.TrimSpace(name))); err != nil {
+			return a, fmt.Errorf("invalid attributes %q: %w", s, err)
+		}
+	}
+	return a, nil
+}
+
+// FormatAttributes formats object attributes as tpm2-tools does (e.g. 'fixedtpm|fixedparent|sign').
+func FormatAttributes(a tpm2.TPMAObject) string {
+	return strings.Join(attributeNames(a), "|")
+}
+
+// attributeNames returns the names of the attributes set in a.
+func attributeNames(a tpm2.TPMAObject) []string {
+	var names []string
+	for _, attr := range objectAttributes {
+		if *attr.field(&a) {
+			names = append(names, attr.name)
+		}
+	}
+	return names
+}
+
+// setAttribute sets the attribute designated by name in a.
+func setAttribute(a *tpm2.TPMAObject, name string) error {
+	for _, attr := range objectAttributes {
+		if attr.name == name {
+			*attr.field(a) = true
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown attribute %q", name)
+}
+
+// nvAttributes names the attributes of [tpm2.TPMANV] as tpm2-tools does.
+// The type of the index (TPM_NT) isn't an attribute, see [ParseNVType].
+var nvAttributes = []struct {
+	name  string
+	field func(a *tpm2.TPMANV) *bool
+}{
+	{"ppwrite", func(a *tpm2.TPMANV) *bool { return &a.PPWrite }},
+	{"ownerwrite", func(a *tpm2.TPMANV) *bool { return &a.OwnerWrite }},
+	{"authwrite", func(a *tpm2.TPMANV) *bool { return &a.AuthWrite }},
+	{"policywrite", func(a *tpm2.TPMANV) *bool { return &a.PolicyWrite }},
+	{"policy_delete", func(a *tpm2.TPMANV) *bool { return &a.PolicyDelete }},
+	{"writelocked", func(a *tpm2.TPMANV) *bool { return &a.WriteLocked }},
+	{"writeall", func(a *tpm2.TPMANV) *bool { return &a.WriteAll }},
+	{"writedefine", func(a *tpm2.TPMANV) *bool { return &a.WriteDefine }},
+	{"write_stclear", func(a *tpm2.TPMANV) *bool { return &a.WriteSTClear }},
+	{"globallock", func(a *tpm2.TPMANV) *bool { return &a.GlobalLock }},
+	{"ppread", func(a *tpm2.TPMANV) *bool { return &a.PPRead }},
+	{"ownerread", func(a *tpm2.TPMANV) *bool { return &a.OwnerRead }},
+	{"authread", func(a *tpm2.TPMANV) *bool { return &a.AuthRead }},
+	{"policyread", func(a *tpm2.TPMANV) *bool { return &a.PolicyRead }},
+	{"no_da", func(a *tpm2.TPMANV) *bool { return &a.NoDA }},
+	{"orderly", func(a *tpm2.TPMANV) *bool { return &a.Orderly }},
+	{"clear_stclear", func(a *tpm2.TPMANV) *bool { return &a.ClearSTClear }},
+	{"readlocked", func(a *tpm2.TPMANV) *bool { return &a.ReadLocked }},
+	{"written", func(a *tpm2.TPMANV) *bool { return &a.Written }},
+	{"platformcreate", func(a *tpm2.TPMANV) *bool { return &a.PlatformCreate }},
+	{"read_stclear", func(a *tpm2.TPMANV) *bool { return &a.ReadSTClear }},
+}
+
+// ParseNVAttributes parses NV index attributes written as tpm2-tools does,
+// as names joined by '|' (e.g. 'ownerwrite|ownerread|authwrite|authread').
+func ParseNVAttributes(s string) (tpm2.TPMANV, error) {
+	var a tpm2.TPMANV
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return a, fmt.Errorf("invalid NV attributes: empty")
+	}
+	for _, name := range strings.Split(s, "|") {
+		if err := setNVAttribute(&a, strings.ToLower(strings.TrimSpace(name))); err != nil {
+			return a, fmt.Errorf("invalid NV attributes %q: %w", s, err)
+		}
+	}
+	return a, nil
+}
+
+// FormatNVAttributes formats NV index attributes as tpm2-tools does (e.g. 'ownerwrite|ownerread|written').
+// The type of the index is left out, see [NVTypeName].
+func FormatNVAttributes(a tpm2.TPMANV) string {
+	var names []string
+	for _, attr := range nvAttributes {
+		if *attr.field(&a) {
+			names = append(names, attr.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// setNVAttribute sets the attribute designated by name in a.
+func setNVAttribute(a *tpm2.TPMANV, name string) error {
+	for _, attr := range nvAttributes {
+		if attr.name == name {
+			*attr.field(a) = true
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown attribute %q", name)
+}
@@ -0,0 +1,90 @@
+package tpmutil
+
+import (
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/loicsikidi/tpm-pills/internal/options"
+)
+
+func TestParseAttributes(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    tpm2.TPMAObject
+		wantErr bool
+	}{
+		{in: "fixedtpm|fixedparent|sensitivedataorigin|userwithauth|sign", want: ECCSignerTemplate.ObjectAttributes},
+		{in: " FixedTPM | fixedparent|sensitivedataorigin|userwithauth|sign ", want: ECCSignerTemplate.ObjectAttributes},
+		{in: "0x00040072", want: ECCSignerTemplate.ObjectAttributes},
+		{in: "fixedtpm|fixedparent|sensitivedataorigin|userwithauth|noda|decrypt|sign", want: RSAEncryptTemplate.ObjectAttributes},
+		{in: "", wantErr: true},
+		{in: "fixedtpm|", wantErr: true},
+		{in: "fixedtpm|exportable", wantErr: true},
+		{in: "0xzz", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseAttributes(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseAttributes(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseAttributes(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatAttributes(t *testing.T) {
+	for _, name := range BuiltinTemplateNames() {
+		attrs := BuiltinTemplates[name].ObjectAttributes
+		s := FormatAttributes(attrs)
+		got, err := ParseAttributes(s)
+		if err != nil {
+			t.Fatalf("ParseAttributes(%q) error = %v", s, err)
+		}
+		if got != attrs {
+			t.Errorf("%s: ParseAttributes(FormatAttributes()) = %+v, want %+v", name, got, attrs)
+		}
+	}
+	if got, want := FormatAttributes(ECCRestrictedSignerTemplate.ObjectAttributes), "fixedtpm|fixedparent|sensitivedataorigin|userwithauth|restricted|sign"; got != want {
+		t.Errorf("FormatAttributes() = %q, want %q", got, want)
+	}
+}
+
+func TestKeyTemplateAttributes(t *testing.T) {
+	opts := &options.CreateKeyOpts{Attributes: "fixedtpm|fixedparent|sensitivedataorigin|userwithauth|noda|sign"}
+	got, err := KeyTemplate(opts, ECCSignerTemplate)
+	if err != nil {
+		t.Fatalf("KeyTemplate() error = %v", err)
+	}
+	if !got.ObjectAttributes.NoDA || !got.ObjectAttributes.SignEncrypt {
+		t.Errorf("KeyTemplate() attributes = %q", FormatAttributes(got.ObjectAttributes))
+	}
+	if ECCSignerTemplate.ObjectAttributes.NoDA {
+		t.Errorf("KeyTemplate() altered the fallback template")
+	}
+
+	opts.Attributes = "fixedtpm|sign|exportable"
+	if _, err := KeyTemplate(opts, ECCSignerTemplate); err == nil {
+		t.Errorf("KeyTemplate() error = nil, want an error")
+	}
+}
+
+func TestParseNVAttributes(t *testing.T) {
+	got, err := ParseNVAttributes(" OwnerWrite|authwrite | ownerread|authread ")
+	if err != nil {
+		t.Fatalf("ParseNVAttributes() error = %v", err)
+	}
+	if got != DefaultNVAttributes {
+		t.Errorf("ParseNVAttributes() = %+v, want %+v", got, DefaultNVAttributes)
+	}
+	if s := FormatNVAttributes(got); s != "ownerwrite|authwrite|ownerread|authread" {
+		t.Errorf("FormatNVAttributes() = %q, want %q", s, "ownerwrite|authwrite|ownerread|authread")
+	}
+	for _, in := range []string{"", "ownerwrite|", "ownerwrite|sign"} {
+		if _, err := ParseNVAttributes(in); err == nil {
+			t.Errorf("ParseNVAttributes(%q) error = nil, want an error", in)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package tpmutil
+
+import (
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+// authHandle is a key loaded by [LoadKey] along with its password and its policy.
+type authHandle struct {
+	HandleCloser
+	auth   []byte
+	policy Policy
+}
+
+// AuthHandle returns h authorized by a password session.
+//
+// The password is the one given to [LoadKey] when h was loaded by it, and empty otherwise.
+// With an empty password, the result is the same as [ToAuthHandle].
+func AuthHandle(h Handle) tpm2.AuthHandle {
+	if a, ok := h.(*authHandle); ok {
+		return ToAuthHandle(a.HandleCloser, tpm2.PasswordAuth(a.auth))
+	}
+	return ToAuthHandle(h)
+}
+
+// AuthorizeKey returns h authorized by a policy session satisfying the policy given to [LoadKey],
+// or by a password session when h has no policy (see [AuthHandle]).
+//
+// A policy session authorizes a single command, the returned function closes it.
+func AuthorizeKey(tpm transport.TPM, h Handle) (tpm2.AuthHandle, func(), error) {
+	a, ok := h.(*authHandle)
+	if !ok || len(a.policy) == 0 {
+		return AuthHandle(h), func() {}, nil
+	}
+	session, closer, err := a.policy.Satisfy(tpm, a.Public().NameAlg, a.auth)
+	if err != nil {
+		return tpm2.AuthHandle{}, nil, err
+	}
+	return ToAuthHandle(a.HandleCloser, session), func() { closer() }, nil
+}
@@ -0,0 +1,163 @@
+package tpmutil
+
+import (
+	"crypto/sha256"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/go-tpm-kit/tpmtest"
+)
+
+// tpmProperty reads a TPM property such as [tpm2.TPMPTLockoutCounter].
+func tpmProperty(t *testing.T, tpm transport.TPM, property tpm2.TPMPT) uint32 {
+	t.Helper()
+	v, err := ReadProperty(tpm, property)
+	if err != nil {
+		t.Fatalf("ReadProperty() error = %v", err)
+	}
+	return v
+}
+
+func signWithAuth(tpm transport.TPM, keyPath string, auth []byte) error {
+	return signWithPolicy(tpm, keyPath, auth, nil)
+}
+
+func signWithPolicy(tpm transport.TPM, keyPath string, auth []byte, policy Policy) error {
+	keyHandle, err := LoadKey(tpm, LoadKeyConfig{
+		ParentTemplate: ECCSRKTemplate,
+		KeyBlobPath:    keyPath,
+		Auth:           auth,
+		Policy:         policy,
+	})
+	if err != nil {
+		return err
+	}
+	defer keyHandle.Close()
+
+	keyAuth, closer, err := AuthorizeKey(tpm, keyHandle)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	digest := sha256.Sum256([]byte("message"))
+	_, err = tpm2.Sign{
+		KeyHandle:  keyAuth,
+		Digest:     tpm2.TPM2BDigest{Buffer: digest[:]},
+		Validation: tpm2.TPMTTKHashCheck{Tag: tpm2.TPMSTHashCheck, Hierarchy: tpm2.TPMRHNull},
+	}.Execute(tpm)
+	return err
+}
+
+// TestWrongAuthIncrementsLockoutCounter shows that a wrong password on a key
+// subject to dictionary attack protection increments the lockout counter,
+// until the TPM refuses any authorization, even with the right password.
+func TestWrongAuthIncrementsLockoutCounter(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	dir := t.TempDir()
+	auth := []byte("correct horse")
+	if err := CreateKey(tpm, CreateKeyConfig{
+		OutDir:           dir,
+		ParentTemplate:   ECCSRKTemplate,
+		OrdinaryTemplate: ECCSignerTemplate,
+		UserAuth:         auth,
+	}); err != nil {
+		t.Fatalf("CreateKey() error = %v", err)
+	}
+	keyPath := filepath.Join(dir, "key.tpm")
+
+	if err := signWithAuth(tpm, keyPath, auth); err != nil {
+		t.Fatalf("Sign() with the right password error = %v", err)
+	}
+	if err := signWithAuth(tpm, keyPath, nil); !errors.Is(err, tpm2.TPMRCAuthFail) {
+		t.Fatalf("Sign() without password error = %v, want %v", err, tpm2.TPMRCAuthFail)
+	}
+	if got := tpmProperty(t, tpm, tpm2.TPMPTLockoutCounter); got != 1 {
+		t.Errorf("lockout counter = %d, want 1", got)
+	}
+
+	maxTries := tpmProperty(t, tpm, tpm2.TPMPTMaxAuthFail)
+	for i := tpmProperty(t, tpm, tpm2.TPMPTLockoutCounter); i < maxTries; i++ {
+		if err := signWithAuth(tpm, keyPath, []byte("wrong")); !errors.Is(err, tpm2.TPMRCAuthFail) {
+			t.Fatalf("Sign() with a wrong password error = %v, want %v", err, tpm2.TPMRCAuthFail)
+		}
+	}
+	if got := tpmProperty(t, tpm, tpm2.TPMPTLockoutCounter); got != maxTries {
+		t.Errorf("lockout counter = %d, want %d", got, maxTries)
+	}
+	if err := signWithAuth(tpm, keyPath, auth); !errors.Is(err, tpm2.TPMRCLockout) {
+		t.Errorf("Sign() in lockout error = %v, want %v", err, tpm2.TPMRCLockout)
+	}
+}
+
+// TestWrongAuthWithNoDA shows that a wrong password on an object with the
+// noDA attribute (e.g. a sealed object) doesn't touch the lockout counter.
+func TestWrongAuthWithNoDA(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	sealedPath := filepath.Join(t.TempDir(), "sealed.tpm")
+	secret := []byte("secret")
+	auth := []byte("correct horse")
+	if err := Seal(tpm, SealConfig{
+		ParentTemplate: ECCSRKTemplate,
+		Message:        secret,
+		OutputFilePath: sealedPath,
+		UserAuth:       auth,
+	}); err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+
+	unsealWithAuth := func(auth []byte) ([]byte, error) {
+		keyHandle, err := LoadKey(tpm, LoadKeyConfig{
+			ParentTemplate: ECCSRKTemplate,
+			KeyBlobPath:    sealedPath,
+			Auth:           auth,
+		})
+		if err != nil {
+			return nil, err
+		}
+		defer keyHandle.Close()
+		return Unseal(tpm, UnsealConfig{KeyHandle: keyHandle})
+	}
+
+	maxTries := tpmProperty(t, tpm, tpm2.TPMPTMaxAuthFail)
+	for range maxTries + 1 {
+		if _, err := unsealWithAuth([]byte("wrong")); !errors.Is(err, tpm2.TPMRCBadAuth) {
+			t.Fatalf("Unseal() with a wrong password error = %v, want %v", err, tpm2.TPMRCBadAuth)
+		}
+	}
+	if got := tpmProperty(t, tpm, tpm2.TPMPTLockoutCounter); got != 0 {
+		t.Errorf("lockout counter = %d, want 0", got)
+	}
+	got, err := unsealWithAuth(auth)
+	if err != nil {
+		t.Fatalf("Unseal() with the right password error = %v", err)
+	}
+	if string(got) != string(secret) {
+		t.Errorf("Unseal() = %q, want %q", got, secret)
+	}
+}
+
+func TestHMACAuth(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	template, err := NewHMACKeyTemplate(tpm2.TPMAlgSHA256)
+	if err != nil {
+		t.Fatalf("NewHMACKeyTemplate() error = %v", err)
+	}
+	data := []byte("data")
+
+	want, err := HMAC(tpm, HMACConfig{KeyTemplate: template, Data: data})
+	if err != nil {
+		t.Fatalf("HMAC() error = %v", err)
+	}
+	// the password authorizes the use of the key but doesn't change it
+	got, err := HMAC(tpm, HMACConfig{KeyTemplate: template, Data: data, UserAuth: []byte("password")})
+	if err != nil {
+		t.Fatalf("HMAC() with a password error = %v", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("HMAC() with a password = %x, want %x", got, want)
+	}
+}
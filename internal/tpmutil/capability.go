@@ -0,0 +1,28 @@
+package tpmutil
+
+import (
+	"fmt"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+// ReadProperty reads a TPM property such as [tpm2.TPMPTNVBufferMax].
+func ReadProperty(tpm transport.TPM, property tpm2.TPMPT) (uint32, error) {
+	rsp, err := tpm2.GetCapability{
+		Capability:    tpm2.TPMCapTPMProperties,
+		Property:      uint32(property),
+		PropertyCount: 1,
+	}.Execute(tpm)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read property 0x%x: %w", uint32(property), err)
+	}
+	props, err := rsp.CapabilityData.Data.TPMProperties()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read property 0x%x: %w", uint32(property), err)
+	}
+	if len(props.TPMProperty) == 0 || props.TPMProperty[0].Property != property {
+		return 0, fmt.Errorf("failed to read property 0x%x: not returned by the TPM", uint32(property))
+	}
+	return props.TPMProperty[0].Value, nil
+}
@@ -0,0 +1,317 @@
+package tpmutil
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+// commandInfo describes the layout of a TPM command (see TPM 2.0 Part 3).
+type commandInfo struct {
+	name string
+	// handles is the number of handles in the command handle area.
+	handles int
+	// rspHandles is the number of handles in the response handle area.
+	rspHandles int
+}
+
+var commands = map[tpm2.TPMCC]commandInfo{
+	tpm2.TPMCCNVUndefineSpaceSpecial:     {"NV_UndefineSpaceSpecial", 2, 0},
+	tpm2.TPMCCEvictControl:               {"EvictControl", 2, 0},
+	tpm2.TPMCCHierarchyControl:           {"HierarchyControl", 1, 0},
+	tpm2.TPMCCNVUndefineSpace:            {"NV_UndefineSpace", 2, 0},
+	tpm2.TPMCCChangeEPS:                  {"ChangeEPS", 1, 0},
+	tpm2.TPMCCChangePPS:                  {"ChangePPS", 1, 0},
+	tpm2.TPMCCClear:                      {"Clear", 1, 0},
+	tpm2.TPMCCClearControl:               {"ClearControl", 1, 0},
+	tpm2.TPMCCClockSet:                   {"ClockSet", 1, 0},
+	tpm2.TPMCCHierarchyChanegAuth:        {"HierarchyChangeAuth", 1, 0},
+	tpm2.TPMCCNVDefineSpace:              {"NV_DefineSpace", 1, 0},
+	tpm2.TPMCCPCRAllocate:                {"PCR_Allocate", 1, 0},
+	tpm2.TPMCCPCRSetAuthPolicy:           {"PCR_SetAuthPolicy", 1, 0},
+	tpm2.TPMCCPPCommands:                 {"PP_Commands", 1, 0},
+	tpm2.TPMCCSetPrimaryPolicy:           {"SetPrimaryPolicy", 1, 0},
+	tpm2.TPMCCFieldUpgradeStart:          {"FieldUpgradeStart", 2, 0},
+	tpm2.TPMCCClockRateAdjust:            {"ClockRateAdjust", 1, 0},
+	tpm2.TPMCCCreatePrimary:              {"CreatePrimary", 1, 1},
+	tpm2.TPMCCNVGlobalWriteLock:          {"NV_GlobalWriteLock", 1, 0},
+	tpm2.TPMCCGetCommandAuditDigest:      {"GetCommandAuditDigest", 2, 0},
+	tpm2.TPMCCNVIncrement:                {"NV_Increment", 2, 0},
+	tpm2.TPMCCNVSetBits:                  {"NV_SetBits", 2, 0},
+	tpm2.TPMCCNVExtend:                   {"NV_Extend", 2, 0},
+	tpm2.TPMCCNVWrite:                    {"NV_Write", 2, 0},
+	tpm2.TPMCCNVWriteLock:                {"NV_WriteLock", 2, 0},
+	tpm2.TPMCCDictionaryAttackLockReset:  {"DictionaryAttackLockReset", 1, 0},
+	tpm2.TPMCCDictionaryAttackParameters: {"DictionaryAttackParameters", 1, 0},
+	tpm2.TPMCCNVChangeAuth:               {"NV_ChangeAuth", 1, 0},
+	tpm2.TPMCCPCREvent:                   {"PCR_Event", 1, 0},
+	tpm2.TPMCCPCRReset:                   {"PCR_Reset", 1, 0},
+	tpm2.TPMCCSequenceComplete:           {"SequenceComplete", 1, 0},
+	tpm2.TPMCCSetAlgorithmSet:            {"SetAlgorithmSet", 1, 0},
+	tpm2.TPMCCSetCommandCodeAuditStatus:  {"SetCommandCodeAuditStatus", 1, 0},
+	tpm2.TPMCCFieldUpgradeData:           {"FieldUpgradeData", 0, 0},
+	tpm2.TPMCCIncrementalSelfTest:        {"IncrementalSelfTest", 0, 0},
+	tpm2.TPMCCSelfTest:                   {"SelfTest", 0, 0},
+	tpm2.TPMCCStartup:                    {"Startup", 0, 0},
+	tpm2.TPMCCShutdown:                   {"Shutdown", 0, 0},
+	tpm2.TPMCCStirRandom:                 {"StirRandom", 0, 0},
+	tpm2.TPMCCActivateCredential:         {"ActivateCredential", 2, 0},
+	tpm2.TPMCCCertify:                    {"Certify", 2, 0},
+	tpm2.TPMCCPolicyNV:                   {"PolicyNV", 3, 0},
+	tpm2.TPMCCCertifyCreation:            {"CertifyCreation", 2, 0},
+	tpm2.TPMCCDuplicate:                  {"Duplicate", 2, 0},
+	tpm2.TPMCCGetTime:                    {"GetTime", 2, 0},
+	tpm2.TPMCCGetSessionAuditDigest:      {"GetSessionAuditDigest", 3, 0},
+	tpm2.TPMCCNVRead:                     {"NV_Read", 2, 0},
+	tpm2.TPMCCNVReadLock:                 {"NV_ReadLock", 2, 0},
+	tpm2.TPMCCObjectChangeAuth:           {"ObjectChangeAuth", 2, 0},
+	tpm2.TPMCCPolicySecret:               {"PolicySecret", 2, 0},
+	tpm2.TPMCCRewrap:                     {"Rewrap", 2, 0},
+	tpm2.TPMCCCreate:                     {"Create", 1, 0},
+	tpm2.TPMCCECDHZGen:                   {"ECDH_ZGen", 1, 0},
+	tpm2.TPMCCMAC:                        {"MAC", 1, 0},
+	tpm2.TPMCCImport:                     {"Import", 1, 0},
+	tpm2.TPMCCLoad:                       {"Load", 1, 1},
+	tpm2.TPMCCQuote:                      {"Quote", 1, 0},
+	tpm2.TPMCCRSADecrypt:                 {"RSA_Decrypt", 1, 0},
+	tpm2.TPMCCMACStart:                   {"MAC_Start", 1, 1},
+	tpm2.TPMCCSequenceUpdate:             {"SequenceUpdate", 1, 0},
+	tpm2.TPMCCSign:                       {"Sign", 1, 0},
+	tpm2.TPMCCUnseal:                     {"Unseal", 1, 0},
+	tpm2.TPMCCPolicySigned:               {"PolicySigned", 2, 0},
+	tpm2.TPMCCContextLoad:                {"ContextLoad", 0, 1},
+	tpm2.TPMCCContextSave:                {"ContextSave", 1, 0},
+	tpm2.TPMCCECDHKeyGen:                 {"ECDH_KeyGen", 1, 0},
+	tpm2.TPMCCEncryptDecrypt:             {"EncryptDecrypt", 1, 0},
+	tpm2.TPMCCFlushContext:               {"FlushContext", 0, 0},
+	tpm2.TPMCCLoadExternal:               {"LoadExternal", 0, 1},
+	tpm2.TPMCCMakeCredential:             {"MakeCredential", 1, 0},
+	tpm2.TPMCCNVReadPublic:               {"NV_ReadPublic", 1, 0},
+	tpm2.TPMCCPolicyAuthorize:            {"PolicyAuthorize", 1, 0},
+	tpm2.TPMCCPolicyAuthValue:            {"PolicyAuthValue", 1, 0},
+	tpm2.TPMCCPolicyCommandCode:          {"PolicyCommandCode", 1, 0},
+	tpm2.TPMCCPolicyCounterTimer:         {"PolicyCounterTimer", 1, 0},
+	tpm2.TPMCCPolicyCpHash:               {"PolicyCpHash", 1, 0},
+	tpm2.TPMCCPolicyLocality:             {"PolicyLocality", 1, 0},
+	tpm2.TPMCCPolicyNameHash:             {"PolicyNameHash", 1, 0},
+	tpm2.TPMCCPolicyOR:                   {"PolicyOR", 1, 0},
+	tpm2.TPMCCPolicyTicket:               {"PolicyTicket", 1, 0},
+	tpm2.TPMCCReadPublic:                 {"ReadPublic", 1, 0},
+	tpm2.TPMCCRSAEncrypt:                 {"RSA_Encrypt", 1, 0},
+	tpm2.TPMCCStartAuthSession:           {"StartAuthSession", 2, 1},
+	tpm2.TPMCCVerifySignature:            {"VerifySignature", 1, 0},
+	tpm2.TPMCCECCParameters:              {"ECC_Parameters", 0, 0},
+	tpm2.TPMCCFirmwareRead:               {"FirmwareRead", 0, 0},
+	tpm2.TPMCCGetCapability:              {"GetCapability", 0, 0},
+	tpm2.TPMCCGetRandom:                  {"GetRandom", 0, 0},
+	tpm2.TPMCCGetTestResult:              {"GetTestResult", 0, 0},
+	tpm2.TPMCCHash:                       {"Hash", 0, 0},
+	tpm2.TPMCCPCRRead:                    {"PCR_Read", 0, 0},
+	tpm2.TPMCCPolicyPCR:                  {"PolicyPCR", 1, 0},
+	tpm2.TPMCCPolicyRestart:              {"PolicyRestart", 1, 0},
+	tpm2.TPMCCReadClock:                  {"ReadClock", 0, 0},
+	tpm2.TPMCCPCRExtend:                  {"PCR_Extend", 1, 0},
+	tpm2.TPMCCPCRSetAuthValue:            {"PCR_SetAuthValue", 1, 0},
+	tpm2.TPMCCNVCertify:                  {"NV_Certify", 3, 0},
+	tpm2.TPMCCEventSequenceComplete:      {"EventSequenceComplete", 2, 0},
+	tpm2.TPMCCHashSequenceStart:          {"HashSequenceStart", 0, 1},
+	tpm2.TPMCCPolicyPhysicalPresence:     {"PolicyPhysicalPresence", 1, 0},
+	tpm2.TPMCCPolicyDuplicationSelect:    {"PolicyDuplicationSelect", 1, 0},
+	tpm2.TPMCCPolicyGetDigest:            {"PolicyGetDigest", 1, 0},
+	tpm2.TPMCCTestParms:                  {"TestParms", 0, 0},
+	tpm2.TPMCCCommit:                     {"Commit", 1, 0},
+	tpm2.TPMCCPolicyPassword:             {"PolicyPassword", 1, 0},
+	tpm2.TPMCCZGen2Phase:                 {"ZGen_2Phase", 1, 0},
+	tpm2.TPMCCECEphemeral:                {"EC_Ephemeral", 0, 0},
+	tpm2.TPMCCPolicyNvWritten:            {"PolicyNvWritten", 1, 0},
+	tpm2.TPMCCPolicyTemplate:             {"PolicyTemplate", 1, 0},
+	tpm2.TPMCCCreateLoaded:               {"CreateLoaded", 1, 1},
+	tpm2.TPMCCPolicyAuthorizeNV:          {"PolicyAuthorizeNV", 3, 0},
+	tpm2.TPMCCEncryptDecrypt2:            {"EncryptDecrypt2", 1, 0},
+	tpm2.TPMCCACGetCapability:            {"AC_GetCapability", 1, 0},
+	tpm2.TPMCCACSend:                     {"AC_Send", 3, 0},
+	tpm2.TPMCCPolicyACSendSelect:         {"Policy_AC_SendSelect", 1, 0},
+	tpm2.TPMCCCertifyX509:                {"CertifyX509", 2, 0},
+	tpm2.TPMCCACTSetTimeout:              {"ACT_SetTimeout", 1, 0},
+}
+
+// headerSize is the size of a command or response header: tag, size and command/response code.
+const headerSize = 10
+
+var errShortBuffer = errors.New("buffer shorter than a tpm header")
+
+// CommandName returns the TPM 2.0 name of a command code (e.g. 'TPM2_CreatePrimary').
+func CommandName(cc tpm2.TPMCC) string {
+	if info, ok := commands[cc]; ok {
+		return "TPM2_" + info.name
+	}
+	return "TPM2_Unknown"
+}
+
+// commandNameOf returns the name of a marshalled command.
+func commandNameOf(cmd []byte) string {
+	cc, err := commandCode(cmd)
+	if err != nil {
+		return "TPM2_Unknown"
+	}
+	return CommandName(cc)
+}
+
+// commandCode extracts the command code of a marshalled command.
+func commandCode(cmd []byte) (tpm2.TPMCC, error) {
+	if len(cmd) < headerSize {
+		return 0, errShortBuffer
+	}
+	return tpm2.TPMCC(binary.BigEndian.Uint32(cmd[6:10])), nil
+}
+
+// responseCode extracts the response code of a marshalled response.
+func responseCode(rsp []byte) (tpm2.TPMRC, error) {
+	if len(rsp) < headerSize {
+		return 0, errShortBuffer
+	}
+	return tpm2.TPMRC(binary.BigEndian.Uint32(rsp[6:10])), nil
+}
+
+// commandHandles returns the handles of a marshalled command along with its number of sessions.
+// ok is false if the command is unknown or malformed.
+func commandHandles(cmd []byte) (handles []tpm2.TPMHandle, sessions int, ok bool) {
+	handles, authSessions, ok := parseCommand(cmd)
+	return handles, len(authSessions), ok
+}
+
+// authSession is a session of the authorization area of a command.
+type authSession struct {
+	handle tpm2.TPMHandle
+	// continueSession asks the TPM to keep the session once the command completes.
+	continueSession bool
+}
+
+// parseCommand returns the handles and the sessions of a marshalled command.
+// ok is false if the command is unknown or malformed.
+func parseCommand(cmd []byte) (handles []tpm2.TPMHandle, sessions []authSession, ok bool) {
+	cc, err := commandCode(cmd)
+	if err != nil {
+		return nil, nil, false
+	}
+	info, known := commands[cc]
+	if !known || len(cmd) < headerSize+4*info.handles {
+		return nil, nil, false
+	}
+	buf := cmd[headerSize:]
+	for range info.handles {
+		handles = append(handles, tpm2.TPMHandle(binary.BigEndian.Uint32(buf)))
+		buf = buf[4:]
+	}
+	if tpm2.TPMST(binary.BigEndian.Uint16(cmd)) != tpm2.TPMSTSessions {
+		return handles, nil, true
+	}
+
+	// authorization area: size followed by a list of TPMS_AUTH_COMMAND
+	if len(buf) < 4 {
+		return handles, nil, false
+	}
+	size := binary.BigEndian.Uint32(buf)
+	buf = buf[4:]
+	if uint32(len(buf)) < size {
+		return handles, nil, false
+	}
+	buf = buf[:size]
+	for len(buf) > 0 {
+		// session handle, nonce, attributes and hmac
+		n, ok := authCommandSize(buf)
+		if !ok {
+			return handles, sessions, false
+		}
+		sessions = append(sessions, authSession{
+			handle:          tpm2.TPMHandle(binary.BigEndian.Uint32(buf)),
+			continueSession: buf[6+binary.BigEndian.Uint16(buf[4:])]&sessionContinued != 0,
+		})
+		buf = buf[n:]
+	}
+	return handles, sessions, true
+}
+
+// sessionContinued is the continueSession bit of TPMA_SESSION.
+const sessionContinued = 0x01
+
+func authCommandSize(buf []byte) (int, bool) {
+	n := 4
+	if len(buf) < n+2 {
+		return 0, false
+	}
+	n += 2 + int(binary.BigEndian.Uint16(buf[n:]))
+	n++ // attributes
+	if len(buf) < n+2 {
+		return 0, false
+	}
+	n += 2 + int(binary.BigEndian.Uint16(buf[n:]))
+	if len(buf) < n {
+		return 0, false
+	}
+	return n, true
+}
+
+// responseHandles returns the handles of a successful marshalled response.
+func responseHandles(cc tpm2.TPMCC, rsp []byte) []tpm2.TPMHandle {
+	info := commands[cc]
+	if rc, err := responseCode(rsp); err != nil || rc != tpm2.TPMRCSuccess || len(rsp) < headerSize+4*info.rspHandles {
+		return nil
+	}
+	var handles []tpm2.TPMHandle
+	for i := range info.rspHandles {
+		handles = append(handles, tpm2.TPMHandle(binary.BigEndian.Uint32(rsp[headerSize+4*i:])))
+	}
+	return handles
+}
+
+// responseSessions returns, for each session of a successful marshalled response, whether
+// the session is still active (continueSession). ok is false if the response is malformed.
+func responseSessions(cc tpm2.TPMCC, rsp []byte) (continued []bool, ok bool) {
+	info := commands[cc]
+	if rc, err := responseCode(rsp); err != nil || rc != tpm2.TPMRCSuccess || len(rsp) < headerSize+4*info.rspHandles {
+		return nil, false
+	}
+	if tpm2.TPMST(binary.BigEndian.Uint16(rsp)) != tpm2.TPMSTSessions {
+		return nil, true
+	}
+	// the parameters are preceded by their size and followed by a list of TPMS_AUTH_RESPONSE
+	buf := rsp[headerSize+4*info.rspHandles:]
+	if len(buf) < 4 || uint32(len(buf)-4) < binary.BigEndian.Uint32(buf) {
+		return nil, false
+	}
+	buf = buf[4+binary.BigEndian.Uint32(buf):]
+	for len(buf) > 0 {
+		// nonce, attributes and hmac
+		if len(buf) < 2 {
+			return continued, false
+		}
+		n := 2 + int(binary.BigEndian.Uint16(buf))
+		if len(buf) < n+3 {
+			return continued, false
+		}
+		continued = append(continued, buf[n]&sessionContinued != 0)
+		n++
+		n += 2 + int(binary.BigEndian.Uint16(buf[n:]))
+		if len(buf) < n {
+			return continued, false
+		}
+		buf = buf[n:]
+	}
+	return continued, true
+}
+
+// ParseCommandCode parses the name of a command, with or without the 'TPM2_' prefix
+// (e.g. 'Unseal' or 'TPM2_Sign'), case-insensitively.
+func ParseCommandCode(name string) (tpm2.TPMCC, error) {
+	trimmed := strings.TrimPrefix(strings.ToLower(name), "tpm2_")
+	for cc, info := range commands {
+		if strings.ToLower(info.name) == trimmed {
+			return cc, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown command %q", name)
+}
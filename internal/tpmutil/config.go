@@ -12,6 +12,11 @@ type CreateKeyConfig struct {
 	ParentTemplate   tpm2.TPMTPublic
 	OrdinaryTemplate tpm2.TPMTPublic
 	CreatePublicKey  bool
+	// UserAuth is the password of the key, empty by default.
+	UserAuth []byte
+	// Policy is required to use the key when set (see [Policy]).
+	// It is saved along with the key, PCR values included (see [PolicyFilePath]).
+	Policy Policy
 }
 
 func (c *CreateKeyConfig) CheckAndSetDefaults() error {
@@ -28,6 +33,11 @@ func (c *CreateKeyConfig) CheckAndSetDefaults() error {
 type LoadKeyConfig struct {
 	ParentTemplate tpm2.TPMTPublic
 	KeyBlobPath    string
+	// Auth is the password of the key, it is carried by the returned handle (see [AuthorizeKey]).
+	Auth []byte
+	// Policy is the policy of the key, it is carried by the returned handle (see [AuthorizeKey]).
+	// It defaults to the policy saved along with the key blob, if any.
+	Policy Policy
 }
 
 func (c *LoadKeyConfig) CheckAndSetDefaults() error {
@@ -56,6 +66,11 @@ type SealConfig struct {
 	ParentTemplate tpm2.TPMTPublic
 	Message        []byte
 	OutputFilePath string
+	// UserAuth is the password of the sealed object, empty by default.
+	UserAuth []byte
+	// Policy is required to unseal the object when set (see [Policy]).
+	// It is saved along with the sealed object, PCR values included (see [PolicyFilePath]).
+	Policy Policy
 }
 
 func (c *SealConfig) CheckAndSetDefaults() error {
@@ -82,6 +97,10 @@ func (c *UnsealConfig) CheckAndSetDefaults() error {
 type HMACConfig struct {
 	KeyTemplate tpm2.TPMTPublic
 	Data        []byte
+	// UserAuth is the password of the HMAC key, empty by default.
+	UserAuth []byte
+	// Policy is satisfied to use the HMAC key when set (see [Policy]).
+	Policy Policy
 }
 
 func (c *HMACConfig) CheckAndSetDefaults() error {
@@ -5,3 +5,7 @@ import "path"
 const SWTPM_ROOT_STATE = ".swtpm"
 
 var SWTPM_STATE = path.Join(SWTPM_ROOT_STATE, "state")
+
+// SWTPM_SNAPSHOTS is where the snapshots of the swtpm state are stored.
+// It lives outside of [SWTPM_ROOT_STATE] so that snapshots survive a cleanup.
+const SWTPM_SNAPSHOTS = ".swtpm-snapshots"
@@ -0,0 +1,87 @@
+package tpmutil
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+// permanentInLockout is the inLockout bit of TPMA_PERMANENT.
+const permanentInLockout = 1 << 9
+
+// DAParameters holds the state of the dictionary attack protection of the TPM.
+//
+// Every authorization failure on an object without noDA increments LockoutCounter.
+// Once it reaches MaxAuthFail, the TPM is in lockout and refuses such authorizations
+// until the counter is decremented, by one every LockoutInterval, or reset with
+// [ResetDALockout].
+type DAParameters struct {
+	// LockoutCounter is the number of authorization failures (TPM_PT_LOCKOUT_COUNTER).
+	LockoutCounter uint32 `json:"lockout_counter"`
+	// MaxAuthFail is the number of failures before the lockout (TPM_PT_MAX_AUTH_FAIL).
+	MaxAuthFail uint32 `json:"max_auth_fail"`
+	// LockoutInterval is the number of seconds decrementing the counter by one (TPM_PT_LOCKOUT_INTERVAL).
+	// Zero disables the protection.
+	LockoutInterval uint32 `json:"lockout_interval"`
+	// LockoutRecovery is the number of seconds before the lockout hierarchy can be used again
+	// after a failed authorization (TPM_PT_LOCKOUT_RECOVERY). With zero, it requires a TPM reset.
+	LockoutRecovery uint32 `json:"lockout_recovery"`
+	// InLockout is set when the TPM refuses authorizations subject to the protection.
+	InLockout bool `json:"in_lockout"`
+}
+
+// ReadDAParameters reads the state of the dictionary attack protection.
+func ReadDAParameters(tpm transport.TPM) (*DAParameters, error) {
+	var params DAParameters
+	for _, p := range []struct {
+		property tpm2.TPMPT
+		value    *uint32
+	}{
+		{tpm2.TPMPTLockoutCounter, &params.LockoutCounter},
+		{tpm2.TPMPTMaxAuthFail, &params.MaxAuthFail},
+		{tpm2.TPMPTLockoutInterval, &params.LockoutInterval},
+		{tpm2.TPMPTLockoutRecovery, &params.LockoutRecovery},
+	} {
+		v, err := ReadProperty(tpm, p.property)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read dictionary attack parameters: %w", err)
+		}
+		*p.value = v
+	}
+	permanent, err := ReadProperty(tpm, tpm2.TPMPTPermanent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dictionary attack parameters: %w", err)
+	}
+	params.InLockout = permanent&permanentInLockout != 0
+	return &params, nil
+}
+
+// SetDAParameters changes the parameters of the dictionary attack protection
+// (TPM2_DictionaryAttackParameters). The counter and the lockout state are ignored.
+//
+// The command is authorized by the lockout hierarchy with lockoutAuth.
+func SetDAParameters(tpm transport.TPM, params DAParameters, lockoutAuth []byte) error {
+	var b []byte
+	b = binary.BigEndian.AppendUint32(b, params.MaxAuthFail)
+	b = binary.BigEndian.AppendUint32(b, params.LockoutInterval)
+	b = binary.BigEndian.AppendUint32(b, params.LockoutRecovery)
+	// go-tpm doesn't implement TPM2_DictionaryAttackParameters
+	if _, err := executeRawPassword(tpm, tpm2.TPMCCDictionaryAttackParameters, []tpm2.TPMHandle{tpm2.TPMRHLockout}, lockoutAuth, b); err != nil {
+		return fmt.Errorf("failed to set dictionary attack parameters: %w", err)
+	}
+	return nil
+}
+
+// ResetDALockout resets the lockout counter to zero and leaves the lockout
+// (TPM2_DictionaryAttackLockReset).
+//
+// The command is authorized by the lockout hierarchy with lockoutAuth.
+func ResetDALockout(tpm transport.TPM, lockoutAuth []byte) error {
+	// go-tpm doesn't implement TPM2_DictionaryAttackLockReset
+	if _, err := executeRawPassword(tpm, tpm2.TPMCCDictionaryAttackLockReset, []tpm2.TPMHandle{tpm2.TPMRHLockout}, lockoutAuth, nil); err != nil {
+		return fmt.Errorf("failed to reset the lockout: %w", err)
+	}
+	return nil
+}
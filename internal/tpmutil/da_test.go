@@ -0,0 +1,98 @@
+package tpmutil
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/go-tpm-kit/tpmtest"
+)
+
+func readDAParameters(t *testing.T, tpm transport.TPM) *DAParameters {
+	t.Helper()
+	params, err := ReadDAParameters(tpm)
+	if err != nil {
+		t.Fatalf("ReadDAParameters() error = %v", err)
+	}
+	return params
+}
+
+// TestDALockout contrasts a key subject to dictionary attack protection with a noDA key
+// under repeated wrong passwords: only the former counts failures and is locked out.
+func TestDALockout(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	want := DAParameters{MaxAuthFail: 3, LockoutInterval: 1000, LockoutRecovery: 1000}
+	if err := SetDAParameters(tpm, want, nil); err != nil {
+		t.Fatalf("SetDAParameters() error = %v", err)
+	}
+	if got := readDAParameters(t, tpm); *got != want {
+		t.Fatalf("ReadDAParameters() = %+v, want %+v", *got, want)
+	}
+
+	auth := []byte("correct horse")
+	createKey := func(noDA bool) string {
+		dir := t.TempDir()
+		template := ECCSignerTemplate
+		template.ObjectAttributes.NoDA = noDA
+		if err := CreateKey(tpm, CreateKeyConfig{
+			OutDir:           dir,
+			ParentTemplate:   ECCSRKTemplate,
+			OrdinaryTemplate: template,
+			UserAuth:         auth,
+		}); err != nil {
+			t.Fatalf("CreateKey() error = %v", err)
+		}
+		return filepath.Join(dir, "key.tpm")
+	}
+	daKey, noDAKey := createKey(false), createKey(true)
+
+	for range want.MaxAuthFail {
+		if err := signWithAuth(tpm, noDAKey, []byte("wrong")); !errors.Is(err, tpm2.TPMRCBadAuth) {
+			t.Fatalf("Sign() with the noDA key error = %v, want %v", err, tpm2.TPMRCBadAuth)
+		}
+	}
+	if got := readDAParameters(t, tpm); got.LockoutCounter != 0 || got.InLockout {
+		t.Errorf("after failures on the noDA key: lockout counter = %d, in lockout = %t, want 0, false", got.LockoutCounter, got.InLockout)
+	}
+
+	for range want.MaxAuthFail {
+		if err := signWithAuth(tpm, daKey, []byte("wrong")); !errors.Is(err, tpm2.TPMRCAuthFail) {
+			t.Fatalf("Sign() with the DA key error = %v, want %v", err, tpm2.TPMRCAuthFail)
+		}
+	}
+	if got := readDAParameters(t, tpm); got.LockoutCounter != want.MaxAuthFail || !got.InLockout {
+		t.Errorf("after failures on the DA key: lockout counter = %d, in lockout = %t, want %d, true", got.LockoutCounter, got.InLockout, want.MaxAuthFail)
+	}
+
+	// in lockout, even the right password is refused, except for noDA objects
+	if err := signWithAuth(tpm, daKey, auth); !errors.Is(err, tpm2.TPMRCLockout) {
+		t.Errorf("Sign() with the DA key in lockout error = %v, want %v", err, tpm2.TPMRCLockout)
+	}
+	if err := signWithAuth(tpm, noDAKey, auth); err != nil {
+		t.Errorf("Sign() with the noDA key in lockout error = %v", err)
+	}
+
+	if err := ResetDALockout(tpm, nil); err != nil {
+		t.Fatalf("ResetDALockout() error = %v", err)
+	}
+	if got := readDAParameters(t, tpm); got.LockoutCounter != 0 || got.InLockout {
+		t.Errorf("after a reset: lockout counter = %d, in lockout = %t, want 0, false", got.LockoutCounter, got.InLockout)
+	}
+	if err := signWithAuth(tpm, daKey, auth); err != nil {
+		t.Errorf("Sign() with the DA key after a reset error = %v", err)
+	}
+}
+
+// TestResetDALockoutWrongAuth shows that a failed authorization of the lockout hierarchy
+// locks it for the lockout recovery time, even with the right password.
+func TestResetDALockoutWrongAuth(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	if err := ResetDALockout(tpm, []byte("wrong")); !errors.Is(err, tpm2.TPMRCAuthFail) {
+		t.Fatalf("ResetDALockout() with a wrong password error = %v, want %v", err, tpm2.TPMRCAuthFail)
+	}
+	if err := ResetDALockout(tpm, nil); !errors.Is(err, tpm2.TPMRCLockout) {
+		t.Errorf("ResetDALockout() after a failure error = %v, want %v", err, tpm2.TPMRCLockout)
+	}
+}
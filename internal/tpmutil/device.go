@@ -0,0 +1,109 @@
+package tpmutil
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DeviceEnvVar is the environment variable used to select a TPM device
+// when none is explicitly provided.
+const DeviceEnvVar = "TPM_PILLS_DEVICE"
+
+// Device designates a TPM using a URI-style selector of the form <scheme>:<value>.
+//
+// Supported schemes are:
+//   - sim: the in-process TPM simulator
+//   - swtpm:[state dir] a swtpm instance backed by the given state directory (default: [SWTPM_STATE])
+//   - dev:[path] a TPM character device (default: /dev/tpmrm0)
+//   - tcp:[host][:port] a TPM speaking the Microsoft simulator protocol (default: localhost:2321)
+//   - tbs: the Windows TPM Base Services
+type Device string
+
+const (
+	schemeSimulator = "sim"
+	schemeSwtpm     = "swtpm"
+	schemeDev       = "dev"
+	schemeTBS       = "tbs"
+	schemeTCP       = "tcp"
+)
+
+const defaultDevPath = "/dev/tpmrm0"
+
+// TPM_SIMULATOR designates the in-process TPM simulator.
+var TPM_SIMULATOR Device = "sim:"
+
+// ParseDevice parses a device selector (e.g. 'swtpm:./state', 'sim:' or 'dev:/dev/tpm0').
+//
+// For convenience, 'simulator', 'swtpm', 'windows' and absolute device paths are also accepted.
+func ParseDevice(s string) (Device, error) {
+	switch {
+	case s == "simulator":
+		return TPM_SIMULATOR, nil
+	case s == "swtpm":
+		return Device(schemeSwtpm + ":"), nil
+	case s == "windows":
+		return Device(schemeTBS + ":"), nil
+	case strings.HasPrefix(s, "/"):
+		return Device(schemeDev + ":" + s), nil
+	}
+
+	scheme, value, ok := strings.Cut(s, ":")
+	if !ok {
+		return "", fmt.Errorf("invalid device %q: expected <scheme>:<value> (e.g. swtpm:./state, sim: or dev:/dev/tpm0)", s)
+	}
+	switch scheme {
+	case schemeSimulator, schemeTBS:
+		if value != "" {
+			return "", fmt.Errorf("invalid device %q: %s does not accept a value", s, scheme)
+		}
+	case schemeSwtpm:
+		// an empty value selects the default state directory
+	case schemeDev:
+		if value == "" {
+			value = defaultDevPath
+		}
+	case schemeTCP:
+		if _, _, err := tcpAddresses(value); err != nil {
+			return "", fmt.Errorf("invalid device %q: %w", s, err)
+		}
+	default:
+		return "", fmt.Errorf("invalid device %q: unsupported scheme %q", s, scheme)
+	}
+	return Device(scheme + ":" + value), nil
+}
+
+// SelectDevice returns the device designated by selector.
+// If selector is empty, it falls back to [DeviceEnvVar] and then to fallback.
+func SelectDevice(selector string, fallback Device) (Device, error) {
+	if selector != "" {
+		return ParseDevice(selector)
+	}
+	if env := os.Getenv(DeviceEnvVar); env != "" {
+		device, err := ParseDevice(env)
+		if err != nil {
+			return "", fmt.Errorf("invalid %s: %w", DeviceEnvVar, err)
+		}
+		return device, nil
+	}
+	return fallback, nil
+}
+
+// SwtpmStateDir returns the state directory of a swtpm device.
+// The boolean is false if the device isn't a swtpm.
+func (d Device) SwtpmStateDir() (string, bool) {
+	scheme, value := d.split()
+	if scheme != schemeSwtpm {
+		return "", false
+	}
+	if value == "" {
+		value = SWTPM_STATE
+	}
+	return value, true
+}
+
+// split returns the scheme and the value of a device previously checked by [ParseDevice].
+func (d Device) split() (scheme, value string) {
+	scheme, value, _ = strings.Cut(string(d), ":")
+	return scheme, value
+}
@@ -0,0 +1,95 @@
+package tpmutil
+
+import (
+	"testing"
+)
+
+func TestParseDevice(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Device
+		wantErr bool
+	}{
+		{in: "sim:", want: "sim:"},
+		{in: "simulator", want: "sim:"},
+		{in: "swtpm:", want: "swtpm:"},
+		{in: "swtpm", want: "swtpm:"},
+		{in: "swtpm:./state", want: "swtpm:./state"},
+		{in: "dev:", want: "dev:/dev/tpmrm0"},
+		{in: "dev:/dev/tpm0", want: "dev:/dev/tpm0"},
+		{in: "/dev/tpm0", want: "dev:/dev/tpm0"},
+		{in: "tbs:", want: "tbs:"},
+		{in: "windows", want: "tbs:"},
+		{in: "tcp:", want: "tcp:"},
+		{in: "tcp:localhost:2321", want: "tcp:localhost:2321"},
+		{in: "tcp:localhost:port", wantErr: true},
+		{in: "sim:foo", wantErr: true},
+		{in: "usb:", wantErr: true},
+		{in: "tpm", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseDevice(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDevice(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseDevice(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectDevice(t *testing.T) {
+	t.Run("selector wins", func(t *testing.T) {
+		t.Setenv(DeviceEnvVar, "sim:")
+		got, err := SelectDevice("swtpm:./state", TPM_SIMULATOR)
+		if err != nil {
+			t.Fatalf("SelectDevice() error = %v", err)
+		}
+		if got != "swtpm:./state" {
+			t.Errorf("SelectDevice() = %q, want %q", got, "swtpm:./state")
+		}
+	})
+
+	t.Run("environment", func(t *testing.T) {
+		t.Setenv(DeviceEnvVar, "dev:/dev/tpm0")
+		got, err := SelectDevice("", TPM_SIMULATOR)
+		if err != nil {
+			t.Fatalf("SelectDevice() error = %v", err)
+		}
+		if got != "dev:/dev/tpm0" {
+			t.Errorf("SelectDevice() = %q, want %q", got, "dev:/dev/tpm0")
+		}
+	})
+
+	t.Run("invalid environment", func(t *testing.T) {
+		t.Setenv(DeviceEnvVar, "usb:")
+		if _, err := SelectDevice("", TPM_SIMULATOR); err == nil {
+			t.Fatal("SelectDevice() error = nil, want error")
+		}
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		t.Setenv(DeviceEnvVar, "")
+		got, err := SelectDevice("", TPM_SIMULATOR)
+		if err != nil {
+			t.Fatalf("SelectDevice() error = %v", err)
+		}
+		if got != TPM_SIMULATOR {
+			t.Errorf("SelectDevice() = %q, want %q", got, TPM_SIMULATOR)
+		}
+	})
+}
+
+func TestSwtpmStateDir(t *testing.T) {
+	if dir, ok := Device("swtpm:").SwtpmStateDir(); !ok || dir != SWTPM_STATE {
+		t.Errorf("SwtpmStateDir() = (%q, %v), want (%q, true)", dir, ok, SWTPM_STATE)
+	}
+	if dir, ok := Device("swtpm:./state").SwtpmStateDir(); !ok || dir != "./state" {
+		t.Errorf("SwtpmStateDir() = (%q, %v), want (%q, true)", dir, ok, "./state")
+	}
+	if _, ok := TPM_SIMULATOR.SwtpmStateDir(); ok {
+		t.Error("SwtpmStateDir() should return false for a non swtpm device")
+	}
+}
@@ -0,0 +1,218 @@
+package tpmutil
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/go-tpm-kit/tpmcrypto"
+)
+
+// NV indices of the EK certificates (TCG EK Credential Profile).
+const (
+	RSAEKCertIndex          tpm2.TPMHandle = 0x01c00002
+	ECCEKCertIndex          tpm2.TPMHandle = 0x01c0000a
+	RSAEKHighRangeCertIndex tpm2.TPMHandle = 0x01c00012
+	ECCEKHighRangeCertIndex tpm2.TPMHandle = 0x01c00014
+)
+
+// ErrNoEKCertificate is returned when the TPM holds no certificate for an EK.
+var ErrNoEKCertificate = errors.New("no EK certificate")
+
+var (
+	// RSAEKTemplate is the RSA-2048 EK template of the low range (template L-1).
+	RSAEKTemplate = tpm2.RSAEKTemplate
+	// ECCEKTemplate is the ECC P-256 EK template of the low range (template L-2).
+	ECCEKTemplate = tpm2.ECCEKTemplate
+	// RSAEKHighRangeTemplate is the RSA-2048 EK template of the high range (template H-1).
+	//
+	// Unlike the low range, the unique field is empty and userWithAuth is set.
+	RSAEKHighRangeTemplate = tpm2.TPMTPublic{
+		Type:             tpm2.TPMAlgRSA,
+		NameAlg:          tpm2.TPMAlgSHA256,
+		ObjectAttributes: ekHighRangeAttributes,
+		AuthPolicy:       tpm2.TPM2BDigest{Buffer: ekPolicyBSHA256},
+		Parameters: tpm2.NewTPMUPublicParms(
+			tpm2.TPMAlgRSA,
+			&tpm2.TPMSRSAParms{
+				Symmetric: ekSymmetric,
+				KeyBits:   2048,
+			},
+		),
+		Unique: tpm2.NewTPMUPublicID(
+			tpm2.TPMAlgRSA,
+			&tpm2.TPM2BPublicKeyRSA{},
+		),
+	}
+	// ECCEKHighRangeTemplate is the ECC P-256 EK template of the high range (template H-2).
+	//
+	// Unlike the low range, the unique field is empty and userWithAuth is set.
+	ECCEKHighRangeTemplate = tpm2.TPMTPublic{
+		Type:             tpm2.TPMAlgECC,
+		NameAlg:          tpm2.TPMAlgSHA256,
+		ObjectAttributes: ekHighRangeAttributes,
+		AuthPolicy:       tpm2.TPM2BDigest{Buffer: ekPolicyBSHA256},
+		Parameters: tpm2.NewTPMUPublicParms(
+			tpm2.TPMAlgECC,
+			&tpm2.TPMSECCParms{
+				Symmetric: ekSymmetric,
+				CurveID:   tpm2.TPMECCNistP256,
+			},
+		),
+		Unique: tpm2.NewTPMUPublicID(
+			tpm2.TPMAlgECC,
+			&tpm2.TPMSECCPoint{},
+		),
+	}
+
+	ekHighRangeAttributes = tpm2.TPMAObject{
+		FixedTPM:            true,
+		FixedParent:         true,
+		SensitiveDataOrigin: true,
+		UserWithAuth:        true,
+		AdminWithPolicy:     true,
+		Restricted:          true,
+		Decrypt:             true,
+	}
+	ekSymmetric = tpm2.TPMTSymDefObject{
+		Algorithm: tpm2.TPMAlgAES,
+		KeyBits:   tpm2.NewTPMUSymKeyBits(tpm2.TPMAlgAES, tpm2.TPMKeyBits(128)),
+		Mode:      tpm2.NewTPMUSymMode(tpm2.TPMAlgAES, tpm2.TPMAlgCFB),
+	}
+	// ekPolicyBSHA256 is PolicyB of the TCG EK Credential Profile: TPM2_PolicyOR of PolicyA,
+	// TPM2_PolicySecret(RH_ENDORSEMENT), and PolicyC, TPM2_PolicyAuthorizeNV(0x01C07F01).
+	ekPolicyBSHA256 = []byte{
+		0xCA, 0x3D, 0x0A, 0x99, 0xA2, 0xB9, 0x39, 0x06,
+		0xF7, 0xA3, 0x34, 0x24, 0x14, 0xEF, 0xCF, 0xB3,
+		0xA3, 0x85, 0xD4, 0x4C, 0xD1, 0xFD, 0x45, 0x90,
+		0x89, 0xD1, 0x9B, 0x50, 0x71, 0xC0, 0xB7, 0xA0,
+	}
+)
+
+// EKProfile is an EK template of the TCG EK Credential Profile, along with the NV index
+// where the manufacturer stores the certificate of the EK.
+type EKProfile struct {
+	Template  tpm2.TPMTPublic
+	CertIndex tpm2.TPMHandle
+}
+
+// EKProfilesByType holds the EK profiles by type, see [LookupEKProfile].
+var EKProfilesByType = map[string]EKProfile{
+	"rsa":      {Template: RSAEKTemplate, CertIndex: RSAEKCertIndex},
+	"ecc":      {Template: ECCEKTemplate, CertIndex: ECCEKCertIndex},
+	"rsa-high": {Template: RSAEKHighRangeTemplate, CertIndex: RSAEKHighRangeCertIndex},
+	"ecc-high": {Template: ECCEKHighRangeTemplate, CertIndex: ECCEKHighRangeCertIndex},
+}
+
+// EKTypes returns the sorted types accepted by [LookupEKProfile].
+func EKTypes() []string {
+	types := make([]string, 0, len(EKProfilesByType))
+	for t := range EKProfilesByType {
+		types = append(types, t)
+	}
+	slices.Sort(types)
+	return types
+}
+
+// LookupEKProfile returns the EK profile of typ: 'rsa', 'ecc', or their high-range
+// variants 'rsa-high' and 'ecc-high'.
+func LookupEKProfile(typ string) (EKProfile, error) {
+	profile, ok := EKProfilesByType[strings.ToLower(typ)]
+	if !ok {
+		return EKProfile{}, fmt.Errorf("invalid EK type %q: expected %s", typ, strings.Join(EKTypes(), ", "))
+	}
+	return profile, nil
+}
+
+// CreateEK creates the EK from template under the endorsement hierarchy, whose password is auth,
+// and returns the response and a cleanup function.
+//
+// The EK is derived from the endorsement seed: the same template always gives the same key on a TPM.
+func CreateEK(tpm transport.TPM, template tpm2.TPMTPublic, auth []byte) (*tpm2.CreatePrimaryResponse, func(), error) {
+	rsp, err := tpm2.CreatePrimary{
+		PrimaryHandle: tpm2.AuthHandle{
+			Handle: tpm2.TPMRHEndorsement,
+			Auth:   tpm2.PasswordAuth(auth),
+		},
+		InPublic: tpm2.New2B(template),
+	}.Execute(tpm)
+	if err != nil {
+		return nil, func() {}, fmt.Errorf("failed to create EK: %w", err)
+	}
+	return rsp, func() {
+		tpm2.FlushContext{FlushHandle: rsp.ObjectHandle}.Execute(tpm)
+	}, nil
+}
+
+// EKSession returns a session authorizing the use of an EK created from template.
+//
+// The policy of the low-range templates is TPM2_PolicySecret with the endorsement hierarchy:
+// using the EK requires the password of the hierarchy, auth, in a policy session.
+// The high-range templates set userWithAuth: the EK is used with its auth value, empty, in a password session.
+// A policy session authorizes a single command, the session must be closed with the returned function.
+func EKSession(tpm transport.TPM, template tpm2.TPMTPublic, auth []byte) (tpm2.Session, func() error, error) {
+	if template.ObjectAttributes.UserWithAuth {
+		return tpm2.PasswordAuth(nil), func() error { return nil }, nil
+	}
+	session, closer, err := tpm2.PolicySession(tpm, template.NameAlg, 16)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to start policy session: %w", err)
+	}
+	if _, err := (tpm2.PolicySecret{
+		AuthHandle: tpm2.AuthHandle{
+			Handle: tpm2.TPMRHEndorsement,
+			Auth:   tpm2.PasswordAuth(auth),
+		},
+		PolicySession: session.Handle(),
+		NonceTPM:      session.NonceTPM(),
+	}).Execute(tpm); err != nil {
+		closer()
+		return nil, nil, fmt.Errorf("failed to satisfy EK policy: %w", err)
+	}
+	return session, closer, nil
+}
+
+// ReadEKCertificate reads the EK certificate stored in the NV index, see [EKProfile].
+// It returns [ErrNoEKCertificate] when the index isn't defined.
+func ReadEKCertificate(tpm transport.TPM, index tpm2.TPMHandle) (*x509.Certificate, error) {
+	if _, _, err := NVReadPublic(tpm, index); err != nil {
+		if errors.Is(err, tpm2.TPMRCHandle) {
+			return nil, fmt.Errorf("%w at NV index 0x%x", ErrNoEKCertificate, uint32(index))
+		}
+		return nil, err
+	}
+	// the index is readable with its empty auth value (TPMA_NV_AUTHREAD)
+	data, err := NVRead(tpm, NVReadConfig{Index: index})
+	if err != nil {
+		return nil, err
+	}
+	// the index may be larger than the certificate: the padding is dropped
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to decode EK certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(raw.FullBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse EK certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// CheckEKCertificate checks that cert certifies the public key of ek.
+func CheckEKCertificate(cert *x509.Certificate, ek *tpm2.TPMTPublic) error {
+	pub, err := tpmcrypto.PublicKey(ek)
+	if err != nil {
+		return fmt.Errorf("failed to get EK public key: %w", err)
+	}
+	certPub, ok := cert.PublicKey.(interface{ Equal(x crypto.PublicKey) bool })
+	if !ok || !certPub.Equal(pub) {
+		return fmt.Errorf("the EK certificate doesn't match the EK")
+	}
+	return nil
+}
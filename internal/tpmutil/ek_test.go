@@ -0,0 +1,77 @@
+package tpmutil
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/loicsikidi/go-tpm-kit/tpmtest"
+)
+
+func TestLookupEKProfile(t *testing.T) {
+	for _, typ := range EKTypes() {
+		profile, err := LookupEKProfile(typ)
+		if err != nil {
+			t.Fatalf("LookupEKProfile(%q) error = %v", typ, err)
+		}
+		if !profile.Template.ObjectAttributes.Restricted || !profile.Template.ObjectAttributes.Decrypt {
+			t.Errorf("LookupEKProfile(%q) template isn't a restricted decryption key", typ)
+		}
+	}
+	if _, err := LookupEKProfile("dsa"); err == nil {
+		t.Errorf("LookupEKProfile() error = nil, want an error")
+	}
+}
+
+// TestEKSession proves that an EK of the low range can't be used with a password session,
+// only with [EKSession], whereas an EK of the high range accepts its empty auth value.
+func TestEKSession(t *testing.T) {
+	tests := []struct {
+		name         string
+		template     tpm2.TPMTPublic
+		passwordAuth bool
+	}{
+		{name: "rsa", template: RSAEKTemplate},
+		{name: "ecc", template: ECCEKTemplate},
+		{name: "rsa-high", template: RSAEKHighRangeTemplate, passwordAuth: true},
+		{name: "ecc-high", template: ECCEKHighRangeTemplate, passwordAuth: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpm := tpmtest.OpenSimulator(t)
+			rsp, closer, err := CreateEK(tpm, tt.template, nil)
+			if err != nil {
+				t.Fatalf("CreateEK() error = %v", err)
+			}
+			defer closer()
+
+			// the EK is a storage key: creating a child key requires its authorization
+			create := func(session tpm2.Session) error {
+				_, err := tpm2.Create{
+					ParentHandle: tpm2.AuthHandle{Handle: rsp.ObjectHandle, Name: rsp.Name, Auth: session},
+					InPublic:     tpm2.New2B(ECCSignerTemplate),
+				}.Execute(tpm)
+				return err
+			}
+			if err := create(tpm2.PasswordAuth(nil)); (err == nil) != tt.passwordAuth {
+				t.Errorf("password session error = %v, want accepted: %v", err, tt.passwordAuth)
+			}
+
+			session, sessionCloser, err := EKSession(tpm, tt.template, nil)
+			if err != nil {
+				t.Fatalf("EKSession() error = %v", err)
+			}
+			defer sessionCloser()
+			if err := create(session); err != nil {
+				t.Errorf("failed to use the EK with its session: %v", err)
+			}
+		})
+	}
+}
+
+func TestReadEKCertificateMissing(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	if _, err := ReadEKCertificate(tpm, RSAEKCertIndex); !errors.Is(err, ErrNoEKCertificate) {
+		t.Errorf("ReadEKCertificate() error = %v, want %v", err, ErrNoEKCertificate)
+	}
+}
@@ -0,0 +1,122 @@
+package tpmutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+// Fault describes how a [FaultInjector] alters the exchanges with the TPM.
+type Fault struct {
+	// CommandCode restricts the fault to a command (zero matches every command).
+	CommandCode tpm2.TPMCC
+	// Nth restricts the fault to the nth matching command, starting at 1 (zero matches every occurrence).
+	Nth int
+
+	// Err makes the transport fail. The command isn't sent to the TPM.
+	Err error
+	// ResponseCode makes the TPM answer with the given error code (e.g. [tpm2.TPMRCRetry]).
+	// The command isn't sent to the TPM.
+	ResponseCode tpm2.TPMRC
+	// Truncate removes the given number of bytes from the end of the response.
+	Truncate int
+	// Corrupt alters the response in place.
+	Corrupt func(rsp []byte)
+}
+
+// FaultInjector is a [transport.TPMCloser] which alters the exchanges with the underlying TPM
+// according to a list of [Fault], which is handy to test error paths.
+//
+// When several faults match a command, the first one wins.
+type FaultInjector struct {
+	tpm transport.TPMCloser
+
+	mu       sync.Mutex
+	faults   []Fault
+	seen     []int
+	injected int
+}
+
+// NewFaultInjector returns a [FaultInjector] applying faults to the exchanges with tpm.
+func NewFaultInjector(tpm transport.TPMCloser, faults ...Fault) *FaultInjector {
+	return &FaultInjector{
+		tpm:    tpm,
+		faults: faults,
+		seen:   make([]int, len(faults)),
+	}
+}
+
+// Send implements [transport.TPM].
+func (f *FaultInjector) Send(cmd []byte) ([]byte, error) {
+	fault, ok := f.match(cmd)
+	if !ok {
+		return f.tpm.Send(cmd)
+	}
+	if fault.Err != nil {
+		return nil, fault.Err
+	}
+	if fault.ResponseCode != tpm2.TPMRCSuccess {
+		return errorResponse(fault.ResponseCode), nil
+	}
+
+	rsp, err := f.tpm.Send(cmd)
+	if err != nil {
+		return nil, err
+	}
+	rsp = bytes.Clone(rsp)
+	if fault.Truncate > 0 {
+		rsp = rsp[:max(len(rsp)-fault.Truncate, 0)]
+	}
+	if fault.Corrupt != nil {
+		fault.Corrupt(rsp)
+	}
+	return rsp, nil
+}
+
+// match returns the fault to apply to cmd, if any.
+func (f *FaultInjector) match(cmd []byte) (Fault, bool) {
+	cc, _ := commandCode(cmd)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// every fault counts its matching commands, so that Nth doesn't depend on the other faults
+	matched := -1
+	for i, fault := range f.faults {
+		if fault.CommandCode != 0 && fault.CommandCode != cc {
+			continue
+		}
+		f.seen[i]++
+		if matched < 0 && (fault.Nth == 0 || fault.Nth == f.seen[i]) {
+			matched = i
+		}
+	}
+	if matched < 0 {
+		return Fault{}, false
+	}
+	f.injected++
+	return f.faults[matched], true
+}
+
+// Injected returns the number of exchanges altered so far.
+func (f *FaultInjector) Injected() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.injected
+}
+
+// Close closes the underlying TPM.
+func (f *FaultInjector) Close() error {
+	return f.tpm.Close()
+}
+
+// errorResponse returns a response carrying only rc.
+func errorResponse(rc tpm2.TPMRC) []byte {
+	rsp := make([]byte, headerSize)
+	binary.BigEndian.PutUint16(rsp, uint16(tpm2.TPMSTNoSessions))
+	binary.BigEndian.PutUint32(rsp[2:], headerSize)
+	binary.BigEndian.PutUint32(rsp[6:], uint32(rc))
+	return rsp
+}
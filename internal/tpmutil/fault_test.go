@@ -0,0 +1,230 @@
+package tpmutil
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/go-tpm-kit/tpmtest"
+)
+
+// transientHandles lists the transient objects currently loaded in the TPM.
+func transientHandles(t *testing.T, tpm transport.TPM) []tpm2.TPMHandle {
+	t.Helper()
+	rsp, err := tpm2.GetCapability{
+		Capability:    tpm2.TPMCapHandles,
+		Property:      uint32(tpm2.TPMHTTransient) << 24,
+		PropertyCount: 64,
+	}.Execute(tpm)
+	if err != nil {
+		t.Fatalf("GetCapability() error = %v", err)
+	}
+	handles, err := rsp.CapabilityData.Data.Handles()
+	if err != nil {
+		t.Fatalf("failed to get handles: %v", err)
+	}
+	return handles.Handle
+}
+
+func createTestKey(t *testing.T, tpm transport.TPM) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := CreateKey(tpm, CreateKeyConfig{
+		OutDir:           dir,
+		ParentTemplate:   ECCSRKTemplate,
+		OrdinaryTemplate: ECCSignerTemplate,
+	}); err != nil {
+		t.Fatalf("CreateKey() error = %v", err)
+	}
+	return filepath.Join(dir, "key.tpm")
+}
+
+func TestFaultInjectorMatching(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	errTransport := errors.New("connection reset")
+	faulty := NewFaultInjector(tpm,
+		Fault{CommandCode: tpm2.TPMCCGetRandom, Nth: 2, ResponseCode: tpm2.TPMRCRetry},
+		Fault{CommandCode: tpm2.TPMCCReadClock, Err: errTransport},
+	)
+
+	getRandom := tpm2.GetRandom{BytesRequested: 8}
+	if _, err := getRandom.Execute(faulty); err != nil {
+		t.Fatalf("1st GetRandom() error = %v", err)
+	}
+	if _, err := getRandom.Execute(faulty); !errors.Is(err, tpm2.TPMRCRetry) {
+		t.Errorf("2nd GetRandom() error = %v, want %v", err, tpm2.TPMRCRetry)
+	}
+	if _, err := getRandom.Execute(faulty); err != nil {
+		t.Errorf("3rd GetRandom() error = %v", err)
+	}
+	for range 2 {
+		if _, err := (tpm2.ReadClock{}).Execute(faulty); !errors.Is(err, errTransport) {
+			t.Errorf("ReadClock() error = %v, want %v", err, errTransport)
+		}
+	}
+	if got := faulty.Injected(); got != 3 {
+		t.Errorf("Injected() = %d, want 3", got)
+	}
+}
+
+func TestCreateKeyFaults(t *testing.T) {
+	tests := []struct {
+		name    string
+		fault   Fault
+		wantMsg string
+		wantRC  tpm2.TPMRC
+	}{
+		{
+			name:    "retry on primary",
+			fault:   Fault{CommandCode: tpm2.TPMCCCreatePrimary, ResponseCode: tpm2.TPMRCRetry},
+			wantMsg: "failed to create primary key",
+			wantRC:  tpm2.TPMRCRetry,
+		},
+		{
+			name:    "object memory on create",
+			fault:   Fault{CommandCode: tpm2.TPMCCCreate, ResponseCode: tpm2.TPMRCObjectMemory},
+			wantMsg: "failed to create ordinary key",
+			wantRC:  tpm2.TPMRCObjectMemory,
+		},
+		{
+			name:    "lockout on the 2nd command",
+			fault:   Fault{Nth: 2, ResponseCode: tpm2.TPMRCLockout},
+			wantMsg: "failed to create ordinary key",
+			wantRC:  tpm2.TPMRCLockout,
+		},
+		{
+			name:    "truncated create response",
+			fault:   Fault{CommandCode: tpm2.TPMCCCreate, Truncate: 16},
+			wantMsg: "failed to create ordinary key",
+		},
+		{
+			name: "corrupted create response",
+			fault: Fault{CommandCode: tpm2.TPMCCCreate, Corrupt: func(rsp []byte) {
+				rsp[10] = 0xff // parameter size
+			}},
+			wantMsg: "failed to create ordinary key",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpm := tpmtest.OpenSimulator(t)
+			faulty := NewFaultInjector(tpm, tt.fault)
+
+			err := CreateKey(faulty, CreateKeyConfig{
+				OutDir:           t.TempDir(),
+				ParentTemplate:   ECCSRKTemplate,
+				OrdinaryTemplate: ECCSignerTemplate,
+			})
+			if err == nil {
+				t.Fatal("CreateKey() error = nil, want error")
+			}
+			if !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("CreateKey() error = %v, want it to contain %q", err, tt.wantMsg)
+			}
+			if tt.wantRC != 0 && !errors.Is(err, tt.wantRC) {
+				t.Errorf("CreateKey() error = %v, want it to wrap %v", err, tt.wantRC)
+			}
+			if handles := transientHandles(t, tpm); len(handles) != 0 {
+				t.Errorf("transient handles left in the TPM: %v", handles)
+			}
+		})
+	}
+}
+
+func TestLoadKeyFaults(t *testing.T) {
+	tests := []struct {
+		name   string
+		fault  Fault
+		wantRC tpm2.TPMRC
+	}{
+		{"object memory on load", Fault{CommandCode: tpm2.TPMCCLoad, ResponseCode: tpm2.TPMRCObjectMemory}, tpm2.TPMRCObjectMemory},
+		{"retry on primary", Fault{CommandCode: tpm2.TPMCCCreatePrimary, ResponseCode: tpm2.TPMRCRetry}, tpm2.TPMRCRetry},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpm := tpmtest.OpenSimulator(t)
+			keyPath := createTestKey(t, tpm)
+			faulty := NewFaultInjector(tpm, tt.fault)
+
+			_, err := LoadKey(faulty, LoadKeyConfig{
+				ParentTemplate: ECCSRKTemplate,
+				KeyBlobPath:    keyPath,
+			})
+			if !errors.Is(err, tt.wantRC) {
+				t.Errorf("LoadKey() error = %v, want it to wrap %v", err, tt.wantRC)
+			}
+			if handles := transientHandles(t, tpm); len(handles) != 0 {
+				t.Errorf("transient handles left in the TPM: %v", handles)
+			}
+		})
+	}
+}
+
+func TestUnsealFaults(t *testing.T) {
+	tests := []struct {
+		name   string
+		fault  Fault
+		wantRC tpm2.TPMRC
+	}{
+		{"lockout", Fault{CommandCode: tpm2.TPMCCUnseal, ResponseCode: tpm2.TPMRCLockout}, tpm2.TPMRCLockout},
+		{"retry", Fault{CommandCode: tpm2.TPMCCUnseal, ResponseCode: tpm2.TPMRCRetry}, tpm2.TPMRCRetry},
+		{"truncated response", Fault{CommandCode: tpm2.TPMCCUnseal, Truncate: 4}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpm := tpmtest.OpenSimulator(t)
+			sealedPath := filepath.Join(t.TempDir(), "sealed.tpm")
+			if err := Seal(tpm, SealConfig{
+				ParentTemplate: ECCSRKTemplate,
+				Message:        []byte("secret"),
+				OutputFilePath: sealedPath,
+			}); err != nil {
+				t.Fatalf("Seal() error = %v", err)
+			}
+			keyHandle, err := LoadKey(tpm, LoadKeyConfig{
+				ParentTemplate: ECCSRKTemplate,
+				KeyBlobPath:    sealedPath,
+			})
+			if err != nil {
+				t.Fatalf("LoadKey() error = %v", err)
+			}
+			defer keyHandle.Close()
+
+			_, err = Unseal(NewFaultInjector(tpm, tt.fault), UnsealConfig{KeyHandle: keyHandle})
+			if err == nil || !strings.HasPrefix(err.Error(), "failed to unseal data") {
+				t.Fatalf("Unseal() error = %v, want a wrapped error", err)
+			}
+			if tt.wantRC != 0 && !errors.Is(err, tt.wantRC) {
+				t.Errorf("Unseal() error = %v, want it to wrap %v", err, tt.wantRC)
+			}
+		})
+	}
+}
+
+func TestPersistFaults(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	keyHandle, err := LoadKey(tpm, LoadKeyConfig{
+		ParentTemplate: ECCSRKTemplate,
+		KeyBlobPath:    createTestKey(t, tpm),
+	})
+	if err != nil {
+		t.Fatalf("LoadKey() error = %v", err)
+	}
+	defer keyHandle.Close()
+
+	persistentHandle := tpm2.TPMHandle(0x81000020)
+	faulty := NewFaultInjector(tpm, Fault{CommandCode: tpm2.TPMCCEvictControl, ResponseCode: tpm2.TPMRCRetry})
+	_, err = Persist(faulty, PersistConfig{
+		TransientHandle:  keyHandle,
+		PersistentHandle: NewHandle(persistentHandle),
+	})
+	if !errors.Is(err, tpm2.TPMRCRetry) {
+		t.Fatalf("Persist() error = %v, want it to wrap %v", err, tpm2.TPMRCRetry)
+	}
+	if _, err := (tpm2.ReadPublic{ObjectHandle: persistentHandle}).Execute(tpm); err == nil {
+		t.Error("key persisted despite the failure")
+	}
+}
@@ -0,0 +1,161 @@
+package tpmutil
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/tpm-pills/internal/options"
+)
+
+// KeyAlg designates the algorithm and the size of an asymmetric key along with its name algorithm.
+type KeyAlg struct {
+	// Type is either [tpm2.TPMAlgRSA] or [tpm2.TPMAlgECC].
+	Type tpm2.TPMAlgID
+	// KeyBits is the size of an RSA key.
+	KeyBits tpm2.TPMKeyBits
+	// Curve is the curve of an ECC key.
+	Curve tpm2.TPMECCCurve
+	// NameAlg is the name algorithm of the key.
+	NameAlg tpm2.TPMAlgID
+}
+
+// SigningHash returns the hash of the signing scheme of a key using a: the hash matching the curve
+// of an ECC key (see [CurveHash]), SHA-256 for an RSA key. Unlike the name algorithm, a verifier
+// can infer it from the public key alone.
+func (a KeyAlg) SigningHash() tpm2.TPMAlgID {
+	if a.Type == tpm2.TPMAlgECC {
+		return CurveHash(a.Curve)
+	}
+	return tpm2.TPMAlgSHA256
+}
+
+// CurveHash returns the hash matching the strength of an ECC curve, defaulting to SHA-256.
+func CurveHash(curve tpm2.TPMECCCurve) tpm2.TPMAlgID {
+	switch curve {
+	case tpm2.TPMECCNistP384:
+		return tpm2.TPMAlgSHA384
+	case tpm2.TPMECCNistP521:
+		return tpm2.TPMAlgSHA512
+	default:
+		return tpm2.TPMAlgSHA256
+	}
+}
+
+// keyAlgs holds the supported key algorithms, named after tpm2-tools.
+// ECC keys default to the name algorithm matching the strength of their curve.
+var keyAlgs = map[string]KeyAlg{
+	"rsa2048": {Type: tpm2.TPMAlgRSA, KeyBits: 2048, NameAlg: tpm2.TPMAlgSHA256},
+	"rsa3072": {Type: tpm2.TPMAlgRSA, KeyBits: 3072, NameAlg: tpm2.TPMAlgSHA256},
+	"rsa4096": {Type: tpm2.TPMAlgRSA, KeyBits: 4096, NameAlg: tpm2.TPMAlgSHA256},
+	"ecc256":  {Type: tpm2.TPMAlgECC, Curve: tpm2.TPMECCNistP256, NameAlg: tpm2.TPMAlgSHA256},
+	"ecc384":  {Type: tpm2.TPMAlgECC, Curve: tpm2.TPMECCNistP384, NameAlg: tpm2.TPMAlgSHA384},
+	"ecc521":  {Type: tpm2.TPMAlgECC, Curve: tpm2.TPMECCNistP521, NameAlg: tpm2.TPMAlgSHA512},
+}
+
+var nameAlgs = []tpm2.TPMAlgID{tpm2.TPMAlgSHA256, tpm2.TPMAlgSHA384, tpm2.TPMAlgSHA512}
+
+// KeyAlgNames returns the sorted names accepted by [ParseKeyAlg], without name algorithm.
+func KeyAlgNames() []string {
+	names := make([]string, 0, len(keyAlgs))
+	for name := range keyAlgs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// ParseKeyAlg parses a key algorithm such as 'rsa3072' or 'ecc384', optionally followed
+// by a name algorithm (e.g. 'ecc256:sha384'). See [KeyAlgNames].
+func ParseKeyAlg(s string) (KeyAlg, error) {
+	name, hash, hasHash := strings.Cut(strings.ToLower(s), ":")
+	alg, ok := keyAlgs[name]
+	if !ok {
+		return KeyAlg{}, fmt.Errorf("invalid key algorithm %q: expected %s", s, strings.Join(KeyAlgNames(), ", "))
+	}
+	if hasHash {
+		nameAlg, err := ParseAlg(hash)
+		if err != nil || !slices.Contains(nameAlgs, nameAlg) {
+			return KeyAlg{}, fmt.Errorf("invalid key algorithm %q: unsupported name algorithm %q (expected sha256, sha384 or sha512)", s, hash)
+		}
+		alg.NameAlg = nameAlg
+	}
+	return alg, nil
+}
+
+// String returns the key algorithm as accepted by [ParseKeyAlg] (e.g. 'ecc384:sha384').
+func (a KeyAlg) String() string {
+	switch a.Type {
+	case tpm2.TPMAlgRSA:
+		return fmt.Sprintf("rsa%d:%s", a.KeyBits, AlgName(a.NameAlg))
+	case tpm2.TPMAlgECC:
+		curve := strings.TrimPrefix(CurveName(a.Curve), "nist_p")
+		return fmt.Sprintf("ecc%s:%s", curve, AlgName(a.NameAlg))
+	default:
+		return AlgName(a.Type)
+	}
+}
+
+// AsymTemplate returns the template of a key of the given type using alg.
+//
+// Decrypt keys must be RSA keys. ECC signers use ECDSA with [KeyAlg.SigningHash], so do restricted
+// RSA signers with RSASSA while other RSA signers pick their scheme when signing.
+// With the default algorithm of a key type (rsa2048 for decrypt, ecc256 for signers),
+// the result is the template of [AsymTemplatesByKeyType].
+func AsymTemplate(kty options.KeyType, alg KeyAlg) (tpm2.TPMTPublic, error) {
+	template, ok := AsymTemplatesByKeyType[kty]
+	if !ok {
+		return tpm2.TPMTPublic{}, fmt.Errorf("invalid input: unsupported key type %q", kty)
+	}
+	template.NameAlg = alg.NameAlg
+
+	switch {
+	case kty == options.Decrypt && alg.Type == tpm2.TPMAlgRSA:
+		template.Parameters = tpm2.NewTPMUPublicParms(tpm2.TPMAlgRSA, &tpm2.TPMSRSAParms{
+			KeyBits: alg.KeyBits,
+		})
+		template.Unique = tpm2.NewTPMUPublicID(tpm2.TPMAlgRSA, &tpm2.TPM2BPublicKeyRSA{
+			Buffer: make([]byte, alg.KeyBits/8),
+		})
+	case kty == options.Decrypt:
+		return tpm2.TPMTPublic{}, fmt.Errorf("invalid input: decrypt keys must be RSA keys, got %s", alg)
+	case alg.Type == tpm2.TPMAlgRSA:
+		params := &tpm2.TPMSRSAParms{KeyBits: alg.KeyBits}
+		if kty == options.RestrictedSigner {
+			params.Scheme = tpm2.TPMTRSAScheme{
+				Scheme:  tpm2.TPMAlgRSASSA,
+				Details: tpm2.NewTPMUAsymScheme(tpm2.TPMAlgRSASSA, &tpm2.TPMSSigSchemeRSASSA{HashAlg: alg.SigningHash()}),
+			}
+		}
+		template.Type = tpm2.TPMAlgRSA
+		template.Parameters = tpm2.NewTPMUPublicParms(tpm2.TPMAlgRSA, params)
+		template.Unique = tpm2.NewTPMUPublicID(tpm2.TPMAlgRSA, &tpm2.TPM2BPublicKeyRSA{})
+	case alg.Type == tpm2.TPMAlgECC:
+		template.Parameters = tpm2.NewTPMUPublicParms(tpm2.TPMAlgECC, &tpm2.TPMSECCParms{
+			Scheme: tpm2.TPMTECCScheme{
+				Scheme:  tpm2.TPMAlgECDSA,
+				Details: tpm2.NewTPMUAsymScheme(tpm2.TPMAlgECDSA, &tpm2.TPMSSigSchemeECDSA{HashAlg: alg.SigningHash()}),
+			},
+			CurveID: alg.Curve,
+		})
+	default:
+		return tpm2.TPMTPublic{}, fmt.Errorf("invalid input: unsupported key algorithm %s", alg)
+	}
+	return template, nil
+}
+
+// CheckTemplateSupported asks the TPM whether it supports the algorithm parameters of template.
+func CheckTemplateSupported(tpm transport.TPM, template tpm2.TPMTPublic) error {
+	_, err := tpm2.TestParms{
+		Parameters: tpm2.TPMTPublicParms{
+			Type:       template.Type,
+			Parameters: template.Parameters,
+		},
+	}.Execute(tpm)
+	if err != nil {
+		return fmt.Errorf("the TPM doesn't support these key parameters: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package tpmutil
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/loicsikidi/go-tpm-kit/tpmtest"
+	"github.com/loicsikidi/tpm-pills/internal/keyutil"
+	"github.com/loicsikidi/tpm-pills/internal/options"
+	"github.com/loicsikidi/tpm-pills/internal/pemutil"
+)
+
+func TestParseKeyAlg(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "rsa2048", want: "rsa2048:sha256"},
+		{in: "RSA4096:SHA512", want: "rsa4096:sha512"},
+		{in: "ecc384", want: "ecc384:sha384"},
+		{in: "ecc521", want: "ecc521:sha512"},
+		{in: "ecc256:sha384", want: "ecc256:sha384"},
+		{in: "ecc192", wantErr: true},
+		{in: "rsa2048:sha1", wantErr: true},
+		{in: "rsa2048:md5", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseKeyAlg(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseKeyAlg(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && got.String() != tt.want {
+				t.Errorf("ParseKeyAlg(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyAlgSigningHash(t *testing.T) {
+	// the signing hash follows the key, not its name algorithm
+	tests := map[string]tpm2.TPMAlgID{
+		"rsa2048:sha384": tpm2.TPMAlgSHA256,
+		"rsa4096":        tpm2.TPMAlgSHA256,
+		"ecc256:sha384":  tpm2.TPMAlgSHA256,
+		"ecc384:sha256":  tpm2.TPMAlgSHA384,
+		"ecc521":         tpm2.TPMAlgSHA512,
+	}
+	for name, want := range tests {
+		alg, err := ParseKeyAlg(name)
+		if err != nil {
+			t.Fatalf("ParseKeyAlg(%q) error = %v", name, err)
+		}
+		if got := alg.SigningHash(); got != want {
+			t.Errorf("%s: SigningHash() = %s, want %s", name, AlgName(got), AlgName(want))
+		}
+	}
+}
+
+func TestAsymTemplateDefaults(t *testing.T) {
+	defaults := map[options.KeyType]string{
+		options.Decrypt:          "rsa2048",
+		options.Signer:           "ecc256",
+		options.RestrictedSigner: "ecc256",
+	}
+	for kty, name := range defaults {
+		alg, err := ParseKeyAlg(name)
+		if err != nil {
+			t.Fatalf("ParseKeyAlg(%q) error = %v", name, err)
+		}
+		got, err := AsymTemplate(kty, alg)
+		if err != nil {
+			t.Fatalf("AsymTemplate(%s, %s) error = %v", kty, alg, err)
+		}
+		if want := AsymTemplatesByKeyType[kty]; !bytes.Equal(tpm2.Marshal(got), tpm2.Marshal(want)) {
+			t.Errorf("AsymTemplate(%s, %s) differs from the default template", kty, alg)
+		}
+	}
+
+	alg, _ := ParseKeyAlg("ecc384")
+	if _, err := AsymTemplate(options.Decrypt, alg); err == nil {
+		t.Errorf("AsymTemplate(decrypt, ecc384) error = nil, want an error")
+	}
+}
+
+func TestAsymTemplateMatrix(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	tests := []struct {
+		kty options.KeyType
+		alg string
+	}{
+		{options.Decrypt, "rsa2048:sha384"},
+		{options.Decrypt, "rsa2048:sha512"},
+		{options.Signer, "rsa2048"},
+		{options.RestrictedSigner, "rsa2048:sha384"},
+		{options.Signer, "ecc256:sha512"},
+		{options.Signer, "ecc384"},
+		{options.Signer, "ecc521"},
+		{options.RestrictedSigner, "ecc384"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kty.String()+"/"+tt.alg, func(t *testing.T) {
+			alg, err := ParseKeyAlg(tt.alg)
+			if err != nil {
+				t.Fatalf("ParseKeyAlg() error = %v", err)
+			}
+			template, err := AsymTemplate(tt.kty, alg)
+			if err != nil {
+				t.Fatalf("AsymTemplate() error = %v", err)
+			}
+			if err := CheckTemplateSupported(tpm, template); err != nil {
+				t.Fatalf("CheckTemplateSupported() error = %v", err)
+			}
+			rsp, err := tpm2.CreatePrimary{
+				PrimaryHandle: tpm2.TPMRHOwner,
+				InPublic:      tpm2.New2B(template),
+			}.Execute(tpm)
+			if err != nil {
+				t.Fatalf("CreatePrimary() error = %v", err)
+			}
+			defer tpm2.FlushContext{FlushHandle: rsp.ObjectHandle}.Execute(tpm)
+
+			pub, err := keyutil.PublicKey(&rsp.OutPublic)
+			if err != nil {
+				t.Fatalf("keyutil.PublicKey() error = %v", err)
+			}
+			if _, err := pemutil.SerializePEMToBytes(pub); err != nil {
+				t.Fatalf("SerializePEMToBytes() error = %v", err)
+			}
+			contents, _ := rsp.OutPublic.Contents()
+			if contents.NameAlg != alg.NameAlg {
+				t.Errorf("nameAlg = %s, want %s", AlgName(contents.NameAlg), AlgName(alg.NameAlg))
+			}
+		})
+	}
+}
+
+func TestCheckTemplateSupported(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	// the simulator is built without RSA 3072 and 4096
+	alg, _ := ParseKeyAlg("rsa4096")
+	template, err := AsymTemplate(options.Signer, alg)
+	if err != nil {
+		t.Fatalf("AsymTemplate() error = %v", err)
+	}
+	err = CheckTemplateSupported(tpm, template)
+	if err == nil || !strings.Contains(err.Error(), "doesn't support") {
+		t.Errorf("CheckTemplateSupported() error = %v, want an unsupported error", err)
+	}
+}
@@ -0,0 +1,343 @@
+package tpmutil
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+// nvTypeNames follows the naming of tpm2-tools.
+var nvTypeNames = map[tpm2.TPMNT]string{
+	tpm2.TPMNTOrdinary: "ordinary",
+	tpm2.TPMNTCounter:  "counter",
+	tpm2.TPMNTBits:     "bits",
+	tpm2.TPMNTExtend:   "extend",
+}
+
+// ParseNVType parses the type of an NV index: 'ordinary', 'counter', 'bits' or 'extend'.
+func ParseNVType(s string) (tpm2.TPMNT, error) {
+	for nt, name := range nvTypeNames {
+		if strings.EqualFold(s, name) {
+			return nt, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown NV type %q. Expected 'ordinary', 'counter', 'bits' or 'extend'", s)
+}
+
+// NVTypeName returns the name of the type of an NV index (e.g. 'counter').
+func NVTypeName(nt tpm2.TPMNT) string {
+	if name, ok := nvTypeNames[nt]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%x", uint8(nt))
+}
+
+// DefaultNVAttributes let both the owner and the password of the index authorize reads
+// and writes, as tpm2_nvdefine does.
+var DefaultNVAttributes = tpm2.TPMANV{
+	OwnerWrite: true,
+	AuthWrite:  true,
+	OwnerRead:  true,
+	AuthRead:   true,
+}
+
+// counterSize is the size of counter and bits indices.
+const counterSize = 8
+
+// NVDefineConfig is the configuration of [NVDefine].
+type NVDefineConfig struct {
+	// Index is the handle of the index, from 0x01000000 to 0x01FFFFFF.
+	Index tpm2.TPMHandle
+	// Type is the type of the index (default: ordinary).
+	Type tpm2.TPMNT
+	// Size is the size of an ordinary index. Counter and bits indices hold 8 bytes,
+	// extend indices a digest of HashAlg.
+	Size int
+	// HashAlg is the name algorithm of the index, also used to extend an extend index (default: SHA-256).
+	HashAlg tpm2.TPMIAlgHash
+	// Attributes of the index (default: [DefaultNVAttributes]), its type is set from Type.
+	Attributes *tpm2.TPMANV
+	// Auth is the password of the index.
+	Auth []byte
+	// OwnerAuth is the password of the owner hierarchy.
+	OwnerAuth []byte
+}
+
+// NVDefine defines an NV index in the owner hierarchy (TPM2_NV_DefineSpace) and returns its public area.
+func NVDefine(tpm transport.TPM, cfg NVDefineConfig) (*tpm2.TPMSNVPublic, error) {
+	if err := checkNVIndex(cfg.Index); err != nil {
+		return nil, err
+	}
+	hashAlg := cfg.HashAlg
+	if hashAlg == 0 {
+		hashAlg = tpm2.TPMAlgSHA256
+	}
+	hash, err := hashAlg.Hash()
+	if err != nil {
+		return nil, fmt.Errorf("invalid input: %w", err)
+	}
+
+	var size int
+	switch cfg.Type {
+	case tpm2.TPMNTOrdinary:
+		if cfg.Size <= 0 || cfg.Size > math.MaxUint16 {
+			return nil, fmt.Errorf("invalid input: the size of an ordinary index must be between 1 and %d", math.MaxUint16)
+		}
+		size = cfg.Size
+	case tpm2.TPMNTCounter, tpm2.TPMNTBits:
+		size = counterSize
+	case tpm2.TPMNTExtend:
+		size = hash.Size()
+	default:
+		return nil, fmt.Errorf("invalid input: unsupported NV type %s", NVTypeName(cfg.Type))
+	}
+	if cfg.Size != 0 && cfg.Size != size {
+		return nil, fmt.Errorf("invalid input: a %s index holds %d bytes, not %d", NVTypeName(cfg.Type), size, cfg.Size)
+	}
+
+	attrs := DefaultNVAttributes
+	if cfg.Attributes != nil {
+		attrs = *cfg.Attributes
+	}
+	attrs.NT = cfg.Type
+	pub := tpm2.TPMSNVPublic{
+		NVIndex:    cfg.Index,
+		NameAlg:    hashAlg,
+		Attributes: attrs,
+		DataSize:   uint16(size),
+	}
+	_, err = tpm2.NVDefineSpace{
+		AuthHandle: tpm2.AuthHandle{Handle: tpm2.TPMRHOwner, Auth: tpm2.PasswordAuth(cfg.OwnerAuth)},
+		Auth:       tpm2.TPM2BAuth{Buffer: cfg.Auth},
+		PublicInfo: tpm2.New2B(pub),
+	}.Execute(tpm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to define NV index 0x%x: %w", uint32(cfg.Index), err)
+	}
+	return &pub, nil
+}
+
+// NVUndefine removes an NV index of the owner hierarchy (TPM2_NV_UndefineSpace).
+func NVUndefine(tpm transport.TPM, index tpm2.TPMHandle, ownerAuth []byte) error {
+	pub, name, err := NVReadPublic(tpm, index)
+	if err != nil {
+		return err
+	}
+	_, err = tpm2.NVUndefineSpace{
+		AuthHandle: tpm2.AuthHandle{Handle: tpm2.TPMRHOwner, Auth: tpm2.PasswordAuth(ownerAuth)},
+		NVIndex:    tpm2.NamedHandle{Handle: pub.NVIndex, Name: *name},
+	}.Execute(tpm)
+	if err != nil {
+		return fmt.Errorf("failed to undefine NV index 0x%x: %w", uint32(index), err)
+	}
+	return nil
+}
+
+// NVReadPublic returns the public area and the Name of an NV index (TPM2_NV_ReadPublic).
+func NVReadPublic(tpm transport.TPM, index tpm2.TPMHandle) (*tpm2.TPMSNVPublic, *tpm2.TPM2BName, error) {
+	if err := checkNVIndex(index); err != nil {
+		return nil, nil, err
+	}
+	rsp, err := tpm2.NVReadPublic{NVIndex: index}.Execute(tpm)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read the public area of NV index 0x%x: %w", uint32(index), err)
+	}
+	pub, err := rsp.NVPublic.Contents()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read the public area of NV index 0x%x: %w", uint32(index), err)
+	}
+	return pub, &rsp.NVName, nil
+}
+
+// NVAuth authorizes an NV command with the owner or platform hierarchy, or with the index itself.
+type NVAuth struct {
+	// Owner authorizes the command with the owner hierarchy (TPMA_NV_OWNERWRITE/OWNERREAD)
+	// instead of the index (TPMA_NV_AUTHWRITE/AUTHREAD).
+	Owner bool
+	// Platform authorizes the command with the platform hierarchy (TPMA_NV_PPWRITE/PPREAD),
+	// as for the indices holding EK certificates.
+	Platform bool
+	// Auth is the password of the hierarchy or of the index.
+	Auth []byte
+}
+
+// nvHandles returns the handle authorizing a command on index and the index itself.
+//
+// The Name of the index is read on every call: it changes once the index is written (TPMA_NV_WRITTEN).
+func (a NVAuth) nvHandles(tpm transport.TPM, index tpm2.TPMHandle) (tpm2.AuthHandle, tpm2.NamedHandle, error) {
+	_, name, err := NVReadPublic(tpm, index)
+	if err != nil {
+		return tpm2.AuthHandle{}, tpm2.NamedHandle{}, err
+	}
+	nvIndex := tpm2.NamedHandle{Handle: index, Name: *name}
+	switch {
+	case a.Owner && a.Platform:
+		return tpm2.AuthHandle{}, tpm2.NamedHandle{}, fmt.Errorf("invalid input: an NV command is authorized by the owner or the platform hierarchy, not both")
+	case a.Owner:
+		return tpm2.AuthHandle{Handle: tpm2.TPMRHOwner, Auth: tpm2.PasswordAuth(a.Auth)}, nvIndex, nil
+	case a.Platform:
+		return tpm2.AuthHandle{Handle: tpm2.TPMRHPlatform, Auth: tpm2.PasswordAuth(a.Auth)}, nvIndex, nil
+	}
+	return tpm2.AuthHandle{Handle: index, Name: *name, Auth: tpm2.PasswordAuth(a.Auth)}, nvIndex, nil
+}
+
+// NVBufferMax returns the maximum size of the data read or written by a single NV command.
+func NVBufferMax(tpm transport.TPM) (int, error) {
+	v, err := ReadProperty(tpm, tpm2.TPMPTNVBufferMax)
+	if err != nil {
+		return 0, err
+	}
+	return int(v), nil
+}
+
+// NVWriteConfig is the configuration of [NVWrite].
+type NVWriteConfig struct {
+	Index tpm2.TPMHandle
+	Auth  NVAuth
+	Data  []byte
+	// Offset is the position of Data in the index.
+	Offset int
+}
+
+// NVWrite writes data to an ordinary index (TPM2_NV_Write), in chunks of [NVBufferMax] bytes.
+func NVWrite(tpm transport.TPM, cfg NVWriteConfig) error {
+	pub, _, err := NVReadPublic(tpm, cfg.Index)
+	if err != nil {
+		return err
+	}
+	if cfg.Offset < 0 || cfg.Offset+len(cfg.Data) > int(pub.DataSize) {
+		return fmt.Errorf("invalid input: %d bytes at offset %d exceed the %d bytes of NV index 0x%x", len(cfg.Data), cfg.Offset, pub.DataSize, uint32(cfg.Index))
+	}
+	chunkSize, err := NVBufferMax(tpm)
+	if err != nil {
+		return err
+	}
+	for written := 0; written < len(cfg.Data); {
+		chunk := cfg.Data[written:min(written+chunkSize, len(cfg.Data))]
+		authHandle, nvIndex, err := cfg.Auth.nvHandles(tpm, cfg.Index)
+		if err != nil {
+			return err
+		}
+		_, err = tpm2.NVWrite{
+			AuthHandle: authHandle,
+			NVIndex:    nvIndex,
+			Data:       tpm2.TPM2BMaxNVBuffer{Buffer: chunk},
+			Offset:     uint16(cfg.Offset + written),
+		}.Execute(tpm)
+		if err != nil {
+			return fmt.Errorf("failed to write NV index 0x%x: %w", uint32(cfg.Index), err)
+		}
+		written += len(chunk)
+	}
+	return nil
+}
+
+// NVReadConfig is the configuration of [NVRead].
+type NVReadConfig struct {
+	Index tpm2.TPMHandle
+	Auth  NVAuth
+	// Size is the number of bytes to read (default: up to the end of the index).
+	Size int
+	// Offset is the position of the first byte to read.
+	Offset int
+}
+
+// NVRead reads an NV index (TPM2_NV_Read), in chunks of [NVBufferMax] bytes.
+func NVRead(tpm transport.TPM, cfg NVReadConfig) ([]byte, error) {
+	pub, _, err := NVReadPublic(tpm, cfg.Index)
+	if err != nil {
+		return nil, err
+	}
+	size := cfg.Size
+	if size == 0 {
+		size = int(pub.DataSize) - cfg.Offset
+	}
+	if cfg.Offset < 0 || size < 0 || cfg.Offset+size > int(pub.DataSize) {
+		return nil, fmt.Errorf("invalid input: %d bytes at offset %d exceed the %d bytes of NV index 0x%x", size, cfg.Offset, pub.DataSize, uint32(cfg.Index))
+	}
+	chunkSize, err := NVBufferMax(tpm)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, 0, size)
+	for len(data) < size {
+		authHandle, nvIndex, err := cfg.Auth.nvHandles(tpm, cfg.Index)
+		if err != nil {
+			return nil, err
+		}
+		rsp, err := tpm2.NVRead{
+			AuthHandle: authHandle,
+			NVIndex:    nvIndex,
+			Size:       uint16(min(chunkSize, size-len(data))),
+			Offset:     uint16(cfg.Offset + len(data)),
+		}.Execute(tpm)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read NV index 0x%x: %w", uint32(cfg.Index), err)
+		}
+		data = append(data, rsp.Data.Buffer...)
+	}
+	return data, nil
+}
+
+// NVIncrement increments a counter index by one (TPM2_NV_Increment).
+func NVIncrement(tpm transport.TPM, index tpm2.TPMHandle, auth NVAuth) error {
+	authHandle, nvIndex, err := auth.nvHandles(tpm, index)
+	if err != nil {
+		return err
+	}
+	if _, err := (tpm2.NVIncrement{AuthHandle: authHandle, NVIndex: nvIndex}).Execute(tpm); err != nil {
+		return fmt.Errorf("failed to increment NV index 0x%x: %w", uint32(index), err)
+	}
+	return nil
+}
+
+// NVSetBits ORs bits into a bits index (TPM2_NV_SetBits).
+func NVSetBits(tpm transport.TPM, index tpm2.TPMHandle, auth NVAuth, bits uint64) error {
+	authHandle, _, err := auth.nvHandles(tpm, index)
+	if err != nil {
+		return err
+	}
+	// go-tpm doesn't implement TPM2_NV_SetBits
+	_, err = executeRawPassword(tpm, tpm2.TPMCCNVSetBits, []tpm2.TPMHandle{authHandle.Handle, index}, auth.Auth,
+		binary.BigEndian.AppendUint64(nil, bits))
+	if err != nil {
+		return fmt.Errorf("failed to set the bits of NV index 0x%x: %w", uint32(index), err)
+	}
+	return nil
+}
+
+// NVExtend extends an extend index with data (TPM2_NV_Extend): the new value of the index
+// is the hash of its current value followed by data, as for a PCR.
+func NVExtend(tpm transport.TPM, index tpm2.TPMHandle, auth NVAuth, data []byte) error {
+	authHandle, _, err := auth.nvHandles(tpm, index)
+	if err != nil {
+		return err
+	}
+	maxSize, err := NVBufferMax(tpm)
+	if err != nil {
+		return err
+	}
+	if len(data) > maxSize {
+		return fmt.Errorf("invalid input: the data extending an index can't exceed %d bytes", maxSize)
+	}
+	// go-tpm doesn't implement TPM2_NV_Extend
+	params := binary.BigEndian.AppendUint16(nil, uint16(len(data)))
+	_, err = executeRawPassword(tpm, tpm2.TPMCCNVExtend, []tpm2.TPMHandle{authHandle.Handle, index}, auth.Auth,
+		append(params, data...))
+	if err != nil {
+		return fmt.Errorf("failed to extend NV index 0x%x: %w", uint32(index), err)
+	}
+	return nil
+}
+
+// checkNVIndex checks that index is an NV index handle.
+func checkNVIndex(index tpm2.TPMHandle) error {
+	if tpm2.TPMHT(index>>24) != tpm2.TPMHTNVIndex {
+		return fmt.Errorf("invalid input: 0x%x is not an NV index, expected a handle from 0x01000000 to 0x01FFFFFF", uint32(index))
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package tpmutil
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/loicsikidi/go-tpm-kit/tpmtest"
+)
+
+func TestParseNVType(t *testing.T) {
+	for nt, name := range nvTypeNames {
+		got, err := ParseNVType(name)
+		if err != nil {
+			t.Fatalf("ParseNVType(%q) error = %v", name, err)
+		}
+		if got != nt || NVTypeName(got) != name {
+			t.Errorf("ParseNVType(%q) = %v, want %v", name, got, nt)
+		}
+	}
+	if _, err := ParseNVType("pin"); err == nil {
+		t.Errorf("ParseNVType() error = nil, want an error")
+	}
+}
+
+// TestNVWriteChunks writes and reads data crossing the boundary of the NV buffer.
+func TestNVWriteChunks(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	index := tpm2.TPMHandle(0x01000100)
+	bufferMax, err := NVBufferMax(tpm)
+	if err != nil {
+		t.Fatalf("NVBufferMax() error = %v", err)
+	}
+	if _, err := NVDefine(tpm, NVDefineConfig{Index: index, Size: 2 * bufferMax}); err != nil {
+		t.Fatalf("NVDefine() error = %v", err)
+	}
+
+	data := bytes.Repeat([]byte("0123456789"), bufferMax/5)
+	if err := NVWrite(tpm, NVWriteConfig{Index: index, Data: data, Offset: 1}); err != nil {
+		t.Fatalf("NVWrite() error = %v", err)
+	}
+	got, err := NVRead(tpm, NVReadConfig{Index: index, Offset: 1, Size: len(data)})
+	if err != nil {
+		t.Fatalf("NVRead() error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("NVRead() = %d bytes, want the %d bytes written", len(got), len(data))
+	}
+
+	if err := NVWrite(tpm, NVWriteConfig{Index: index, Data: data, Offset: 2*bufferMax - len(data) + 1}); err == nil {
+		t.Errorf("NVWrite() past the end error = nil, want an error")
+	}
+	if _, err := NVRead(tpm, NVReadConfig{Index: index, Offset: 2*bufferMax + 1}); err == nil {
+		t.Errorf("NVRead() past the end error = nil, want an error")
+	}
+}
+
+func TestNVAuthBothHierarchies(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	index := tpm2.TPMHandle(0x01000100)
+	if _, err := NVDefine(tpm, NVDefineConfig{Index: index, Size: 8}); err != nil {
+		t.Fatalf("NVDefine() error = %v", err)
+	}
+	auth := NVAuth{Owner: true, Platform: true}
+	if err := NVWrite(tpm, NVWriteConfig{Index: index, Auth: auth, Data: []byte("data")}); err == nil {
+		t.Errorf("NVWrite() error = nil, want an error")
+	}
+}
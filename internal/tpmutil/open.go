@@ -4,6 +4,7 @@ package tpmutil
 
 import (
 	"fmt"
+	"os"
 	"runtime"
 	"slices"
 
@@ -13,38 +14,62 @@ import (
 	"github.com/google/go-tpm/tpm2/transport/simulator"
 )
 
-type Device string
-
 var (
-	TPM_SIMULATOR Device = "simulator"
-	SWTPM         Device = "swtpm"
-	LINUX         Device = "/dev/tpmrm0"
+	SWTPM Device = "swtpm:"
+	LINUX Device = "dev:/dev/tpmrm0"
 )
 
 // OpenTPM opens a connection to the specified TPM device.
-// If no device is specified, it defaults to the appropriate device based on the OS.
+// If no device is specified, it relies on [DeviceEnvVar] and then defaults to the
+// appropriate device based on the OS.
+//
+// The connection is a [Retrier] using the default [RetryPolicy].
 func OpenTPM(device Device) (transport.TPMCloser, error) {
 	if device == "" {
 		device = getDefaultDevice()
 	}
 
+	device, err := ParseDevice(string(device))
+	if err != nil {
+		return nil, err
+	}
+	tpm, err := openDevice(device)
+	if err != nil {
+		return nil, err
+	}
+	retrier, err := NewRetrier(tpm, RetryPolicy{})
+	if err != nil {
+		tpm.Close()
+		return nil, err
+	}
+	return retrier, nil
+}
+
+// openDevice opens a device previously checked by [ParseDevice].
+func openDevice(device Device) (transport.TPMCloser, error) {
 	if err := validateDevice(device); err != nil {
 		return nil, err
 	}
 
-	switch device {
-	case LINUX:
-		return linuxtpm.Open(string(LINUX))
-	case SWTPM:
-		return swtpm.OpenSwtpm(SWTPM_STATE)
-	case TPM_SIMULATOR:
+	switch scheme, value := device.split(); scheme {
+	case schemeDev:
+		return linuxtpm.Open(value)
+	case schemeSwtpm:
+		dir, _ := device.SwtpmStateDir()
+		return swtpm.OpenSwtpm(dir)
+	case schemeSimulator:
 		return simulator.OpenSimulator()
+	case schemeTCP:
+		return openTCP(value)
 	default:
 		return nil, fmt.Errorf("unsupported device: %s", device)
 	}
 }
 
 func getDefaultDevice() Device {
+	if env := os.Getenv(DeviceEnvVar); env != "" {
+		return Device(env)
+	}
 	switch runtime.GOOS {
 	case "darwin":
 		return TPM_SIMULATOR
@@ -56,13 +81,16 @@ func getDefaultDevice() Device {
 }
 
 func validateDevice(device Device) error {
+	scheme, _ := device.split()
 	switch runtime.GOOS {
 	case "darwin":
-		if !slices.Contains([]Device{TPM_SIMULATOR, SWTPM}, device) {
-			return fmt.Errorf("darwin only supports %s and %s", TPM_SIMULATOR, SWTPM)
+		if !slices.Contains([]string{schemeSimulator, schemeSwtpm, schemeTCP}, scheme) {
+			return fmt.Errorf("darwin only supports %s, %s and tcp:", TPM_SIMULATOR, SWTPM)
 		}
 	case "linux":
-		return nil // Linux supports all devices
+		if scheme == schemeTBS {
+			return fmt.Errorf("linux does not support %s", device)
+		}
 	default:
 		return fmt.Errorf("unsupported platform: %s", runtime.GOOS)
 	}
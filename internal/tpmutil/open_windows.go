@@ -4,30 +4,55 @@ package tpmutil
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/google/go-tpm/tpm2/transport"
 	"github.com/google/go-tpm/tpm2/transport/simulator"
 	"github.com/google/go-tpm/tpm2/transport/windowstpm"
 )
 
-type Device string
-
 var (
-	TPM_SIMULATOR Device = "simulator"
-	WINDOWS       Device = "windows"
+	WINDOWS       Device = "tbs:"
 	DefaultDevice Device = TPM_SIMULATOR
 )
 
+// OpenTPM opens a connection to the specified TPM device.
+// If no device is specified, it relies on [DeviceEnvVar] and then defaults to [DefaultDevice].
+//
+// The connection is a [Retrier] using the default [RetryPolicy].
 func OpenTPM(device Device) (transport.TPMCloser, error) {
 	if device == "" {
 		device = DefaultDevice
+		if env := os.Getenv(DeviceEnvVar); env != "" {
+			device = Device(env)
+		}
+	}
+
+	device, err := ParseDevice(string(device))
+	if err != nil {
+		return nil, err
 	}
+	tpm, err := openDevice(device)
+	if err != nil {
+		return nil, err
+	}
+	retrier, err := NewRetrier(tpm, RetryPolicy{})
+	if err != nil {
+		tpm.Close()
+		return nil, err
+	}
+	return retrier, nil
+}
 
-	switch device {
-	case WINDOWS:
+// openDevice opens a device previously checked by [ParseDevice].
+func openDevice(device Device) (transport.TPMCloser, error) {
+	switch scheme, value := device.split(); scheme {
+	case schemeTBS:
 		return windowstpm.Open()
-	case TPM_SIMULATOR:
+	case schemeSimulator:
 		return simulator.OpenSimulator()
+	case schemeTCP:
+		return openTCP(value)
 	default:
 		return nil, fmt.Errorf("unsupported device: %s", device)
 	}
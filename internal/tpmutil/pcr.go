@@ -0,0 +1,453 @@
+package tpmutil
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/tpm-pills/internal/utils"
+)
+
+// NumPCRs is the number of PCRs of a bank on a PC Client TPM.
+const NumPCRs = 24
+
+// PCRSelection selects PCRs of a bank.
+type PCRSelection struct {
+	// Hash is the algorithm of the bank.
+	Hash tpm2.TPMIAlgHash
+	// PCRs holds the sorted indexes of the selected PCRs.
+	PCRs []int
+}
+
+// ParsePCRSelection parses a selection in the format of tpm2-tools: a bank followed
+// by PCR indexes (e.g. 'sha256:0,7,16'), several banks being joined by '+' (e.g. 'sha1:0+sha256:0,7').
+func ParsePCRSelection(s string) ([]PCRSelection, error) {
+	if s == "" {
+		return nil, fmt.Errorf("invalid PCR selection: empty selection")
+	}
+	var sels []PCRSelection
+	for _, bank := range strings.Split(s, "+") {
+		name, list, ok := strings.Cut(bank, ":")
+		if !ok || list == "" {
+			return nil, fmt.Errorf("invalid PCR selection %q: expected <bank>:<pcr>[,<pcr>...]", bank)
+		}
+		hash, err := parseHashAlg(strings.TrimSpace(name))
+		if err != nil {
+			return nil, fmt.Errorf("invalid PCR selection %q: %w", bank, err)
+		}
+		if slices.ContainsFunc(sels, func(sel PCRSelection) bool { return sel.Hash == hash }) {
+			return nil, fmt.Errorf("invalid PCR selection %q: bank %s selected twice", s, name)
+		}
+		sel := PCRSelection{Hash: hash}
+		for _, idx := range strings.Split(list, ",") {
+			pcr, err := ParsePCRIndex(strings.TrimSpace(idx))
+			if err != nil {
+				return nil, fmt.Errorf("invalid PCR selection %q: %w", bank, err)
+			}
+			sel.PCRs = append(sel.PCRs, pcr)
+		}
+		slices.Sort(sel.PCRs)
+		sel.PCRs = slices.Compact(sel.PCRs)
+		sels = append(sels, sel)
+	}
+	return sels, nil
+}
+
+// parseHashAlg returns the hash algorithm designated by name (e.g. 'sha256').
+func parseHashAlg(name string) (tpm2.TPMIAlgHash, error) {
+	hash, err := ParseAlg(name)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := hash.Hash(); err != nil {
+		return 0, fmt.Errorf("%s is not a hash algorithm", name)
+	}
+	return hash, nil
+}
+
+// ParsePCRIndex parses the index of a PCR, between 0 and [NumPCRs]-1.
+func ParsePCRIndex(s string) (int, error) {
+	pcr, err := strconv.Atoi(s)
+	if err != nil || pcr < 0 || pcr >= NumPCRs {
+		return 0, fmt.Errorf("PCR %q is not between 0 and %d", s, NumPCRs-1)
+	}
+	return pcr, nil
+}
+
+// ParsePCRValues parses the hex values of the PCRs of sels, separated by commas
+// and in the order of the selection (e.g. '<sha256:0>,<sha256:7>' for 'sha256:0,7').
+func ParsePCRValues(s string, sels []PCRSelection) ([][]byte, error) {
+	var sizes []int
+	for _, sel := range sels {
+		hash, err := sel.Hash.Hash()
+		if err != nil {
+			return nil, err
+		}
+		for range sel.PCRs {
+			sizes = append(sizes, hash.Size())
+		}
+	}
+	fields := strings.Split(s, ",")
+	if len(fields) != len(sizes) {
+		return nil, fmt.Errorf("invalid PCR values: expected %d values for %s, got %d", len(sizes), FormatPCRSelection(sels), len(fields))
+	}
+	values := make([][]byte, len(fields))
+	for i, field := range fields {
+		value, err := hex.DecodeString(strings.TrimSpace(field))
+		if err != nil || len(value) != sizes[i] {
+			return nil, fmt.Errorf("invalid PCR values: value %d is not a %d-byte hex digest", i+1, sizes[i])
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// FormatPCRSelection formats a selection as accepted by [ParsePCRSelection].
+func FormatPCRSelection(sels []PCRSelection) string {
+	banks := make([]string, len(sels))
+	for i, sel := range sels {
+		pcrs := make([]string, len(sel.PCRs))
+		for j, pcr := range sel.PCRs {
+			pcrs[j] = strconv.Itoa(pcr)
+		}
+		banks[i] = AlgName(sel.Hash) + ":" + strings.Join(pcrs, ",")
+	}
+	return strings.Join(banks, "+")
+}
+
+// PCRSelectionList converts a selection to its TPM representation.
+func PCRSelectionList(sels []PCRSelection) tpm2.TPMLPCRSelection {
+	var list tpm2.TPMLPCRSelection
+	for _, sel := range sels {
+		bitmap := make([]byte, NumPCRs/8)
+		for _, pcr := range sel.PCRs {
+			bitmap[pcr/8] |= 1 << (pcr % 8)
+		}
+		list.PCRSelections = append(list.PCRSelections, tpm2.TPMSPCRSelection{
+			Hash:      sel.Hash,
+			PCRSelect: bitmap,
+		})
+	}
+	return list
+}
+
+// PCRSelectionFromList converts the TPM representation of a selection back, see [PCRSelectionList].
+// The order of the list is kept: it is the order of the PCR values digested by the TPM.
+func PCRSelectionFromList(list tpm2.TPMLPCRSelection) []PCRSelection {
+	var sels []PCRSelection
+	for _, sel := range list.PCRSelections {
+		pcrs := selectedPCRs(tpm2.TPMLPCRSelection{PCRSelections: []tpm2.TPMSPCRSelection{sel}}, sel.Hash)
+		if len(pcrs) > 0 {
+			sels = append(sels, PCRSelection{Hash: sel.Hash, PCRs: pcrs})
+		}
+	}
+	return sels
+}
+
+// ReadPCRs reads the values of the selected PCRs, in the order of the selection.
+func ReadPCRs(tpm transport.TPM, sels []PCRSelection) ([][]byte, error) {
+	var values [][]byte
+	for _, sel := range sels {
+		// the TPM returns at most 8 digests per TPM2_PCR_Read
+		remaining := sel.PCRs
+		for len(remaining) > 0 {
+			rsp, err := tpm2.PCRRead{
+				PCRSelectionIn: PCRSelectionList([]PCRSelection{{Hash: sel.Hash, PCRs: remaining}}),
+			}.Execute(tpm)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read PCRs: %w", err)
+			}
+			read := selectedPCRs(rsp.PCRSelectionOut, sel.Hash)
+			if len(read) == 0 || len(read) != len(rsp.PCRValues.Digests) {
+				return nil, fmt.Errorf("failed to read PCRs: bank %s isn't allocated", AlgName(sel.Hash))
+			}
+			for _, digest := range rsp.PCRValues.Digests {
+				values = append(values, digest.Buffer)
+			}
+			remaining = slices.DeleteFunc(slices.Clone(remaining), func(pcr int) bool {
+				return slices.Contains(read, pcr)
+			})
+		}
+	}
+	return values, nil
+}
+
+// selectedPCRs returns the PCRs of the bank hash selected in list.
+func selectedPCRs(list tpm2.TPMLPCRSelection, hash tpm2.TPMIAlgHash) []int {
+	var pcrs []int
+	for _, sel := range list.PCRSelections {
+		if sel.Hash != hash {
+			continue
+		}
+		for i, b := range sel.PCRSelect {
+			for bit := range 8 {
+				if b&(1<<bit) != 0 {
+					pcrs = append(pcrs, i*8+bit)
+				}
+			}
+		}
+	}
+	return pcrs
+}
+
+// PCRDigest returns the digest of PCR values expected by TPM2_PolicyPCR:
+// the hash of their concatenation using hashAlg.
+func PCRDigest(hashAlg tpm2.TPMIAlgHash, values [][]byte) ([]byte, error) {
+	hash, err := hashAlg.Hash()
+	if err != nil {
+		return nil, err
+	}
+	h := hash.New()
+	for _, v := range values {
+		h.Write(v)
+	}
+	return h.Sum(nil), nil
+}
+
+// PCRValues holds PCR values by bank and index.
+//
+// It is marshalled in JSON as an object per bank, PCR values being hex strings:
+//
+//	{"sha256": {"0": "<hex>", "16": "<hex>"}}
+type PCRValues map[tpm2.TPMIAlgHash]map[int][]byte
+
+// NewPCRValues returns the values read by [ReadPCRs] for sels.
+func NewPCRValues(sels []PCRSelection, values [][]byte) PCRValues {
+	v := PCRValues{}
+	i := 0
+	for _, sel := range sels {
+		for _, pcr := range sel.PCRs {
+			if i < len(values) {
+				v.set(sel.Hash, pcr, values[i])
+			}
+			i++
+		}
+	}
+	return v
+}
+
+func (v PCRValues) set(hash tpm2.TPMIAlgHash, pcr int, value []byte) {
+	if v[hash] == nil {
+		v[hash] = map[int][]byte{}
+	}
+	v[hash][pcr] = value
+}
+
+// Selection returns the PCRs holding a value, banks being sorted by algorithm ID.
+func (v PCRValues) Selection() []PCRSelection {
+	var sels []PCRSelection
+	for hash, pcrs := range v {
+		sel := PCRSelection{Hash: hash}
+		for pcr := range pcrs {
+			sel.PCRs = append(sel.PCRs, pcr)
+		}
+		slices.Sort(sel.PCRs)
+		sels = append(sels, sel)
+	}
+	slices.SortFunc(sels, func(a, b PCRSelection) int { return int(a.Hash) - int(b.Hash) })
+	return sels
+}
+
+// Values returns the values of the PCRs of sels, in the order of the selection.
+func (v PCRValues) Values(sels []PCRSelection) ([][]byte, error) {
+	var values [][]byte
+	for _, sel := range sels {
+		for _, pcr := range sel.PCRs {
+			value, ok := v[sel.Hash][pcr]
+			if !ok {
+				return nil, fmt.Errorf("no value for PCR %s:%d", AlgName(sel.Hash), pcr)
+			}
+			values = append(values, value)
+		}
+	}
+	return values, nil
+}
+
+// MarshalJSON implements [json.Marshaler], PCRs being sorted by index.
+func (v PCRValues) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, sel := range v.Selection() {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%q:{", AlgName(sel.Hash))
+		for j, pcr := range sel.PCRs {
+			if j > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, "\"%d\":\"%x\"", pcr, v[sel.Hash][pcr])
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// UnmarshalJSON implements [json.Unmarshaler].
+func (v *PCRValues) UnmarshalJSON(b []byte) error {
+	var in map[string]map[string]string
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+	values := PCRValues{}
+	for bank, pcrs := range in {
+		hash, err := parseHashAlg(bank)
+		if err != nil {
+			return fmt.Errorf("invalid PCR bank: %w", err)
+		}
+		h, _ := hash.Hash()
+		for idx, hexValue := range pcrs {
+			pcr, err := ParsePCRIndex(idx)
+			if err != nil {
+				return fmt.Errorf("invalid PCR of bank %s: %w", bank, err)
+			}
+			value, err := hex.DecodeString(hexValue)
+			if err != nil || len(value) != h.Size() {
+				return fmt.Errorf("invalid value of PCR %s:%d: expected a %d-byte hex digest", bank, pcr, h.Size())
+			}
+			values.set(hash, pcr, value)
+		}
+	}
+	*v = values
+	return nil
+}
+
+// LoadPCRValues reads PCR values saved in JSON (see [PCRValues]).
+func LoadPCRValues(path string) (PCRValues, error) {
+	b, err := utils.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PCR values: %w", err)
+	}
+	var values PCRValues
+	if err := json.Unmarshal(b, &values); err != nil {
+		return nil, fmt.Errorf("invalid PCR values %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// ReadPCRValues reads the values of the selected PCRs.
+func ReadPCRValues(tpm transport.TPM, sels []PCRSelection) (PCRValues, error) {
+	values, err := ReadPCRs(tpm, sels)
+	if err != nil {
+		return nil, err
+	}
+	return NewPCRValues(sels, values), nil
+}
+
+// ParsePCRDigests parses digests to extend a PCR with, in the format of tpm2-tools:
+// a bank followed by a hex digest, several banks being joined by ',' (e.g. 'sha1=<hex>,sha256=<hex>').
+func ParsePCRDigests(s string) ([]tpm2.TPMTHA, error) {
+	var digests []tpm2.TPMTHA
+	for _, field := range strings.Split(s, ",") {
+		bank, hexDigest, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid digest %q: expected <bank>=<hex>", field)
+		}
+		hash, err := parseHashAlg(strings.TrimSpace(bank))
+		if err != nil {
+			return nil, fmt.Errorf("invalid digest %q: %w", field, err)
+		}
+		if slices.ContainsFunc(digests, func(d tpm2.TPMTHA) bool { return d.HashAlg == hash }) {
+			return nil, fmt.Errorf("invalid digest %q: bank %s given twice", s, bank)
+		}
+		h, _ := hash.Hash()
+		digest, err := hex.DecodeString(strings.TrimSpace(hexDigest))
+		if err != nil || len(digest) != h.Size() {
+			return nil, fmt.Errorf("invalid digest %q: expected a %d-byte hex digest", field, h.Size())
+		}
+		digests = append(digests, tpm2.TPMTHA{HashAlg: hash, Digest: digest})
+	}
+	return digests, nil
+}
+
+// ExtendPCR extends a PCR with a digest per bank (TPM2_PCR_Extend):
+// the new value of the PCR is the hash of its current value followed by the digest.
+func ExtendPCR(tpm transport.TPM, pcr int, digests ...tpm2.TPMTHA) error {
+	if pcr < 0 || pcr >= NumPCRs {
+		return fmt.Errorf("invalid input: PCR %d is not between 0 and %d", pcr, NumPCRs-1)
+	}
+	_, err := tpm2.PCRExtend{
+		PCRHandle: tpm2.AuthHandle{Handle: tpm2.TPMHandle(pcr), Auth: tpm2.PasswordAuth(nil)},
+		Digests:   tpm2.TPMLDigestValues{Digests: digests},
+	}.Execute(tpm)
+	if err != nil {
+		return fmt.Errorf("failed to extend PCR %d: %w", pcr, err)
+	}
+	return nil
+}
+
+// MaxEventSize is the maximum size of the data of [PCREvent].
+const MaxEventSize = 1024
+
+// PCREvent extends a PCR of every bank with the digest of data computed by the TPM (TPM2_PCR_Event).
+func PCREvent(tpm transport.TPM, pcr int, data []byte) error {
+	if pcr < 0 || pcr >= NumPCRs {
+		return fmt.Errorf("invalid input: PCR %d is not between 0 and %d", pcr, NumPCRs-1)
+	}
+	if len(data) > MaxEventSize {
+		return fmt.Errorf("invalid input: event data exceeds %d bytes", MaxEventSize)
+	}
+	_, err := tpm2.PCREvent{
+		PCRHandle: tpm2.AuthHandle{Handle: tpm2.TPMHandle(pcr), Auth: tpm2.PasswordAuth(nil)},
+		EventData: tpm2.TPM2BEvent{Buffer: data},
+	}.Execute(tpm)
+	if err != nil {
+		return fmt.Errorf("failed to extend PCR %d: %w", pcr, err)
+	}
+	return nil
+}
+
+// ResettablePCRs holds the PCRs which can be reset at locality 0: the debug PCR 16 and the application PCR 23.
+var ResettablePCRs = []int{16, 23}
+
+// ResetPCR resets a PCR of every bank to zero (TPM2_PCR_Reset), see [ResettablePCRs].
+func ResetPCR(tpm transport.TPM, pcr int) error {
+	if !slices.Contains(ResettablePCRs, pcr) {
+		return fmt.Errorf("invalid input: PCR %d can't be reset, only PCRs 16 and 23 can", pcr)
+	}
+	_, err := tpm2.PCRReset{
+		PCRHandle: tpm2.AuthHandle{Handle: tpm2.TPMHandle(pcr), Auth: tpm2.PasswordAuth(nil)},
+	}.Execute(tpm)
+	if err != nil {
+		return fmt.Errorf("failed to reset PCR %d: %w", pcr, err)
+	}
+	return nil
+}
+
+// EventDigest returns the digest of data extended by [PCREvent] in the bank hashAlg.
+func EventDigest(hashAlg tpm2.TPMIAlgHash, data []byte) ([]byte, error) {
+	return PCRDigest(hashAlg, [][]byte{data})
+}
+
+// ComputePCR computes offline the value of a PCR of the bank hashAlg after extending it with digests.
+// The initial value defaults to zero, the value of a PCR after a reset.
+func ComputePCR(hashAlg tpm2.TPMIAlgHash, initial []byte, digests ...[]byte) ([]byte, error) {
+	hash, err := hashAlg.Hash()
+	if err != nil {
+		return nil, err
+	}
+	value := initial
+	if value == nil {
+		value = make([]byte, hash.Size())
+	}
+	if len(value) != hash.Size() {
+		return nil, fmt.Errorf("invalid initial value: expected %d bytes, got %d", hash.Size(), len(value))
+	}
+	for _, digest := range digests {
+		if len(digest) != hash.Size() {
+			return nil, fmt.Errorf("invalid digest %x: expected %d bytes, got %d", digest, hash.Size(), len(digest))
+		}
+		h := hash.New()
+		h.Write(value)
+		h.Write(digest)
+		value = h.Sum(nil)
+	}
+	return value, nil
+}
@@ -0,0 +1,244 @@
+package tpmutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/go-tpm-kit/tpmtest"
+)
+
+// extendPCR extends a PCR of the SHA-256 bank with the digest of data.
+func extendPCR(t *testing.T, tpm transport.TPM, pcr int, data []byte) {
+	t.Helper()
+	digest := sha256.Sum256(data)
+	if err := ExtendPCR(tpm, pcr, tpm2.TPMTHA{HashAlg: tpm2.TPMAlgSHA256, Digest: digest[:]}); err != nil {
+		t.Fatalf("ExtendPCR() error = %v", err)
+	}
+}
+
+func mustParsePCRSelection(t *testing.T, s string) []PCRSelection {
+	t.Helper()
+	sels, err := ParsePCRSelection(s)
+	if err != nil {
+		t.Fatalf("ParsePCRSelection(%q) error = %v", s, err)
+	}
+	return sels
+}
+
+func TestParsePCRSelection(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "sha256:16,0,7", want: "sha256:0,7,16"},
+		{in: "sha256:7,7", want: "sha256:7"},
+		{in: "sha1:0+sha256:0,23", want: "sha1:0+sha256:0,23"},
+		{in: "", wantErr: true},
+		{in: "sha256", wantErr: true},
+		{in: "sha256:", wantErr: true},
+		{in: "sha256:24", wantErr: true},
+		{in: "sha256:-1", wantErr: true},
+		{in: "aes:0", wantErr: true},
+		{in: "md5:0", wantErr: true},
+		{in: "sha256:0+sha256:1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			sels, err := ParsePCRSelection(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParsePCRSelection() = %v, want an error", sels)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParsePCRSelection() error = %v", err)
+			}
+			if got := FormatPCRSelection(sels); got != tt.want {
+				t.Errorf("FormatPCRSelection() = %q, want %q", got, tt.want)
+			}
+			if got := FormatPCRSelection(PCRSelectionFromList(PCRSelectionList(sels))); got != tt.want {
+				t.Errorf("PCRSelectionFromList() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePCRValues(t *testing.T) {
+	sels := []PCRSelection{{Hash: tpm2.TPMAlgSHA1, PCRs: []int{0}}, {Hash: tpm2.TPMAlgSHA256, PCRs: []int{0, 7}}}
+	sha1Value := strings.Repeat("01", 20)
+	sha256Value := strings.Repeat("02", 32)
+
+	values, err := ParsePCRValues(sha1Value+", "+sha256Value+","+sha256Value, sels)
+	if err != nil {
+		t.Fatalf("ParsePCRValues() error = %v", err)
+	}
+	if len(values) != 3 || len(values[0]) != 20 || len(values[2]) != 32 {
+		t.Errorf("ParsePCRValues() = %x, want a SHA-1 and two SHA-256 digests", values)
+	}
+
+	for _, in := range []string{
+		sha1Value + "," + sha256Value,
+		sha256Value + "," + sha256Value + "," + sha256Value,
+		sha1Value + "," + sha256Value + ",zz",
+	} {
+		if _, err := ParsePCRValues(in, sels); err == nil {
+			t.Errorf("ParsePCRValues(%q) error = nil, want an error", in)
+		}
+	}
+}
+
+func TestReadPCRs(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	// more PCRs than a single TPM2_PCR_Read returns
+	sels := mustParsePCRSelection(t, "sha256:0,1,2,3,4,5,6,7,8,9,16")
+
+	before, err := ReadPCRs(tpm, sels)
+	if err != nil {
+		t.Fatalf("ReadPCRs() error = %v", err)
+	}
+	if len(before) != 11 {
+		t.Fatalf("ReadPCRs() = %d values, want 11", len(before))
+	}
+	extendPCR(t, tpm, 16, []byte("event"))
+	after, err := ReadPCRs(tpm, sels)
+	if err != nil {
+		t.Fatalf("ReadPCRs() error = %v", err)
+	}
+	if got := changedPCRs(sels, before, after); len(got) != 1 || got[0] != "sha256:16" {
+		t.Errorf("changed PCRs = %v, want [sha256:16]", got)
+	}
+	digest := sha256.Sum256([]byte("event"))
+	want := sha256.Sum256(append(before[10], digest[:]...))
+	if !bytes.Equal(after[10], want[:]) {
+		t.Errorf("PCR 16 = %x, want %x", after[10], want)
+	}
+}
+
+func TestPCRValuesJSON(t *testing.T) {
+	sels := mustParsePCRSelection(t, "sha256:0,7,16+sha1:23")
+	values := [][]byte{
+		bytes.Repeat([]byte{0}, 32), bytes.Repeat([]byte{7}, 32), bytes.Repeat([]byte{16}, 32),
+		bytes.Repeat([]byte{23}, 20),
+	}
+	b, err := json.Marshal(NewPCRValues(sels, values))
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	want := `{"sha1":{"23":"` + strings.Repeat("17", 20) + `"},"sha256":{"0":"` + strings.Repeat("00", 32) +
+		`","7":"` + strings.Repeat("07", 32) + `","16":"` + strings.Repeat("10", 32) + `"}}`
+	if string(b) != want {
+		t.Errorf("Marshal() = %s, want %s", b, want)
+	}
+
+	var got PCRValues
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if sel := FormatPCRSelection(got.Selection()); sel != "sha1:23+sha256:0,7,16" {
+		t.Errorf("Selection() = %s, want sha1:23+sha256:0,7,16", sel)
+	}
+	gotValues, err := got.Values(sels)
+	if err != nil {
+		t.Fatalf("Values() error = %v", err)
+	}
+	for i := range values {
+		if !bytes.Equal(gotValues[i], values[i]) {
+			t.Errorf("Values()[%d] = %x, want %x", i, gotValues[i], values[i])
+		}
+	}
+	if _, err := got.Values(mustParsePCRSelection(t, "sha256:1")); err == nil {
+		t.Errorf("Values() error = nil, want an error for a missing PCR")
+	}
+
+	for _, in := range []string{
+		`{"md5": {"0": "00"}}`,
+		`{"sha256": {"24": "` + strings.Repeat("00", 32) + `"}}`,
+		`{"sha256": {"0": "` + strings.Repeat("00", 20) + `"}}`,
+		`{"sha256": {"0": "zz"}}`,
+	} {
+		if err := json.Unmarshal([]byte(in), &got); err == nil {
+			t.Errorf("Unmarshal(%s) error = nil, want an error", in)
+		}
+	}
+}
+
+func TestParsePCRDigests(t *testing.T) {
+	digests, err := ParsePCRDigests("sha1=" + strings.Repeat("01", 20) + ",sha256=" + strings.Repeat("02", 32))
+	if err != nil {
+		t.Fatalf("ParsePCRDigests() error = %v", err)
+	}
+	if len(digests) != 2 || digests[0].HashAlg != tpm2.TPMAlgSHA1 || digests[1].HashAlg != tpm2.TPMAlgSHA256 {
+		t.Errorf("ParsePCRDigests() = %v, want a SHA-1 and a SHA-256 digest", digests)
+	}
+	for _, in := range []string{
+		"sha256",
+		"sha256=" + strings.Repeat("02", 20),
+		"aes=00",
+		"sha256=" + strings.Repeat("02", 32) + ",sha256=" + strings.Repeat("02", 32),
+	} {
+		if _, err := ParsePCRDigests(in); err == nil {
+			t.Errorf("ParsePCRDigests(%q) error = nil, want an error", in)
+		}
+	}
+}
+
+// TestComputePCR checks the values computed offline against the PCRs extended by the TPM.
+func TestComputePCR(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	sels := mustParsePCRSelection(t, "sha1:16+sha256:16")
+	events := [][]byte{[]byte("first event"), []byte("second event")}
+
+	for _, event := range events {
+		if err := PCREvent(tpm, 16, event); err != nil {
+			t.Fatalf("PCREvent() error = %v", err)
+		}
+	}
+	got, err := ReadPCRs(tpm, sels)
+	if err != nil {
+		t.Fatalf("ReadPCRs() error = %v", err)
+	}
+	for i, sel := range sels {
+		var digests [][]byte
+		for _, event := range events {
+			digest, err := EventDigest(sel.Hash, event)
+			if err != nil {
+				t.Fatalf("EventDigest() error = %v", err)
+			}
+			digests = append(digests, digest)
+		}
+		want, err := ComputePCR(sel.Hash, nil, digests...)
+		if err != nil {
+			t.Fatalf("ComputePCR() error = %v", err)
+		}
+		if !bytes.Equal(got[i], want) {
+			t.Errorf("PCR %s:16 = %x, want %x", AlgName(sel.Hash), got[i], want)
+		}
+	}
+
+	if err := ResetPCR(tpm, 16); err != nil {
+		t.Fatalf("ResetPCR() error = %v", err)
+	}
+	got, err = ReadPCRs(tpm, sels)
+	if err != nil {
+		t.Fatalf("ReadPCRs() error = %v", err)
+	}
+	for i, sel := range sels {
+		h, _ := sel.Hash.Hash()
+		if !bytes.Equal(got[i], make([]byte, h.Size())) {
+			t.Errorf("PCR %s:16 after reset = %x, want zero", AlgName(sel.Hash), got[i])
+		}
+	}
+	if err := ResetPCR(tpm, 0); err == nil {
+		t.Errorf("ResetPCR(0) error = nil, want an error")
+	}
+	if _, err := ComputePCR(tpm2.TPMAlgSHA256, nil, make([]byte, 20)); err == nil {
+		t.Errorf("ComputePCR() with a SHA-1 digest error = nil, want an error")
+	}
+}
@@ -0,0 +1,515 @@
+package tpmutil
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/tpm-pills/internal/options"
+	"github.com/loicsikidi/tpm-pills/internal/utils"
+)
+
+// ErrPCRMismatch is returned when the PCRs don't have the values expected by a [PolicyPCR].
+var ErrPCRMismatch = errors.New("PCR values don't match the policy")
+
+// Policy is a list of assertions authorizing the use of an object once satisfied in a policy session.
+//
+// Its digest, computed offline by [Policy.Digest], is the authPolicy of the object.
+// It is marshalled in JSON as a list of assertions, each one being an object with a single key:
+//
+//	[
+//	  {"pcrs": "sha256:0,7", "values": ["<hex>", "<hex>"]},
+//	  {"authValue": true},
+//	  {"password": true},
+//	  {"commandCode": "Unseal"},
+//	  {"or": [[...], [...]]}
+//	]
+type Policy []PolicyAssertion
+
+// PolicyAssertion is an assertion of a [Policy]: [PolicyPCR], [PolicyAuthValue], [PolicyPassword],
+// [PolicyCommandCode] or [PolicyOR].
+type PolicyAssertion interface {
+	// update extends the digest of calc, prefix holds the assertions preceding this one.
+	update(calc *tpm2.PolicyCalculator, hashAlg tpm2.TPMIAlgHash, prefix Policy) error
+	// plan returns the steps asserting this one in a session, prefix holds the assertions preceding this one.
+	plan(tpm transport.TPM, hashAlg tpm2.TPMIAlgHash, prefix Policy) ([]policyStep, error)
+}
+
+// policyAuth tells how a policy session proves the knowledge of the auth value of the object.
+type policyAuth int
+
+const (
+	policyNoAuth policyAuth = iota
+	policyAuthValue
+	policyPassword
+)
+
+// policyStep is a policy command asserted in a session.
+type policyStep struct {
+	run  func(tpm transport.TPM, session tpm2.TPMHandle) error
+	auth policyAuth
+}
+
+// PolicyPCR binds the policy to the values of PCRs (TPM2_PolicyPCR).
+type PolicyPCR struct {
+	Selection []PCRSelection
+	// Values holds the expected PCR values in the order of Selection.
+	// They are required to compute the digest, see [Policy.WithCurrentPCRs].
+	Values [][]byte
+}
+
+func (a PolicyPCR) digest(hashAlg tpm2.TPMIAlgHash) ([]byte, error) {
+	if len(a.Values) != countPCRs(a.Selection) {
+		return nil, fmt.Errorf("PolicyPCR: expected %d PCR values for %s, got %d", countPCRs(a.Selection), FormatPCRSelection(a.Selection), len(a.Values))
+	}
+	return PCRDigest(hashAlg, a.Values)
+}
+
+func (a PolicyPCR) update(calc *tpm2.PolicyCalculator, hashAlg tpm2.TPMIAlgHash, _ Policy) error {
+	digest, err := a.digest(hashAlg)
+	if err != nil {
+		return err
+	}
+	return tpm2.PolicyPCR{
+		PcrDigest: tpm2.TPM2BDigest{Buffer: digest},
+		Pcrs:      PCRSelectionList(a.Selection),
+	}.Update(calc)
+}
+
+func (a PolicyPCR) plan(tpm transport.TPM, hashAlg tpm2.TPMIAlgHash, _ Policy) ([]policyStep, error) {
+	current, err := ReadPCRs(tpm, a.Selection)
+	if err != nil {
+		return nil, err
+	}
+	if a.Values == nil {
+		a.Values = current
+	}
+	if changed := changedPCRs(a.Selection, a.Values, current); len(changed) > 0 {
+		return nil, fmt.Errorf("%w: %s changed", ErrPCRMismatch, strings.Join(changed, ", "))
+	}
+	digest, err := a.digest(hashAlg)
+	if err != nil {
+		return nil, err
+	}
+	return []policyStep{{run: func(tpm transport.TPM, session tpm2.TPMHandle) error {
+		_, err := tpm2.PolicyPCR{
+			PolicySession: session,
+			PcrDigest:     tpm2.TPM2BDigest{Buffer: digest},
+			Pcrs:          PCRSelectionList(a.Selection),
+		}.Execute(tpm)
+		return err
+	}}}, nil
+}
+
+// changedPCRs returns the PCRs (e.g. 'sha256:16') whose value differs between want and got.
+func changedPCRs(sels []PCRSelection, want, got [][]byte) []string {
+	var changed []string
+	i := 0
+	for _, sel := range sels {
+		for _, pcr := range sel.PCRs {
+			if i >= len(want) || i >= len(got) || !slices.Equal(want[i], got[i]) {
+				changed = append(changed, fmt.Sprintf("%s:%d", AlgName(sel.Hash), pcr))
+			}
+			i++
+		}
+	}
+	return changed
+}
+
+func countPCRs(sels []PCRSelection) int {
+	n := 0
+	for _, sel := range sels {
+		n += len(sel.PCRs)
+	}
+	return n
+}
+
+// PolicyAuthValue requires the auth value of the object, proven by the HMAC of the session (TPM2_PolicyAuthValue).
+type PolicyAuthValue struct{}
+
+func (PolicyAuthValue) update(calc *tpm2.PolicyCalculator, _ tpm2.TPMIAlgHash, _ Policy) error {
+	return tpm2.PolicyAuthValue{}.Update(calc)
+}
+
+func (PolicyAuthValue) plan(transport.TPM, tpm2.TPMIAlgHash, Policy) ([]policyStep, error) {
+	return []policyStep{{
+		run: func(tpm transport.TPM, session tpm2.TPMHandle) error {
+			_, err := tpm2.PolicyAuthValue{PolicySession: session}.Execute(tpm)
+			return err
+		},
+		auth: policyAuthValue,
+	}}, nil
+}
+
+// PolicyPassword requires the auth value of the object, sent in clear (TPM2_PolicyPassword).
+// Its digest is the one of [PolicyAuthValue].
+type PolicyPassword struct{}
+
+func (PolicyPassword) update(calc *tpm2.PolicyCalculator, _ tpm2.TPMIAlgHash, _ Policy) error {
+	return calc.Update(tpm2.TPMCCPolicyAuthValue)
+}
+
+func (PolicyPassword) plan(transport.TPM, tpm2.TPMIAlgHash, Policy) ([]policyStep, error) {
+	return []policyStep{{
+		run: func(tpm transport.TPM, session tpm2.TPMHandle) error {
+			// go-tpm doesn't implement TPM2_PolicyPassword
+			_, err := executeRaw(tpm, tpm2.TPMCCPolicyPassword, []tpm2.TPMHandle{session}, nil)
+			return err
+		},
+		auth: policyPassword,
+	}}, nil
+}
+
+// PolicyCommandCode restricts the use of the object to a command (TPM2_PolicyCommandCode).
+type PolicyCommandCode struct {
+	Code tpm2.TPMCC
+}
+
+func (a PolicyCommandCode) update(calc *tpm2.PolicyCalculator, _ tpm2.TPMIAlgHash, _ Policy) error {
+	return tpm2.PolicyCommandCode{Code: a.Code}.Update(calc)
+}
+
+func (a PolicyCommandCode) plan(transport.TPM, tpm2.TPMIAlgHash, Policy) ([]policyStep, error) {
+	return []policyStep{{run: func(tpm transport.TPM, session tpm2.TPMHandle) error {
+		_, err := tpm2.PolicyCommandCode{PolicySession: session, Code: a.Code}.Execute(tpm)
+		return err
+	}}}, nil
+}
+
+// PolicyOR is satisfied when one of its branches is (TPM2_PolicyOR).
+//
+// Each branch is evaluated after the assertions preceding the PolicyOR, which usually comes first.
+// When satisfying the policy, the first branch whose PCRs match is used.
+type PolicyOR struct {
+	// Branches holds between 2 and 8 policies.
+	Branches []Policy
+}
+
+func (a PolicyOR) digests(hashAlg tpm2.TPMIAlgHash, prefix Policy) (tpm2.TPMLDigest, error) {
+	if len(a.Branches) < 2 || len(a.Branches) > 8 {
+		return tpm2.TPMLDigest{}, fmt.Errorf("PolicyOR: expected between 2 and 8 branches, got %d", len(a.Branches))
+	}
+	var list tpm2.TPMLDigest
+	for _, branch := range a.Branches {
+		digest, err := slices.Concat(prefix, branch).Digest(hashAlg)
+		if err != nil {
+			return tpm2.TPMLDigest{}, err
+		}
+		list.Digests = append(list.Digests, tpm2.TPM2BDigest{Buffer: digest})
+	}
+	return list, nil
+}
+
+func (a PolicyOR) update(calc *tpm2.PolicyCalculator, hashAlg tpm2.TPMIAlgHash, prefix Policy) error {
+	digests, err := a.digests(hashAlg, prefix)
+	if err != nil {
+		return err
+	}
+	return tpm2.PolicyOr{PHashList: digests}.Update(calc)
+}
+
+func (a PolicyOR) plan(tpm transport.TPM, hashAlg tpm2.TPMIAlgHash, prefix Policy) ([]policyStep, error) {
+	digests, err := a.digests(hashAlg, prefix)
+	if err != nil {
+		return nil, err
+	}
+	var mismatches []error
+	for i, branch := range a.Branches {
+		steps, err := branch.plan(tpm, hashAlg, prefix)
+		if errors.Is(err, ErrPCRMismatch) {
+			mismatches = append(mismatches, fmt.Errorf("branch %d: %w", i+1, err))
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return append(steps, policyStep{run: func(tpm transport.TPM, session tpm2.TPMHandle) error {
+			_, err := tpm2.PolicyOr{PolicySession: session, PHashList: digests}.Execute(tpm)
+			return err
+		}}), nil
+	}
+	return nil, fmt.Errorf("PolicyOR: no branch can be satisfied: %w", errors.Join(mismatches...))
+}
+
+// Digest computes offline the digest of the policy, i.e. the authPolicy of an object whose name algorithm is hashAlg.
+func (p Policy) Digest(hashAlg tpm2.TPMIAlgHash) ([]byte, error) {
+	calc, err := tpm2.NewPolicyCalculator(hashAlg)
+	if err != nil {
+		return nil, err
+	}
+	for i, a := range p {
+		if err := a.update(calc, hashAlg, p[:i:i]); err != nil {
+			return nil, err
+		}
+	}
+	return calc.Hash().Digest, nil
+}
+
+func (p Policy) plan(tpm transport.TPM, hashAlg tpm2.TPMIAlgHash, prefix Policy) ([]policyStep, error) {
+	var steps []policyStep
+	for i, a := range p {
+		s, err := a.plan(tpm, hashAlg, slices.Concat(prefix, p[:i]))
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, s...)
+	}
+	return steps, nil
+}
+
+// WithCurrentPCRs returns a copy of p where the [PolicyPCR] assertions without values expect the current PCR values.
+func (p Policy) WithCurrentPCRs(tpm transport.TPM) (Policy, error) {
+	out := make(Policy, len(p))
+	for i, a := range p {
+		switch a := a.(type) {
+		case PolicyPCR:
+			if a.Values == nil {
+				values, err := ReadPCRs(tpm, a.Selection)
+				if err != nil {
+					return nil, err
+				}
+				a.Values = values
+			}
+			out[i] = a
+		case PolicyOR:
+			branches := make([]Policy, len(a.Branches))
+			for j, branch := range a.Branches {
+				var err error
+				if branches[j], err = branch.WithCurrentPCRs(tpm); err != nil {
+					return nil, err
+				}
+			}
+			out[i] = PolicyOR{Branches: branches}
+		default:
+			out[i] = a
+		}
+	}
+	return out, nil
+}
+
+// Satisfy starts a policy session using hashAlg (the name algorithm of the object) and asserts p in it.
+//
+// auth is the auth value of the object, only used when p requires it ([PolicyAuthValue] or [PolicyPassword]).
+// PCR values are checked beforehand, a mismatch is reported as [ErrPCRMismatch].
+// The session must be closed with the returned function.
+func (p Policy) Satisfy(tpm transport.TPM, hashAlg tpm2.TPMIAlgHash, auth []byte) (tpm2.Session, func() error, error) {
+	steps, err := p.plan(tpm, hashAlg, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to satisfy policy: %w", err)
+	}
+
+	var opts []tpm2.AuthOption
+	password := false
+	for _, step := range steps {
+		// the last assertion asking for the auth value wins
+		switch step.auth {
+		case policyAuthValue:
+			opts, password = []tpm2.AuthOption{tpm2.Auth(auth)}, false
+		case policyPassword:
+			opts, password = []tpm2.AuthOption{tpm2.Password(auth)}, true
+		}
+	}
+	session, closer, err := tpm2.PolicySession(tpm, hashAlg, 16, opts...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to start policy session: %w", err)
+	}
+	if password {
+		session = passwordPolicySession{session}
+	}
+	for _, step := range steps {
+		if err := step.run(tpm, session.Handle()); err != nil {
+			closer()
+			return nil, nil, fmt.Errorf("failed to satisfy policy: %w", err)
+		}
+	}
+	return session, closer, nil
+}
+
+// passwordPolicySession is a policy session after TPM2_PolicyPassword.
+//
+// go-tpm expects no nonceTPM in the response, whereas the TPM returns one: only the HMAC is empty.
+type passwordPolicySession struct {
+	tpm2.Session
+}
+
+// Validate implements [tpm2.Session].
+func (s passwordPolicySession) Validate(_ tpm2.TPMRC, _ tpm2.TPMCC, _ []byte, _ []tpm2.TPM2BName, _ int, auth *tpm2.TPMSAuthResponse) error {
+	if len(auth.Authorization.Buffer) != 0 {
+		return fmt.Errorf("expected empty HMAC in response auth to PW policy, got %x", auth.Authorization.Buffer)
+	}
+	return nil
+}
+
+// policyAssertionJSON is the JSON representation of a [PolicyAssertion], exactly one field must be set.
+type policyAssertionJSON struct {
+	PCRs        string   `json:"pcrs,omitempty"`
+	Values      []string `json:"values,omitempty"`
+	AuthValue   bool     `json:"authValue,omitempty"`
+	Password    bool     `json:"password,omitempty"`
+	CommandCode string   `json:"commandCode,omitempty"`
+	OR          []Policy `json:"or,omitempty"`
+}
+
+// MarshalJSON implements [json.Marshaler].
+func (p Policy) MarshalJSON() ([]byte, error) {
+	out := make([]policyAssertionJSON, len(p))
+	for i, a := range p {
+		switch a := a.(type) {
+		case PolicyPCR:
+			out[i].PCRs = FormatPCRSelection(a.Selection)
+			for _, v := range a.Values {
+				out[i].Values = append(out[i].Values, hex.EncodeToString(v))
+			}
+		case PolicyAuthValue:
+			out[i].AuthValue = true
+		case PolicyPassword:
+			out[i].Password = true
+		case PolicyCommandCode:
+			out[i].CommandCode = strings.TrimPrefix(CommandName(a.Code), "TPM2_")
+		case PolicyOR:
+			out[i].OR = a.Branches
+		default:
+			return nil, fmt.Errorf("unsupported policy assertion %T", a)
+		}
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON implements [json.Unmarshaler].
+func (p *Policy) UnmarshalJSON(b []byte) error {
+	var in []policyAssertionJSON
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+	policy := make(Policy, len(in))
+	for i, a := range in {
+		set := 0
+		for _, isSet := range []bool{a.PCRs != "", a.AuthValue, a.Password, a.CommandCode != "", a.OR != nil} {
+			if isSet {
+				set++
+			}
+		}
+		if set != 1 {
+			return fmt.Errorf("assertion %d: expected exactly one of pcrs, authValue, password, commandCode or or", i+1)
+		}
+		if a.Values != nil && a.PCRs == "" {
+			return fmt.Errorf("assertion %d: values are only allowed along with pcrs", i+1)
+		}
+
+		switch {
+		case a.PCRs != "":
+			sels, err := ParsePCRSelection(a.PCRs)
+			if err != nil {
+				return fmt.Errorf("assertion %d: %w", i+1, err)
+			}
+			pcr := PolicyPCR{Selection: sels}
+			for _, v := range a.Values {
+				value, err := hex.DecodeString(v)
+				if err != nil {
+					return fmt.Errorf("assertion %d: invalid PCR value %q: %w", i+1, v, err)
+				}
+				pcr.Values = append(pcr.Values, value)
+			}
+			if pcr.Values != nil && len(pcr.Values) != countPCRs(sels) {
+				return fmt.Errorf("assertion %d: expected %d PCR values, got %d", i+1, countPCRs(sels), len(pcr.Values))
+			}
+			policy[i] = pcr
+		case a.AuthValue:
+			policy[i] = PolicyAuthValue{}
+		case a.Password:
+			policy[i] = PolicyPassword{}
+		case a.CommandCode != "":
+			cc, err := ParseCommandCode(a.CommandCode)
+			if err != nil {
+				return fmt.Errorf("assertion %d: %w", i+1, err)
+			}
+			policy[i] = PolicyCommandCode{Code: cc}
+		default:
+			if len(a.OR) < 2 || len(a.OR) > 8 {
+				return fmt.Errorf("assertion %d: expected between 2 and 8 branches, got %d", i+1, len(a.OR))
+			}
+			policy[i] = PolicyOR{Branches: a.OR}
+		}
+	}
+	*p = policy
+	return nil
+}
+
+// LoadPolicy reads a JSON policy (see [Policy]).
+func LoadPolicy(path string) (Policy, error) {
+	b, err := utils.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy: %w", err)
+	}
+	var policy Policy
+	if err := json.Unmarshal(b, &policy); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %w", path, err)
+	}
+	if len(policy) == 0 {
+		return nil, fmt.Errorf("invalid policy %s: no assertion", path)
+	}
+	return policy, nil
+}
+
+// AuthPolicy loads the policy of opts, if any.
+func AuthPolicy(opts *options.AuthOpts) (Policy, error) {
+	if opts.PolicyPath == "" {
+		return nil, nil
+	}
+	return LoadPolicy(opts.PolicyPath)
+}
+
+// SavePolicy writes policy in JSON (see [Policy]).
+func SavePolicy(path string, policy Policy) error {
+	b, err := json.MarshalIndent(policy, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal policy: %w", err)
+	}
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		return fmt.Errorf("failed to save policy: %w", err)
+	}
+	return nil
+}
+
+// PolicyFilePath returns the path of the policy saved along with the blob of an object
+// (e.g. 'sealed.policy.json' for 'sealed.tpm').
+func PolicyFilePath(blobPath string) string {
+	return strings.TrimSuffix(blobPath, filepath.Ext(blobPath)) + ".policy.json"
+}
+
+// savePolicyFile saves policy along with the blob of an object, or removes the policy
+// saved there for a previous object when policy is nil: [LoadKey] would otherwise use it.
+func savePolicyFile(blobPath string, policy Policy) error {
+	path := PolicyFilePath(blobPath)
+	if policy == nil {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove stale policy: %w", err)
+		}
+		return nil
+	}
+	return SavePolicy(path, policy)
+}
+
+// withPolicy returns template whose use requires policy: its authPolicy is set to the digest
+// of policy, and userWithAuth is cleared so that the auth value alone isn't enough.
+// It also returns policy where the [PolicyPCR] assertions without values expect the current PCR values.
+func withPolicy(tpm transport.TPM, template tpm2.TPMTPublic, policy Policy) (tpm2.TPMTPublic, Policy, error) {
+	policy, err := policy.WithCurrentPCRs(tpm)
+	if err != nil {
+		return tpm2.TPMTPublic{}, nil, err
+	}
+	digest, err := policy.Digest(template.NameAlg)
+	if err != nil {
+		return tpm2.TPMTPublic{}, nil, fmt.Errorf("failed to compute policy digest: %w", err)
+	}
+	template.AuthPolicy = tpm2.TPM2BDigest{Buffer: digest}
+	template.ObjectAttributes.UserWithAuth = false
+	return template, policy, nil
+}
@@ -0,0 +1,331 @@
+package tpmutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/loicsikidi/go-tpm-kit/tpmtest"
+)
+
+func TestParseCommandCode(t *testing.T) {
+	for _, name := range []string{"Unseal", "unseal", "TPM2_Unseal", "tpm2_unseal"} {
+		cc, err := ParseCommandCode(name)
+		if err != nil {
+			t.Fatalf("ParseCommandCode(%q) error = %v", name, err)
+		}
+		if cc != tpm2.TPMCCUnseal {
+			t.Errorf("ParseCommandCode(%q) = %v, want %v", name, cc, tpm2.TPMCCUnseal)
+		}
+	}
+	if _, err := ParseCommandCode("Unsealing"); err == nil {
+		t.Errorf("ParseCommandCode() error = nil, want an error")
+	}
+}
+
+// TestPolicyDigest checks the digest computed offline against the one of a trial session.
+func TestPolicyDigest(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	pcrs := mustParsePCRSelection(t, "sha256:0,7+sha1:16")
+
+	tests := []struct {
+		name   string
+		policy Policy
+	}{
+		{name: "authValue", policy: Policy{PolicyAuthValue{}}},
+		{name: "commandCode", policy: Policy{PolicyCommandCode{Code: tpm2.TPMCCUnseal}, PolicyAuthValue{}}},
+		{name: "pcrs", policy: Policy{PolicyPCR{Selection: pcrs}}},
+		{name: "or", policy: Policy{
+			PolicyCommandCode{Code: tpm2.TPMCCSign},
+			PolicyOR{Branches: []Policy{
+				{PolicyPCR{Selection: pcrs}},
+				{PolicyAuthValue{}},
+			}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, hashAlg := range []tpm2.TPMIAlgHash{tpm2.TPMAlgSHA256, tpm2.TPMAlgSHA384} {
+				policy, err := tt.policy.WithCurrentPCRs(tpm)
+				if err != nil {
+					t.Fatalf("WithCurrentPCRs() error = %v", err)
+				}
+				got, err := policy.Digest(hashAlg)
+				if err != nil {
+					t.Fatalf("Digest() error = %v", err)
+				}
+
+				steps, err := policy.plan(tpm, hashAlg, nil)
+				if err != nil {
+					t.Fatalf("plan() error = %v", err)
+				}
+				session, closer, err := tpm2.PolicySession(tpm, hashAlg, 16, tpm2.Trial())
+				if err != nil {
+					t.Fatalf("PolicySession() error = %v", err)
+				}
+				defer closer()
+				for _, step := range steps {
+					if err := step.run(tpm, session.Handle()); err != nil {
+						t.Fatalf("trial policy error = %v", err)
+					}
+				}
+				rsp, err := tpm2.PolicyGetDigest{PolicySession: session.Handle()}.Execute(tpm)
+				if err != nil {
+					t.Fatalf("PolicyGetDigest() error = %v", err)
+				}
+				if !bytes.Equal(got, rsp.PolicyDigest.Buffer) {
+					t.Errorf("Digest(%s) = %x, want %x", AlgName(hashAlg), got, rsp.PolicyDigest.Buffer)
+				}
+			}
+		})
+	}
+
+	authValue, _ := Policy{PolicyAuthValue{}}.Digest(tpm2.TPMAlgSHA256)
+	password, _ := Policy{PolicyPassword{}}.Digest(tpm2.TPMAlgSHA256)
+	if !bytes.Equal(authValue, password) {
+		t.Errorf("PolicyPassword digest = %x, want the PolicyAuthValue one %x", password, authValue)
+	}
+}
+
+func TestPolicyJSON(t *testing.T) {
+	value := strings.Repeat("ab", sha256.Size)
+	in := `[
+		{"commandCode": "Sign"},
+		{"or": [
+			[{"pcrs": "sha256:7", "values": ["` + value + `"]}],
+			[{"pcrs": "sha256:0,7", "values": ["` + value + `", "` + value + `"]}, {"password": true}]
+		]},
+		{"authValue": true}
+	]`
+	var policy Policy
+	if err := json.Unmarshal([]byte(in), &policy); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if len(policy) != 3 {
+		t.Fatalf("Unmarshal() = %d assertions, want 3", len(policy))
+	}
+	if or, ok := policy[1].(PolicyOR); !ok || len(or.Branches) != 2 {
+		t.Fatalf("Unmarshal() assertion 2 = %#v, want a PolicyOR with 2 branches", policy[1])
+	}
+	want, err := policy.Digest(tpm2.TPMAlgSHA256)
+	if err != nil {
+		t.Fatalf("Digest() error = %v", err)
+	}
+
+	b, err := json.Marshal(policy)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var got Policy
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	digest, err := got.Digest(tpm2.TPMAlgSHA256)
+	if err != nil {
+		t.Fatalf("Digest() error = %v", err)
+	}
+	if !bytes.Equal(digest, want) {
+		t.Errorf("Digest() after a round trip = %x, want %x", digest, want)
+	}
+
+	// without values, the digest requires the current PCR values
+	if _, err := (Policy{PolicyPCR{Selection: mustParsePCRSelection(t, "sha256:7")}}).Digest(tpm2.TPMAlgSHA256); err == nil {
+		t.Errorf("Digest() error = nil, want an error as PCR values are missing")
+	}
+}
+
+func TestPolicyJSONErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{name: "not a list", in: `{"authValue": true}`},
+		{name: "empty assertion", in: `[{}]`},
+		{name: "two assertions", in: `[{"authValue": true, "password": true}]`},
+		{name: "values without pcrs", in: `[{"values": ["00"]}]`},
+		{name: "invalid pcrs", in: `[{"pcrs": "sha256:24"}]`},
+		{name: "invalid value", in: `[{"pcrs": "sha256:0", "values": ["zz"]}]`},
+		{name: "wrong value count", in: `[{"pcrs": "sha256:0,7", "values": ["00"]}]`},
+		{name: "unknown command", in: `[{"commandCode": "Foo"}]`},
+		{name: "single branch", in: `[{"or": [[{"authValue": true}]]}]`},
+		{name: "invalid branch", in: `[{"or": [[{"authValue": true}], [{}]]}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var policy Policy
+			if err := json.Unmarshal([]byte(tt.in), &policy); err == nil {
+				t.Errorf("Unmarshal() = %v, want an error", policy)
+			}
+		})
+	}
+}
+
+func TestPolicySign(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	dir := t.TempDir()
+	auth := []byte("correct horse")
+	policy := Policy{PolicyCommandCode{Code: tpm2.TPMCCSign}, PolicyAuthValue{}}
+	if err := CreateKey(tpm, CreateKeyConfig{
+		OutDir:           dir,
+		ParentTemplate:   ECCSRKTemplate,
+		OrdinaryTemplate: ECCSignerTemplate,
+		UserAuth:         auth,
+		Policy:           policy,
+	}); err != nil {
+		t.Fatalf("CreateKey() error = %v", err)
+	}
+	keyPath := filepath.Join(dir, "key.tpm")
+
+	if err := signWithPolicy(tpm, keyPath, auth, policy); err != nil {
+		t.Fatalf("Sign() with the policy error = %v", err)
+	}
+	if err := signWithPolicy(tpm, keyPath, []byte("wrong"), policy); !errors.Is(err, tpm2.TPMRCAuthFail) {
+		t.Errorf("Sign() with a wrong password error = %v, want %v", err, tpm2.TPMRCAuthFail)
+	}
+	// userWithAuth is cleared: without the policy saved along with the key, the password alone isn't enough
+	if err := os.Remove(PolicyFilePath(keyPath)); err != nil {
+		t.Fatalf("failed to remove the saved policy: %v", err)
+	}
+	if err := signWithAuth(tpm, keyPath, auth); !errors.Is(err, tpm2.TPMRCAuthUnavailable) {
+		t.Errorf("Sign() without the policy error = %v, want %v", err, tpm2.TPMRCAuthUnavailable)
+	}
+	// the digest of another policy doesn't match the authPolicy of the key
+	other := Policy{PolicyCommandCode{Code: tpm2.TPMCCUnseal}, PolicyAuthValue{}}
+	if err := signWithPolicy(tpm, keyPath, auth, other); !errors.Is(err, tpm2.TPMRCPolicyFail) {
+		t.Errorf("Sign() with an Unseal policy error = %v, want %v", err, tpm2.TPMRCPolicyFail)
+	}
+}
+
+// TestPolicyORPCR shows a key usable without password as long as PCR 16 is unchanged,
+// and with its password afterwards.
+// TestPolicyLongData checks that each chunk of data longer than a TPM2B_MAX_BUFFER
+// is authorized by its own policy session.
+func TestPolicyLongData(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	dir := t.TempDir()
+	auth := []byte("correct horse")
+	if err := CreateKey(tpm, CreateKeyConfig{
+		OutDir:           dir,
+		ParentTemplate:   ECCSRKTemplate,
+		OrdinaryTemplate: AES128CFBTemplate,
+		UserAuth:         auth,
+		Policy:           Policy{PolicyCommandCode{Code: tpm2.TPMCCEncryptDecrypt2}, PolicyAuthValue{}},
+	}); err != nil {
+		t.Fatalf("CreateKey() error = %v", err)
+	}
+	// the policy saved along with the key is used
+	keyHandle, err := LoadKey(tpm, LoadKeyConfig{
+		ParentTemplate: ECCSRKTemplate,
+		KeyBlobPath:    filepath.Join(dir, "key.tpm"),
+		Auth:           auth,
+	})
+	if err != nil {
+		t.Fatalf("LoadKey() error = %v", err)
+	}
+	defer keyHandle.Close()
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 2*maxBufferSize/16+10)
+	iv := make([]byte, 16)
+	cfg := SymEncryptDecryptConfig{KeyHandle: keyHandle, Data: data, IV: iv, Mode: tpm2.TPMAlgCFB}
+	ciphertext, err := SymEncryptDecrypt(tpm, cfg)
+	if err != nil {
+		t.Fatalf("SymEncryptDecrypt() error = %v", err)
+	}
+	cfg.Data, cfg.Decrypt = ciphertext, true
+	got, err := SymEncryptDecrypt(tpm, cfg)
+	if err != nil {
+		t.Fatalf("SymEncryptDecrypt() decrypt error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("SymEncryptDecrypt() decrypt doesn't return the data")
+	}
+}
+
+func TestPolicyORPCR(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	dir := t.TempDir()
+	auth := []byte("correct horse")
+	policy := Policy{PolicyOR{Branches: []Policy{
+		{PolicyPCR{Selection: mustParsePCRSelection(t, "sha256:16")}},
+		{PolicyPassword{}},
+	}}}
+	policy, err := policy.WithCurrentPCRs(tpm)
+	if err != nil {
+		t.Fatalf("WithCurrentPCRs() error = %v", err)
+	}
+	if err := CreateKey(tpm, CreateKeyConfig{
+		OutDir:           dir,
+		ParentTemplate:   ECCSRKTemplate,
+		OrdinaryTemplate: ECCSignerTemplate,
+		UserAuth:         auth,
+		Policy:           policy,
+	}); err != nil {
+		t.Fatalf("CreateKey() error = %v", err)
+	}
+	keyPath := filepath.Join(dir, "key.tpm")
+
+	if err := signWithPolicy(tpm, keyPath, nil, policy); err != nil {
+		t.Fatalf("Sign() with unchanged PCRs error = %v", err)
+	}
+
+	extendPCR(t, tpm, 16, []byte("event"))
+	if err := signWithPolicy(tpm, keyPath, auth, policy); err != nil {
+		t.Fatalf("Sign() with the password error = %v", err)
+	}
+	if err := signWithPolicy(tpm, keyPath, []byte("wrong"), policy); !errors.Is(err, tpm2.TPMRCAuthFail) {
+		t.Errorf("Sign() with a wrong password error = %v, want %v", err, tpm2.TPMRCAuthFail)
+	}
+
+	pcrOnly := policy[0].(PolicyOR).Branches[0]
+	if _, _, err := pcrOnly.Satisfy(tpm, tpm2.TPMAlgSHA256, nil); !errors.Is(err, ErrPCRMismatch) {
+		t.Errorf("Satisfy() error = %v, want %v", err, ErrPCRMismatch)
+	}
+}
+
+func TestPolicyPasswordUnseal(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	sealedPath := filepath.Join(t.TempDir(), "sealed.tpm")
+	secret := []byte("secret")
+	auth := []byte("correct horse")
+	policy := Policy{PolicyCommandCode{Code: tpm2.TPMCCUnseal}, PolicyPassword{}}
+	if err := Seal(tpm, SealConfig{
+		ParentTemplate: ECCSRKTemplate,
+		Message:        secret,
+		OutputFilePath: sealedPath,
+		UserAuth:       auth,
+		Policy:         policy,
+	}); err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+
+	unsealWithPolicy := func(auth []byte) ([]byte, error) {
+		keyHandle, err := LoadKey(tpm, LoadKeyConfig{
+			ParentTemplate: ECCSRKTemplate,
+			KeyBlobPath:    sealedPath,
+			Auth:           auth,
+			Policy:         policy,
+		})
+		if err != nil {
+			return nil, err
+		}
+		defer keyHandle.Close()
+		return Unseal(tpm, UnsealConfig{KeyHandle: keyHandle})
+	}
+
+	got, err := unsealWithPolicy(auth)
+	if err != nil {
+		t.Fatalf("Unseal() error = %v", err)
+	}
+	if string(got) != string(secret) {
+		t.Errorf("Unseal() = %q, want %q", got, secret)
+	}
+	if _, err := unsealWithPolicy([]byte("wrong")); !errors.Is(err, tpm2.TPMRCBadAuth) {
+		t.Errorf("Unseal() with a wrong password error = %v, want %v", err, tpm2.TPMRCBadAuth)
+	}
+}
@@ -0,0 +1,54 @@
+package tpmutil
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+// Persistent handles of the TCG TPM v2.0 Provisioning Guidance.
+const (
+	// SRKHandle is the handle of the Storage Root Key, in the owner range.
+	SRKHandle tpm2.TPMHandle = 0x81000001
+	// EKHandle is the handle of the Endorsement Key, in the endorsement range.
+	EKHandle tpm2.TPMHandle = 0x81010001
+	// AKHandle is the handle of the attestation key, the first free one of the owner range.
+	AKHandle tpm2.TPMHandle = 0x81000002
+)
+
+// ReadPersisted returns the public area, the Name and the qualified name of the object persisted at handle
+// (TPM2_ReadPublic), or nil when the handle is free.
+func ReadPersisted(tpm transport.TPM, handle tpm2.TPMHandle) (*tpm2.ReadPublicResponse, error) {
+	rsp, err := tpm2.ReadPublic{ObjectHandle: handle}.Execute(tpm)
+	if errors.Is(err, tpm2.TPMRCHandle) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public area of handle 0x%x: %w", uint32(handle), err)
+	}
+	return rsp, nil
+}
+
+// QualifiedName computes the qualified name of an object from the qualified name of its parent:
+// the hash of the parent's qualified name and of the object's Name, with the name algorithm of the object.
+// The qualified name of a hierarchy is its handle (see [tpm2.HandleName]).
+//
+// Unlike the Name, it binds an object to its parents up to the hierarchy: two objects created
+// from the same public area under different parents have different qualified names.
+func QualifiedName(parent, name tpm2.TPM2BName) (*tpm2.TPM2BName, error) {
+	if len(name.Buffer) < 2 {
+		return nil, fmt.Errorf("invalid input: the Name of the object has no name algorithm")
+	}
+	nameAlg := tpm2.TPMIAlgHash(binary.BigEndian.Uint16(name.Buffer))
+	hash, err := nameAlg.Hash()
+	if err != nil {
+		return nil, fmt.Errorf("invalid input: unsupported name algorithm: %w", err)
+	}
+	h := hash.New()
+	h.Write(parent.Buffer)
+	h.Write(name.Buffer)
+	return &tpm2.TPM2BName{Buffer: h.Sum(name.Buffer[:2:2])}, nil
+}
@@ -0,0 +1,79 @@
+package tpmutil
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/loicsikidi/go-tpm-kit/tpmtest"
+)
+
+func TestReadPersisted(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	rsp, err := ReadPersisted(tpm, SRKHandle)
+	if err != nil {
+		t.Fatalf("ReadPersisted() error = %v", err)
+	}
+	if rsp != nil {
+		t.Errorf("ReadPersisted() = %v, want nil for a free handle", rsp)
+	}
+}
+
+// TestQualifiedName checks the qualified names computed for a primary key and its child
+// against those returned by TPM2_ReadPublic.
+func TestQualifiedName(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	primary, err := tpm2.CreatePrimary{
+		PrimaryHandle: tpm2.TPMRHOwner,
+		InPublic:      tpm2.New2B(ECCSRKTemplate),
+	}.Execute(tpm)
+	if err != nil {
+		t.Fatalf("failed to create primary key: %v", err)
+	}
+	defer tpm2.FlushContext{FlushHandle: primary.ObjectHandle}.Execute(tpm)
+	created, err := tpm2.Create{
+		ParentHandle: tpm2.NamedHandle{Handle: primary.ObjectHandle, Name: primary.Name},
+		InPublic:     tpm2.New2B(ECCRestrictedSignerTemplate),
+	}.Execute(tpm)
+	if err != nil {
+		t.Fatalf("failed to create key: %v", err)
+	}
+	child, err := tpm2.Load{
+		ParentHandle: tpm2.NamedHandle{Handle: primary.ObjectHandle, Name: primary.Name},
+		InPrivate:    created.OutPrivate,
+		InPublic:     created.OutPublic,
+	}.Execute(tpm)
+	if err != nil {
+		t.Fatalf("failed to load key: %v", err)
+	}
+	defer tpm2.FlushContext{FlushHandle: child.ObjectHandle}.Execute(tpm)
+
+	primaryPublic, err := tpm2.ReadPublic{ObjectHandle: primary.ObjectHandle}.Execute(tpm)
+	if err != nil {
+		t.Fatalf("failed to read public area: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		handle tpm2.TPMHandle
+		parent tpm2.TPM2BName
+	}{
+		{name: "primary", handle: primary.ObjectHandle, parent: tpm2.HandleName(tpm2.TPMRHOwner)},
+		{name: "child", handle: child.ObjectHandle, parent: primaryPublic.QualifiedName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rsp, err := tpm2.ReadPublic{ObjectHandle: tt.handle}.Execute(tpm)
+			if err != nil {
+				t.Fatalf("failed to read public area: %v", err)
+			}
+			got, err := QualifiedName(tt.parent, rsp.Name)
+			if err != nil {
+				t.Fatalf("QualifiedName() error = %v", err)
+			}
+			if !bytes.Equal(got.Buffer, rsp.QualifiedName.Buffer) {
+				t.Errorf("QualifiedName() = %x, want %x", got.Buffer, rsp.QualifiedName.Buffer)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package tpmutil
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+// executeRaw sends a command without authorization area, for the commands go-tpm
+// doesn't implement (e.g. TPM2_PolicyPassword). It returns the parameters of the response.
+func executeRaw(tpm transport.TPM, cc tpm2.TPMCC, handles []tpm2.TPMHandle, params []byte) ([]byte, error) {
+	return sendRaw(tpm, cc, handles, nil, params)
+}
+
+// executeRawPassword is executeRaw for a command whose first handle is authorized by
+// a password session (e.g. TPM2_NV_SetBits). It returns the parameters of the response.
+func executeRawPassword(tpm transport.TPM, cc tpm2.TPMCC, handles []tpm2.TPMHandle, password []byte, params []byte) ([]byte, error) {
+	// TPMS_AUTH_COMMAND: TPM_RS_PW, an empty nonce, no session attributes and the password
+	auth := binary.BigEndian.AppendUint32(nil, uint32(tpm2.TPMRSPW))
+	auth = binary.BigEndian.AppendUint16(auth, 0)
+	auth = append(auth, 0)
+	auth = binary.BigEndian.AppendUint16(auth, uint16(len(password)))
+	auth = append(auth, password...)
+
+	rsp, err := sendRaw(tpm, cc, handles, auth, params)
+	if err != nil {
+		return nil, err
+	}
+	// the parameters are preceded by their size and followed by the session area
+	if len(rsp) < 4 || len(rsp)-4 < int(binary.BigEndian.Uint32(rsp)) {
+		return nil, fmt.Errorf("invalid %s response: truncated parameters", CommandName(cc))
+	}
+	return rsp[4 : 4+binary.BigEndian.Uint32(rsp)], nil
+}
+
+// sendRaw sends a command with the given authorization area, if any, and returns
+// what follows the header of the response.
+func sendRaw(tpm transport.TPM, cc tpm2.TPMCC, handles []tpm2.TPMHandle, auth []byte, params []byte) ([]byte, error) {
+	cmd := make([]byte, headerSize, headerSize+4*len(handles)+4+len(auth)+len(params))
+	for _, h := range handles {
+		cmd = binary.BigEndian.AppendUint32(cmd, uint32(h))
+	}
+	tag := tpm2.TPMSTNoSessions
+	if auth != nil {
+		tag = tpm2.TPMSTSessions
+		cmd = binary.BigEndian.AppendUint32(cmd, uint32(len(auth)))
+		cmd = append(cmd, auth...)
+	}
+	cmd = append(cmd, params...)
+	binary.BigEndian.PutUint16(cmd, uint16(tag))
+	binary.BigEndian.PutUint32(cmd[2:], uint32(len(cmd)))
+	binary.BigEndian.PutUint32(cmd[6:], uint32(cc))
+
+	rsp, err := tpm.Send(cmd)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := responseCode(rsp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s response: %w", CommandName(cc), err)
+	}
+	if rc != tpm2.TPMRCSuccess {
+		return nil, rc
+	}
+	return rsp[headerSize:], nil
+}
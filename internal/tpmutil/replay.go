@@ -0,0 +1,167 @@
+package tpmutil
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+// ErrReplayDivergence is returned by a [Replayer] when a command doesn't match the transcript.
+var ErrReplayDivergence = errors.New("command diverges from transcript")
+
+// Exchange is a command sent to the TPM along with its response.
+type Exchange struct {
+	Command  []byte
+	Response []byte
+}
+
+type exchangeJSON struct {
+	Name     string `json:"name"`
+	Command  string `json:"command"`
+	Response string `json:"response"`
+}
+
+// MarshalJSON encodes the exchange as hex strings annotated with the command name.
+func (e Exchange) MarshalJSON() ([]byte, error) {
+	return json.Marshal(exchangeJSON{
+		Name:     commandNameOf(e.Command),
+		Command:  hex.EncodeToString(e.Command),
+		Response: hex.EncodeToString(e.Response),
+	})
+}
+
+// UnmarshalJSON implements [json.Unmarshaler].
+func (e *Exchange) UnmarshalJSON(data []byte) error {
+	var v exchangeJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	cmd, err := hex.DecodeString(v.Command)
+	if err != nil {
+		return fmt.Errorf("invalid command: %w", err)
+	}
+	rsp, err := hex.DecodeString(v.Response)
+	if err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	e.Command, e.Response = cmd, rsp
+	return nil
+}
+
+// Transcript is the sequence of exchanges of a TPM session.
+type Transcript struct {
+	Exchanges []Exchange `json:"exchanges"`
+}
+
+// LoadTranscript reads a transcript previously written by [Transcript.Save].
+func LoadTranscript(path string) (*Transcript, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transcript: %w", err)
+	}
+	var t Transcript
+	if err := json.Unmarshal(content, &t); err != nil {
+		return nil, fmt.Errorf("failed to decode transcript: %w", err)
+	}
+	return &t, nil
+}
+
+// Save writes the transcript to path.
+func (t *Transcript) Save(path string) error {
+	content, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode transcript: %w", err)
+	}
+	if err := os.WriteFile(path, append(content, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write transcript: %w", err)
+	}
+	return nil
+}
+
+// Recorder is a [transport.TPMCloser] which records the exchanges with the underlying TPM.
+type Recorder struct {
+	tpm transport.TPMCloser
+
+	mu         sync.Mutex
+	transcript Transcript
+}
+
+// NewRecorder returns a [Recorder] sending commands to tpm.
+func NewRecorder(tpm transport.TPMCloser) *Recorder {
+	return &Recorder{tpm: tpm}
+}
+
+// Send implements [transport.TPM]. Exchanges failing at the transport level aren't recorded.
+func (r *Recorder) Send(cmd []byte) ([]byte, error) {
+	rsp, err := r.tpm.Send(cmd)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.transcript.Exchanges = append(r.transcript.Exchanges, Exchange{
+		Command:  bytes.Clone(cmd),
+		Response: bytes.Clone(rsp),
+	})
+	return rsp, nil
+}
+
+// Transcript returns the exchanges recorded so far.
+func (r *Recorder) Transcript() *Transcript {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &Transcript{Exchanges: slices.Clone(r.transcript.Exchanges)}
+}
+
+// Close closes the underlying TPM.
+func (r *Recorder) Close() error {
+	return r.tpm.Close()
+}
+
+// Replayer is a [transport.TPMCloser] serving the responses of a transcript.
+//
+// Commands must be sent in the same order and with the same content as during the recording,
+// otherwise [ErrReplayDivergence] is returned.
+type Replayer struct {
+	mu         sync.Mutex
+	transcript *Transcript
+	next       int
+}
+
+// NewReplayer returns a [Replayer] serving the given transcript.
+func NewReplayer(t *Transcript) *Replayer {
+	return &Replayer{transcript: t}
+}
+
+// Send implements [transport.TPM].
+func (r *Replayer) Send(cmd []byte) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name := commandNameOf(cmd)
+	if r.next >= len(r.transcript.Exchanges) {
+		return nil, fmt.Errorf("%w: unexpected %s after the last exchange", ErrReplayDivergence, name)
+	}
+	exchange := r.transcript.Exchanges[r.next]
+	if !bytes.Equal(exchange.Command, cmd) {
+		return nil, fmt.Errorf("%w: exchange #%d is %s, transcript expects %s", ErrReplayDivergence, r.next, name, commandNameOf(exchange.Command))
+	}
+	r.next++
+	return bytes.Clone(exchange.Response), nil
+}
+
+// Close reports an error if the transcript hasn't been fully replayed.
+func (r *Replayer) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if remaining := len(r.transcript.Exchanges) - r.next; remaining > 0 {
+		return fmt.Errorf("%d exchange(s) of the transcript were not replayed (next: %s)", remaining, commandNameOf(r.transcript.Exchanges[r.next].Command))
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package tpmutil
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/loicsikidi/go-tpm-kit/tpmtest"
+)
+
+func TestRecordReplay(t *testing.T) {
+	recorder := NewRecorder(tpmtest.OpenSimulator(t))
+	recorded, err := tpm2.GetRandom{BytesRequested: 16}.Execute(recorder)
+	if err != nil {
+		t.Fatalf("GetRandom() error = %v", err)
+	}
+	if _, err := (tpm2.ReadPublic{ObjectHandle: tpm2.TPMHandle(0x81ffffff)}).Execute(recorder); err == nil {
+		t.Fatal("ReadPublic() error = nil, want error")
+	}
+
+	path := filepath.Join(t.TempDir(), "transcript.json")
+	if err := recorder.Transcript().Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	transcript, err := LoadTranscript(path)
+	if err != nil {
+		t.Fatalf("LoadTranscript() error = %v", err)
+	}
+	if len(transcript.Exchanges) != 2 {
+		t.Fatalf("len(Exchanges) = %d, want 2", len(transcript.Exchanges))
+	}
+
+	replayer := NewReplayer(transcript)
+	replayed, err := tpm2.GetRandom{BytesRequested: 16}.Execute(replayer)
+	if err != nil {
+		t.Fatalf("replayed GetRandom() error = %v", err)
+	}
+	if !bytes.Equal(recorded.RandomBytes.Buffer, replayed.RandomBytes.Buffer) {
+		t.Errorf("replayed random bytes = %x, want %x", replayed.RandomBytes.Buffer, recorded.RandomBytes.Buffer)
+	}
+	if err := replayer.Close(); err == nil {
+		t.Error("Close() error = nil, want an error since ReadPublic wasn't replayed")
+	}
+
+	// TPM errors are replayed as well
+	_, err = tpm2.ReadPublic{ObjectHandle: tpm2.TPMHandle(0x81ffffff)}.Execute(replayer)
+	var rc tpm2.TPMRC
+	if !errors.As(err, &rc) {
+		t.Errorf("replayed ReadPublic() error = %v, want a TPM error", err)
+	}
+	if err := replayer.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
+
+func TestReplayDivergence(t *testing.T) {
+	recorder := NewRecorder(tpmtest.OpenSimulator(t))
+	if _, err := (tpm2.GetRandom{BytesRequested: 16}).Execute(recorder); err != nil {
+		t.Fatalf("GetRandom() error = %v", err)
+	}
+	replayer := NewReplayer(recorder.Transcript())
+
+	_, err := tpm2.GetRandom{BytesRequested: 8}.Execute(replayer)
+	if !errors.Is(err, ErrReplayDivergence) {
+		t.Errorf("GetRandom() with another size error = %v, want %v", err, ErrReplayDivergence)
+	}
+	if _, err := (tpm2.GetRandom{BytesRequested: 16}).Execute(replayer); err != nil {
+		t.Fatalf("GetRandom() error = %v", err)
+	}
+	_, err = tpm2.GetRandom{BytesRequested: 16}.Execute(replayer)
+	if !errors.Is(err, ErrReplayDivergence) {
+		t.Errorf("GetRandom() after the last exchange error = %v, want %v", err, ErrReplayDivergence)
+	}
+}
@@ -0,0 +1,145 @@
+package tpmutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+// transientWarnings are the response codes after which a command can simply be sent again.
+var transientWarnings = []tpm2.TPMRC{
+	tpm2.TPMRCRetry,
+	tpm2.TPMRCYielded,
+	tpm2.TPMRCTesting,
+}
+
+// RetryPolicy configures a [Retrier].
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times a command is sent (default: 5).
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry. It doubles after each attempt (default: 20ms).
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between two attempts (default: 1s).
+	MaxBackoff time.Duration
+	// Context aborts the pending retries once done (default: [context.Background]).
+	Context context.Context
+}
+
+func (p *RetryPolicy) CheckAndSetDefaults() error {
+	if p.MaxAttempts < 0 || p.InitialBackoff < 0 || p.MaxBackoff < 0 {
+		return fmt.Errorf("invalid input: retry policy values must be positive")
+	}
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = 5
+	}
+	if p.InitialBackoff == 0 {
+		p.InitialBackoff = 20 * time.Millisecond
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = time.Second
+	}
+	if p.Context == nil {
+		p.Context = context.Background()
+	}
+	return nil
+}
+
+// RetryStats counts the activity of a [Retrier].
+type RetryStats struct {
+	// Commands is the number of commands sent, regardless of the number of attempts.
+	Commands uint64
+	// Retries is the number of additional attempts.
+	Retries uint64
+	// GaveUp is the number of commands still failing with a transient warning after the last attempt.
+	GaveUp uint64
+}
+
+// Retrier is a [transport.TPMCloser] which sends a command again when the TPM
+// answers with a transient warning (i.e. TPM_RC_RETRY, TPM_RC_YIELDED or TPM_RC_TESTING).
+//
+// [OpenTPM] wraps every TPM with a Retrier using the default [RetryPolicy].
+type Retrier struct {
+	tpm    transport.TPMCloser
+	policy RetryPolicy
+	sleep  func(ctx context.Context, d time.Duration) error
+
+	commands atomic.Uint64
+	retries  atomic.Uint64
+	gaveUp   atomic.Uint64
+}
+
+// NewRetrier returns a [Retrier] sending commands to tpm according to policy.
+func NewRetrier(tpm transport.TPMCloser, policy RetryPolicy) (*Retrier, error) {
+	if err := policy.CheckAndSetDefaults(); err != nil {
+		return nil, err
+	}
+	return &Retrier{tpm: tpm, policy: policy, sleep: sleep}, nil
+}
+
+// Send implements [transport.TPM].
+func (r *Retrier) Send(cmd []byte) ([]byte, error) {
+	r.commands.Add(1)
+	ctx := r.policy.Context
+	backoff := r.policy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("failed to send %s: %w", commandNameOf(cmd), err)
+		}
+		rsp, err := r.tpm.Send(cmd)
+		if err != nil || !isTransient(rsp) {
+			return rsp, err
+		}
+		if attempt >= r.policy.MaxAttempts {
+			r.gaveUp.Add(1)
+			return rsp, nil
+		}
+		if err := r.sleep(ctx, backoff); err != nil {
+			return nil, fmt.Errorf("failed to send %s: %w", commandNameOf(cmd), err)
+		}
+		backoff = min(2*backoff, r.policy.MaxBackoff)
+		r.retries.Add(1)
+	}
+}
+
+// Stats returns the counters of the retrier.
+func (r *Retrier) Stats() RetryStats {
+	return RetryStats{
+		Commands: r.commands.Load(),
+		Retries:  r.retries.Load(),
+		GaveUp:   r.gaveUp.Load(),
+	}
+}
+
+// Close closes the underlying TPM.
+func (r *Retrier) Close() error {
+	return r.tpm.Close()
+}
+
+func isTransient(rsp []byte) bool {
+	rc, err := responseCode(rsp)
+	if err != nil || rc == tpm2.TPMRCSuccess {
+		return false
+	}
+	for _, warning := range transientWarnings {
+		if errors.Is(rc, warning) {
+			return true
+		}
+	}
+	return false
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,98 @@
+package tpmutil
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/loicsikidi/go-tpm-kit/tpmtest"
+)
+
+// newTestRetrier returns a retrier which records its backoffs instead of sleeping.
+func newTestRetrier(t *testing.T, policy RetryPolicy, faults ...Fault) (*Retrier, *[]time.Duration) {
+	t.Helper()
+	retrier, err := NewRetrier(NewFaultInjector(tpmtest.OpenSimulator(t), faults...), policy)
+	if err != nil {
+		t.Fatalf("NewRetrier() error = %v", err)
+	}
+	var backoffs []time.Duration
+	retrier.sleep = func(ctx context.Context, d time.Duration) error {
+		backoffs = append(backoffs, d)
+		return ctx.Err()
+	}
+	return retrier, &backoffs
+}
+
+func TestRetrierRetriesTransientWarnings(t *testing.T) {
+	retrier, backoffs := newTestRetrier(t, RetryPolicy{},
+		Fault{CommandCode: tpm2.TPMCCGetRandom, Nth: 1, ResponseCode: tpm2.TPMRCRetry},
+		Fault{CommandCode: tpm2.TPMCCGetRandom, Nth: 2, ResponseCode: tpm2.TPMRCYielded},
+		Fault{CommandCode: tpm2.TPMCCGetRandom, Nth: 3, ResponseCode: tpm2.TPMRCTesting},
+	)
+
+	if _, err := (tpm2.GetRandom{BytesRequested: 8}).Execute(retrier); err != nil {
+		t.Fatalf("GetRandom() error = %v", err)
+	}
+	want := []time.Duration{20 * time.Millisecond, 40 * time.Millisecond, 80 * time.Millisecond}
+	if !slices.Equal(*backoffs, want) {
+		t.Errorf("backoffs = %v, want %v", *backoffs, want)
+	}
+	if got, want := retrier.Stats(), (RetryStats{Commands: 1, Retries: 3}); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRetrierGivesUp(t *testing.T) {
+	retrier, backoffs := newTestRetrier(t, RetryPolicy{MaxAttempts: 4, InitialBackoff: 300 * time.Millisecond},
+		Fault{CommandCode: tpm2.TPMCCGetRandom, ResponseCode: tpm2.TPMRCRetry},
+	)
+
+	_, err := tpm2.GetRandom{BytesRequested: 8}.Execute(retrier)
+	if !errors.Is(err, tpm2.TPMRCRetry) {
+		t.Fatalf("GetRandom() error = %v, want %v", err, tpm2.TPMRCRetry)
+	}
+	want := []time.Duration{300 * time.Millisecond, 600 * time.Millisecond, time.Second}
+	if !slices.Equal(*backoffs, want) {
+		t.Errorf("backoffs = %v, want %v (capped by MaxBackoff)", *backoffs, want)
+	}
+	if got, want := retrier.Stats(), (RetryStats{Commands: 1, Retries: 3, GaveUp: 1}); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRetrierIgnoresOtherErrors(t *testing.T) {
+	retrier, backoffs := newTestRetrier(t, RetryPolicy{},
+		Fault{CommandCode: tpm2.TPMCCGetRandom, Nth: 1, ResponseCode: tpm2.TPMRCLockout},
+	)
+
+	if _, err := (tpm2.GetRandom{BytesRequested: 8}).Execute(retrier); !errors.Is(err, tpm2.TPMRCLockout) {
+		t.Fatalf("GetRandom() error = %v, want %v", err, tpm2.TPMRCLockout)
+	}
+	if len(*backoffs) != 0 {
+		t.Errorf("backoffs = %v, want none", *backoffs)
+	}
+	if got, want := retrier.Stats(), (RetryStats{Commands: 1}); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRetrierContextCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	retrier, _ := newTestRetrier(t, RetryPolicy{Context: ctx},
+		Fault{CommandCode: tpm2.TPMCCGetRandom, Nth: 1, ResponseCode: tpm2.TPMRCRetry},
+	)
+	cancel()
+
+	if _, err := (tpm2.GetRandom{BytesRequested: 8}).Execute(retrier); !errors.Is(err, context.Canceled) {
+		t.Fatalf("GetRandom() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestRetryPolicyCheckAndSetDefaults(t *testing.T) {
+	if err := (&RetryPolicy{MaxAttempts: -1}).CheckAndSetDefaults(); err == nil {
+		t.Error("CheckAndSetDefaults() error = nil, want error for a negative value")
+	}
+}
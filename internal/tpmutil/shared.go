@@ -0,0 +1,204 @@
+package tpmutil
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+// ErrHandleNotOwned is returned when a [Caller] uses a handle loaded by another caller.
+var ErrHandleNotOwned = errors.New("handle owned by another caller")
+
+// SharedTPM is a TPM connection which can be used by several goroutines.
+//
+// Commands are serialised so that the TPM only ever processes one command at a time.
+// Each goroutine should work through its own [Caller], which tracks the transient
+// objects and sessions it loads and flushes them once closed.
+//
+// A TPM only holds a few transient objects at once (e.g. 3 for the simulator), so
+// operations loading objects (e.g. [LoadKey] followed by a signature) should run
+// with [SharedTPM.Do], which grants exclusive access for the whole operation.
+type SharedTPM struct {
+	tpm transport.TPMCloser
+
+	// op serialises operations run with Do and the commands sent outside of them
+	op sync.Mutex
+
+	// mu serialises commands and guards the fields below
+	mu     sync.Mutex
+	owners map[tpm2.TPMHandle]*Caller
+	closed bool
+}
+
+// NewSharedTPM returns a [SharedTPM] sending commands to tpm.
+func NewSharedTPM(tpm transport.TPMCloser) *SharedTPM {
+	return &SharedTPM{
+		tpm:    tpm,
+		owners: make(map[tpm2.TPMHandle]*Caller),
+	}
+}
+
+// Caller returns a new [Caller] of the shared TPM.
+func (s *SharedTPM) Caller() *Caller {
+	return &Caller{shared: s}
+}
+
+// Do runs fn with exclusive access to the TPM.
+//
+// fn is given a dedicated [Caller], so every transient handle it leaves behind is flushed once it returns.
+func (s *SharedTPM) Do(fn func(tpm transport.TPM) error) error {
+	s.op.Lock()
+	defer s.op.Unlock()
+
+	caller := &Caller{shared: s, exclusive: true}
+	err := fn(caller)
+	if closeErr := caller.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Close flushes the handles still owned by callers and closes the underlying TPM.
+func (s *SharedTPM) Close() error {
+	s.op.Lock()
+	defer s.op.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	var errs []error
+	for _, h := range slices.Sorted(maps.Keys(s.owners)) {
+		if _, err := (tpm2.FlushContext{FlushHandle: h}).Execute(s.tpm); err != nil {
+			errs = append(errs, fmt.Errorf("failed to flush handle 0x%x: %w", h, err))
+		}
+	}
+	clear(s.owners)
+	errs = append(errs, s.tpm.Close())
+	return errors.Join(errs...)
+}
+
+// send transmits cmd on behalf of caller and updates the ownership of handles.
+func (s *SharedTPM) send(caller *Caller, cmd []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, errors.New("shared tpm is closed")
+	}
+
+	cc, err := commandCode(cmd)
+	if err != nil {
+		return nil, err
+	}
+	handles, sessions, _ := parseCommand(cmd)
+	if cc == tpm2.TPMCCFlushContext {
+		handles = append(handles, flushedHandle(cmd))
+	}
+	for _, session := range sessions {
+		handles = append(handles, session.handle)
+	}
+	for _, h := range handles {
+		if owner, ok := s.owners[h]; ok && owner != caller {
+			return nil, fmt.Errorf("%w: %s uses 0x%x", ErrHandleNotOwned, CommandName(cc), h)
+		}
+	}
+
+	rsp, err := s.tpm.Send(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if rc, err := responseCode(rsp); err != nil || rc != tpm2.TPMRCSuccess {
+		return rsp, nil
+	}
+	for _, h := range responseHandles(cc, rsp) {
+		if isFlushable(h) {
+			s.owners[h] = caller
+		}
+	}
+	if cc == tpm2.TPMCCFlushContext {
+		delete(s.owners, flushedHandle(cmd))
+	}
+	// the TPM flushes the sessions used without continueSession, e.g. the one-shot sessions of go-tpm
+	if continued, ok := responseSessions(cc, rsp); ok && len(continued) == len(sessions) {
+		for i, session := range sessions {
+			if !continued[i] {
+				delete(s.owners, session.handle)
+			}
+		}
+	}
+	return rsp, nil
+}
+
+// owned returns the handles owned by caller.
+func (s *SharedTPM) owned(caller *Caller) []tpm2.TPMHandle {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var handles []tpm2.TPMHandle
+	for h, owner := range s.owners {
+		if owner == caller {
+			handles = append(handles, h)
+		}
+	}
+	slices.Sort(handles)
+	return handles
+}
+
+// Caller is a [transport.TPMCloser] sending commands through a [SharedTPM] on behalf of a single goroutine.
+type Caller struct {
+	shared *SharedTPM
+	// exclusive is set for the caller of [SharedTPM.Do], which already holds the operation lock
+	exclusive bool
+}
+
+// Send implements [transport.TPM].
+//
+// It waits for the operation run with [SharedTPM.Do], if any, and fails with [ErrHandleNotOwned]
+// when the command uses a transient object or a session loaded by another caller.
+func (c *Caller) Send(cmd []byte) ([]byte, error) {
+	if !c.exclusive {
+		c.shared.op.Lock()
+		defer c.shared.op.Unlock()
+	}
+	return c.shared.send(c, cmd)
+}
+
+// Handles returns the transient objects and sessions owned by the caller.
+func (c *Caller) Handles() []tpm2.TPMHandle {
+	return c.shared.owned(c)
+}
+
+// Close flushes the handles owned by the caller. The shared TPM remains open.
+func (c *Caller) Close() error {
+	var errs []error
+	for _, h := range c.Handles() {
+		if _, err := (tpm2.FlushContext{FlushHandle: h}).Execute(c); err != nil {
+			errs = append(errs, fmt.Errorf("failed to flush handle 0x%x: %w", h, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// flushedHandle returns the handle of a TPM2_FlushContext command, which is a parameter.
+func flushedHandle(cmd []byte) tpm2.TPMHandle {
+	if len(cmd) < headerSize+4 {
+		return 0
+	}
+	return tpm2.TPMHandle(binary.BigEndian.Uint32(cmd[headerSize:]))
+}
+
+// isFlushable reports whether h designates a transient object or a session.
+func isFlushable(h tpm2.TPMHandle) bool {
+	switch tpm2.TPMHT(h >> 24) {
+	case tpm2.TPMHTTransient, tpm2.TPMHTHMACSession, tpm2.TPMHTPolicySession:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,275 @@
+package tpmutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/go-tpm-kit/tpmcrypto"
+	"github.com/loicsikidi/go-tpm-kit/tpmtest"
+)
+
+// nopCloser leaves the closing of the simulator to tpmtest.
+type nopCloser struct {
+	transport.TPM
+}
+
+func (nopCloser) Close() error { return nil }
+
+func sign(tpm transport.TPM, keyPath string, message []byte) error {
+	keyHandle, err := LoadKey(tpm, LoadKeyConfig{
+		ParentTemplate: ECCSRKTemplate,
+		KeyBlobPath:    keyPath,
+	})
+	if err != nil {
+		return err
+	}
+	defer keyHandle.Close()
+
+	digest := sha256.Sum256(message)
+	rsp, err := tpm2.Sign{
+		KeyHandle:  ToAuthHandle(keyHandle),
+		Digest:     tpm2.TPM2BDigest{Buffer: digest[:]},
+		Validation: tpm2.TPMTTKHashCheck{Tag: tpm2.TPMSTHashCheck, Hierarchy: tpm2.TPMRHNull},
+	}.Execute(tpm)
+	if err != nil {
+		return fmt.Errorf("failed to sign: %w", err)
+	}
+	sig, err := rsp.Signature.Signature.ECDSA()
+	if err != nil {
+		return err
+	}
+	pub, err := tpmcrypto.PublicKey(keyHandle.Public())
+	if err != nil {
+		return err
+	}
+	r := new(big.Int).SetBytes(sig.SignatureR.Buffer)
+	s := new(big.Int).SetBytes(sig.SignatureS.Buffer)
+	if !ecdsa.Verify(pub.(*ecdsa.PublicKey), digest[:], r, s) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+func unseal(tpm transport.TPM, sealedPath string) ([]byte, error) {
+	keyHandle, err := LoadKey(tpm, LoadKeyConfig{
+		ParentTemplate: ECCSRKTemplate,
+		KeyBlobPath:    sealedPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer keyHandle.Close()
+	return Unseal(tpm, UnsealConfig{KeyHandle: keyHandle})
+}
+
+func TestSharedTPMConcurrentSignUnseal(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	keyPath := createTestKey(t, tpm)
+	sealedPath := filepath.Join(t.TempDir(), "sealed.tpm")
+	secret := []byte("shared secret")
+	if err := Seal(tpm, SealConfig{
+		ParentTemplate: ECCSRKTemplate,
+		Message:        secret,
+		OutputFilePath: sealedPath,
+	}); err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+
+	shared := NewSharedTPM(nopCloser{tpm})
+	const workers, iterations = 16, 5
+	var wg sync.WaitGroup
+	errs := make(chan error, 3*workers*iterations)
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range iterations {
+				// commands which don't load anything can be sent concurrently
+				if _, err := (tpm2.GetRandom{BytesRequested: 8}).Execute(shared.Caller()); err != nil {
+					errs <- fmt.Errorf("GetRandom: %w", err)
+				}
+				errs <- shared.Do(func(tpm transport.TPM) error {
+					if (w+i)%2 == 0 {
+						return sign(tpm, keyPath, fmt.Appendf(nil, "message %d-%d", w, i))
+					}
+					got, err := unseal(tpm, sealedPath)
+					if err != nil {
+						return err
+					}
+					if string(got) != string(secret) {
+						return fmt.Errorf("unsealed %q, want %q", got, secret)
+					}
+					return nil
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if handles := transientHandles(t, tpm); len(handles) != 0 {
+		t.Errorf("transient handles left in the TPM: %v", handles)
+	}
+}
+
+func TestSharedTPMOwnership(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	keyPath := createTestKey(t, tpm)
+	shared := NewSharedTPM(nopCloser{tpm})
+
+	owner := shared.Caller()
+	keyHandle, err := LoadKey(owner, LoadKeyConfig{
+		ParentTemplate: ECCSRKTemplate,
+		KeyBlobPath:    keyPath,
+	})
+	if err != nil {
+		t.Fatalf("LoadKey() error = %v", err)
+	}
+	if got := owner.Handles(); len(got) != 1 || got[0] != keyHandle.Handle() {
+		t.Fatalf("Handles() = %v, want [0x%x]", got, keyHandle.Handle())
+	}
+
+	other := shared.Caller()
+	_, err = tpm2.ReadPublic{ObjectHandle: keyHandle.Handle()}.Execute(other)
+	if !errors.Is(err, ErrHandleNotOwned) {
+		t.Errorf("ReadPublic() from another caller error = %v, want %v", err, ErrHandleNotOwned)
+	}
+	_, err = tpm2.FlushContext{FlushHandle: keyHandle.Handle()}.Execute(other)
+	if !errors.Is(err, ErrHandleNotOwned) {
+		t.Errorf("FlushContext() from another caller error = %v, want %v", err, ErrHandleNotOwned)
+	}
+	if len(other.Handles()) != 0 {
+		t.Errorf("other Handles() = %v, want none", other.Handles())
+	}
+
+	// the owner flushes what it forgot
+	if err := owner.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if handles := transientHandles(t, tpm); len(handles) != 0 {
+		t.Errorf("transient handles left in the TPM: %v", handles)
+	}
+}
+
+func TestSharedTPMFlushesLeakedHandles(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	keyPath := createTestKey(t, tpm)
+	shared := NewSharedTPM(nopCloser{tpm})
+
+	// Do flushes the handles left behind by the operation
+	if err := shared.Do(func(tpm transport.TPM) error {
+		_, err := LoadKey(tpm, LoadKeyConfig{ParentTemplate: ECCSRKTemplate, KeyBlobPath: keyPath})
+		return err
+	}); err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if handles := transientHandles(t, tpm); len(handles) != 0 {
+		t.Errorf("transient handles left by Do: %v", handles)
+	}
+
+	// Close flushes the handles left behind by any caller
+	if _, err := LoadKey(shared.Caller(), LoadKeyConfig{ParentTemplate: ECCSRKTemplate, KeyBlobPath: keyPath}); err != nil {
+		t.Fatalf("LoadKey() error = %v", err)
+	}
+	if err := shared.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if handles := transientHandles(t, tpm); len(handles) != 0 {
+		t.Errorf("transient handles left by Close: %v", handles)
+	}
+	if _, err := (tpm2.GetRandom{BytesRequested: 8}).Execute(shared.Caller()); err == nil {
+		t.Error("GetRandom() after Close error = nil, want error")
+	}
+}
+
+func TestSharedTPMDoIsExclusive(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	shared := NewSharedTPM(nopCloser{tpm})
+
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- shared.Do(func(tpm transport.TPM) error {
+			close(started)
+			<-release
+			_, err := (tpm2.GetRandom{BytesRequested: 8}).Execute(tpm)
+			return err
+		})
+	}()
+	<-started
+
+	// the commands of other callers wait for the operation to complete
+	sent := make(chan error, 1)
+	go func() {
+		_, err := (tpm2.GetRandom{BytesRequested: 8}).Execute(shared.Caller())
+		sent <- err
+	}()
+	select {
+	case err := <-sent:
+		t.Fatalf("GetRandom() ran during Do, error = %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if err := <-sent; err != nil {
+		t.Fatalf("GetRandom() error = %v", err)
+	}
+}
+
+func TestSharedTPMSessionOwnership(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	shared := NewSharedTPM(nopCloser{tpm})
+	createPrimary := func(caller *Caller, session tpm2.Session) error {
+		rsp, err := tpm2.CreatePrimary{
+			PrimaryHandle: tpm2.AuthHandle{Handle: tpm2.TPMRHOwner, Auth: session},
+			InPublic:      tpm2.New2B(ECCSRKTemplate),
+		}.Execute(caller)
+		if err != nil {
+			return err
+		}
+		_, err = tpm2.FlushContext{FlushHandle: rsp.ObjectHandle}.Execute(caller)
+		return err
+	}
+
+	// a session of the authorization area belongs to the caller which started it
+	owner, other := shared.Caller(), shared.Caller()
+	session, closer, err := tpm2.HMACSession(owner, tpm2.TPMAlgSHA256, 16)
+	if err != nil {
+		t.Fatalf("HMACSession() error = %v", err)
+	}
+	if err := createPrimary(other, session); !errors.Is(err, ErrHandleNotOwned) {
+		t.Errorf("CreatePrimary() with the session of another caller error = %v, want %v", err, ErrHandleNotOwned)
+	}
+	if err := createPrimary(owner, session); err != nil {
+		t.Errorf("CreatePrimary() error = %v", err)
+	}
+	if err := closer(); err != nil {
+		t.Fatalf("failed to flush session: %v", err)
+	}
+
+	// a one-shot session is flushed by the TPM itself: the caller no longer owns it
+	if err := createPrimary(owner, tpm2.HMAC(tpm2.TPMAlgSHA256, 16)); err != nil {
+		t.Fatalf("CreatePrimary() error = %v", err)
+	}
+	if got := owner.Handles(); len(got) != 0 {
+		t.Errorf("Handles() = %v, want none", got)
+	}
+	if err := owner.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
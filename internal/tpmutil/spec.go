@@ -0,0 +1,471 @@
+package tpmutil
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/google/go-tpm/tpm2"
+)
+
+// TemplateSpec is a declarative description of a key template, meant to be stored as JSON.
+//
+// Algorithms, curves and attributes are designated by their tpm2-tools names (see [AlgName],
+// [CurveName]) and binary values are hex encoded. Omitted fields keep their zero value,
+// which the TPM interprets as TPM_ALG_NULL for schemes and symmetric definitions.
+//
+// Example:
+//
+//	{
+//	  "type": "ecc",
+//	  "nameAlg": "sha256",
+//	  "attributes": "fixedtpm|fixedparent|sensitivedataorigin|userwithauth|sign",
+//	  "scheme": {"alg": "ecdsa", "hash": "sha256"},
+//	  "curve": "nist_p256"
+//	}
+type TemplateSpec struct {
+	// Type is the object type: 'rsa', 'ecc', 'keyedhash' or 'symcipher'.
+	Type    string `json:"type"`
+	NameAlg string `json:"nameAlg"`
+	// Attributes are written as tpm2-tools does (see [ParseAttributes]).
+	Attributes string `json:"attributes,omitempty"`
+	// AuthPolicy is the hex encoded policy digest.
+	AuthPolicy string      `json:"authPolicy,omitempty"`
+	Scheme     *SchemeSpec `json:"scheme,omitempty"`
+	// KeyBits and Exponent only apply to RSA keys.
+	KeyBits  uint16 `json:"keyBits,omitempty"`
+	Exponent uint32 `json:"exponent,omitempty"`
+	// Curve only applies to ECC keys.
+	Curve string `json:"curve,omitempty"`
+	// Symmetric is the cipher protecting the children of a storage key,
+	// or the cipher itself for a 'symcipher' key.
+	Symmetric *SymmetricSpec `json:"symmetric,omitempty"`
+	Unique    *UniqueSpec    `json:"unique,omitempty"`
+}
+
+// SchemeSpec describes a signing, decryption or HMAC scheme (e.g. ecdsa with sha256).
+type SchemeSpec struct {
+	Alg  string `json:"alg"`
+	Hash string `json:"hash,omitempty"`
+}
+
+// SymmetricSpec describes a block cipher (e.g. aes 128 cfb).
+type SymmetricSpec struct {
+	Alg     string `json:"alg"`
+	KeyBits uint16 `json:"keyBits"`
+	Mode    string `json:"mode"`
+}
+
+// UniqueSpec holds the hex encoded unique field of a template.
+// X and Y apply to ECC keys while Data applies to the other types.
+type UniqueSpec struct {
+	X    string `json:"x,omitempty"`
+	Y    string `json:"y,omitempty"`
+	Data string `json:"data,omitempty"`
+}
+
+// BuiltinTemplates holds the templates shipped with tpm-pills, by name.
+var BuiltinTemplates = map[string]tpm2.TPMTPublic{
+	"ecc-signer":            ECCSignerTemplate,
+	"ecc-restricted-signer": ECCRestrictedSignerTemplate,
+	"ecc-srk":               ECCSRKTemplate,
+	"rsa-encrypt":           RSAEncryptTemplate,
+	"aes128-cfb":            AES128CFBTemplate,
+	"seal":                  SealTemplate,
+}
+
+// BuiltinTemplateNames returns the sorted names of [BuiltinTemplates].
+func BuiltinTemplateNames() []string {
+	names := make([]string, 0, len(BuiltinTemplates))
+	for name := range BuiltinTemplates {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// LoadTemplateSpec reads a [TemplateSpec] from a JSON file.
+func LoadTemplateSpec(path string) (*TemplateSpec, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template: %w", err)
+	}
+	var spec TemplateSpec
+	if err := json.Unmarshal(b, &spec); err != nil {
+		return nil, fmt.Errorf("failed to decode template %q: %w", path, err)
+	}
+	return &spec, nil
+}
+
+// LoadTemplate reads a template from a JSON file (see [TemplateSpec]).
+func LoadTemplate(path string) (tpm2.TPMTPublic, error) {
+	spec, err := LoadTemplateSpec(path)
+	if err != nil {
+		return tpm2.TPMTPublic{}, err
+	}
+	template, err := spec.Template()
+	if err != nil {
+		return tpm2.TPMTPublic{}, fmt.Errorf("invalid template %q: %w", path, err)
+	}
+	return template, nil
+}
+
+// Template converts the specification into a TPM template.
+func (s *TemplateSpec) Template() (tpm2.TPMTPublic, error) {
+	var pub tpm2.TPMTPublic
+	var err error
+	if pub.Type, err = ParseAlg(s.Type); err != nil {
+		return pub, fmt.Errorf("invalid type: %w", err)
+	}
+	if pub.NameAlg, err = ParseAlg(s.NameAlg); err != nil {
+		return pub, fmt.Errorf("invalid nameAlg: %w", err)
+	}
+	if s.Attributes != "" {
+		if pub.ObjectAttributes, err = ParseAttributes(s.Attributes); err != nil {
+			return pub, err
+		}
+	}
+	if pub.AuthPolicy.Buffer, err = hex.DecodeString(s.AuthPolicy); err != nil {
+		return pub, fmt.Errorf("invalid authPolicy: %w", err)
+	}
+
+	switch pub.Type {
+	case tpm2.TPMAlgRSA:
+		err = s.rsa(&pub)
+	case tpm2.TPMAlgECC:
+		err = s.ecc(&pub)
+	case tpm2.TPMAlgKeyedHash:
+		err = s.keyedHash(&pub)
+	case tpm2.TPMAlgSymCipher:
+		err = s.symCipher(&pub)
+	default:
+		err = fmt.Errorf("invalid type: unsupported %q (expected rsa, ecc, keyedhash or symcipher)", s.Type)
+	}
+	return pub, err
+}
+
+func (s *TemplateSpec) rsa(pub *tpm2.TPMTPublic) error {
+	if s.KeyBits == 0 {
+		return fmt.Errorf("missing keyBits")
+	}
+	sym, err := s.Symmetric.symDef()
+	if err != nil {
+		return err
+	}
+	params := &tpm2.TPMSRSAParms{
+		Symmetric: sym,
+		KeyBits:   tpm2.TPMKeyBits(s.KeyBits),
+		Exponent:  s.Exponent,
+	}
+	if s.Scheme != nil {
+		alg, details, err := s.Scheme.asymScheme()
+		if err != nil {
+			return err
+		}
+		params.Scheme = tpm2.TPMTRSAScheme{Scheme: alg, Details: details}
+	}
+	pub.Parameters = tpm2.NewTPMUPublicParms(tpm2.TPMAlgRSA, params)
+
+	unique, err := s.Unique.data()
+	if err != nil {
+		return err
+	}
+	pub.Unique = tpm2.NewTPMUPublicID(tpm2.TPMAlgRSA, &tpm2.TPM2BPublicKeyRSA{Buffer: unique})
+	return nil
+}
+
+func (s *TemplateSpec) ecc(pub *tpm2.TPMTPublic) error {
+	if s.Curve == "" {
+		return fmt.Errorf("missing curve")
+	}
+	curve, err := ParseCurve(s.Curve)
+	if err != nil {
+		return err
+	}
+	sym, err := s.Symmetric.symDef()
+	if err != nil {
+		return err
+	}
+	params := &tpm2.TPMSECCParms{
+		Symmetric: sym,
+		CurveID:   curve,
+	}
+	if s.Scheme != nil {
+		alg, details, err := s.Scheme.asymScheme()
+		if err != nil {
+			return err
+		}
+		params.Scheme = tpm2.TPMTECCScheme{Scheme: alg, Details: details}
+	}
+	pub.Parameters = tpm2.NewTPMUPublicParms(tpm2.TPMAlgECC, params)
+
+	var point tpm2.TPMSECCPoint
+	if s.Unique != nil {
+		if point.X.Buffer, err = hex.DecodeString(s.Unique.X); err != nil {
+			return fmt.Errorf("invalid unique.x: %w", err)
+		}
+		if point.Y.Buffer, err = hex.DecodeString(s.Unique.Y); err != nil {
+			return fmt.Errorf("invalid unique.y: %w", err)
+		}
+	}
+	pub.Unique = tpm2.NewTPMUPublicID(tpm2.TPMAlgECC, &point)
+	return nil
+}
+
+func (s *TemplateSpec) keyedHash(pub *tpm2.TPMTPublic) error {
+	params := &tpm2.TPMSKeyedHashParms{}
+	if s.Scheme != nil {
+		alg, hash, err := s.Scheme.parse()
+		if err != nil {
+			return err
+		}
+		if alg != tpm2.TPMAlgHMAC {
+			return fmt.Errorf("invalid scheme: unsupported %q for a keyedhash (expected hmac)", s.Scheme.Alg)
+		}
+		params.Scheme = tpm2.TPMTKeyedHashScheme{
+			Scheme:  alg,
+			Details: tpm2.NewTPMUSchemeKeyedHash(alg, &tpm2.TPMSSchemeHMAC{HashAlg: hash}),
+		}
+	}
+	pub.Parameters = tpm2.NewTPMUPublicParms(tpm2.TPMAlgKeyedHash, params)
+
+	unique, err := s.Unique.data()
+	if err != nil {
+		return err
+	}
+	pub.Unique = tpm2.NewTPMUPublicID(tpm2.TPMAlgKeyedHash, &tpm2.TPM2BDigest{Buffer: unique})
+	return nil
+}
+
+func (s *TemplateSpec) symCipher(pub *tpm2.TPMTPublic) error {
+	if s.Symmetric == nil {
+		return fmt.Errorf("missing symmetric")
+	}
+	sym, err := s.Symmetric.symDef()
+	if err != nil {
+		return err
+	}
+	pub.Parameters = tpm2.NewTPMUPublicParms(tpm2.TPMAlgSymCipher, &tpm2.TPMSSymCipherParms{Sym: sym})
+
+	unique, err := s.Unique.data()
+	if err != nil {
+		return err
+	}
+	pub.Unique = tpm2.NewTPMUPublicID(tpm2.TPMAlgSymCipher, &tpm2.TPM2BDigest{Buffer: unique})
+	return nil
+}
+
+// parse returns the scheme algorithm and its hash algorithm.
+func (s *SchemeSpec) parse() (alg, hash tpm2.TPMAlgID, err error) {
+	if alg, err = ParseAlg(s.Alg); err != nil {
+		return 0, 0, fmt.Errorf("invalid scheme: %w", err)
+	}
+	if s.Hash != "" {
+		if hash, err = ParseAlg(s.Hash); err != nil {
+			return 0, 0, fmt.Errorf("invalid scheme hash: %w", err)
+		}
+	}
+	return alg, hash, nil
+}
+
+// asymScheme returns the selector and the details of an RSA or ECC scheme.
+func (s *SchemeSpec) asymScheme() (tpm2.TPMAlgID, tpm2.TPMUAsymScheme, error) {
+	alg, hash, err := s.parse()
+	if err != nil {
+		return 0, tpm2.TPMUAsymScheme{}, err
+	}
+	var details tpm2.TPMUAsymScheme
+	switch alg {
+	case tpm2.TPMAlgNull:
+		return alg, details, nil
+	case tpm2.TPMAlgRSAES:
+		return alg, tpm2.NewTPMUAsymScheme(alg, &tpm2.TPMSEncSchemeRSAES{}), nil
+	case tpm2.TPMAlgRSASSA:
+		details = tpm2.NewTPMUAsymScheme(alg, &tpm2.TPMSSigSchemeRSASSA{HashAlg: hash})
+	case tpm2.TPMAlgRSAPSS:
+		details = tpm2.NewTPMUAsymScheme(alg, &tpm2.TPMSSigSchemeRSAPSS{HashAlg: hash})
+	case tpm2.TPMAlgOAEP:
+		details = tpm2.NewTPMUAsymScheme(alg, &tpm2.TPMSEncSchemeOAEP{HashAlg: hash})
+	case tpm2.TPMAlgECDSA:
+		details = tpm2.NewTPMUAsymScheme(alg, &tpm2.TPMSSigSchemeECDSA{HashAlg: hash})
+	case tpm2.TPMAlgECDH:
+		details = tpm2.NewTPMUAsymScheme(alg, &tpm2.TPMSKeySchemeECDH{HashAlg: hash})
+	default:
+		return 0, details, fmt.Errorf("invalid scheme: unsupported %q", s.Alg)
+	}
+	if hash == 0 {
+		return 0, details, fmt.Errorf("invalid scheme: %s requires a hash", s.Alg)
+	}
+	return alg, details, nil
+}
+
+// symDef returns the symmetric definition described by s, which may be nil.
+func (s *SymmetricSpec) symDef() (tpm2.TPMTSymDefObject, error) {
+	if s == nil {
+		return tpm2.TPMTSymDefObject{}, nil
+	}
+	alg, err := ParseAlg(s.Alg)
+	if err != nil {
+		return tpm2.TPMTSymDefObject{}, fmt.Errorf("invalid symmetric: %w", err)
+	}
+	if alg == tpm2.TPMAlgNull {
+		return tpm2.TPMTSymDefObject{Algorithm: alg}, nil
+	}
+	mode, err := ParseAlg(s.Mode)
+	if err != nil {
+		return tpm2.TPMTSymDefObject{}, fmt.Errorf("invalid symmetric mode: %w", err)
+	}
+	return tpm2.TPMTSymDefObject{
+		Algorithm: alg,
+		KeyBits:   tpm2.NewTPMUSymKeyBits(alg, tpm2.TPMKeyBits(s.KeyBits)),
+		Mode:      tpm2.NewTPMUSymMode(alg, mode),
+	}, nil
+}
+
+// data returns the decoded unique data, u may be nil.
+func (u *UniqueSpec) data() ([]byte, error) {
+	if u == nil {
+		return nil, nil
+	}
+	b, err := hex.DecodeString(u.Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid unique.data: %w", err)
+	}
+	return b, nil
+}
+
+// NewTemplateSpec describes an existing template (e.g. one of [BuiltinTemplates]).
+func NewTemplateSpec(template tpm2.TPMTPublic) (*TemplateSpec, error) {
+	// a marshalling round trip makes every union of the template readable
+	pub, err := tpm2.Unmarshal[tpm2.TPMTPublic](tpm2.Marshal(template))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode template: %w", err)
+	}
+	spec := &TemplateSpec{
+		Type:       AlgName(pub.Type),
+		NameAlg:    AlgName(pub.NameAlg),
+		Attributes: FormatAttributes(pub.ObjectAttributes),
+		AuthPolicy: hex.EncodeToString(pub.AuthPolicy.Buffer),
+	}
+
+	switch pub.Type {
+	case tpm2.TPMAlgRSA:
+		params, err := pub.Parameters.RSADetail()
+		if err != nil {
+			return nil, err
+		}
+		spec.KeyBits = uint16(params.KeyBits)
+		spec.Exponent = params.Exponent
+		spec.Symmetric = newSymmetricSpec(params.Symmetric)
+		spec.Scheme = newAsymSchemeSpec(params.Scheme.Scheme, params.Scheme.Details)
+		unique, err := pub.Unique.RSA()
+		if err != nil {
+			return nil, err
+		}
+		spec.Unique = newUniqueSpec(unique.Buffer)
+	case tpm2.TPMAlgECC:
+		params, err := pub.Parameters.ECCDetail()
+		if err != nil {
+			return nil, err
+		}
+		spec.Curve = CurveName(params.CurveID)
+		spec.Symmetric = newSymmetricSpec(params.Symmetric)
+		spec.Scheme = newAsymSchemeSpec(params.Scheme.Scheme, params.Scheme.Details)
+		point, err := pub.Unique.ECC()
+		if err != nil {
+			return nil, err
+		}
+		if len(point.X.Buffer) > 0 || len(point.Y.Buffer) > 0 {
+			spec.Unique = &UniqueSpec{
+				X: hex.EncodeToString(point.X.Buffer),
+				Y: hex.EncodeToString(point.Y.Buffer),
+			}
+		}
+	case tpm2.TPMAlgKeyedHash:
+		params, err := pub.Parameters.KeyedHashDetail()
+		if err != nil {
+			return nil, err
+		}
+		switch params.Scheme.Scheme {
+		case tpm2.TPMAlgNull:
+		case tpm2.TPMAlgHMAC:
+			hmac, err := params.Scheme.Details.HMAC()
+			if err != nil {
+				return nil, err
+			}
+			spec.Scheme = &SchemeSpec{Alg: AlgName(tpm2.TPMAlgHMAC), Hash: AlgName(hmac.HashAlg)}
+		default:
+			return nil, fmt.Errorf("unsupported keyedhash scheme %s", AlgName(params.Scheme.Scheme))
+		}
+		unique, err := pub.Unique.KeyedHash()
+		if err != nil {
+			return nil, err
+		}
+		spec.Unique = newUniqueSpec(unique.Buffer)
+	case tpm2.TPMAlgSymCipher:
+		params, err := pub.Parameters.SymDetail()
+		if err != nil {
+			return nil, err
+		}
+		spec.Symmetric = newSymmetricSpec(params.Sym)
+		unique, err := pub.Unique.SymCipher()
+		if err != nil {
+			return nil, err
+		}
+		spec.Unique = newUniqueSpec(unique.Buffer)
+	default:
+		return nil, fmt.Errorf("unsupported type %s", AlgName(pub.Type))
+	}
+	return spec, nil
+}
+
+func newSymmetricSpec(sym tpm2.TPMTSymDefObject) *SymmetricSpec {
+	if sym.Algorithm == tpm2.TPMAlgNull {
+		return nil
+	}
+	spec := &SymmetricSpec{Alg: AlgName(sym.Algorithm)}
+	if keyBits, err := sym.KeyBits.Sym(); err == nil {
+		spec.KeyBits = uint16(*keyBits)
+	}
+	if mode, err := sym.Mode.Sym(); err == nil {
+		spec.Mode = AlgName(*mode)
+	}
+	return spec
+}
+
+func newAsymSchemeSpec(alg tpm2.TPMAlgID, details tpm2.TPMUAsymScheme) *SchemeSpec {
+	spec := &SchemeSpec{Alg: AlgName(alg)}
+	var hash *tpm2.TPMSSchemeHash
+	switch alg {
+	case tpm2.TPMAlgNull:
+		return nil
+	case tpm2.TPMAlgRSASSA:
+		s, _ := details.RSASSA()
+		hash = (*tpm2.TPMSSchemeHash)(s)
+	case tpm2.TPMAlgRSAPSS:
+		s, _ := details.RSAPSS()
+		hash = (*tpm2.TPMSSchemeHash)(s)
+	case tpm2.TPMAlgOAEP:
+		s, _ := details.OAEP()
+		hash = (*tpm2.TPMSSchemeHash)(s)
+	case tpm2.TPMAlgECDSA:
+		s, _ := details.ECDSA()
+		hash = (*tpm2.TPMSSchemeHash)(s)
+	case tpm2.TPMAlgECDH:
+		s, _ := details.ECDH()
+		hash = (*tpm2.TPMSSchemeHash)(s)
+	}
+	if hash != nil {
+		spec.Hash = AlgName(hash.HashAlg)
+	}
+	return spec
+}
+
+func newUniqueSpec(data []byte) *UniqueSpec {
+	if len(data) == 0 {
+		return nil
+	}
+	return &UniqueSpec{Data: hex.EncodeToString(data)}
+}
@@ -0,0 +1,104 @@
+package tpmutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/loicsikidi/go-tpm-kit/tpmtest"
+)
+
+func TestTemplateSpecRoundTrip(t *testing.T) {
+	for _, name := range BuiltinTemplateNames() {
+		t.Run(name, func(t *testing.T) {
+			template := BuiltinTemplates[name]
+			spec, err := NewTemplateSpec(template)
+			if err != nil {
+				t.Fatalf("NewTemplateSpec() error = %v", err)
+			}
+			b, err := json.Marshal(spec)
+			if err != nil {
+				t.Fatalf("json.Marshal() error = %v", err)
+			}
+			path := filepath.Join(t.TempDir(), name+".json")
+			if err := os.WriteFile(path, b, 0644); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+
+			got, err := LoadTemplate(path)
+			if err != nil {
+				t.Fatalf("LoadTemplate() error = %v", err)
+			}
+			if !bytes.Equal(tpm2.Marshal(got), tpm2.Marshal(template)) {
+				t.Errorf("LoadTemplate() = %x, want %x", tpm2.Marshal(got), tpm2.Marshal(template))
+			}
+		})
+	}
+}
+
+func TestTemplateSpecCreate(t *testing.T) {
+	spec := TemplateSpec{
+		Type:       "rsa",
+		NameAlg:    "sha256",
+		Attributes: "fixedtpm|fixedparent|sensitivedataorigin|userwithauth|sign",
+		Scheme:     &SchemeSpec{Alg: "rsassa", Hash: "sha256"},
+		KeyBits:    2048,
+	}
+	template, err := spec.Template()
+	if err != nil {
+		t.Fatalf("Template() error = %v", err)
+	}
+
+	tpm := tpmtest.OpenSimulator(t)
+	rsp, err := tpm2.CreatePrimary{
+		PrimaryHandle: tpm2.TPMRHOwner,
+		InPublic:      tpm2.New2B(template),
+	}.Execute(tpm)
+	if err != nil {
+		t.Fatalf("CreatePrimary() error = %v", err)
+	}
+	defer tpm2.FlushContext{FlushHandle: rsp.ObjectHandle}.Execute(tpm)
+
+	pub, err := rsp.OutPublic.Contents()
+	if err != nil {
+		t.Fatalf("OutPublic.Contents() error = %v", err)
+	}
+	got, err := NewTemplateSpec(*pub)
+	if err != nil {
+		t.Fatalf("NewTemplateSpec() error = %v", err)
+	}
+	if got.Scheme == nil || *got.Scheme != *spec.Scheme {
+		t.Errorf("scheme = %+v, want %+v", got.Scheme, spec.Scheme)
+	}
+	if got.Unique == nil || len(got.Unique.Data) != 2*256 {
+		t.Errorf("unique = %+v, want a 2048-bit modulus", got.Unique)
+	}
+}
+
+func TestTemplateSpecErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		spec TemplateSpec
+	}{
+		{name: "unknown type", spec: TemplateSpec{Type: "dsa", NameAlg: "sha256"}},
+		{name: "unsupported type", spec: TemplateSpec{Type: "aes", NameAlg: "sha256"}},
+		{name: "unknown nameAlg", spec: TemplateSpec{Type: "ecc", NameAlg: "md5", Curve: "nist_p256"}},
+		{name: "unknown attribute", spec: TemplateSpec{Type: "ecc", NameAlg: "sha256", Curve: "nist_p256", Attributes: "exportable"}},
+		{name: "missing curve", spec: TemplateSpec{Type: "ecc", NameAlg: "sha256"}},
+		{name: "missing keyBits", spec: TemplateSpec{Type: "rsa", NameAlg: "sha256"}},
+		{name: "missing scheme hash", spec: TemplateSpec{Type: "ecc", NameAlg: "sha256", Curve: "nist_p256", Scheme: &SchemeSpec{Alg: "ecdsa"}}},
+		{name: "keyedhash scheme", spec: TemplateSpec{Type: "keyedhash", NameAlg: "sha256", Scheme: &SchemeSpec{Alg: "ecdsa", Hash: "sha256"}}},
+		{name: "missing symmetric", spec: TemplateSpec{Type: "symcipher", NameAlg: "sha256"}},
+		{name: "invalid policy", spec: TemplateSpec{Type: "keyedhash", NameAlg: "sha256", AuthPolicy: "zz"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.spec.Template(); err == nil {
+				t.Errorf("Template() error = nil, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package tpmutil
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/google/go-tpm/tpm2/transport/tcp"
+)
+
+const (
+	defaultTCPHost = "localhost"
+	// defaultTCPPort is the command port used by the Microsoft reference simulator.
+	// By convention, the platform port is the next one.
+	defaultTCPPort = 2321
+)
+
+// tcpAddresses returns the command and platform addresses designated by a 'tcp:' device value.
+func tcpAddresses(value string) (cmdAddr, platAddr string, err error) {
+	host, port := defaultTCPHost, defaultTCPPort
+	if value != "" {
+		h, p, err := net.SplitHostPort(value)
+		if err != nil {
+			// no port provided, only a host
+			h, p = value, strconv.Itoa(defaultTCPPort)
+		}
+		if h != "" {
+			host = h
+		}
+		port, err = strconv.Atoi(p)
+		if err != nil || port <= 0 || port >= 65535 {
+			return "", "", fmt.Errorf("invalid port %q", p)
+		}
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port)), net.JoinHostPort(host, strconv.Itoa(port+1)), nil
+}
+
+// openTCP connects to a TPM speaking the Microsoft simulator (mssim) protocol.
+//
+// The TPM is powered on (i.e. POWER_ON and NV_ON platform commands) and started up,
+// unless it already was.
+func openTCP(value string) (transport.TPMCloser, error) {
+	cmdAddr, platAddr, err := tcpAddresses(value)
+	if err != nil {
+		return nil, err
+	}
+	tpm, err := tcp.Open(tcp.Config{
+		CommandAddress:  cmdAddr,
+		PlatformAddress: platAddr,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tpm.PowerOn(); err != nil {
+		tpm.Close()
+		return nil, fmt.Errorf("failed to power on tpm: %w", err)
+	}
+	if _, err := (tpm2.Startup{StartupType: tpm2.TPMSUClear}).Execute(tpm); err != nil && !errors.Is(err, tpm2.TPMRCInitialize) {
+		tpm.Close()
+		return nil, fmt.Errorf("failed to start up tpm: %w", err)
+	}
+	return tpm, nil
+}
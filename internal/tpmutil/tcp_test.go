@@ -0,0 +1,214 @@
+package tpmutil
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+	"github.com/loicsikidi/go-tpm-kit/tpmtest"
+)
+
+// Platform and TPM commands of the Microsoft simulator protocol used by the stand-in server.
+const (
+	mssimSendCommand = 8
+	mssimPowerOn     = 1
+	mssimNVOn        = 11
+)
+
+// mssimServer is a stand-in for the Microsoft reference simulator: it speaks the
+// command/platform port protocol and forwards TPM commands to another TPM.
+type mssimServer struct {
+	tpm transport.TPM
+
+	mu               sync.Mutex
+	platformCommands []uint32
+	powered          bool
+}
+
+// startMssimServer listens on two consecutive ports and returns the command address.
+func startMssimServer(t *testing.T, tpm transport.TPM) (*mssimServer, string) {
+	t.Helper()
+	s := &mssimServer{tpm: tpm}
+
+	var cmdListener, platListener net.Listener
+	for range 10 {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to listen: %v", err)
+		}
+		port := l.Addr().(*net.TCPAddr).Port
+		p, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port+1)))
+		if err != nil {
+			l.Close()
+			continue
+		}
+		cmdListener, platListener = l, p
+		break
+	}
+	if cmdListener == nil {
+		t.Fatal("failed to find two consecutive free ports")
+	}
+	t.Cleanup(func() {
+		cmdListener.Close()
+		platListener.Close()
+	})
+
+	go s.serve(cmdListener, s.handleCommands)
+	go s.serve(platListener, s.handlePlatform)
+	return s, cmdListener.Addr().String()
+}
+
+func (s *mssimServer) serve(l net.Listener, handle func(net.Conn) error) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			handle(conn)
+		}()
+	}
+}
+
+func (s *mssimServer) handlePlatform(conn net.Conn) error {
+	for {
+		var cmd uint32
+		if err := binary.Read(conn, binary.BigEndian, &cmd); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.platformCommands = append(s.platformCommands, cmd)
+		if cmd == mssimPowerOn {
+			s.powered = true
+		}
+		s.mu.Unlock()
+		if err := binary.Write(conn, binary.BigEndian, uint32(0)); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *mssimServer) handleCommands(conn net.Conn) error {
+	for {
+		var hdr struct {
+			Command  uint32
+			Locality uint8
+			Length   uint32
+		}
+		if err := binary.Read(conn, binary.BigEndian, &hdr); err != nil {
+			return err
+		}
+		if hdr.Command != mssimSendCommand {
+			return errors.New("unexpected command")
+		}
+		cmd := make([]byte, hdr.Length)
+		if _, err := io.ReadFull(conn, cmd); err != nil {
+			return err
+		}
+
+		var rsp []byte
+		s.mu.Lock()
+		powered := s.powered
+		s.mu.Unlock()
+		if powered {
+			var err error
+			if rsp, err = s.tpm.Send(cmd); err != nil {
+				return err
+			}
+		}
+		if err := binary.Write(conn, binary.BigEndian, uint32(len(rsp))); err != nil {
+			return err
+		}
+		if _, err := conn.Write(rsp); err != nil {
+			return err
+		}
+		if err := binary.Write(conn, binary.BigEndian, uint32(0)); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *mssimServer) receivedPlatformCommands() []uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]uint32(nil), s.platformCommands...)
+}
+
+func TestOpenTCP(t *testing.T) {
+	server, addr := startMssimServer(t, tpmtest.OpenSimulator(t))
+
+	tpm, err := OpenTPM(Device("tcp:" + addr))
+	if err != nil {
+		t.Fatalf("OpenTPM() error = %v", err)
+	}
+	defer tpm.Close()
+
+	got := server.receivedPlatformCommands()
+	if len(got) != 2 || got[0] != mssimPowerOn || got[1] != mssimNVOn {
+		t.Errorf("platform commands = %v, want [%d %d] (POWER_ON, NV_ON)", got, mssimPowerOn, mssimNVOn)
+	}
+
+	rsp, err := tpm2.GetRandom{BytesRequested: 16}.Execute(tpm)
+	if err != nil {
+		t.Fatalf("GetRandom() error = %v", err)
+	}
+	if len(rsp.RandomBytes.Buffer) != 16 {
+		t.Errorf("GetRandom() returned %d bytes, want 16", len(rsp.RandomBytes.Buffer))
+	}
+
+	// an ordinary key can be created end-to-end through the TCP transport
+	if err := CreateKey(tpm, CreateKeyConfig{
+		OutDir:           t.TempDir(),
+		ParentTemplate:   ECCSRKTemplate,
+		OrdinaryTemplate: ECCSignerTemplate,
+	}); err != nil {
+		t.Fatalf("CreateKey() error = %v", err)
+	}
+}
+
+func TestOpenTCPConnectionRefused(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	if _, err := OpenTPM(Device("tcp:" + addr)); err == nil {
+		t.Fatal("OpenTPM() error = nil, want error")
+	}
+}
+
+func TestTCPAddresses(t *testing.T) {
+	tests := []struct {
+		value    string
+		wantCmd  string
+		wantPlat string
+		wantErr  bool
+	}{
+		{value: "", wantCmd: "localhost:2321", wantPlat: "localhost:2322"},
+		{value: "10.0.0.1", wantCmd: "10.0.0.1:2321", wantPlat: "10.0.0.1:2322"},
+		{value: "localhost:4321", wantCmd: "localhost:4321", wantPlat: "localhost:4322"},
+		{value: ":4321", wantCmd: "localhost:4321", wantPlat: "localhost:4322"},
+		{value: "localhost:port", wantErr: true},
+		{value: "localhost:65535", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			cmd, plat, err := tcpAddresses(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("tcpAddresses(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if cmd != tt.wantCmd || plat != tt.wantPlat {
+				t.Errorf("tcpAddresses(%q) = (%q, %q), want (%q, %q)", tt.value, cmd, plat, tt.wantCmd, tt.wantPlat)
+			}
+		})
+	}
+}
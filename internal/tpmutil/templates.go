@@ -1,17 +1,20 @@
 package tpmutil
 
 import (
+	"fmt"
+
 	"github.com/google/go-tpm/tpm2"
 	"github.com/loicsikidi/go-tpm-kit/tpmcrypto"
 	"github.com/loicsikidi/go-tpm-kit/tpmutil"
 	"github.com/loicsikidi/tpm-pills/internal/options"
 )
 
-// var AsymTemplatesByKeyType = map[options.KeyType]tpm2.TPMTPublic{
-// 	options.Decrypt:          RSA2048EncryptTemplate,
-// 	options.Signer:           ECCP256SignerTemplate,
-// 	options.RestrictedSigner: ECCP256RestrictedSignerTemplate,
-// }
+// AsymTemplatesByKeyType holds the default template of each asymmetric key type (see [AsymTemplate]).
+var AsymTemplatesByKeyType = map[options.KeyType]tpm2.TPMTPublic{
+	options.Decrypt:          RSAEncryptTemplate,
+	options.Signer:           ECCSignerTemplate,
+	options.RestrictedSigner: ECCRestrictedSignerTemplate,
+}
 
 var SymTemplatesByKeyType = map[options.KeyType]tpm2.TPMTPublic{
 	options.Decrypt: AES128CFBTemplate,
@@ -178,6 +181,26 @@ var (
 	}
 )
 
+// KeyTemplate returns the template requested by opts: the template spec at opts.TemplatePath
+// or else fallback, with the attributes replaced by opts.Attributes if any.
+func KeyTemplate(opts *options.CreateKeyOpts, fallback tpm2.TPMTPublic) (tpm2.TPMTPublic, error) {
+	template := fallback
+	if opts.TemplatePath != "" {
+		var err error
+		if template, err = LoadTemplate(opts.TemplatePath); err != nil {
+			return tpm2.TPMTPublic{}, err
+		}
+	}
+	if opts.Attributes != "" {
+		attrs, err := ParseAttributes(opts.Attributes)
+		if err != nil {
+			return tpm2.TPMTPublic{}, fmt.Errorf("invalid input: %w", err)
+		}
+		template.ObjectAttributes = attrs
+	}
+	return template, nil
+}
+
 func NewHMACKeyTemplate(hashAlg tpm2.TPMIAlgHash) (tpm2.TPMTPublic, error) {
 	template := hmacKeyTemplate
 	template.NameAlg = hashAlg
@@ -8,10 +8,10 @@ import (
 
 	"github.com/google/go-tpm/tpm2"
 	"github.com/google/go-tpm/tpm2/transport"
-	"github.com/loicsikidi/go-tpm-kit/tpmcrypto"
 	"github.com/loicsikidi/go-tpm-kit/tpmutil"
 	"github.com/loicsikidi/tpm-pills/internal/keyutil"
 	"github.com/loicsikidi/tpm-pills/internal/pemutil"
+	"github.com/loicsikidi/tpm-pills/internal/utils"
 )
 
 // CreatePrimary creates a simple primary key in the TPM and returns the response and a cleanup function.
@@ -95,9 +95,17 @@ func CreateKey(tpm transport.TPM, cfg CreateKeyConfig) error {
 	}
 	defer skrHandle.Close()
 
+	template, policy := cfg.OrdinaryTemplate, cfg.Policy
+	if policy != nil {
+		if template, policy, err = withPolicy(tpm, template, policy); err != nil {
+			return err
+		}
+	}
+
 	createKeyResult, err := tpmutil.CreateWithResult(tpm, tpmutil.CreateConfig{
 		ParentHandle: skrHandle,
-		InPublic:     cfg.OrdinaryTemplate,
+		InPublic:     template,
+		UserAuth:     cfg.UserAuth,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to create ordinary key: %w", err)
@@ -108,13 +116,18 @@ func CreateKey(tpm transport.TPM, cfg CreateKeyConfig) error {
 		return fmt.Errorf("failed to marshal create key result: %w", err)
 	}
 
-	if err := os.WriteFile(filepath.Join(cfg.OutDir, "key.tpm"), b, 0644); err != nil {
+	keyPath := filepath.Join(cfg.OutDir, "key.tpm")
+	if err := os.WriteFile(keyPath, b, 0644); err != nil {
 		return fmt.Errorf("failed to save tpm blob: %w", err)
 	}
+	// the PCR values are required to check them before using the key
+	if err := savePolicyFile(keyPath, policy); err != nil {
+		return err
+	}
 
 	if cfg.CreatePublicKey {
 		if slices.Contains([]tpm2.TPMIAlgPublic{tpm2.TPMAlgECC, tpm2.TPMAlgRSA}, cfg.OrdinaryTemplate.Type) {
-			pub, err := tpmcrypto.PublicKey(createKeyResult.PublicArea())
+			pub, err := keyutil.FromPublic(createKeyResult.PublicArea())
 			if err != nil {
 				return fmt.Errorf("failed to get public key: %w", err)
 			}
@@ -183,10 +196,20 @@ func loadTPMBlob(pubPath, privPath string) (*tpm2.TPM2BPublic, *tpm2.TPM2BPrivat
 	return pub, priv, nil
 }
 
+// LoadKey loads the key blob saved by [CreateKey] or [Seal] under the primary key created from cfg.ParentTemplate.
+// The returned handle carries cfg.Auth and cfg.Policy, use [AuthorizeKey] to authorize the key in TPM commands.
+// Without cfg.Policy, the policy saved along with the blob by [CreateKey] or [Seal] is used, if any (see [PolicyFilePath]).
 func LoadKey(tpm transport.TPM, cfg LoadKeyConfig) (HandleCloser, error) {
 	if err := cfg.CheckAndSetDefaults(); err != nil {
 		return nil, err
 	}
+	if cfg.Policy == nil && utils.FileExists(PolicyFilePath(cfg.KeyBlobPath)) {
+		policy, err := LoadPolicy(PolicyFilePath(cfg.KeyBlobPath))
+		if err != nil {
+			return nil, err
+		}
+		cfg.Policy = policy
+	}
 	skrHandle, err := tpmutil.CreatePrimary(tpm, tpmutil.CreatePrimaryConfig{
 		InPublic: cfg.ParentTemplate,
 	})
@@ -199,21 +222,50 @@ func LoadKey(tpm transport.TPM, cfg LoadKeyConfig) (HandleCloser, error) {
 	if err != nil {
 		return nil, err
 	}
-	return tpmutil.Load(tpm, tpmutil.LoadConfig{
+	keyHandle, err := tpmutil.Load(tpm, tpmutil.LoadConfig{
 		ParentHandle: skrHandle,
 		InPublic:     loadedBlob.OutPublic,
 		InPrivate:    loadedBlob.OutPrivate,
 	})
+	if err != nil {
+		return nil, err
+	}
+	return &authHandle{HandleCloser: keyHandle, auth: cfg.Auth, policy: cfg.Policy}, nil
 }
 
+// maxBufferSize is the size of a TPM2B_MAX_BUFFER, the largest input of EncryptDecrypt2 and Hmac.
+const maxBufferSize = 1024
+
+// SymEncryptDecrypt encrypts or decrypts cfg.Data with a symmetric key.
+//
+// Data longer than a TPM2B_MAX_BUFFER is sent in chunks, each one starting from the IV returned for the previous one.
 func SymEncryptDecrypt(tpm transport.TPM, cfg SymEncryptDecryptConfig) ([]byte, error) {
-	return tpmutil.SymEncryptDecrypt(tpm, tpmutil.SymEncryptDecryptConfig{
-		KeyHandle: cfg.KeyHandle,
-		Data:      cfg.Data,
-		Mode:      cfg.Mode,
-		IV:        cfg.IV,
-		Decrypt:   cfg.Decrypt,
-	})
+	out := make([]byte, 0, len(cfg.Data))
+	iv, data := cfg.IV, cfg.Data
+	for {
+		// a policy session authorizes a single command
+		keyAuth, closer, err := AuthorizeKey(tpm, cfg.KeyHandle)
+		if err != nil {
+			return nil, err
+		}
+		chunk := data[:min(len(data), maxBufferSize)]
+		rsp, err := tpm2.EncryptDecrypt2{
+			KeyHandle: keyAuth,
+			Message:   tpm2.TPM2BMaxBuffer{Buffer: chunk},
+			Mode:      cfg.Mode,
+			Decrypt:   cfg.Decrypt,
+			IV:        tpm2.TPM2BIV{Buffer: iv},
+		}.Execute(tpm)
+		closer()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rsp.OutData.Buffer...)
+		if data = data[len(chunk):]; len(data) == 0 {
+			return out, nil
+		}
+		iv = rsp.IV.Buffer
+	}
 }
 
 func Seal(tpm transport.TPM, cfg SealConfig) error {
@@ -228,10 +280,18 @@ func Seal(tpm transport.TPM, cfg SealConfig) error {
 	}
 	defer skrHandle.Close()
 
+	template, policy := SealTemplate, cfg.Policy
+	if policy != nil {
+		if template, policy, err = withPolicy(tpm, template, policy); err != nil {
+			return err
+		}
+	}
+
 	createKeyResult, err := tpmutil.CreateWithResult(tpm, tpmutil.CreateConfig{
 		ParentHandle: skrHandle,
-		InPublic:     SealTemplate,
+		InPublic:     template,
 		SealingData:  cfg.Message,
+		UserAuth:     cfg.UserAuth,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to seal data into TPM: %w", err)
@@ -245,12 +305,19 @@ func Seal(tpm transport.TPM, cfg SealConfig) error {
 	if err := os.WriteFile(cfg.OutputFilePath, b, 0644); err != nil {
 		return fmt.Errorf("failed to save tpm blob: %w", err)
 	}
-	return nil
+	// the PCR values are required to check them before unsealing
+	return savePolicyFile(cfg.OutputFilePath, policy)
 }
 
 func Unseal(tpm transport.TPM, cfg UnsealConfig) ([]byte, error) {
+	keyAuth, closer, err := AuthorizeKey(tpm, cfg.KeyHandle)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
 	unsealRsp, err := tpm2.Unseal{
-		ItemHandle: tpmutil.ToAuthHandle(cfg.KeyHandle),
+		ItemHandle: keyAuth,
 	}.Execute(tpm)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unseal data: %w", err)
@@ -258,20 +325,79 @@ func Unseal(tpm transport.TPM, cfg UnsealConfig) ([]byte, error) {
 	return unsealRsp.OutData.Buffer, nil
 }
 
+// HMAC computes the HMAC of cfg.Data with a primary HMAC key created from cfg.KeyTemplate.
+//
+// Data longer than a TPM2B_MAX_BUFFER goes through an HMAC sequence, started by TPM2_HMAC_Start:
+// a policy of the key restricted to TPM2_HMAC doesn't authorize it.
 func HMAC(tpm transport.TPM, cfg HMACConfig) ([]byte, error) {
 	if err := cfg.CheckAndSetDefaults(); err != nil {
 		return nil, err
 	}
+	template, policy := cfg.KeyTemplate, cfg.Policy
+	if policy != nil {
+		var err error
+		if template, policy, err = withPolicy(tpm, template, policy); err != nil {
+			return nil, err
+		}
+	}
 	hmacKeyHandle, err := tpmutil.CreatePrimary(tpm, tpmutil.CreatePrimaryConfig{
-		InPublic: cfg.KeyTemplate,
+		InPublic: template,
+		UserAuth: cfg.UserAuth,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create primary key: %v", err)
 	}
 	defer hmacKeyHandle.Close()
 
-	return tpmutil.Hmac(tpm, tpmutil.HmacConfig{
-		KeyHandle: hmacKeyHandle,
-		Data:      cfg.Data,
-	})
+	keyAuth, closer, err := AuthorizeKey(tpm, &authHandle{HandleCloser: hmacKeyHandle, auth: cfg.UserAuth, policy: policy})
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	if len(cfg.Data) > maxBufferSize {
+		return hmacSequence(tpm, keyAuth, cfg.Data)
+	}
+	rsp, err := tpm2.Hmac{
+		Handle:  keyAuth,
+		Buffer:  tpm2.TPM2BMaxBuffer{Buffer: cfg.Data},
+		HashAlg: tpm2.TPMAlgNull,
+	}.Execute(tpm)
+	if err != nil {
+		return nil, err
+	}
+	return rsp.OutHMAC.Buffer, nil
+}
+
+// hmacSequence computes the HMAC of data longer than a TPM2B_MAX_BUFFER with an HMAC sequence.
+func hmacSequence(tpm transport.TPM, key tpm2.AuthHandle, data []byte) ([]byte, error) {
+	startRsp, err := tpm2.HmacStart{
+		Handle:  key,
+		HashAlg: tpm2.TPMAlgNull,
+	}.Execute(tpm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start HMAC sequence: %w", err)
+	}
+	sequence := tpm2.AuthHandle{Handle: startRsp.SequenceHandle, Auth: tpm2.PasswordAuth(nil)}
+
+	for len(data) > maxBufferSize {
+		if _, err := (tpm2.SequenceUpdate{
+			SequenceHandle: sequence,
+			Buffer:         tpm2.TPM2BMaxBuffer{Buffer: data[:maxBufferSize]},
+		}).Execute(tpm); err != nil {
+			tpm2.FlushContext{FlushHandle: sequence.Handle}.Execute(tpm)
+			return nil, fmt.Errorf("failed to update HMAC sequence: %w", err)
+		}
+		data = data[maxBufferSize:]
+	}
+	rsp, err := tpm2.SequenceComplete{
+		SequenceHandle: sequence,
+		Buffer:         tpm2.TPM2BMaxBuffer{Buffer: data},
+		Hierarchy:      tpm2.TPMRHNull,
+	}.Execute(tpm)
+	if err != nil {
+		tpm2.FlushContext{FlushHandle: sequence.Handle}.Execute(tpm)
+		return nil, fmt.Errorf("failed to complete HMAC sequence: %w", err)
+	}
+	return rsp.Result.Buffer, nil
 }
@@ -0,0 +1,124 @@
+package tpmutil
+
+import (
+	"bytes"
+	"crypto/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/loicsikidi/go-tpm-kit/tpmtest"
+)
+
+// TestSymEncryptDecryptLongData checks that data longer than a TPM2B_MAX_BUFFER is
+// encrypted as a single CFB stream, by a key protected by a password.
+func TestSymEncryptDecryptLongData(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	dir := t.TempDir()
+	auth := []byte("password")
+	if err := CreateKey(tpm, CreateKeyConfig{
+		OutDir:           dir,
+		ParentTemplate:   ECCSRKTemplate,
+		OrdinaryTemplate: AES128CFBTemplate,
+		UserAuth:         auth,
+	}); err != nil {
+		t.Fatalf("CreateKey() error = %v", err)
+	}
+	keyHandle, err := LoadKey(tpm, LoadKeyConfig{
+		ParentTemplate: ECCSRKTemplate,
+		KeyBlobPath:    filepath.Join(dir, "key.tpm"),
+		Auth:           auth,
+	})
+	if err != nil {
+		t.Fatalf("LoadKey() error = %v", err)
+	}
+	defer keyHandle.Close()
+
+	data := make([]byte, 2*maxBufferSize+100)
+	rand.Read(data)
+	iv := make([]byte, 16)
+	rand.Read(iv)
+	cfg := SymEncryptDecryptConfig{KeyHandle: keyHandle, Data: data, IV: iv, Mode: tpm2.TPMAlgCFB}
+
+	ciphertext, err := SymEncryptDecrypt(tpm, cfg)
+	if err != nil {
+		t.Fatalf("SymEncryptDecrypt() error = %v", err)
+	}
+	if len(ciphertext) != len(data) {
+		t.Fatalf("len(ciphertext) = %d, want %d", len(ciphertext), len(data))
+	}
+
+	// in CFB mode, the last block of ciphertext is the IV of what follows
+	cfg.Data, cfg.IV, cfg.Decrypt = ciphertext[maxBufferSize:], ciphertext[maxBufferSize-16:maxBufferSize], true
+	tail, err := SymEncryptDecrypt(tpm, cfg)
+	if err != nil {
+		t.Fatalf("SymEncryptDecrypt() of the tail error = %v", err)
+	}
+	if !bytes.Equal(tail, data[maxBufferSize:]) {
+		t.Errorf("the tail of the ciphertext doesn't decrypt to the tail of the data")
+	}
+
+	cfg.Data, cfg.IV = ciphertext, iv
+	got, err := SymEncryptDecrypt(tpm, cfg)
+	if err != nil {
+		t.Fatalf("SymEncryptDecrypt() decrypt error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("SymEncryptDecrypt() decrypt doesn't return the data")
+	}
+}
+
+// TestHMACLongData checks that data longer than a TPM2B_MAX_BUFFER goes through an HMAC sequence,
+// which computes the same HMAC as the Hmac command.
+func TestHMACLongData(t *testing.T) {
+	tpm := tpmtest.OpenSimulator(t)
+	template, err := NewHMACKeyTemplate(tpm2.TPMAlgSHA256)
+	if err != nil {
+		t.Fatalf("NewHMACKeyTemplate() error = %v", err)
+	}
+	auth := []byte("password")
+	data := make([]byte, 2*maxBufferSize+100)
+	rand.Read(data)
+
+	long, err := HMAC(tpm, HMACConfig{KeyTemplate: template, Data: data, UserAuth: auth})
+	if err != nil {
+		t.Fatalf("HMAC() error = %v", err)
+	}
+	again, err := HMAC(tpm, HMACConfig{KeyTemplate: template, Data: data, UserAuth: auth})
+	if err != nil {
+		t.Fatalf("HMAC() error = %v", err)
+	}
+	if !bytes.Equal(long, again) {
+		t.Errorf("HMAC() isn't deterministic: %x, then %x", long, again)
+	}
+	short, err := HMAC(tpm, HMACConfig{KeyTemplate: template, Data: data[:maxBufferSize], UserAuth: auth})
+	if err != nil {
+		t.Fatalf("HMAC() error = %v", err)
+	}
+	if bytes.Equal(long, short) {
+		t.Errorf("HMAC() ignores the data beyond %d bytes", maxBufferSize)
+	}
+
+	// a sequence over data that fits in a single command matches the Hmac command
+	hmacKeyHandle, closer, err := CreatePrimary(tpm, tpm2.New2B(template))
+	if err != nil {
+		t.Fatalf("CreatePrimary() error = %v", err)
+	}
+	defer closer()
+	key := tpm2.AuthHandle{Handle: hmacKeyHandle.ObjectHandle, Name: hmacKeyHandle.Name, Auth: tpm2.PasswordAuth(nil)}
+	rsp, err := tpm2.Hmac{
+		Handle:  key,
+		Buffer:  tpm2.TPM2BMaxBuffer{Buffer: data[:maxBufferSize]},
+		HashAlg: tpm2.TPMAlgNull,
+	}.Execute(tpm)
+	if err != nil {
+		t.Fatalf("Hmac() error = %v", err)
+	}
+	got, err := hmacSequence(tpm, key, data[:maxBufferSize])
+	if err != nil {
+		t.Fatalf("hmacSequence() error = %v", err)
+	}
+	if !bytes.Equal(got, rsp.OutHMAC.Buffer) {
+		t.Errorf("hmacSequence() = %x, want %x", got, rsp.OutHMAC.Buffer)
+	}
+}
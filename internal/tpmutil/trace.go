@@ -0,0 +1,118 @@
+package tpmutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/google/go-tpm/tpm2/transport"
+)
+
+// TraceEntry describes a command sent to the TPM. It's written as a JSON line by [Tracer].
+type TraceEntry struct {
+	Time         time.Time `json:"time"`
+	Command      string    `json:"command"`
+	CommandCode  string    `json:"command_code"`
+	Handles      []string  `json:"handles,omitempty"`
+	Sessions     int       `json:"sessions"`
+	ResponseCode string    `json:"response_code,omitempty"`
+	Response     string    `json:"response,omitempty"`
+	// OutHandles holds the handles returned by the TPM (e.g. the handle of a loaded object).
+	OutHandles   []string `json:"out_handles,omitempty"`
+	CommandSize  int      `json:"command_size"`
+	ResponseSize int      `json:"response_size"`
+	LatencyUs    int64    `json:"latency_us"`
+	// Error holds the transport error, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Tracer is a [transport.TPMCloser] which records every command sent to
+// the underlying TPM as a [TraceEntry].
+type Tracer struct {
+	tpm transport.TPMCloser
+
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// NewTracer returns a [Tracer] writing JSON lines to w.
+//
+// Closing the tracer closes tpm but not w.
+func NewTracer(tpm transport.TPMCloser, w io.Writer) *Tracer {
+	return &Tracer{tpm: tpm, enc: json.NewEncoder(w)}
+}
+
+// Send implements [transport.TPM].
+func (t *Tracer) Send(cmd []byte) ([]byte, error) {
+	start := time.Now()
+	rsp, err := t.tpm.Send(cmd)
+	entry := newTraceEntry(cmd, rsp, err)
+	entry.Time = start
+	entry.LatencyUs = time.Since(start).Microseconds()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if encErr := t.enc.Encode(entry); encErr != nil && t.err == nil {
+		t.err = encErr
+	}
+	return rsp, err
+}
+
+// Close closes the underlying TPM. It reports the first error which occurred while writing the trace.
+func (t *Tracer) Close() error {
+	if err := t.tpm.Close(); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return fmt.Errorf("failed to write trace: %w", t.err)
+	}
+	return nil
+}
+
+func newTraceEntry(cmd, rsp []byte, err error) TraceEntry {
+	entry := TraceEntry{
+		CommandSize:  len(cmd),
+		ResponseSize: len(rsp),
+	}
+	entry.Command = commandNameOf(cmd)
+	cc, ccErr := commandCode(cmd)
+	if ccErr == nil {
+		entry.CommandCode = formatUint32(uint32(cc))
+	}
+	handles, sessions, _ := commandHandles(cmd)
+	entry.Handles = formatHandles(handles)
+	entry.Sessions = sessions
+
+	if err != nil {
+		entry.Error = err.Error()
+		return entry
+	}
+	if rc, rcErr := responseCode(rsp); rcErr == nil {
+		entry.ResponseCode = formatUint32(uint32(rc))
+		if rc == tpm2.TPMRCSuccess {
+			entry.Response = "TPM_RC_SUCCESS"
+		} else {
+			entry.Response = rc.Error()
+		}
+	}
+	entry.OutHandles = formatHandles(responseHandles(cc, rsp))
+	return entry
+}
+
+func formatHandles(handles []tpm2.TPMHandle) []string {
+	var s []string
+	for _, h := range handles {
+		s = append(s, formatUint32(uint32(h)))
+	}
+	return s
+}
+
+func formatUint32(v uint32) string {
+	return fmt.Sprintf("0x%08x", v)
+}
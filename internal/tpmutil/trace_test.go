@@ -0,0 +1,107 @@
+package tpmutil
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/google/go-tpm/tpm2"
+	"github.com/loicsikidi/go-tpm-kit/tpmtest"
+)
+
+func TestTracer(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := NewTracer(tpmtest.OpenSimulator(t), &buf)
+
+	if err := CreateKey(tracer, CreateKeyConfig{
+		OutDir:           t.TempDir(),
+		ParentTemplate:   ECCSRKTemplate,
+		OrdinaryTemplate: ECCSignerTemplate,
+	}); err != nil {
+		t.Fatalf("CreateKey() error = %v", err)
+	}
+	_, err := tpm2.FlushContext{FlushHandle: tpm2.TPMHandle(0x80ffffff)}.Execute(tracer)
+	if err == nil {
+		t.Fatal("FlushContext() error = nil, want error")
+	}
+
+	var entries []TraceEntry
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var entry TraceEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("invalid trace line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+
+	var createPrimary *TraceEntry
+	for i := range entries {
+		if entries[i].Command == "TPM2_CreatePrimary" {
+			createPrimary = &entries[i]
+			break
+		}
+	}
+	if createPrimary == nil {
+		t.Fatalf("no TPM2_CreatePrimary in trace: %+v", entries)
+	}
+	if createPrimary.CommandCode != "0x00000131" {
+		t.Errorf("CommandCode = %s, want 0x00000131", createPrimary.CommandCode)
+	}
+	if len(createPrimary.Handles) != 1 || createPrimary.Handles[0] != "0x40000001" {
+		t.Errorf("Handles = %v, want [0x40000001] (owner hierarchy)", createPrimary.Handles)
+	}
+	if createPrimary.Sessions != 1 {
+		t.Errorf("Sessions = %d, want 1", createPrimary.Sessions)
+	}
+	if createPrimary.Response != "TPM_RC_SUCCESS" || len(createPrimary.OutHandles) != 1 {
+		t.Errorf("Response = %s, OutHandles = %v, want TPM_RC_SUCCESS and the primary handle", createPrimary.Response, createPrimary.OutHandles)
+	}
+	if createPrimary.CommandSize == 0 || createPrimary.ResponseSize == 0 {
+		t.Errorf("CommandSize = %d, ResponseSize = %d, want non-zero sizes", createPrimary.CommandSize, createPrimary.ResponseSize)
+	}
+
+	last := entries[len(entries)-1]
+	if last.Command != "TPM2_FlushContext" {
+		t.Fatalf("last Command = %s, want TPM2_FlushContext", last.Command)
+	}
+	var rc tpm2.TPMRC
+	if !errors.As(err, &rc) || last.ResponseCode != formatUint32(uint32(rc)) {
+		t.Errorf("ResponseCode = %s, want the code of %v", last.ResponseCode, err)
+	}
+	if last.Response == "TPM_RC_SUCCESS" || last.Response == "" {
+		t.Errorf("Response = %q, want an error description", last.Response)
+	}
+
+	if err := tracer.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
+
+func TestCommandHandles(t *testing.T) {
+	cmd := []byte{
+		0x80, 0x02, // TPM_ST_SESSIONS
+		0x00, 0x00, 0x00, 0x24, // size
+		0x00, 0x00, 0x01, 0x5e, // TPM_CC_Unseal
+		0x80, 0x00, 0x00, 0x01, // item handle
+		0x00, 0x00, 0x00, 0x12, // authorization size
+		0x40, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00, 0x00, 0x00, // password session without auth
+		0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, // policy session without nonce nor hmac
+	}
+	handles, sessions, ok := commandHandles(cmd)
+	if !ok {
+		t.Fatal("commandHandles() ok = false, want true")
+	}
+	if len(handles) != 1 || handles[0] != 0x80000001 {
+		t.Errorf("handles = %v, want [0x80000001]", handles)
+	}
+	if sessions != 2 {
+		t.Errorf("sessions = %d, want 2", sessions)
+	}
+
+	if _, _, ok := commandHandles(cmd[:12]); ok {
+		t.Error("commandHandles() on a truncated command ok = true, want false")
+	}
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ReadPassword prints prompt on stderr and reads a password from the first line of stdin.
+//
+// When stdin is a terminal, the password isn't echoed while typed.
+func ReadPassword(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	if restore, err := disableEcho(int(os.Stdin.Fd())); err == nil {
+		defer func() {
+			restore()
+			// the newline typed by the user wasn't echoed either
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	return readLine(os.Stdin)
+}
+
+// readLine reads r one byte at a time so that nothing past the line is consumed:
+// a buffered reader would swallow the next line, e.g. the confirmation of a password piped on stdin.
+func readLine(r io.Reader) ([]byte, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+		}
+		if err == io.EOF && len(line) > 0 {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read password: %w", err)
+		}
+	}
+	return bytes.TrimRight(line, "\r"), nil
+}
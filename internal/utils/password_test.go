@@ -0,0 +1,86 @@
+package utils_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/loicsikidi/tpm-pills/internal/utils"
+)
+
+func TestReadPassword(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "line", input: "p@ssw0rd\nnext line\n", want: "p@ssw0rd"},
+		{name: "crlf", input: "p@ssw0rd\r\n", want: "p@ssw0rd"},
+		{name: "no newline", input: "p@ssw0rd", want: "p@ssw0rd"},
+		{name: "empty line", input: "\n", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldStdin := os.Stdin
+			defer func() { os.Stdin = oldStdin }()
+
+			r, w, err := os.Pipe()
+			if err != nil {
+				t.Fatalf("failed to create pipe: %v", err)
+			}
+			os.Stdin = r
+
+			go func() {
+				defer w.Close()
+				w.Write([]byte(tt.input))
+			}()
+
+			got, err := utils.ReadPassword("")
+			if err != nil {
+				t.Fatalf("ReadPassword() error = %v, want nil", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("ReadPassword() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("consecutive lines", func(t *testing.T) {
+		oldStdin := os.Stdin
+		defer func() { os.Stdin = oldStdin }()
+
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("failed to create pipe: %v", err)
+		}
+		os.Stdin = r
+		// a prompted password and its confirmation piped at once
+		w.Write([]byte("p@ssw0rd\nconfirmed\n"))
+		w.Close()
+
+		for _, want := range []string{"p@ssw0rd", "confirmed"} {
+			got, err := utils.ReadPassword("")
+			if err != nil {
+				t.Fatalf("ReadPassword() error = %v, want nil", err)
+			}
+			if string(got) != want {
+				t.Errorf("ReadPassword() = %q, want %q", got, want)
+			}
+		}
+	})
+
+	t.Run("empty input", func(t *testing.T) {
+		oldStdin := os.Stdin
+		defer func() { os.Stdin = oldStdin }()
+
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("failed to create pipe: %v", err)
+		}
+		os.Stdin = r
+		w.Close()
+
+		if _, err := utils.ReadPassword(""); err == nil {
+			t.Error("ReadPassword() error = nil, want an error")
+		}
+	})
+}
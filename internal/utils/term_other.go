@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package utils
+
+import "errors"
+
+// disableEcho isn't supported on this platform, so passwords are echoed.
+func disableEcho(fd int) (func(), error) {
+	return nil, errors.New("disabling the terminal echo is not supported")
+}
@@ -0,0 +1,21 @@
+//go:build linux || darwin
+
+package utils
+
+import "golang.org/x/sys/unix"
+
+// disableEcho turns off the echo of the terminal fd and returns a function restoring it.
+// It fails when fd isn't a terminal.
+func disableEcho(fd int) (func(), error) {
+	termios, err := unix.IoctlGetTermios(fd, ioctlReadTermios)
+	if err != nil {
+		return nil, err
+	}
+	noEcho := *termios
+	noEcho.Lflag &^= unix.ECHO
+	noEcho.Lflag |= unix.ICANON | unix.ISIG
+	if err := unix.IoctlSetTermios(fd, ioctlWriteTermios, &noEcho); err != nil {
+		return nil, err
+	}
+	return func() { unix.IoctlSetTermios(fd, ioctlWriteTermios, termios) }, nil
+}